@@ -32,3 +32,19 @@ type BPFInstruction struct {
 	// K is a constant parameter. The meaning depends on the value of OpCode.
 	K uint32
 }
+
+// Offsets used by classic BPF socket filters to load data other than the
+// packet contents, from include/uapi/linux/filter.h. These are negative, and
+// are interpreted as such by Linux's socket filter loads; they are never valid
+// in seccomp filters.
+const (
+	// SKF_AD_OFF is the offset of ancillary data, such as the packet's
+	// protocol.
+	SKF_AD_OFF = -0x1000
+
+	// SKF_NET_OFF is the offset of the packet's network header.
+	SKF_NET_OFF = -0x100000
+
+	// SKF_LL_OFF is the offset of the packet's link layer header.
+	SKF_LL_OFF = -0x200000
+)
@@ -14,6 +14,10 @@
 
 package linux
 
+// MAX_ERRNO is the largest errno value that may be returned by a system call,
+// taken from include/linux/err.h.
+const MAX_ERRNO = 4095
+
 // Errno represents a Linux errno value.
 type Errno struct {
 	number int
@@ -97,3 +97,11 @@ const (
 	AshmemGetPinStatusIoctl   = 0x00007709
 	AshmemPurgeAllCachesIoctl = 0x0000770a
 )
+
+// ioctl(2) requests provided by uapi/linux/seccomp.h
+const (
+	SECCOMP_IOCTL_NOTIF_RECV     = 0xc0502100
+	SECCOMP_IOCTL_NOTIF_SEND     = 0xc0182101
+	SECCOMP_IOCTL_NOTIF_ID_VALID = 0x40082102
+	SECCOMP_IOCTL_NOTIF_ADDFD    = 0x40182103
+)
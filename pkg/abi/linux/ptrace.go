@@ -87,3 +87,15 @@ const (
 	PTRACE_O_EXITKILL        = 1 << 20
 	PTRACE_O_SUSPEND_SECCOMP = 1 << 21
 )
+
+// SeccompMetadata is equivalent to struct seccomp_metadata, used by
+// PTRACE_SECCOMP_GET_METADATA.
+type SeccompMetadata struct {
+	// FilterOff is the index of the filter, where 0 is the most recently
+	// installed filter.
+	FilterOff uint64
+
+	// Flags is the set of SECCOMP_FILTER_FLAG_* flags with which the filter
+	// was installed.
+	Flags uint64
+}
@@ -17,22 +17,134 @@ package linux
 // Seccomp constants taken from <linux/seccomp.h>.
 const (
 	SECCOMP_MODE_NONE   = 0
+	SECCOMP_MODE_STRICT = 1
 	SECCOMP_MODE_FILTER = 2
 
-	SECCOMP_RET_KILL  = 0x00000000
-	SECCOMP_RET_TRAP  = 0x00030000
-	SECCOMP_RET_ERRNO = 0x00050000
-	SECCOMP_RET_TRACE = 0x7ff00000
-	SECCOMP_RET_ALLOW = 0x7fff0000
+	SECCOMP_RET_KILL_PROCESS = 0x80000000
+	SECCOMP_RET_KILL_THREAD  = 0x00000000
+	SECCOMP_RET_KILL         = SECCOMP_RET_KILL_THREAD
+	SECCOMP_RET_TRAP         = 0x00030000
+	SECCOMP_RET_ERRNO        = 0x00050000
+	SECCOMP_RET_USER_NOTIF   = 0x7fc00000
+	SECCOMP_RET_TRACE        = 0x7ff00000
+	SECCOMP_RET_LOG          = 0x7ffc0000
+	SECCOMP_RET_ALLOW        = 0x7fff0000
 
-	SECCOMP_RET_ACTION = 0x7fff0000
-	SECCOMP_RET_DATA   = 0x0000ffff
+	SECCOMP_RET_ACTION_FULL = 0xffff0000
+	SECCOMP_RET_ACTION      = 0x7fff0000
+	SECCOMP_RET_DATA        = 0x0000ffff
 
-	SECCOMP_SET_MODE_FILTER   = 1
-	SECCOMP_FILTER_FLAG_TSYNC = 1
+	SECCOMP_SET_MODE_STRICT          = 0
+	SECCOMP_SET_MODE_FILTER          = 1
+	SECCOMP_GET_ACTION_AVAIL         = 2
+	SECCOMP_GET_NOTIF_SIZES          = 3
+	SECCOMP_FILTER_FLAG_TSYNC        = 1
+	SECCOMP_FILTER_FLAG_LOG          = 2
+	SECCOMP_FILTER_FLAG_SPEC_ALLOW   = 4
+	SECCOMP_FILTER_FLAG_NEW_LISTENER = 8
+	SECCOMP_FILTER_FLAG_TSYNC_ESRCH  = 16
+
+	SECCOMP_USER_NOTIF_FLAG_CONTINUE = 1
+
+	SECCOMP_ADDFD_FLAG_SETFD = 1
+	SECCOMP_ADDFD_FLAG_SEND  = 2
 )
 
+// Audit architectures, taken from <linux/audit.h>.
 const (
-	// AUDIT_ARCH_X86_64 is taken from <linux/audit.h>.
-	AUDIT_ARCH_X86_64 = 0xc000003e
+	// AUDIT_ARCH_64BIT is set in the AUDIT_ARCH_* values of 64-bit system
+	// call conventions. It is equivalent to Linux's __AUDIT_ARCH_64BIT.
+	AUDIT_ARCH_64BIT = 0x80000000
+
+	AUDIT_ARCH_I386    = 0x40000003
+	AUDIT_ARCH_X86_64  = 0xc000003e
+	AUDIT_ARCH_AARCH64 = 0xc00000b7
 )
+
+// Audit message types, taken from <linux/audit.h>.
+const (
+	// AUDIT_SECCOMP is the type of audit records generated by seccomp
+	// filter actions that are logged.
+	AUDIT_SECCOMP = 1326
+)
+
+// SeccompData is equivalent to struct seccomp_data.
+type SeccompData struct {
+	// Nr is the system call number.
+	Nr int32
+
+	// Arch is an AUDIT_ARCH_* value indicating the system call convention.
+	Arch uint32
+
+	// InstructionPointer is the value of the instruction pointer at the time
+	// of the system call.
+	InstructionPointer uint64
+
+	// Args contains the first 6 system call arguments.
+	Args [6]uint64
+}
+
+// SeccompNotif is equivalent to struct seccomp_notif.
+type SeccompNotif struct {
+	// ID is the notification's unique identifier.
+	ID uint64
+
+	// Pid is the thread ID of the notifying task, in the supervisor's PID
+	// namespace.
+	Pid uint32
+
+	// Flags is currently unused and always 0.
+	Flags uint32
+
+	// Data describes the system call that triggered the notification.
+	Data SeccompData
+}
+
+// SeccompNotifResp is equivalent to struct seccomp_notif_resp.
+type SeccompNotifResp struct {
+	// ID is the identifier of the notification being responded to.
+	ID uint64
+
+	// Val is the system call's return value if Error is 0.
+	Val int64
+
+	// Error is the negated errno returned by the system call, or 0.
+	Error int32
+
+	// Flags is a set of SECCOMP_USER_NOTIF_FLAG_* values.
+	Flags uint32
+}
+
+// SeccompNotifAddfd is equivalent to struct seccomp_notif_addfd.
+type SeccompNotifAddfd struct {
+	// ID is the identifier of the notification whose task receives the file
+	// descriptor.
+	ID uint64
+
+	// Flags is a set of SECCOMP_ADDFD_FLAG_* values.
+	Flags uint32
+
+	// SrcFD is the supervisor's file descriptor to install in the notifying
+	// task.
+	SrcFD uint32
+
+	// NewFD is the file descriptor number to use if Flags contains
+	// SECCOMP_ADDFD_FLAG_SETFD, and must otherwise be 0.
+	NewFD uint32
+
+	// NewFDFlags is a set of flags (only O_CLOEXEC is permitted) to set on
+	// the new file descriptor.
+	NewFDFlags uint32
+}
+
+// SeccompNotifSizes is equivalent to struct seccomp_notif_sizes.
+type SeccompNotifSizes struct {
+	// Notif is the size of struct seccomp_notif.
+	Notif uint16
+
+	// NotifResp is the size of struct seccomp_notif_resp.
+	NotifResp uint16
+
+	// Data is the size of struct seccomp_data.
+	Data uint16
+}
@@ -127,3 +127,9 @@ func Jump(code uint16, k uint32, jt, jf uint8) linux.BPFInstruction {
 		K:           k,
 	}
 }
+
+// InstructionClass returns the instruction class of opcode code: one of Ld,
+// Ldx, St, Stx, Alu, Jmp, Ret or Misc.
+func InstructionClass(code uint16) uint16 {
+	return code & instructionClassMask
+}
@@ -0,0 +1,170 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bpf
+
+import (
+	"bytes"
+	"fmt"
+
+	"gvisor.googlesource.com/gvisor/pkg/abi/linux"
+)
+
+// Disassemble returns a textual representation of p, with one line per
+// instruction in the format used by tcpdump -d. Since Programs are most
+// commonly seccomp filters, loads of absolute offsets are annotated with the
+// struct seccomp_data field that they read.
+func Disassemble(p Program) string {
+	var b bytes.Buffer
+	for pc, i := range p.instructions {
+		disassemble(i, pc, &b)
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// disassemble writes the representation of instruction i at pc to b.
+func disassemble(i linux.BPFInstruction, pc int, b *bytes.Buffer) {
+	op, operand := mnemonic(i, pc)
+	if i.OpCode&instructionClassMask == Jmp && i.OpCode&jmpMask != Ja {
+		fmt.Fprintf(b, "(%03d) %-8s %-16s jt %d\tjf %d", pc, op, operand, pc+int(i.JumpIfTrue)+1, pc+int(i.JumpIfFalse)+1)
+		return
+	}
+	fmt.Fprintf(b, "(%03d) %-8s %s", pc, op, operand)
+	if i.OpCode&instructionClassMask == Ld && i.OpCode&loadModeMask == Abs {
+		fmt.Fprintf(b, "\t; %s", seccompDataField(i.K))
+	}
+}
+
+// mnemonic returns the opcode and operand of instruction i at pc, as printed
+// by tcpdump -d.
+func mnemonic(i linux.BPFInstruction, pc int) (string, string) {
+	switch i.OpCode {
+	case Ret | K:
+		return "ret", fmt.Sprintf("#%d", i.K)
+	case Ret | A:
+		return "ret", ""
+	case Ld | Abs | W:
+		return "ld", fmt.Sprintf("[%d]", i.K)
+	case Ld | Abs | H:
+		return "ldh", fmt.Sprintf("[%d]", i.K)
+	case Ld | Abs | B:
+		return "ldb", fmt.Sprintf("[%d]", i.K)
+	case Ld | Len | W:
+		return "ld", "#pktlen"
+	case Ld | Ind | W:
+		return "ld", fmt.Sprintf("[x + %d]", i.K)
+	case Ld | Ind | H:
+		return "ldh", fmt.Sprintf("[x + %d]", i.K)
+	case Ld | Ind | B:
+		return "ldb", fmt.Sprintf("[x + %d]", i.K)
+	case Ld | Imm | W:
+		return "ld", fmt.Sprintf("#0x%x", i.K)
+	case Ldx | Imm | W:
+		return "ldx", fmt.Sprintf("#0x%x", i.K)
+	case Ldx | Msh | B:
+		return "ldxb", fmt.Sprintf("4*([%d]&0xf)", i.K)
+	case Ld | Mem | W:
+		return "ld", fmt.Sprintf("M[%d]", i.K)
+	case Ldx | Mem | W:
+		return "ldx", fmt.Sprintf("M[%d]", i.K)
+	case Ldx | Len | W:
+		return "ldx", "#pktlen"
+	case St:
+		return "st", fmt.Sprintf("M[%d]", i.K)
+	case Stx:
+		return "stx", fmt.Sprintf("M[%d]", i.K)
+	case Jmp | Ja:
+		return "ja", fmt.Sprintf("%d", pc+int(i.K)+1)
+	case Alu | Neg:
+		return "neg", ""
+	case Misc | Tax:
+		return "tax", ""
+	case Misc | Txa:
+		return "txa", ""
+	}
+
+	switch i.OpCode & instructionClassMask {
+	case Alu:
+		if op, ok := aluMnemonics[i.OpCode&aluMask]; ok {
+			if i.OpCode&srcAluJmpMask == X {
+				return op, "x"
+			}
+			return op, fmt.Sprintf("#%d", i.K)
+		}
+	case Jmp:
+		if op, ok := jmpMnemonics[i.OpCode&jmpMask]; ok {
+			if i.OpCode&srcAluJmpMask == X {
+				return op, "x"
+			}
+			return op, fmt.Sprintf("#0x%x", i.K)
+		}
+	}
+	return "unimp", fmt.Sprintf("0x%x", i.OpCode)
+}
+
+// aluMnemonics maps ALU operations that take a source operand to their
+// mnemonics.
+var aluMnemonics = map[uint16]string{
+	Add: "add",
+	Sub: "sub",
+	Mul: "mul",
+	Div: "div",
+	Mod: "mod",
+	And: "and",
+	Or:  "or",
+	Xor: "xor",
+	Lsh: "lsh",
+	Rsh: "rsh",
+}
+
+// jmpMnemonics maps conditional jump operations to their mnemonics.
+var jmpMnemonics = map[uint16]string{
+	Jeq:  "jeq",
+	Jgt:  "jgt",
+	Jge:  "jge",
+	Jset: "jset",
+}
+
+// seccompDataFields are the fields of struct seccomp_data, in order.
+var seccompDataFields = []struct {
+	name string
+	size uint32
+}{
+	{"nr", 4},
+	{"arch", 4},
+	{"instruction_pointer", 8},
+	{"args[0]", 8},
+	{"args[1]", 8},
+	{"args[2]", 8},
+	{"args[3]", 8},
+	{"args[4]", 8},
+	{"args[5]", 8},
+}
+
+// seccompDataField returns the name of the struct seccomp_data field at byte
+// offset off, followed by the offset within the field if it is not 0.
+func seccompDataField(off uint32) string {
+	start := uint32(0)
+	for _, f := range seccompDataFields {
+		if off < start+f.size {
+			if off == start {
+				return f.name
+			}
+			return fmt.Sprintf("%s+%d", f.name, off-start)
+		}
+		start += f.size
+	}
+	return "out of bounds"
+}
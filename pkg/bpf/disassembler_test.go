@@ -0,0 +1,91 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bpf
+
+import (
+	"testing"
+
+	"gvisor.googlesource.com/gvisor/pkg/abi/linux"
+)
+
+func TestDisassembleSeccompFilter(t *testing.T) {
+	// Filter generated by libseccomp for an x86-64 allowlist of read(2),
+	// write(2) to fd 1, and exit_group(2), killing the process for all other
+	// system calls.
+	p, err := Compile([]linux.BPFInstruction{
+		{OpCode: 0x20, JumpIfTrue: 0x00, JumpIfFalse: 0x00, K: 0x00000004},
+		{OpCode: 0x15, JumpIfTrue: 0x00, JumpIfFalse: 0x0b, K: 0xc000003e},
+		{OpCode: 0x20, JumpIfTrue: 0x00, JumpIfFalse: 0x00, K: 0x00000000},
+		{OpCode: 0x35, JumpIfTrue: 0x00, JumpIfFalse: 0x01, K: 0x40000000},
+		{OpCode: 0x15, JumpIfTrue: 0x00, JumpIfFalse: 0x08, K: 0xffffffff},
+		{OpCode: 0x15, JumpIfTrue: 0x06, JumpIfFalse: 0x00, K: 0x00000000},
+		{OpCode: 0x15, JumpIfTrue: 0x05, JumpIfFalse: 0x00, K: 0x000000e7},
+		{OpCode: 0x15, JumpIfTrue: 0x00, JumpIfFalse: 0x05, K: 0x00000001},
+		{OpCode: 0x20, JumpIfTrue: 0x00, JumpIfFalse: 0x00, K: 0x00000014},
+		{OpCode: 0x15, JumpIfTrue: 0x00, JumpIfFalse: 0x03, K: 0x00000000},
+		{OpCode: 0x20, JumpIfTrue: 0x00, JumpIfFalse: 0x00, K: 0x00000010},
+		{OpCode: 0x15, JumpIfTrue: 0x00, JumpIfFalse: 0x01, K: 0x00000001},
+		{OpCode: 0x06, JumpIfTrue: 0x00, JumpIfFalse: 0x00, K: 0x7fff0000},
+		{OpCode: 0x06, JumpIfTrue: 0x00, JumpIfFalse: 0x00, K: 0x80000000},
+	})
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	want := "(000) ld       [4]\t; arch\n" +
+		"(001) jeq      #0xc000003e      jt 2\tjf 13\n" +
+		"(002) ld       [0]\t; nr\n" +
+		"(003) jge      #0x40000000      jt 4\tjf 5\n" +
+		"(004) jeq      #0xffffffff      jt 5\tjf 13\n" +
+		"(005) jeq      #0x0             jt 12\tjf 6\n" +
+		"(006) jeq      #0xe7            jt 12\tjf 7\n" +
+		"(007) jeq      #0x1             jt 8\tjf 13\n" +
+		"(008) ld       [20]\t; args[0]+4\n" +
+		"(009) jeq      #0x0             jt 10\tjf 13\n" +
+		"(010) ld       [16]\t; args[0]\n" +
+		"(011) jeq      #0x1             jt 12\tjf 13\n" +
+		"(012) ret      #2147418112\n" +
+		"(013) ret      #2147483648\n"
+	if got := Disassemble(p); got != want {
+		t.Errorf("Disassemble got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestDisassembleInstructions(t *testing.T) {
+	for _, test := range []struct {
+		insn linux.BPFInstruction
+		want string
+	}{
+		{insn: Stmt(Ld|Imm|W, 10), want: "(000) ld       #0xa"},
+		{insn: Stmt(Ld|Abs|H, 9), want: "(000) ldh      [9]\t; instruction_pointer+1"},
+		{insn: Stmt(Ld|Abs|B, 64), want: "(000) ldb      [64]\t; out of bounds"},
+		{insn: Stmt(Ld|Ind|W, 2), want: "(000) ld       [x + 2]"},
+		{insn: Stmt(Ld|Mem|W, 3), want: "(000) ld       M[3]"},
+		{insn: Stmt(Ld|Len|W, 0), want: "(000) ld       #pktlen"},
+		{insn: Stmt(Ldx|Msh|B, 14), want: "(000) ldxb     4*([14]&0xf)"},
+		{insn: Stmt(Stx, 15), want: "(000) stx      M[15]"},
+		{insn: Stmt(Alu|Add|K, 10), want: "(000) add      #10"},
+		{insn: Stmt(Alu|Rsh|X, 0), want: "(000) rsh      x"},
+		{insn: Stmt(Alu|Neg, 0), want: "(000) neg      "},
+		{insn: Stmt(Jmp|Ja, 5), want: "(000) ja       6"},
+		{insn: Jump(Jmp|Jset|X, 0, 1, 2), want: "(000) jset     x                jt 2\tjf 3"},
+		{insn: Stmt(Ret|A, 0), want: "(000) ret      "},
+		{insn: Stmt(Misc|Tax, 0), want: "(000) tax      "},
+		{insn: Stmt(Alu|0xf0, 0), want: "(000) unimp    0xf4"},
+	} {
+		if got := Disassemble(Program{[]linux.BPFInstruction{test.insn}}); got != test.want+"\n" {
+			t.Errorf("Disassemble(%v) got %q, want %q", test.insn, got, test.want+"\n")
+		}
+	}
+}
@@ -0,0 +1,503 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bpf
+
+import (
+	"gvisor.googlesource.com/gvisor/pkg/abi/linux"
+)
+
+// Executable is a Program that has been lowered into a sequence of Go
+// closures, which avoids Exec's per-instruction opcode decoding. Runs of
+// comparisons of the same value against a list of constants, which dominate
+// typical seccomp filters, are executed by a single closure. Executing an
+// Executable produces the same results, including errors, as executing the
+// Program it was created from with Exec, even if the Program was not
+// validated: jumps out of bounds fail with InvalidJumpTarget, and running off
+// the end of the program fails with InvalidEndOfProgram. Since every step
+// moves forward, an Executable can never exceed Exec's execution limit. Like
+// Programs, Executables are immutable and may be executed concurrently.
+type Executable struct {
+	// steps contains one step per instruction in the program, followed by a
+	// final step that reports InvalidEndOfProgram. The step for an
+	// instruction may also execute the instructions that follow it.
+	steps []step
+}
+
+// executableMachine is the state of an Executable during execution.
+type executableMachine struct {
+	machine
+
+	// ret is the program's return value, valid once a step has returned
+	// stepReturn.
+	ret uint32
+}
+
+// step executes a single instruction. It returns the index of the next
+// instruction to execute, or stepReturn if the program has returned. If the
+// instruction fails, step returns an error.
+type step func(m *executableMachine, in Input) (int, error)
+
+// stepReturn is returned by a step that terminates the program.
+const stepReturn = -1
+
+// NewExecutable lowers p into an Executable.
+func NewExecutable(p Program) *Executable {
+	n := len(p.instructions)
+	steps := make([]step, 0, n+1)
+	chain := newJeqChain(p.instructions)
+	for pc, i := range p.instructions {
+		if chain.length(pc) > 1 {
+			steps = append(steps, chain.lower(pc, n))
+			continue
+		}
+		s := lower(i, pc)
+		if i.OpCode&instructionClassMask == Jmp && maxJumpTarget(i, pc) >= n {
+			s = checkJumpTarget(s, pc, n)
+		}
+		steps = append(steps, s)
+	}
+	// Only a non-jump instruction can reach the final step, since jumps out
+	// of bounds are checked above, so as for Exec, the error is reported at
+	// the last instruction.
+	last := n - 1
+	if last < 0 {
+		last = 0
+	}
+	steps = append(steps, func(*executableMachine, Input) (int, error) {
+		return 0, Error{InvalidEndOfProgram, last}
+	})
+	return &Executable{steps}
+}
+
+// Exec executes e over the given input and returns its return value.
+func (e *Executable) Exec(in Input) (uint32, error) {
+	var m executableMachine
+	pc := 0
+	for {
+		next, err := e.steps[pc](&m, in)
+		if err != nil {
+			return 0, err
+		}
+		if next == stepReturn {
+			return m.ret, nil
+		}
+		pc = next
+	}
+}
+
+// maxJumpTarget returns the index of the furthest instruction to which jump
+// instruction i at index pc may jump.
+func maxJumpTarget(i linux.BPFInstruction, pc int) int {
+	if i.OpCode == Jmp|Ja {
+		return pc + 1 + int(i.K)
+	}
+	if i.JumpIfTrue > i.JumpIfFalse {
+		return pc + 1 + int(i.JumpIfTrue)
+	}
+	return pc + 1 + int(i.JumpIfFalse)
+}
+
+// checkJumpTarget returns a step that executes s, the step for the jump
+// instruction at index pc of a program of n instructions, and fails with
+// InvalidJumpTarget if s jumps out of bounds. This is only needed for programs
+// that were not validated, so validated programs don't pay for the check.
+func checkJumpTarget(s step, pc, n int) step {
+	return func(m *executableMachine, in Input) (int, error) {
+		next, err := s(m, in)
+		if err == nil && next >= n {
+			return 0, Error{InvalidJumpTarget, pc}
+		}
+		return next, err
+	}
+}
+
+// jeqChain describes runs of consecutive "jeq #k" instructions that fall
+// through to the next instruction when the comparison is false. Such runs are
+// how libseccomp tests the syscall number against a list of syscalls, and
+// executing each run as a single step avoids dispatching on every comparison.
+type jeqChain struct {
+	// keys[pc] is the K of the instruction at pc, if it is part of a run.
+	keys []uint32
+
+	// targets[pc] is the absolute jump target of the instruction at pc if its
+	// comparison is true, if it is part of a run.
+	targets []int
+
+	// ends[pc] is the index of the first instruction after the run that
+	// includes pc, or pc if pc isn't part of a run.
+	ends []int
+}
+
+func newJeqChain(insns []linux.BPFInstruction) *jeqChain {
+	c := &jeqChain{
+		keys:    make([]uint32, len(insns)),
+		targets: make([]int, len(insns)),
+		ends:    make([]int, len(insns)),
+	}
+	end := len(insns)
+	for pc := len(insns) - 1; pc >= 0; pc-- {
+		i := insns[pc]
+		if i.OpCode != Jmp|Jeq|K || i.JumpIfFalse != 0 {
+			c.ends[pc] = pc
+			end = pc
+			continue
+		}
+		c.keys[pc] = i.K
+		c.targets[pc] = pc + 1 + int(i.JumpIfTrue)
+		c.ends[pc] = end
+	}
+	return c
+}
+
+// length returns the number of instructions in the run starting at pc.
+func (c *jeqChain) length(pc int) int {
+	return c.ends[pc] - pc
+}
+
+// lower returns a step that executes the run starting at pc in a program of n
+// instructions.
+func (c *jeqChain) lower(pc, n int) step {
+	end := c.ends[pc]
+	keys := c.keys[pc:end]
+	targets := c.targets[pc:end]
+	inBounds := end < n
+	for _, target := range targets {
+		if target >= n {
+			inBounds = false
+		}
+	}
+	if !inBounds {
+		// As for checkJumpTarget, the jump that leaves the program is
+		// reported, which is the last in the run if no comparison is true.
+		return func(m *executableMachine, in Input) (int, error) {
+			for j, k := range keys {
+				if m.A == k {
+					if targets[j] >= n {
+						return 0, Error{InvalidJumpTarget, pc + j}
+					}
+					return targets[j], nil
+				}
+			}
+			if end >= n {
+				return 0, Error{InvalidJumpTarget, end - 1}
+			}
+			return end, nil
+		}
+	}
+	return func(m *executableMachine, in Input) (int, error) {
+		for j, k := range keys {
+			if m.A == k {
+				return targets[j], nil
+			}
+		}
+		return end, nil
+	}
+}
+
+// branch returns jt if cond is true and jf otherwise.
+func branch(cond bool, jt, jf int) int {
+	if cond {
+		return jt
+	}
+	return jf
+}
+
+// lower returns the step for instruction i at index pc. The semantics of each
+// step must match the corresponding case in Exec.
+func lower(i linux.BPFInstruction, pc int) step {
+	k := i.K
+	next := pc + 1
+	jt := next + int(i.JumpIfTrue)
+	jf := next + int(i.JumpIfFalse)
+	switch i.OpCode {
+	case Ld | Imm | W:
+		return func(m *executableMachine, in Input) (int, error) {
+			m.A = k
+			return next, nil
+		}
+	case Ld | Abs | W:
+		return func(m *executableMachine, in Input) (int, error) {
+			val, ok := in.Load32(k)
+			if !ok {
+				return 0, Error{InvalidLoad, pc}
+			}
+			m.A = val
+			return next, nil
+		}
+	case Ld | Abs | H:
+		return func(m *executableMachine, in Input) (int, error) {
+			val, ok := in.Load16(k)
+			if !ok {
+				return 0, Error{InvalidLoad, pc}
+			}
+			m.A = uint32(val)
+			return next, nil
+		}
+	case Ld | Abs | B:
+		return func(m *executableMachine, in Input) (int, error) {
+			val, ok := in.Load8(k)
+			if !ok {
+				return 0, Error{InvalidLoad, pc}
+			}
+			m.A = uint32(val)
+			return next, nil
+		}
+	case Ld | Ind | W:
+		return func(m *executableMachine, in Input) (int, error) {
+			val, ok := in.Load32(m.X + k)
+			if !ok {
+				return 0, Error{InvalidLoad, pc}
+			}
+			m.A = val
+			return next, nil
+		}
+	case Ld | Ind | H:
+		return func(m *executableMachine, in Input) (int, error) {
+			val, ok := in.Load16(m.X + k)
+			if !ok {
+				return 0, Error{InvalidLoad, pc}
+			}
+			m.A = uint32(val)
+			return next, nil
+		}
+	case Ld | Ind | B:
+		return func(m *executableMachine, in Input) (int, error) {
+			val, ok := in.Load8(m.X + k)
+			if !ok {
+				return 0, Error{InvalidLoad, pc}
+			}
+			m.A = uint32(val)
+			return next, nil
+		}
+	case Ld | Mem | W:
+		return func(m *executableMachine, in Input) (int, error) {
+			m.A = m.M[int(k)]
+			return next, nil
+		}
+	case Ld | Len | W:
+		return func(m *executableMachine, in Input) (int, error) {
+			m.A = in.Length()
+			return next, nil
+		}
+	case Ldx | Imm | W:
+		return func(m *executableMachine, in Input) (int, error) {
+			m.X = k
+			return next, nil
+		}
+	case Ldx | Mem | W:
+		return func(m *executableMachine, in Input) (int, error) {
+			m.X = m.M[int(k)]
+			return next, nil
+		}
+	case Ldx | Len | W:
+		return func(m *executableMachine, in Input) (int, error) {
+			m.X = in.Length()
+			return next, nil
+		}
+	case Ldx | Msh | B:
+		return func(m *executableMachine, in Input) (int, error) {
+			val, ok := in.Load8(k)
+			if !ok {
+				return 0, Error{InvalidLoad, pc}
+			}
+			m.X = 4 * uint32(val&0xf)
+			return next, nil
+		}
+	case St:
+		return func(m *executableMachine, in Input) (int, error) {
+			m.M[int(k)] = m.A
+			return next, nil
+		}
+	case Stx:
+		return func(m *executableMachine, in Input) (int, error) {
+			m.M[int(k)] = m.X
+			return next, nil
+		}
+	case Alu | Add | K:
+		return func(m *executableMachine, in Input) (int, error) {
+			m.A += k
+			return next, nil
+		}
+	case Alu | Add | X:
+		return func(m *executableMachine, in Input) (int, error) {
+			m.A += m.X
+			return next, nil
+		}
+	case Alu | Sub | K:
+		return func(m *executableMachine, in Input) (int, error) {
+			m.A -= k
+			return next, nil
+		}
+	case Alu | Sub | X:
+		return func(m *executableMachine, in Input) (int, error) {
+			m.A -= m.X
+			return next, nil
+		}
+	case Alu | Mul | K:
+		return func(m *executableMachine, in Input) (int, error) {
+			m.A *= k
+			return next, nil
+		}
+	case Alu | Mul | X:
+		return func(m *executableMachine, in Input) (int, error) {
+			m.A *= m.X
+			return next, nil
+		}
+	case Alu | Div | K:
+		// K != 0 already checked by Compile.
+		return func(m *executableMachine, in Input) (int, error) {
+			m.A /= k
+			return next, nil
+		}
+	case Alu | Div | X:
+		return func(m *executableMachine, in Input) (int, error) {
+			if m.X == 0 {
+				return 0, Error{DivisionByZero, pc}
+			}
+			m.A /= m.X
+			return next, nil
+		}
+	case Alu | Or | K:
+		return func(m *executableMachine, in Input) (int, error) {
+			m.A |= k
+			return next, nil
+		}
+	case Alu | Or | X:
+		return func(m *executableMachine, in Input) (int, error) {
+			m.A |= m.X
+			return next, nil
+		}
+	case Alu | And | K:
+		return func(m *executableMachine, in Input) (int, error) {
+			m.A &= k
+			return next, nil
+		}
+	case Alu | And | X:
+		return func(m *executableMachine, in Input) (int, error) {
+			m.A &= m.X
+			return next, nil
+		}
+	case Alu | Lsh | K:
+		return func(m *executableMachine, in Input) (int, error) {
+			m.A <<= k
+			return next, nil
+		}
+	case Alu | Lsh | X:
+		return func(m *executableMachine, in Input) (int, error) {
+			m.A <<= m.X
+			return next, nil
+		}
+	case Alu | Rsh | K:
+		return func(m *executableMachine, in Input) (int, error) {
+			m.A >>= k
+			return next, nil
+		}
+	case Alu | Rsh | X:
+		return func(m *executableMachine, in Input) (int, error) {
+			m.A >>= m.X
+			return next, nil
+		}
+	case Alu | Neg:
+		return func(m *executableMachine, in Input) (int, error) {
+			m.A = uint32(-int32(m.A))
+			return next, nil
+		}
+	case Alu | Mod | K:
+		// K != 0 already checked by Compile.
+		return func(m *executableMachine, in Input) (int, error) {
+			m.A %= k
+			return next, nil
+		}
+	case Alu | Mod | X:
+		return func(m *executableMachine, in Input) (int, error) {
+			if m.X == 0 {
+				return 0, Error{DivisionByZero, pc}
+			}
+			m.A %= m.X
+			return next, nil
+		}
+	case Alu | Xor | K:
+		return func(m *executableMachine, in Input) (int, error) {
+			m.A ^= k
+			return next, nil
+		}
+	case Alu | Xor | X:
+		return func(m *executableMachine, in Input) (int, error) {
+			m.A ^= m.X
+			return next, nil
+		}
+	case Jmp | Ja:
+		target := next + int(k)
+		return func(m *executableMachine, in Input) (int, error) {
+			return target, nil
+		}
+	case Jmp | Jeq | K:
+		return func(m *executableMachine, in Input) (int, error) {
+			return branch(m.A == k, jt, jf), nil
+		}
+	case Jmp | Jeq | X:
+		return func(m *executableMachine, in Input) (int, error) {
+			return branch(m.A == m.X, jt, jf), nil
+		}
+	case Jmp | Jgt | K:
+		return func(m *executableMachine, in Input) (int, error) {
+			return branch(m.A > k, jt, jf), nil
+		}
+	case Jmp | Jgt | X:
+		return func(m *executableMachine, in Input) (int, error) {
+			return branch(m.A > m.X, jt, jf), nil
+		}
+	case Jmp | Jge | K:
+		return func(m *executableMachine, in Input) (int, error) {
+			return branch(m.A >= k, jt, jf), nil
+		}
+	case Jmp | Jge | X:
+		return func(m *executableMachine, in Input) (int, error) {
+			return branch(m.A >= m.X, jt, jf), nil
+		}
+	case Jmp | Jset | K:
+		return func(m *executableMachine, in Input) (int, error) {
+			return branch((m.A&k) != 0, jt, jf), nil
+		}
+	case Jmp | Jset | X:
+		return func(m *executableMachine, in Input) (int, error) {
+			return branch((m.A&m.X) != 0, jt, jf), nil
+		}
+	case Ret | K:
+		return func(m *executableMachine, in Input) (int, error) {
+			m.ret = k
+			return stepReturn, nil
+		}
+	case Ret | A:
+		return func(m *executableMachine, in Input) (int, error) {
+			m.ret = m.A
+			return stepReturn, nil
+		}
+	case Misc | Tax:
+		return func(m *executableMachine, in Input) (int, error) {
+			m.A = m.X
+			return next, nil
+		}
+	case Misc | Txa:
+		return func(m *executableMachine, in Input) (int, error) {
+			m.X = m.A
+			return next, nil
+		}
+	default:
+		return func(*executableMachine, Input) (int, error) {
+			return 0, Error{InvalidOpcode, pc}
+		}
+	}
+}
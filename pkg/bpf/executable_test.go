@@ -0,0 +1,314 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bpf
+
+import (
+	"math/rand"
+	"testing"
+
+	"gvisor.googlesource.com/gvisor/pkg/abi/linux"
+	"gvisor.googlesource.com/gvisor/pkg/binary"
+)
+
+// validOpcodes is the set of all opcodes accepted by Compile.
+var validOpcodes = []uint16{
+	Ld | Imm | W, Ld | Abs | W, Ld | Abs | H, Ld | Abs | B,
+	Ld | Ind | W, Ld | Ind | H, Ld | Ind | B, Ld | Mem | W, Ld | Len | W,
+	Ldx | Imm | W, Ldx | Mem | W, Ldx | Len | W, Ldx | Msh | B,
+	St, Stx,
+	Alu | Add | K, Alu | Add | X, Alu | Sub | K, Alu | Sub | X,
+	Alu | Mul | K, Alu | Mul | X, Alu | Div | K, Alu | Div | X,
+	Alu | Or | K, Alu | Or | X, Alu | And | K, Alu | And | X,
+	Alu | Lsh | K, Alu | Lsh | X, Alu | Rsh | K, Alu | Rsh | X,
+	Alu | Neg, Alu | Mod | K, Alu | Mod | X, Alu | Xor | K, Alu | Xor | X,
+	Jmp | Ja, Jmp | Jeq | K, Jmp | Jeq | X, Jmp | Jgt | K, Jmp | Jgt | X,
+	Jmp | Jge | K, Jmp | Jge | X, Jmp | Jset | K, Jmp | Jset | X,
+	Ret | K, Ret | A,
+	Misc | Tax, Misc | Txa,
+}
+
+// randomProgram returns a random valid program of at most maxLen
+// instructions. Immediate values are biased towards small numbers, so that
+// loads are often (but not always) in bounds and branches are taken both
+// ways. Runs of "jeq #k" instructions, which Executable handles specially, are
+// also favored.
+func randomProgram(r *rand.Rand, maxLen int) Program {
+	for {
+		n := 1 + r.Intn(maxLen)
+		insns := make([]linux.BPFInstruction, n)
+		for pc := range insns {
+			i := linux.BPFInstruction{OpCode: validOpcodes[r.Intn(len(validOpcodes))]}
+			if r.Intn(4) == 0 {
+				i.OpCode = Jmp | Jeq | K
+			}
+			if r.Intn(4) == 0 {
+				i.K = r.Uint32()
+			} else {
+				i.K = uint32(r.Intn(72))
+			}
+			switch i.OpCode {
+			case Ld | Mem | W, Ldx | Mem | W, St, Stx:
+				i.K %= ScratchMemRegisters
+			case Jmp | Ja:
+				i.K = uint32(r.Intn(n - pc))
+			}
+			if i.OpCode&instructionClassMask == Jmp {
+				i.JumpIfTrue = uint8(r.Intn(n - pc))
+				i.JumpIfFalse = uint8(r.Intn(n - pc))
+				if r.Intn(2) == 0 {
+					i.JumpIfFalse = 0
+				}
+			}
+			insns[pc] = i
+		}
+		insns[n-1] = Stmt(Ret|A, 0)
+		if p, err := Compile(insns); err == nil {
+			return p
+		}
+	}
+}
+
+func TestExecutableMatchesExec(t *testing.T) {
+	r := rand.New(rand.NewSource(0))
+	for iter := 0; iter < 10000; iter++ {
+		p := randomProgram(r, 32)
+		e := NewExecutable(p)
+		for j := 0; j < 8; j++ {
+			data := make([]byte, r.Intn(80))
+			r.Read(data)
+			in := InputBytes{data, binary.LittleEndian}
+			wantRet, wantErr := Exec(p, in)
+			gotRet, gotErr := e.Exec(in)
+			if gotRet != wantRet || gotErr != wantErr {
+				t.Fatalf("program %v with input %v: Executable.Exec got (%#x, %v), Exec got (%#x, %v)", p.instructions, data, gotRet, gotErr, wantRet, wantErr)
+			}
+		}
+	}
+}
+
+// randomUnvalidatedProgram returns a random program of at most maxLen
+// instructions that is not validated, so its jumps may leave the program and
+// it may not end with a return. Its instructions can still be executed by
+// Exec without panicking: scratch memory accesses are in bounds, and divisors
+// of K are not zero.
+func randomUnvalidatedProgram(r *rand.Rand, maxLen int) Program {
+	n := 1 + r.Intn(maxLen)
+	insns := make([]linux.BPFInstruction, n)
+	for pc := range insns {
+		i := linux.BPFInstruction{OpCode: validOpcodes[r.Intn(len(validOpcodes))]}
+		if r.Intn(4) == 0 {
+			i.OpCode = Jmp | Jeq | K
+		}
+		i.K = uint32(r.Intn(72))
+		switch i.OpCode {
+		case Ld | Mem | W, Ldx | Mem | W, St, Stx:
+			i.K %= ScratchMemRegisters
+		case Alu | Div | K, Alu | Mod | K:
+			i.K++
+		case Jmp | Ja:
+			i.K = uint32(r.Intn(n - pc + 1))
+		}
+		if i.OpCode&instructionClassMask == Jmp {
+			i.JumpIfTrue = uint8(r.Intn(n - pc + 1))
+			i.JumpIfFalse = uint8(r.Intn(n - pc + 1))
+			if r.Intn(2) == 0 {
+				i.JumpIfFalse = 0
+			}
+		}
+		insns[pc] = i
+	}
+	return Program{insns}
+}
+
+func TestExecutableMatchesExecUnvalidated(t *testing.T) {
+	r := rand.New(rand.NewSource(0))
+	for iter := 0; iter < 10000; iter++ {
+		p := randomUnvalidatedProgram(r, 16)
+		e := NewExecutable(p)
+		for j := 0; j < 8; j++ {
+			data := make([]byte, r.Intn(80))
+			r.Read(data)
+			in := InputBytes{data, binary.LittleEndian}
+			gotRet, gotErr := e.Exec(in)
+			wantRet, wantErr := Exec(p, in)
+			if gotRet != wantRet || gotErr != wantErr {
+				t.Fatalf("program %v with input %v: Executable.Exec got (%#x, %v), Exec got (%#x, %v)", p.instructions, data, gotRet, gotErr, wantRet, wantErr)
+			}
+		}
+	}
+}
+
+func TestExecutableUnvalidatedProgram(t *testing.T) {
+	for _, test := range []struct {
+		desc  string
+		insns []linux.BPFInstruction
+		want  error
+	}{
+		{
+			desc: "jump far out of bounds",
+			insns: []linux.BPFInstruction{
+				Stmt(Jmp|Ja, ^uint32(0)), // jump far out of bounds
+				Stmt(Ret|K, 0),           // return 0
+			},
+			want: Error{InvalidJumpTarget, 0},
+		},
+		{
+			desc: "jump just out of bounds",
+			insns: []linux.BPFInstruction{
+				Stmt(Jmp|Ja, 1), // jmp nextpc+1
+				Stmt(Ret|K, 0),  // return 0
+			},
+			want: Error{InvalidJumpTarget, 0},
+		},
+		{
+			desc: "conditional jump just out of bounds",
+			insns: []linux.BPFInstruction{
+				Stmt(Ld|Imm|W, 1),        // A = 1
+				Jump(Jmp|Jgt|K, 0, 1, 0), // if (A > 0) jmp nextpc+1
+				Stmt(Ret|K, 0),           // return 0
+			},
+			want: Error{InvalidJumpTarget, 1},
+		},
+		{
+			desc: "jeq run jumping out of bounds",
+			insns: []linux.BPFInstruction{
+				Stmt(Ld|Imm|W, 2),        // A = 2
+				Jump(Jmp|Jeq|K, 1, 2, 0), // if (A == 1) jmp nextpc+2
+				Jump(Jmp|Jeq|K, 2, 2, 0), // if (A == 2) jmp nextpc+2
+				Stmt(Ret|K, 0),           // return 0
+			},
+			want: Error{InvalidJumpTarget, 2},
+		},
+		{
+			desc: "jeq run falling off the end",
+			insns: []linux.BPFInstruction{
+				Stmt(Ld|Imm|W, 3),        // A = 3
+				Jump(Jmp|Jeq|K, 1, 1, 0), // if (A == 1) jmp nextpc+1
+				Jump(Jmp|Jeq|K, 2, 0, 0), // if (A == 2) jmp nextpc
+			},
+			want: Error{InvalidJumpTarget, 2},
+		},
+		{
+			desc: "no return",
+			insns: []linux.BPFInstruction{
+				Stmt(Ld|Imm|W, 0), // A = 0
+				Stmt(Ld|Imm|W, 1), // A = 1
+			},
+			want: Error{InvalidEndOfProgram, 1},
+		},
+		{
+			desc: "no instructions",
+			want: Error{InvalidEndOfProgram, 0},
+		},
+	} {
+		p := Program{test.insns}
+		in := InputBytes{nil, binary.BigEndian}
+		if ret, err := NewExecutable(p).Exec(in); err != test.want {
+			t.Errorf("Executable.Exec of %s: got (%d, %v), want error %v", test.desc, ret, err, test.want)
+		}
+		if ret, err := Exec(p, in); err != test.want {
+			t.Errorf("Exec of %s: got (%d, %v), want error %v", test.desc, ret, err, test.want)
+		}
+	}
+}
+
+func TestExecutableSimpleFilter(t *testing.T) {
+	p := libseccompFilter(t, 10)
+	e := NewExecutable(p)
+	for _, test := range []struct {
+		desc        string
+		seccompData seccompData
+		expectedRet uint32
+	}{
+		{
+			desc:        "Invalid arch is rejected",
+			seccompData: seccompData{nr: 0, arch: 0x40000003 /* AUDIT_ARCH_I386 */},
+			expectedRet: linux.SECCOMP_RET_KILL_PROCESS,
+		},
+		{
+			desc:        "Disallowed syscall is rejected",
+			seccompData: seccompData{nr: 10, arch: 0xc000003e},
+			expectedRet: linux.SECCOMP_RET_ERRNO | 1,
+		},
+		{
+			desc:        "Whitelisted syscall is allowed",
+			seccompData: seccompData{nr: 9, arch: 0xc000003e},
+			expectedRet: linux.SECCOMP_RET_ALLOW,
+		},
+	} {
+		ret, err := e.Exec(test.seccompData.asInput())
+		if err != nil {
+			t.Errorf("%s: expected return value of %#x, got execution error: %v", test.desc, test.expectedRet, err)
+			continue
+		}
+		if ret != test.expectedRet {
+			t.Errorf("%s: expected return value of %#x, got value %#x", test.desc, test.expectedRet, ret)
+		}
+	}
+}
+
+// libseccompFilter returns a filter with the structure generated by libseccomp
+// for an x86-64 allowlist of syscalls 0 through allowed-1, where each allowed
+// syscall is checked by a separate comparison.
+func libseccompFilter(tb testing.TB, allowed int) Program {
+	insns := []linux.BPFInstruction{
+		Stmt(Ld|Abs|W, 4), // arch
+		Jump(Jmp|Jeq|K, linux.AUDIT_ARCH_X86_64, 1, 0),
+		Stmt(Ret|K, linux.SECCOMP_RET_KILL_PROCESS),
+		Stmt(Ld|Abs|W, 0), // nr
+	}
+	for nr := 0; nr < allowed; nr++ {
+		// Jump to the final "allow" if equal.
+		insns = append(insns, Jump(Jmp|Jeq|K, uint32(nr), uint8(allowed-nr), 0))
+	}
+	insns = append(insns,
+		Stmt(Ret|K, linux.SECCOMP_RET_ERRNO|1),
+		Stmt(Ret|K, linux.SECCOMP_RET_ALLOW),
+	)
+	p, err := Compile(insns)
+	if err != nil {
+		tb.Fatalf("Compile failed: %v", err)
+	}
+	return p
+}
+
+// benchmarkAllowed is the number of syscalls allowed by the filter used by
+// benchmarks. The benchmarked syscall is the last one allowed, which is the
+// most expensive to evaluate.
+const benchmarkAllowed = 200
+
+func BenchmarkExec(b *testing.B) {
+	p := libseccompFilter(b, benchmarkAllowed)
+	data := seccompData{nr: benchmarkAllowed - 1, arch: linux.AUDIT_ARCH_X86_64}
+	in := data.asInput()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Exec(p, in); err != nil {
+			b.Fatalf("Exec failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkExecutable(b *testing.B) {
+	e := NewExecutable(libseccompFilter(b, benchmarkAllowed))
+	data := seccompData{nr: benchmarkAllowed - 1, arch: linux.AUDIT_ARCH_X86_64}
+	in := data.asInput()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := e.Exec(in); err != nil {
+			b.Fatalf("Executable.Exec failed: %v", err)
+		}
+	}
+}
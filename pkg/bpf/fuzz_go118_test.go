@@ -0,0 +1,39 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.18
+// +build go1.18
+
+package bpf
+
+import "testing"
+
+// FuzzSeccompProgram runs the checks of checkFuzzProgram on fuzzer-generated
+// programs and inputs. Native fuzzing requires Go 1.18, so this file is not
+// part of the bpf_test target; TestSeccompProgramRandomized runs the same
+// checks there.
+//
+// Run it with: go test -fuzz=FuzzSeccompProgram ./pkg/bpf
+func FuzzSeccompProgram(f *testing.F) {
+	for _, seed := range fuzzSeeds(f) {
+		f.Add(seed.prog, seed.data)
+	}
+	f.Fuzz(func(t *testing.T, prog []byte, data []byte) {
+		p, ok := decodeFuzzProgram(prog)
+		if !ok {
+			t.Skip("invalid program")
+		}
+		checkFuzzProgram(t, p, data)
+	})
+}
@@ -0,0 +1,173 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bpf
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"gvisor.googlesource.com/gvisor/pkg/abi/linux"
+	"gvisor.googlesource.com/gvisor/pkg/binary"
+)
+
+// fuzzInstructionSize is the size of an instruction encoded by
+// encodeFuzzProgram.
+const fuzzInstructionSize = 8
+
+// maxFuzzProgramLength is the maximum number of instructions decoded by
+// decodeFuzzProgram. It is less than 256, so that conditional jump offsets
+// can be reduced modulo the number of remaining instructions.
+const maxFuzzProgramLength = 255
+
+// encodeFuzzProgram returns the instructions of p, encoded as struct
+// sock_filter in little-endian byte order.
+func encodeFuzzProgram(p Program) []byte {
+	return binary.Marshal(nil, binary.LittleEndian, p.instructions)
+}
+
+// decodeFuzzProgram returns the program encoded in buf, as by
+// encodeFuzzProgram. To keep most fuzzer-generated programs valid, invalid
+// opcodes are mapped to validOpcodes, out-of-range jumps and scratch memory
+// indices are reduced modulo their valid ranges, and a final "ret a" is
+// appended if the last instruction isn't a return; valid programs are decoded
+// unchanged. It returns false if the resulting program is still invalid.
+func decodeFuzzProgram(buf []byte) (Program, bool) {
+	n := len(buf) / fuzzInstructionSize
+	if n > maxFuzzProgramLength {
+		n = maxFuzzProgramLength
+	}
+	insns := make([]linux.BPFInstruction, 0, n+1)
+	for pc := 0; pc < n; pc++ {
+		b := buf[pc*fuzzInstructionSize:]
+		i := linux.BPFInstruction{
+			OpCode:      binary.LittleEndian.Uint16(b),
+			JumpIfTrue:  b[2],
+			JumpIfFalse: b[3],
+			K:           binary.LittleEndian.Uint32(b[4:]),
+		}
+		if !isValidFuzzOpcode(i.OpCode) {
+			i.OpCode = validOpcodes[int(i.OpCode)%len(validOpcodes)]
+		}
+		// Jumps may target any later instruction, including the appended
+		// return.
+		remaining := n - pc
+		switch i.OpCode {
+		case Ld | Mem | W, Ldx | Mem | W, St, Stx:
+			i.K %= ScratchMemRegisters
+		case Jmp | Ja:
+			i.K %= uint32(remaining)
+		}
+		if i.OpCode&instructionClassMask == Jmp {
+			i.JumpIfTrue %= uint8(remaining)
+			i.JumpIfFalse %= uint8(remaining)
+		}
+		insns = append(insns, i)
+	}
+	if len(insns) == 0 || insns[len(insns)-1].OpCode&instructionClassMask != Ret {
+		insns = append(insns, Stmt(Ret|A, 0))
+	}
+	p, err := Compile(insns)
+	return p, err == nil
+}
+
+// isValidFuzzOpcode returns true if op is in validOpcodes.
+func isValidFuzzOpcode(op uint16) bool {
+	for _, valid := range validOpcodes {
+		if op == valid {
+			return true
+		}
+	}
+	return false
+}
+
+// fuzzSeed is an input to FuzzSeccompProgram.
+type fuzzSeed struct {
+	prog []byte
+	data []byte
+}
+
+// fuzzSeeds returns the seed corpus of FuzzSeccompProgram: random programs
+// with random inputs, and a libseccomp-style filter with inputs that take each
+// of its paths.
+func fuzzSeeds(tb testing.TB) []fuzzSeed {
+	var seeds []fuzzSeed
+	r := rand.New(rand.NewSource(0))
+	for i := 0; i < 64; i++ {
+		seeds = append(seeds, fuzzSeed{encodeFuzzProgram(randomOptimizerProgram(r, 32)), randomOptimizerInput(r)})
+	}
+	var data [64]byte // struct seccomp_data
+	binary.LittleEndian.PutUint32(data[4:], linux.AUDIT_ARCH_X86_64)
+	for _, nr := range []uint32{0, 9, 10} {
+		binary.LittleEndian.PutUint32(data[0:], nr)
+		seeds = append(seeds, fuzzSeed{encodeFuzzProgram(libseccompFilter(tb, 10)), append([]byte(nil), data[:]...)})
+	}
+	return seeds
+}
+
+// checkFuzzProgram checks that the interpreter, the optimizer and Executable
+// agree on the result of valid program p for input data. Since seccomp
+// filters are the main users of all three, any divergence between them
+// changes the outcome of system calls made by sandboxed applications.
+func checkFuzzProgram(t *testing.T, p Program, data []byte) {
+	o := Optimize(p)
+	if err := Validate(o.instructions); err != nil {
+		t.Fatalf("program %v: optimized program %v is invalid: %v", p.instructions, o.instructions, err)
+	}
+
+	in := InputBytes{data, binary.LittleEndian}
+	wantRet, wantErr := Exec(p, in)
+	for _, impl := range []struct {
+		name string
+		exec func(Input) (uint32, error)
+	}{
+		{"Executable", NewExecutable(p).Exec},
+		{"optimized Exec", func(in Input) (uint32, error) { return Exec(o, in) }},
+		{"optimized Executable", NewExecutable(o).Exec},
+	} {
+		gotRet, gotErr := impl.exec(in)
+		// The optimizer may move the instruction at which execution fails,
+		// so only error codes are compared.
+		if gotRet != wantRet || (gotErr == nil) != (wantErr == nil) {
+			t.Fatalf("program %v (optimized to %v) with input %v: %s got (%#x, %v), Exec got (%#x, %v)", p.instructions, o.instructions, data, impl.name, gotRet, gotErr, wantRet, wantErr)
+		}
+		if gotErr != nil && gotErr.(Error).Code != wantErr.(Error).Code {
+			t.Fatalf("program %v (optimized to %v) with input %v: %s got error %v, Exec got %v", p.instructions, o.instructions, data, impl.name, gotErr, wantErr)
+		}
+	}
+}
+
+// TestSeccompProgramRandomized runs the checks of FuzzSeccompProgram on its
+// seed corpus and on programs decoded from random bytes, so that they are
+// run by toolchains without native fuzzing.
+func TestSeccompProgramRandomized(t *testing.T) {
+	// The seeds are valid programs, so they must be decoded unchanged.
+	for _, seed := range fuzzSeeds(t) {
+		p, ok := decodeFuzzProgram(seed.prog)
+		if !ok || !bytes.Equal(encodeFuzzProgram(p), seed.prog) {
+			t.Fatalf("seed program %x decoded to %v, want it unchanged", seed.prog, p.instructions)
+		}
+		checkFuzzProgram(t, p, seed.data)
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		prog := make([]byte, r.Intn(64*fuzzInstructionSize))
+		r.Read(prog)
+		if p, ok := decodeFuzzProgram(prog); ok {
+			checkFuzzProgram(t, p, randomOptimizerInput(r))
+		}
+	}
+}
@@ -26,6 +26,10 @@ const (
 	// division or modulo by zero.
 	DivisionByZero = iota
 
+	// ExecutionLimitExceeded indicates that a program executed more
+	// instructions than it contains, which is impossible for valid programs.
+	ExecutionLimitExceeded
+
 	// InvalidEndOfProgram indicates that the last instruction of a program is
 	// not a return.
 	InvalidEndOfProgram
@@ -61,10 +65,18 @@ type Error struct {
 	PC int
 }
 
+// Kind returns a description of the kind of error that occurred, as indicated
+// by e.Code, such as "division by zero".
+func (e Error) Kind() string {
+	return e.codeString()
+}
+
 func (e Error) codeString() string {
 	switch e.Code {
 	case DivisionByZero:
 		return "division by zero"
+	case ExecutionLimitExceeded:
+		return "too many instructions executed"
 	case InvalidEndOfProgram:
 		return "last instruction must be a return"
 	case InvalidInstructionCount:
@@ -89,26 +101,49 @@ func (e Error) Error() string {
 
 // Program is a BPF program that has been validated for consistency.
 //
+// Programs are immutable, so a Program may be shared and executed
+// concurrently, such as by all tasks whose seccomp filters are synchronized
+// by SECCOMP_FILTER_FLAG_TSYNC.
+//
 // +stateify savable
 type Program struct {
 	instructions []linux.BPFInstruction
 }
 
-// Length returns the number of instructions in the program.
+// Length returns the number of instructions in the program. Length is O(1).
 func (p Program) Length() int {
 	return len(p.instructions)
 }
 
+// Instructions returns a copy of the instructions in the program.
+func (p Program) Instructions() []linux.BPFInstruction {
+	return append([]linux.BPFInstruction(nil), p.instructions...)
+}
+
 // Compile performs validation on a sequence of BPF instructions before
-// wrapping them in a Program.
+// wrapping them in a Program. The Program holds a copy of insns, so later
+// changes to insns don't affect it.
 func Compile(insns []linux.BPFInstruction) (Program, error) {
+	// Copy before validating, so that the validated instructions are the
+	// ones that the Program executes.
+	insns = append([]linux.BPFInstruction(nil), insns...)
+	if err := Validate(insns); err != nil {
+		return Program{}, err
+	}
+	return Program{insns}, nil
+}
+
+// Validate checks that a sequence of BPF instructions is a valid BPF program,
+// as for Linux's net/core/filter.c:bpf_check_classic(). If it is not, Validate
+// returns an Error describing the first problem found.
+func Validate(insns []linux.BPFInstruction) error {
 	if len(insns) == 0 || len(insns) > MaxInstructions {
-		return Program{}, Error{InvalidInstructionCount, len(insns)}
+		return Error{InvalidInstructionCount, len(insns)}
 	}
 
 	// The last instruction must be a return.
 	if last := insns[len(insns)-1]; last.OpCode != (Ret|K) && last.OpCode != (Ret|A) {
-		return Program{}, Error{InvalidEndOfProgram, len(insns) - 1}
+		return Error{InvalidEndOfProgram, len(insns) - 1}
 	}
 
 	// Validate each instruction. Note that we skip a validation Linux does:
@@ -119,7 +154,7 @@ func Compile(insns []linux.BPFInstruction) (Program, error) {
 	// M array.
 	for pc, i := range insns {
 		if i.OpCode&unusedBitsMask != 0 {
-			return Program{}, Error{InvalidOpcode, pc}
+			return Error{InvalidOpcode, pc}
 		}
 		switch i.OpCode & instructionClassMask {
 		case Ld:
@@ -127,41 +162,41 @@ func Compile(insns []linux.BPFInstruction) (Program, error) {
 			switch i.OpCode & loadSizeMask {
 			case W:
 				if mode != Imm && mode != Abs && mode != Ind && mode != Mem && mode != Len {
-					return Program{}, Error{InvalidOpcode, pc}
+					return Error{InvalidOpcode, pc}
 				}
 				if mode == Mem && i.K >= ScratchMemRegisters {
-					return Program{}, Error{InvalidRegister, pc}
+					return Error{InvalidRegister, pc}
 				}
 			case H, B:
 				if mode != Abs && mode != Ind {
-					return Program{}, Error{InvalidOpcode, pc}
+					return Error{InvalidOpcode, pc}
 				}
 			default:
-				return Program{}, Error{InvalidOpcode, pc}
+				return Error{InvalidOpcode, pc}
 			}
 		case Ldx:
 			mode := i.OpCode & loadModeMask
 			switch i.OpCode & loadSizeMask {
 			case W:
 				if mode != Imm && mode != Mem && mode != Len {
-					return Program{}, Error{InvalidOpcode, pc}
+					return Error{InvalidOpcode, pc}
 				}
 				if mode == Mem && i.K >= ScratchMemRegisters {
-					return Program{}, Error{InvalidRegister, pc}
+					return Error{InvalidRegister, pc}
 				}
 			case B:
 				if mode != Msh {
-					return Program{}, Error{InvalidOpcode, pc}
+					return Error{InvalidOpcode, pc}
 				}
 			default:
-				return Program{}, Error{InvalidOpcode, pc}
+				return Error{InvalidOpcode, pc}
 			}
 		case St, Stx:
 			if i.OpCode&storeUnusedBitsMask != 0 {
-				return Program{}, Error{InvalidOpcode, pc}
+				return Error{InvalidOpcode, pc}
 			}
 			if i.K >= ScratchMemRegisters {
-				return Program{}, Error{InvalidRegister, pc}
+				return Error{InvalidRegister, pc}
 			}
 		case Alu:
 			switch i.OpCode & aluMask {
@@ -169,54 +204,54 @@ func Compile(insns []linux.BPFInstruction) (Program, error) {
 				break
 			case Div, Mod:
 				if src := i.OpCode & srcAluJmpMask; src == K && i.K == 0 {
-					return Program{}, Error{DivisionByZero, pc}
+					return Error{DivisionByZero, pc}
 				}
 			case Neg:
 				// Negation doesn't take a source operand.
 				if i.OpCode&srcAluJmpMask != 0 {
-					return Program{}, Error{InvalidOpcode, pc}
+					return Error{InvalidOpcode, pc}
 				}
 			default:
-				return Program{}, Error{InvalidOpcode, pc}
+				return Error{InvalidOpcode, pc}
 			}
 		case Jmp:
 			switch i.OpCode & jmpMask {
 			case Ja:
 				// Unconditional jump doesn't take a source operand.
 				if i.OpCode&srcAluJmpMask != 0 {
-					return Program{}, Error{InvalidOpcode, pc}
+					return Error{InvalidOpcode, pc}
 				}
 				// Do the comparison in 64 bits to avoid the possibility of
 				// overflow from a very large i.K.
 				if uint64(pc)+uint64(i.K)+1 >= uint64(len(insns)) {
-					return Program{}, Error{InvalidJumpTarget, pc}
+					return Error{InvalidJumpTarget, pc}
 				}
 			case Jeq, Jgt, Jge, Jset:
 				// jt and jf are uint16s, so there's no threat of overflow.
 				if pc+int(i.JumpIfTrue)+1 >= len(insns) {
-					return Program{}, Error{InvalidJumpTarget, pc}
+					return Error{InvalidJumpTarget, pc}
 				}
 				if pc+int(i.JumpIfFalse)+1 >= len(insns) {
-					return Program{}, Error{InvalidJumpTarget, pc}
+					return Error{InvalidJumpTarget, pc}
 				}
 			default:
-				return Program{}, Error{InvalidOpcode, pc}
+				return Error{InvalidOpcode, pc}
 			}
 		case Ret:
 			if i.OpCode&retUnusedBitsMask != 0 {
-				return Program{}, Error{InvalidOpcode, pc}
+				return Error{InvalidOpcode, pc}
 			}
 			if src := i.OpCode & srcRetMask; src != K && src != A {
-				return Program{}, Error{InvalidOpcode, pc}
+				return Error{InvalidOpcode, pc}
 			}
 		case Misc:
 			if misc := i.OpCode & miscMask; misc != Tax && misc != Txa {
-				return Program{}, Error{InvalidOpcode, pc}
+				return Error{InvalidOpcode, pc}
 			}
 		}
 	}
 
-	return Program{insns}, nil
+	return nil
 }
 
 // Input represents a source of input data for a BPF program. (BPF
@@ -263,11 +298,24 @@ func conditionalJumpOffset(insn linux.BPFInstruction, cond bool) int {
 }
 
 // Exec executes a BPF program over the given input and returns its return
-// value.
+// value. Exec does not modify p; the machine state (A, X and M) is local to
+// each call.
 func Exec(p Program, in Input) (uint32, error) {
 	var m machine
 	var pc int
-	for ; pc < len(p.instructions); pc++ {
+	// last is the index of the last instruction executed.
+	var last int
+	// Since jumps can only go forward, valid programs execute each instruction
+	// at most once. Enforce this anyway, so that a program that somehow
+	// escaped validation can't loop.
+	for executed := 0; pc < len(p.instructions); pc, executed = pc+1, executed+1 {
+		if pc < 0 {
+			return 0, Error{InvalidJumpTarget, last}
+		}
+		if executed == len(p.instructions) {
+			return 0, Error{ExecutionLimitExceeded, pc}
+		}
+		last = pc
 		i := p.instructions[pc]
 		switch i.OpCode {
 		case Ld | Imm | W:
@@ -408,5 +456,11 @@ func Exec(p Program, in Input) (uint32, error) {
 			return 0, Error{InvalidOpcode, pc}
 		}
 	}
-	return 0, Error{InvalidEndOfProgram, pc}
+	// Execution ran past the end of the program, which only a program that
+	// escaped validation can do: either a jump's target is out of bounds, or
+	// the program doesn't end with a return.
+	if len(p.instructions) != 0 && p.instructions[last].OpCode&instructionClassMask == Jmp {
+		return 0, Error{InvalidJumpTarget, last}
+	}
+	return 0, Error{InvalidEndOfProgram, last}
 }
@@ -15,6 +15,8 @@
 package bpf
 
 import (
+	"fmt"
+	"sync"
 	"testing"
 
 	"gvisor.googlesource.com/gvisor/pkg/abi/linux"
@@ -75,6 +77,14 @@ func TestCompilationErrors(t *testing.T) {
 			},
 			expectedErr: Error{DivisionByZero, 0},
 		},
+		{
+			desc: "Modulo literal zero is a compilation error",
+			insns: []linux.BPFInstruction{
+				Stmt(Alu|Mod|K, 0), // A %= 0
+				Stmt(Ret|K, 0),     // return 0
+			},
+			expectedErr: Error{DivisionByZero, 0},
+		},
 		{
 			desc: "An unconditional jump outside of the program is a compilation error",
 			insns: []linux.BPFInstruction{
@@ -99,11 +109,85 @@ func TestCompilationErrors(t *testing.T) {
 			},
 			expectedErr: Error{InvalidJumpTarget, 0},
 		},
+		{
+			desc: "An instruction with an invalid opcode is a compilation error",
+			insns: []linux.BPFInstruction{
+				Stmt(Ld|Imm|H, 0), // immediate loads must be words
+				Stmt(Ret|K, 0),    // return 0
+			},
+			expectedErr: Error{InvalidOpcode, 0},
+		},
 	} {
 		_, err := Compile(test.insns)
 		if err != test.expectedErr {
 			t.Errorf("%s: expected error %q, got error %q", test.desc, test.expectedErr, err)
 		}
+		if err := Validate(test.insns); err != test.expectedErr {
+			t.Errorf("%s: expected validation error %q, got error %q", test.desc, test.expectedErr, err)
+		}
+	}
+}
+
+// TestLoadAddressingModes checks every combination of load class, size and
+// addressing mode: Compile must accept exactly the supported loads, which
+// Exec and Executable must execute identically, and reject all others.
+func TestLoadAddressingModes(t *testing.T) {
+	// Each load uses K = 1, and is executed with X = 1 and M[1] = 0x2a.
+	want := map[uint16]uint32{
+		Ld | Imm | W:  1,
+		Ld | Abs | W:  0x3456789a,
+		Ld | Abs | H:  0x3456,
+		Ld | Abs | B:  0x34,
+		Ld | Ind | W:  0x56789abc,
+		Ld | Ind | H:  0x5678,
+		Ld | Ind | B:  0x56,
+		Ld | Mem | W:  0x2a,
+		Ld | Len | W:  8,
+		Ldx | Imm | W: 1,
+		Ldx | Mem | W: 0x2a,
+		Ldx | Len | W: 8,
+		Ldx | Msh | B: 4 * (0x34 & 0xf),
+	}
+	data := []byte{0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0}
+	const loadPC = 4
+	for _, class := range []uint16{Ld, Ldx} {
+		for size := uint16(0); size <= loadSizeMask; size += 0x08 {
+			for mode := uint16(0); mode <= loadModeMask; mode += 0x20 {
+				op := class | size | mode
+				insns := []linux.BPFInstruction{
+					Stmt(Ld|Imm|W, 0x2a), // A = 0x2a
+					Stmt(St, 1),          // M[1] = A
+					Stmt(Ldx|Imm|W, 1),   // X = 1
+					Stmt(Ld|Imm|W, 0),    // A = 0
+					Stmt(op, 1),          // the load
+				}
+				if class == Ldx {
+					insns = append(insns,
+						Stmt(Stx, 2),      // M[2] = X
+						Stmt(Ld|Mem|W, 2)) // A = M[2]
+				}
+				insns = append(insns, Stmt(Ret|A, 0)) // return A
+				p, err := Compile(insns)
+				wantRet, ok := want[op]
+				if !ok {
+					if wantErr := (Error{InvalidOpcode, loadPC}); err != wantErr {
+						t.Errorf("opcode %#x: Compile got error %v, want %v", op, err, wantErr)
+					}
+					continue
+				}
+				if err != nil {
+					t.Errorf("opcode %#x: Compile failed: %v", op, err)
+					continue
+				}
+				in := InputBytes{data, binary.BigEndian}
+				if ret, err := Exec(p, in); ret != wantRet || err != nil {
+					t.Errorf("opcode %#x: Exec got (%#x, %v), want (%#x, nil)", op, ret, err, wantRet)
+				}
+				if ret, err := NewExecutable(p).Exec(in); ret != wantRet || err != nil {
+					t.Errorf("opcode %#x: Executable.Exec got (%#x, %v), want (%#x, nil)", op, ret, err, wantRet)
+				}
+			}
+		}
 	}
 }
 
@@ -155,6 +239,109 @@ func TestExecErrors(t *testing.T) {
 	}
 }
 
+func TestExecUnvalidatedProgram(t *testing.T) {
+	// Exec must terminate, and report what is wrong, even for programs that
+	// bypassed Compile.
+	for _, test := range []struct {
+		desc  string
+		insns []linux.BPFInstruction
+		want  error
+	}{
+		{
+			desc: "jump far out of bounds",
+			insns: []linux.BPFInstruction{
+				Stmt(Jmp|Ja, ^uint32(0)), // jump far out of bounds
+				Stmt(Ret|K, 0),           // return 0
+			},
+			want: Error{InvalidJumpTarget, 0},
+		},
+		{
+			desc: "conditional jump just out of bounds",
+			insns: []linux.BPFInstruction{
+				Stmt(Ld|Imm|W, 0),        // A = 0
+				Jump(Jmp|Jeq|K, 0, 1, 0), // if (A == 0) jmp nextpc+1
+				Stmt(Ret|K, 0),           // return 0
+			},
+			want: Error{InvalidJumpTarget, 1},
+		},
+		{
+			desc: "no return",
+			insns: []linux.BPFInstruction{
+				Stmt(Ld|Imm|W, 0), // A = 0
+				Stmt(Ld|Imm|W, 1), // A = 1
+			},
+			want: Error{InvalidEndOfProgram, 1},
+		},
+		{
+			desc: "no instructions",
+			want: Error{InvalidEndOfProgram, 0},
+		},
+	} {
+		p := Program{test.insns}
+		if ret, err := Exec(p, InputBytes{nil, binary.BigEndian}); err != test.want {
+			t.Errorf("Exec of %s: got (%d, %v), want error %v", test.desc, ret, err, test.want)
+		}
+	}
+}
+
+func TestModXorOpcodes(t *testing.T) {
+	for _, test := range []struct {
+		op      uint16
+		a, v    uint32
+		want    uint32
+		wantErr error
+	}{
+		{op: Mod, a: 17, v: 7, want: 3},
+		{op: Mod, a: 5, v: 7, want: 5},
+		{op: Mod, a: 0xffffffff, v: 1, want: 0},
+		{op: Mod, a: 0xffffffff, v: 0x80000000, want: 0x7fffffff},
+		{op: Mod, a: 17, v: 0, wantErr: Error{DivisionByZero, 4}},
+		{op: Xor, a: 0xff00aa55, v: 0xff0055aa, want: 0x0000ffff},
+		{op: Xor, a: 0xdeadbeef, v: 0xdeadbeef, want: 0},
+		{op: Xor, a: 0xdeadbeef, v: 0, want: 0xdeadbeef},
+		{op: Xor, a: 0, v: 0xffffffff, want: 0xffffffff},
+	} {
+		// With the X source, the operand is loaded from the input, so that
+		// the divisor of BPF_MOD isn't known until the program is executed.
+		input := []byte{byte(test.v >> 24), byte(test.v >> 16), byte(test.v >> 8), byte(test.v)}
+		for _, src := range []uint16{K, X} {
+			name := map[uint16]string{K: "K", X: "X"}[src]
+			insns := []linux.BPFInstruction{
+				Stmt(Ld|Abs|W, 0),      // A = input[0..3]
+				Stmt(St, 0),            // M[0] = A
+				Stmt(Ldx|Mem|W, 0),     // X = M[0]
+				Stmt(Ld|Imm|W, test.a), // A = a
+				Stmt(Alu|test.op|X, 0), // A op= X
+				Stmt(Ret|A, 0),         // return A
+			}
+			if src == K {
+				if test.v == 0 && test.op == Mod {
+					// A literal zero divisor is rejected by Compile.
+					continue
+				}
+				insns = []linux.BPFInstruction{
+					Stmt(Ld|Imm|W, test.a),      // A = a
+					Stmt(Alu|test.op|K, test.v), // A op= v
+					Stmt(Ret|A, 0),              // return A
+				}
+			}
+			desc := fmt.Sprintf("%#x %s %#x from %s", test.a, map[uint16]string{Mod: "%", Xor: "^"}[test.op], test.v, name)
+			p, err := Compile(insns)
+			if err != nil {
+				t.Errorf("%s: unexpected compilation error: %v", desc, err)
+				continue
+			}
+			in := InputBytes{input, binary.BigEndian}
+			if ret, err := Exec(p, in); ret != test.want || err != test.wantErr {
+				t.Errorf("%s: Exec got (%#x, %v), want (%#x, %v)", desc, ret, err, test.want, test.wantErr)
+			}
+			if ret, err := NewExecutable(p).Exec(in); ret != test.want || err != test.wantErr {
+				t.Errorf("%s: Executable.Exec got (%#x, %v), want (%#x, %v)", desc, ret, err, test.want, test.wantErr)
+			}
+		}
+	}
+}
+
 func TestValidInstructions(t *testing.T) {
 	for _, test := range []struct {
 		// desc is the test's description.
@@ -795,3 +982,80 @@ type seccompData struct {
 func (d *seccompData) asInput() Input {
 	return InputBytes{binary.Marshal(nil, binary.LittleEndian, d), binary.LittleEndian}
 }
+
+func TestCompileCopiesInstructions(t *testing.T) {
+	insns := []linux.BPFInstruction{
+		Stmt(Ld|Abs|W, 0),
+		Stmt(Ret|A, 0),
+	}
+	p, err := Compile(insns)
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	// Changing the caller's instructions doesn't affect the program.
+	insns[0] = Stmt(Ld|Abs|W, 1000)
+	insns[1] = Stmt(Ret|K, 1)
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint32(data, 42)
+	if got, err := Exec(p, InputBytes{data, binary.LittleEndian}); err != nil || got != 42 {
+		t.Errorf("Exec after modifying compiled instructions got (%d, %v), want (42, nil)", got, err)
+	}
+}
+
+func TestExecConcurrent(t *testing.T) {
+	// Compute (in[0] + in[1]) * 2 + in[0] via the scratch memory and both
+	// registers, so that any machine state shared between executions would
+	// change the result.
+	p, err := Compile([]linux.BPFInstruction{
+		Stmt(Ld|Abs|W, 0),
+		Stmt(St, 0),
+		Stmt(Ldx|Mem|W, 0),
+		Stmt(Ld|Abs|W, 4),
+		Stmt(Alu|Add|X, 0),
+		Stmt(St, 1),
+		Stmt(Alu|Add|K, 0),
+		Stmt(Ldx|Mem|W, 1),
+		Stmt(Alu|Add|X, 0),
+		Stmt(Ldx|Mem|W, 0),
+		Stmt(Alu|Add|X, 0),
+		Stmt(Ret|A, 0),
+	})
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	e := NewExecutable(p)
+
+	const (
+		goroutines = 8
+		iterations = 1000
+	)
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*goroutines)
+	for g := 0; g < goroutines; g++ {
+		for _, exec := range []func(Input) (uint32, error){
+			func(in Input) (uint32, error) { return Exec(p, in) },
+			e.Exec,
+		} {
+			wg.Add(1)
+			go func(g uint32, exec func(Input) (uint32, error)) {
+				defer wg.Done()
+				for i := uint32(0); i < iterations; i++ {
+					a, b := g, i
+					data := make([]byte, 8)
+					binary.LittleEndian.PutUint32(data, a)
+					binary.LittleEndian.PutUint32(data[4:], b)
+					got, err := exec(InputBytes{data, binary.LittleEndian})
+					if want := (a+b)*2 + a; err != nil || got != want {
+						errs <- fmt.Errorf("input (%d, %d): got (%d, %v), want (%d, nil)", a, b, got, err, want)
+						return
+					}
+				}
+			}(uint32(g), exec)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,553 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bpf
+
+import (
+	"gvisor.googlesource.com/gvisor/pkg/abi/linux"
+)
+
+// Optimize returns a Program that is equivalent to p, but that may be smaller
+// and execute fewer instructions. For every input, executing the returned
+// Program with Exec produces the same return value as executing p, or fails
+// with the same kind of error (although possibly at a different PC).
+//
+// Optimize repeatedly applies the following passes until none of them changes
+// the program:
+//
+// - Constant folding: instructions whose results are known, either because
+// they only depend on constants or because they reload input that a previous
+// comparison has already determined, are replaced by immediate loads or
+// returns, and conditional jumps whose outcomes are known are replaced by
+// unconditional jumps.
+//
+// - Dead store elimination: instructions that can't fail and whose results
+// are never used are removed.
+//
+// - Dead code elimination: unreachable instructions and no-op jumps are
+// removed.
+func Optimize(p Program) Program {
+	insns := append([]linux.BPFInstruction(nil), p.instructions...)
+	for {
+		changed := foldConstants(insns)
+		if eliminateDeadStores(insns) {
+			changed = true
+		}
+		var removed bool
+		insns, removed = removeDeadCode(insns)
+		if !changed && !removed {
+			return Program{insns}
+		}
+	}
+}
+
+// optValueKind is the kind of an optValue.
+type optValueKind uint8
+
+const (
+	// optUnknown indicates that nothing is known about a value.
+	optUnknown optValueKind = iota
+
+	// optConst indicates that a value is the constant optValue.v.
+	optConst
+
+	// optLoad indicates that a value is the result of a successful 32-bit
+	// load from input offset optValue.v, which is not otherwise known.
+	optLoad
+)
+
+// optValue is what Optimize knows about the value of a register.
+type optValue struct {
+	kind optValueKind
+	v    uint32
+}
+
+// constant returns the value of v and true if it is known, and false
+// otherwise.
+func (v optValue) constant() (uint32, bool) {
+	return v.v, v.kind == optConst
+}
+
+// optState is what Optimize knows about the state of the machine before
+// executing an instruction, on all paths through the program that reach it.
+type optState struct {
+	a optValue
+	x optValue
+	m [ScratchMemRegisters]optValue
+
+	// loads maps input offsets to the results of 32-bit loads from those
+	// offsets, which are known to succeed.
+	loads map[uint32]uint32
+}
+
+// newOptState returns the state of the machine at the start of a program.
+func newOptState() *optState {
+	s := &optState{
+		a:     optValue{kind: optConst},
+		x:     optValue{kind: optConst},
+		loads: make(map[uint32]uint32),
+	}
+	for i := range s.m {
+		s.m[i] = optValue{kind: optConst}
+	}
+	return s
+}
+
+// copy returns a copy of s.
+func (s *optState) copy() *optState {
+	c := *s
+	c.loads = make(map[uint32]uint32, len(s.loads))
+	for off, v := range s.loads {
+		c.loads[off] = v
+	}
+	return &c
+}
+
+// merge updates s to contain only what is known in both s and o.
+func (s *optState) merge(o *optState) {
+	if s.a != o.a {
+		s.a = optValue{}
+	}
+	if s.x != o.x {
+		s.x = optValue{}
+	}
+	for i := range s.m {
+		if s.m[i] != o.m[i] {
+			s.m[i] = optValue{}
+		}
+	}
+	for off, v := range s.loads {
+		if ov, ok := o.loads[off]; !ok || ov != v {
+			delete(s.loads, off)
+		}
+	}
+}
+
+// learn records that v, if it is the result of a load, is equal to c.
+func (s *optState) learn(v optValue, c uint32) {
+	if v.kind != optLoad {
+		return
+	}
+	known := optValue{kind: optConst, v: c}
+	if s.a == v {
+		s.a = known
+	}
+	if s.x == v {
+		s.x = known
+	}
+	for i := range s.m {
+		if s.m[i] == v {
+			s.m[i] = known
+		}
+	}
+	s.loads[v.v] = c
+}
+
+// step updates s to reflect the execution of i, which must not be a jump or
+// return.
+func (s *optState) step(i linux.BPFInstruction) {
+	switch i.OpCode {
+	case Ld | Imm | W:
+		s.a = optValue{kind: optConst, v: i.K}
+	case Ld | Abs | W:
+		if v, ok := s.loads[i.K]; ok {
+			s.a = optValue{kind: optConst, v: v}
+		} else {
+			s.a = optValue{kind: optLoad, v: i.K}
+		}
+	case Ld | Mem | W:
+		s.a = s.m[i.K]
+	case Ldx | Imm | W:
+		s.x = optValue{kind: optConst, v: i.K}
+	case Ldx | Mem | W:
+		s.x = s.m[i.K]
+	case St:
+		s.m[i.K] = s.a
+	case Stx:
+		s.m[i.K] = s.x
+	case Misc | Tax:
+		s.a = s.x
+	case Misc | Txa:
+		s.x = s.a
+	case Ldx | Len | W, Ldx | Msh | B:
+		s.x = optValue{}
+	default:
+		if i.OpCode&instructionClassMask == Alu {
+			if v, ok := s.alu(i); ok {
+				s.a = optValue{kind: optConst, v: v}
+				return
+			}
+		}
+		// All remaining instructions only modify A.
+		s.a = optValue{}
+	}
+}
+
+// alu returns the result of ALU instruction i and true if it is known and i
+// can't fail. Otherwise, alu returns false.
+func (s *optState) alu(i linux.BPFInstruction) (uint32, bool) {
+	a, ok := s.a.constant()
+	if !ok {
+		return 0, false
+	}
+	if i.OpCode&aluMask == Neg {
+		return uint32(-int32(a)), true
+	}
+	operand, ok := s.operand(i)
+	if !ok {
+		return 0, false
+	}
+	switch i.OpCode & aluMask {
+	case Add:
+		return a + operand, true
+	case Sub:
+		return a - operand, true
+	case Mul:
+		return a * operand, true
+	case Div:
+		if operand == 0 {
+			return 0, false
+		}
+		return a / operand, true
+	case Mod:
+		if operand == 0 {
+			return 0, false
+		}
+		return a % operand, true
+	case Or:
+		return a | operand, true
+	case And:
+		return a & operand, true
+	case Lsh:
+		return a << operand, true
+	case Rsh:
+		return a >> operand, true
+	case Xor:
+		return a ^ operand, true
+	default:
+		return 0, false
+	}
+}
+
+// operand returns the value of the source operand of ALU or conditional
+// jump instruction i and true if it is known, and false otherwise.
+func (s *optState) operand(i linux.BPFInstruction) (uint32, bool) {
+	if i.OpCode&srcAluJmpMask == K {
+		return i.K, true
+	}
+	return s.x.constant()
+}
+
+// condition returns the outcome of conditional jump instruction i and true if
+// it is known, and false otherwise.
+func (s *optState) condition(i linux.BPFInstruction) (bool, bool) {
+	a, ok := s.a.constant()
+	if !ok {
+		return false, false
+	}
+	operand, ok := s.operand(i)
+	if !ok {
+		return false, false
+	}
+	switch i.OpCode & jmpMask {
+	case Jeq:
+		return a == operand, true
+	case Jgt:
+		return a > operand, true
+	case Jge:
+		return a >= operand, true
+	case Jset:
+		return a&operand != 0, true
+	default:
+		return false, false
+	}
+}
+
+// analyze returns the state before each instruction in insns, or nil for
+// instructions that are unreachable.
+func analyze(insns []linux.BPFInstruction) []*optState {
+	states := make([]*optState, len(insns))
+	states[0] = newOptState()
+	propagate := func(pc int, s *optState) {
+		if states[pc] == nil {
+			states[pc] = s
+		} else {
+			states[pc].merge(s)
+		}
+	}
+	// Since jumps can only go forward, every predecessor of an instruction
+	// precedes it, so states[pc] is complete by the time it is visited.
+	for pc, i := range insns {
+		if states[pc] == nil {
+			continue
+		}
+		s := states[pc].copy()
+		switch i.OpCode & instructionClassMask {
+		case Ret:
+		case Jmp:
+			if i.OpCode == Jmp|Ja {
+				propagate(pc+int(i.K)+1, s)
+				break
+			}
+			t := s.copy()
+			if i.OpCode&jmpMask == Jeq {
+				// If the jump is taken, A is equal to the operand.
+				if operand, ok := t.operand(i); ok {
+					t.learn(t.a, operand)
+					t.a = optValue{kind: optConst, v: operand}
+				} else if a, ok := t.a.constant(); ok {
+					t.learn(t.x, a)
+					t.x = optValue{kind: optConst, v: a}
+				}
+			}
+			propagate(pc+int(i.JumpIfTrue)+1, t)
+			propagate(pc+int(i.JumpIfFalse)+1, s)
+		default:
+			s.step(i)
+			propagate(pc+1, s)
+		}
+	}
+	return states
+}
+
+// foldConstants replaces instructions in insns with equivalent, cheaper
+// instructions where analyze determines that their results are known. It
+// returns true if any instruction was replaced.
+func foldConstants(insns []linux.BPFInstruction) bool {
+	changed := false
+	for pc, s := range analyze(insns) {
+		if s == nil {
+			continue
+		}
+		if i := fold(insns, pc, s); i != insns[pc] {
+			insns[pc] = i
+			changed = true
+		}
+	}
+	return changed
+}
+
+// fold returns an instruction equivalent to insns[pc], given the state s
+// before it.
+func fold(insns []linux.BPFInstruction, pc int, s *optState) linux.BPFInstruction {
+	i := insns[pc]
+	switch i.OpCode {
+	case Ld | Abs | W:
+		if v, ok := s.loads[i.K]; ok {
+			return Stmt(Ld|Imm|W, v)
+		}
+	case Ld | Mem | W:
+		if v, ok := s.m[i.K].constant(); ok {
+			return Stmt(Ld|Imm|W, v)
+		}
+	case Ldx | Mem | W:
+		if v, ok := s.m[i.K].constant(); ok {
+			return Stmt(Ldx|Imm|W, v)
+		}
+	case Misc | Tax:
+		if v, ok := s.x.constant(); ok {
+			return Stmt(Ld|Imm|W, v)
+		}
+	case Misc | Txa:
+		if v, ok := s.a.constant(); ok {
+			return Stmt(Ldx|Imm|W, v)
+		}
+	case Ret | A:
+		if v, ok := s.a.constant(); ok {
+			return Stmt(Ret|K, v)
+		}
+	case Jmp | Ja:
+		// Replace jumps to returns with the return itself.
+		if t := insns[pc+int(i.K)+1]; t.OpCode&instructionClassMask == Ret {
+			return t
+		}
+	default:
+		switch i.OpCode & instructionClassMask {
+		case Alu:
+			if v, ok := s.alu(i); ok {
+				return Stmt(Ld|Imm|W, v)
+			}
+			if x, ok := s.x.constant(); ok && i.OpCode&srcAluJmpMask == X {
+				if op := i.OpCode & aluMask; (op != Div && op != Mod) || x != 0 {
+					return Stmt(Alu|op|K, x)
+				}
+			}
+		case Jmp:
+			if i.JumpIfTrue == i.JumpIfFalse {
+				return Stmt(Jmp|Ja, uint32(i.JumpIfTrue))
+			}
+			if cond, ok := s.condition(i); ok {
+				return Stmt(Jmp|Ja, uint32(conditionalJumpOffset(i, cond)))
+			}
+			if x, ok := s.x.constant(); ok && i.OpCode&srcAluJmpMask == X {
+				return Jump(Jmp|(i.OpCode&jmpMask)|K, x, i.JumpIfTrue, i.JumpIfFalse)
+			}
+		}
+	}
+	return i
+}
+
+// optRegisters is a set of registers, represented as a bitmask: bit
+// ScratchMemRegisters represents A, bit ScratchMemRegisters+1 represents X,
+// and all lower bits represent the corresponding M register.
+type optRegisters uint32
+
+const (
+	optA optRegisters = 1 << ScratchMemRegisters
+	optX optRegisters = 1 << (ScratchMemRegisters + 1)
+)
+
+// optM returns the set containing only M register k.
+func optM(k uint32) optRegisters {
+	return 1 << k
+}
+
+// registers returns the registers used and defined by i, and whether i is
+// pure: it can't fail, and has no effects other than defining registers.
+func registers(i linux.BPFInstruction) (use, def optRegisters, pure bool) {
+	switch i.OpCode {
+	case Ld | Imm | W, Ld | Len | W:
+		return 0, optA, true
+	case Ld | Abs | W, Ld | Abs | H, Ld | Abs | B:
+		return 0, optA, false
+	case Ld | Ind | W, Ld | Ind | H, Ld | Ind | B:
+		return optX, optA, false
+	case Ld | Mem | W:
+		return optM(i.K), optA, true
+	case Ldx | Imm | W, Ldx | Len | W:
+		return 0, optX, true
+	case Ldx | Mem | W:
+		return optM(i.K), optX, true
+	case Ldx | Msh | B:
+		return 0, optX, false
+	case St:
+		return optA, optM(i.K), true
+	case Stx:
+		return optX, optM(i.K), true
+	case Alu | Neg:
+		return optA, optA, true
+	case Alu | Div | X, Alu | Mod | X:
+		return optA | optX, optA, false
+	case Ret | K, Jmp | Ja:
+		return 0, 0, false
+	case Ret | A:
+		return optA, 0, false
+	case Misc | Tax:
+		return optX, optA, true
+	case Misc | Txa:
+		return optA, optX, true
+	}
+	switch i.OpCode & instructionClassMask {
+	case Alu:
+		if i.OpCode&srcAluJmpMask == X {
+			return optA | optX, optA, true
+		}
+		return optA, optA, true
+	case Jmp:
+		if i.OpCode&srcAluJmpMask == X {
+			return optA | optX, 0, false
+		}
+		return optA, 0, false
+	}
+	// Not reachable for valid programs; be conservative.
+	return optA | optX | (optM(ScratchMemRegisters) - 1), 0, false
+}
+
+// eliminateDeadStores replaces pure instructions in insns whose results are
+// never used with no-op jumps. It returns true if any instruction was
+// replaced.
+func eliminateDeadStores(insns []linux.BPFInstruction) bool {
+	changed := false
+	// live[pc] is the set of registers whose values before instruction pc
+	// may be used. Since jumps can only go forward, every successor of an
+	// instruction follows it, so live[pc] can be computed in reverse order.
+	live := make([]optRegisters, len(insns)+1)
+	for pc := len(insns) - 1; pc >= 0; pc-- {
+		i := insns[pc]
+		var out optRegisters
+		switch {
+		case i.OpCode&instructionClassMask == Ret:
+		case i.OpCode == Jmp|Ja:
+			out = live[pc+int(i.K)+1]
+		case i.OpCode&instructionClassMask == Jmp:
+			out = live[pc+int(i.JumpIfTrue)+1] | live[pc+int(i.JumpIfFalse)+1]
+		default:
+			out = live[pc+1]
+		}
+		use, def, pure := registers(i)
+		if pure && def&out == 0 {
+			insns[pc] = Stmt(Jmp|Ja, 0)
+			changed = true
+			live[pc] = out
+			continue
+		}
+		live[pc] = use | (out &^ def)
+	}
+	return changed
+}
+
+// removeDeadCode returns insns with unreachable instructions and no-op jumps
+// removed, and true if any instruction was removed.
+func removeDeadCode(insns []linux.BPFInstruction) ([]linux.BPFInstruction, bool) {
+	reachable := make([]bool, len(insns))
+	reachable[0] = true
+	for pc, i := range insns {
+		if !reachable[pc] {
+			continue
+		}
+		switch {
+		case i.OpCode&instructionClassMask == Ret:
+		case i.OpCode == Jmp|Ja:
+			reachable[pc+int(i.K)+1] = true
+		case i.OpCode&instructionClassMask == Jmp:
+			reachable[pc+int(i.JumpIfTrue)+1] = true
+			reachable[pc+int(i.JumpIfFalse)+1] = true
+		default:
+			reachable[pc+1] = true
+		}
+	}
+
+	// newPCs[pc] is the number of instructions before pc that are kept, which
+	// is the new PC of instruction pc if it is kept. If instruction pc is
+	// removed, newPCs[pc] is the new PC of the next instruction that is kept,
+	// which is equivalent (as a jump target) since removed reachable
+	// instructions are no-ops.
+	newPCs := make([]int, len(insns))
+	n := 0
+	for pc, i := range insns {
+		newPCs[pc] = n
+		if reachable[pc] && i != Stmt(Jmp|Ja, 0) {
+			n++
+		}
+	}
+	if n == len(insns) {
+		return insns, false
+	}
+
+	out := make([]linux.BPFInstruction, 0, n)
+	for pc, i := range insns {
+		if !reachable[pc] || i == Stmt(Jmp|Ja, 0) {
+			continue
+		}
+		switch {
+		case i.OpCode == Jmp|Ja:
+			i.K = uint32(newPCs[pc+int(i.K)+1] - newPCs[pc] - 1)
+		case i.OpCode&instructionClassMask == Jmp:
+			i.JumpIfTrue = uint8(newPCs[pc+int(i.JumpIfTrue)+1] - newPCs[pc] - 1)
+			i.JumpIfFalse = uint8(newPCs[pc+int(i.JumpIfFalse)+1] - newPCs[pc] - 1)
+		}
+		out = append(out, i)
+	}
+	return out, true
+}
@@ -0,0 +1,258 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bpf
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"gvisor.googlesource.com/gvisor/pkg/abi/linux"
+	"gvisor.googlesource.com/gvisor/pkg/binary"
+)
+
+func TestOptimize(t *testing.T) {
+	for _, test := range []struct {
+		// desc is the test's description.
+		desc string
+
+		// insns is the program to optimize.
+		insns []linux.BPFInstruction
+
+		// want is the expected optimized program.
+		want []linux.BPFInstruction
+	}{
+		{
+			desc: "Constant ALU operations are folded",
+			insns: []linux.BPFInstruction{
+				Stmt(Ld|Imm|W, 1),
+				Stmt(Ldx|Imm|W, 2),
+				Stmt(Alu|Add|X, 0),
+				Stmt(Alu|Mul|K, 3),
+				Stmt(Ret|A, 0),
+			},
+			want: []linux.BPFInstruction{
+				Stmt(Ret|K, 9),
+			},
+		},
+		{
+			desc: "Scratch memory is folded",
+			insns: []linux.BPFInstruction{
+				Stmt(Ld|Imm|W, 7),
+				Stmt(St, 3),
+				Stmt(Ld|Abs|W, 0),
+				Stmt(Ldx|Mem|W, 3),
+				Jump(Jmp|Jeq|X, 0, 0, 1),
+				Stmt(Ret|K, 1),
+				Stmt(Ret|K, 2),
+			},
+			want: []linux.BPFInstruction{
+				Stmt(Ld|Abs|W, 0),
+				Jump(Jmp|Jeq|K, 7, 0, 1),
+				Stmt(Ret|K, 1),
+				Stmt(Ret|K, 2),
+			},
+		},
+		{
+			desc: "Always-taken and never-taken branches are collapsed",
+			insns: []linux.BPFInstruction{
+				Stmt(Ld|Imm|W, 5),
+				Jump(Jmp|Jeq|K, 5, 0, 2),
+				Jump(Jmp|Jgt|K, 5, 1, 0),
+				Stmt(Ret|K, 1),
+				Stmt(Ret|K, 2),
+			},
+			want: []linux.BPFInstruction{
+				Stmt(Ret|K, 1),
+			},
+		},
+		{
+			desc: "Branches with identical targets are collapsed",
+			insns: []linux.BPFInstruction{
+				Stmt(Ld|Abs|W, 0),
+				Jump(Jmp|Jeq|K, 1, 1, 1),
+				Stmt(Ret|K, 1),
+				Stmt(Ret|A, 0),
+			},
+			want: []linux.BPFInstruction{
+				Stmt(Ld|Abs|W, 0),
+				Stmt(Ret|A, 0),
+			},
+		},
+		{
+			desc: "Redundant comparisons are removed",
+			insns: []linux.BPFInstruction{
+				Stmt(Ld|Abs|W, 4),
+				Jump(Jmp|Jeq|K, linux.AUDIT_ARCH_X86_64, 1, 0),
+				Stmt(Ret|K, linux.SECCOMP_RET_KILL_PROCESS),
+				Stmt(Ld|Abs|W, 0),
+				Jump(Jmp|Jeq|K, 1, 4, 0),
+				Stmt(Ld|Abs|W, 4),
+				Jump(Jmp|Jeq|K, linux.AUDIT_ARCH_X86_64, 0, 1),
+				Stmt(Ret|K, linux.SECCOMP_RET_ERRNO|1),
+				Stmt(Ret|K, linux.SECCOMP_RET_KILL_PROCESS),
+				Stmt(Ret|K, linux.SECCOMP_RET_ALLOW),
+			},
+			want: []linux.BPFInstruction{
+				Stmt(Ld|Abs|W, 4),
+				Jump(Jmp|Jeq|K, linux.AUDIT_ARCH_X86_64, 1, 0),
+				Stmt(Ret|K, linux.SECCOMP_RET_KILL_PROCESS),
+				Stmt(Ld|Abs|W, 0),
+				Jump(Jmp|Jeq|K, 1, 1, 0),
+				Stmt(Ret|K, linux.SECCOMP_RET_ERRNO|1),
+				Stmt(Ret|K, linux.SECCOMP_RET_ALLOW),
+			},
+		},
+		{
+			desc: "Comparisons against X are used",
+			insns: []linux.BPFInstruction{
+				Stmt(Ld|Abs|W, 0),
+				Stmt(Misc|Txa, 0),
+				Stmt(Ld|Imm|W, 1),
+				Jump(Jmp|Jeq|X, 0, 0, 2),
+				Stmt(Ld|Abs|W, 0),
+				Stmt(Ret|A, 0),
+				Stmt(Ret|K, 2),
+			},
+			want: []linux.BPFInstruction{
+				Stmt(Ld|Abs|W, 0),
+				Stmt(Misc|Txa, 0),
+				Stmt(Ld|Imm|W, 1),
+				Jump(Jmp|Jeq|X, 0, 0, 1),
+				Stmt(Ret|K, 1),
+				Stmt(Ret|K, 2),
+			},
+		},
+		{
+			desc: "Scratch memory that differs between paths is not folded",
+			insns: []linux.BPFInstruction{
+				Stmt(Ld|Imm|W, 5),
+				Stmt(St, 0),
+				Stmt(Ld|Abs|W, 0),
+				Jump(Jmp|Jeq|K, 1, 0, 2),
+				Stmt(Ld|Imm|W, 6),
+				Stmt(St, 0),
+				Stmt(Ld|Mem|W, 0),
+				Stmt(Ret|A, 0),
+			},
+			want: []linux.BPFInstruction{
+				Stmt(Ld|Imm|W, 5),
+				Stmt(St, 0),
+				Stmt(Ld|Abs|W, 0),
+				Jump(Jmp|Jeq|K, 1, 0, 2),
+				Stmt(Ld|Imm|W, 6),
+				Stmt(St, 0),
+				Stmt(Ld|Mem|W, 0),
+				Stmt(Ret|A, 0),
+			},
+		},
+		{
+			desc: "Loads that may fail are not removed",
+			insns: []linux.BPFInstruction{
+				Stmt(Ld|Abs|W, 0),
+				Stmt(Ldx|Msh|B, 0),
+				Stmt(Ld|Imm|W, 1),
+				Stmt(Ret|K, 1),
+			},
+			want: []linux.BPFInstruction{
+				Stmt(Ld|Abs|W, 0),
+				Stmt(Ldx|Msh|B, 0),
+				Stmt(Ret|K, 1),
+			},
+		},
+		{
+			desc: "Division by zero is not folded",
+			insns: []linux.BPFInstruction{
+				Stmt(Ld|Imm|W, 1),
+				Stmt(Alu|Div|X, 0),
+				Stmt(Ret|K, 0),
+			},
+			want: []linux.BPFInstruction{
+				Stmt(Ld|Imm|W, 1),
+				Stmt(Alu|Div|X, 0),
+				Stmt(Ret|K, 0),
+			},
+		},
+	} {
+		p, err := Compile(test.insns)
+		if err != nil {
+			t.Errorf("%s: Compile failed: %v", test.desc, err)
+			continue
+		}
+		got := Optimize(p).instructions
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: Optimize got %v, want %v", test.desc, got, test.want)
+		}
+	}
+}
+
+// randomOptimizerProgram returns a random valid program of at most maxLen
+// instructions, which is biased towards 32-bit loads from a few small offsets
+// and comparisons against the values returned by randomOptimizerInput, so
+// that the same input is often loaded and compared more than once.
+func randomOptimizerProgram(r *rand.Rand, maxLen int) Program {
+	insns := randomProgram(r, maxLen).Instructions()
+	for pc := range insns[:len(insns)-1] {
+		switch {
+		case r.Intn(4) == 0:
+			insns[pc] = Stmt(Ld|Abs|W, 4*uint32(r.Intn(3)))
+		case insns[pc].OpCode == Jmp|Jeq|K && r.Intn(2) == 0:
+			insns[pc].K = uint32(r.Intn(4))
+		}
+	}
+	return Program{insns}
+}
+
+// randomOptimizerInput returns a random input for a program produced by
+// randomProgram. Half of the returned inputs consist of small 32-bit words,
+// so that comparisons of loaded words against small constants, on which
+// Optimize depends, are often true.
+func randomOptimizerInput(r *rand.Rand) []byte {
+	data := make([]byte, r.Intn(80))
+	if r.Intn(2) == 0 {
+		r.Read(data)
+		return data
+	}
+	for off := 0; off+4 <= len(data); off += 4 {
+		binary.LittleEndian.PutUint32(data[off:], uint32(r.Intn(4)))
+	}
+	return data
+}
+
+func TestOptimizeMatchesExec(t *testing.T) {
+	r := rand.New(rand.NewSource(0))
+	for iter := 0; iter < 10000; iter++ {
+		p := randomOptimizerProgram(r, 32)
+		o := Optimize(p)
+		if err := Validate(o.instructions); err != nil {
+			t.Fatalf("program %v: optimized program %v is invalid: %v", p.instructions, o.instructions, err)
+		}
+		if o.Length() > p.Length() {
+			t.Fatalf("program %v: optimized program %v is longer", p.instructions, o.instructions)
+		}
+		for j := 0; j < 8; j++ {
+			data := randomOptimizerInput(r)
+			in := InputBytes{data, binary.LittleEndian}
+			wantRet, wantErr := Exec(p, in)
+			gotRet, gotErr := Exec(o, in)
+			if gotRet != wantRet || (gotErr == nil) != (wantErr == nil) {
+				t.Fatalf("program %v optimized to %v with input %v: got (%#x, %v), want (%#x, %v)", p.instructions, o.instructions, data, gotRet, gotErr, wantRet, wantErr)
+			}
+			if gotErr != nil && gotErr.(Error).Code != wantErr.(Error).Code {
+				t.Fatalf("program %v optimized to %v with input %v: got error %v, want %v", p.instructions, o.instructions, data, gotErr, wantErr)
+			}
+		}
+	}
+}
@@ -116,6 +116,19 @@ func BuildProgram(rules []RuleSet, defaultAction uint32) ([]linux.BPFInstruction
 	return program.Instructions()
 }
 
+// BuildFilter builds a seccomp filter from the given RuleSets, as for
+// BuildProgram, and validates it. Unlike BuildProgram's result, which is
+// intended for installation in the host kernel by SetFilter, the returned
+// bpf.Program can be executed by the bpf package, or installed for a
+// sandboxed task by kernel.Task.AppendSyscallFilter.
+func BuildFilter(rules []RuleSet, defaultAction uint32) (bpf.Program, error) {
+	instrs, err := BuildProgram(rules, defaultAction)
+	if err != nil {
+		return bpf.Program{}, err
+	}
+	return bpf.Compile(instrs)
+}
+
 // buildIndex builds a BST to quickly search through all syscalls.
 func buildIndex(rules []RuleSet, program *bpf.ProgramBuilder) error {
 	// Build a list of all application system calls, across all given rule
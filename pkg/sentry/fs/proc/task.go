@@ -495,7 +495,7 @@ func (s *statusData) ReadSeqFileData(ctx context.Context, h seqfile.SeqHandle) (
 	fmt.Fprintf(&buf, "CapPrm:\t%016x\n", creds.PermittedCaps)
 	fmt.Fprintf(&buf, "CapEff:\t%016x\n", creds.EffectiveCaps)
 	fmt.Fprintf(&buf, "CapBnd:\t%016x\n", creds.BoundingCaps)
-	fmt.Fprintf(&buf, "Seccomp:\t%d\n", s.t.SeccompMode())
+	buf.WriteString(s.t.SeccompStatus())
 	return []seqfile.SeqData{{Buf: buf.Bytes(), Handle: (*statusData)(nil)}}, 0
 }
 
@@ -23,11 +23,16 @@
 //     ktime.Timer.mu (for kernelCPUClockTicker and IntervalTimer)
 //       TaskSet.mu
 //         SignalHandlers.mu
-//           Task.mu
+//           ThreadGroup.seccompMu
+//             Task.seccompMu
+//               Task.mu
 //
 // Locking SignalHandlers.mu in multiple SignalHandlers requires locking
 // TaskSet.mu exclusively first. Locking Task.mu in multiple Tasks at the same
-// time requires locking all of their signal mutexes first.
+// time requires locking all of their signal mutexes first. Locking
+// Task.seccompMu in multiple Tasks at the same time requires locking their
+// ThreadGroup.seccompMu first, and is only done for tasks in the same thread
+// group.
 package kernel
 
 import (
@@ -167,6 +172,14 @@ type Kernel struct {
 	// operations.
 	nextInotifyCookie uint32
 
+	// seccompNotifID is the ID of the most recently sent seccomp user
+	// notification. IDs are never reused, so that a supervisor can't mistake
+	// a new notification for one whose notifying task has died.
+	//
+	// seccompNotifID is mutable, and is accessed using atomic memory
+	// operations.
+	seccompNotifID uint64
+
 	// netlinkPorts manages allocation of netlink socket port IDs.
 	netlinkPorts *port.Manager
 
@@ -983,6 +996,15 @@ func (k *Kernel) GenerateInotifyCookie() uint32 {
 	return id
 }
 
+// generateSeccompNotifID returns the ID of a new seccomp user notification.
+func (k *Kernel) generateSeccompNotifID() uint64 {
+	id := atomic.AddUint64(&k.seccompNotifID, 1)
+	if id == 0 {
+		panic("seccomp notification ID generator wrapped around")
+	}
+	return id
+}
+
 // NetlinkPorts returns the netlink port manager.
 func (k *Kernel) NetlinkPorts() *port.Manager {
 	return k.netlinkPorts
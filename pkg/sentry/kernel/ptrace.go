@@ -18,6 +18,7 @@ import (
 	"fmt"
 
 	"gvisor.googlesource.com/gvisor/pkg/abi/linux"
+	"gvisor.googlesource.com/gvisor/pkg/binary"
 	"gvisor.googlesource.com/gvisor/pkg/sentry/arch"
 	"gvisor.googlesource.com/gvisor/pkg/sentry/usermem"
 	"gvisor.googlesource.com/gvisor/pkg/syserror"
@@ -749,35 +750,96 @@ func (t *Task) ptraceKill(target *Task) error {
 	return nil
 }
 
+// ptraceSeccompGetFilter implements PTRACE_SECCOMP_GET_FILTER, copying the
+// instructions of target's seccomp-bpf filter with the given index to data
+// and returning the number of instructions in the filter. If data is 0, only
+// the number of instructions is returned, allowing the tracer to size its
+// buffer.
+//
+// Preconditions: target must be a ptrace-stopped tracee of t.
+func (t *Task) ptraceSeccompGetFilter(target *Task, index uint64, data usermem.Addr) (uintptr, error) {
+	// As in Linux, the tracer must have CAP_SYS_ADMIN in the root user
+	// namespace, and must not itself be subject to seccomp filters (which
+	// could otherwise be bypassed by reading filters from a tracee).
+	if !t.HasCapabilityIn(linux.CAP_SYS_ADMIN, t.k.RootUserNamespace()) || t.SeccompMode() != linux.SECCOMP_MODE_NONE {
+		return 0, syserror.EACCES
+	}
+	f, err := target.seccompFilter(index)
+	if err != nil {
+		return 0, err
+	}
+	insns := f.program.Instructions()
+	if data != 0 {
+		if _, err := t.CopyOut(data, insns); err != nil {
+			return 0, err
+		}
+	}
+	return uintptr(len(insns)), nil
+}
+
+// ptraceSeccompGetMetadata implements PTRACE_SECCOMP_GET_METADATA. size is
+// the size of the tracer's struct seccomp_metadata at data, whose filter_off
+// field selects the filter. ptraceSeccompGetMetadata returns the number of
+// bytes of struct seccomp_metadata copied to data.
+//
+// Preconditions: target must be a ptrace-stopped tracee of t.
+func (t *Task) ptraceSeccompGetMetadata(target *Task, size uint64, data usermem.Addr) (uintptr, error) {
+	// See ptraceSeccompGetFilter.
+	if !t.HasCapabilityIn(linux.CAP_SYS_ADMIN, t.k.RootUserNamespace()) || t.SeccompMode() != linux.SECCOMP_MODE_NONE {
+		return 0, syserror.EACCES
+	}
+	var md linux.SeccompMetadata
+	if size < uint64(binary.Size(md.FilterOff)) {
+		return 0, syserror.EINVAL
+	}
+	if _, err := t.CopyIn(data, &md.FilterOff); err != nil {
+		return 0, err
+	}
+	f, err := target.seccompFilter(md.FilterOff)
+	if err != nil {
+		return 0, err
+	}
+	md.Flags = uint64(f.flags)
+
+	// As in Linux, copy out no more than the tracer's struct can hold, so
+	// that the struct may be extended in the future.
+	buf := binary.Marshal(nil, usermem.ByteOrder, &md)
+	if size < uint64(len(buf)) {
+		buf = buf[:size]
+	}
+	n, err := t.CopyOut(data, buf)
+	return uintptr(n), err
+}
+
 // Ptrace implements the ptrace system call.
-func (t *Task) Ptrace(req int64, pid ThreadID, addr, data usermem.Addr) error {
+func (t *Task) Ptrace(req int64, pid ThreadID, addr, data usermem.Addr) (uintptr, error) {
 	// PTRACE_TRACEME ignores all other arguments.
 	if req == linux.PTRACE_TRACEME {
-		return t.ptraceTraceme()
+		return 0, t.ptraceTraceme()
 	}
 	// All other ptrace requests operate on a current or future tracee
 	// specified by pid.
 	target := t.tg.pidns.TaskWithID(pid)
 	if target == nil {
-		return syserror.ESRCH
+		return 0, syserror.ESRCH
 	}
 
 	// PTRACE_ATTACH (and PTRACE_SEIZE, which is unimplemented) do not require
 	// that target is not already a tracee.
 	if req == linux.PTRACE_ATTACH {
-		return t.ptraceAttach(target)
+		return 0, t.ptraceAttach(target)
 	}
 	// PTRACE_KILL (and PTRACE_INTERRUPT, which is unimplemented) require that
 	// the target is a tracee, but does not require that it is ptrace-stopped.
 	if req == linux.PTRACE_KILL {
-		return t.ptraceKill(target)
+		return 0, t.ptraceKill(target)
 	}
 	// All other ptrace requests require that the target is a ptrace-stopped
 	// tracee, and freeze the ptrace-stop so the tracee can be operated on.
 	t.tg.pidns.owner.mu.RLock()
 	if target.Tracer() != t {
 		t.tg.pidns.owner.mu.RUnlock()
-		return syserror.ESRCH
+		return 0, syserror.ESRCH
 	}
 	if !target.ptraceFreeze() {
 		t.tg.pidns.owner.mu.RUnlock()
@@ -785,7 +847,7 @@ func (t *Task) Ptrace(req int64, pid ThreadID, addr, data usermem.Addr) error {
 		// PTRACE_TRACEME, PTRACE_INTERRUPT, and PTRACE_KILL) require the
 		// tracee to be in a ptrace-stop, otherwise they fail with ESRCH." -
 		// ptrace(2)
-		return syserror.ESRCH
+		return 0, syserror.ESRCH
 	}
 	t.tg.pidns.owner.mu.RUnlock()
 	// Even if the target has a ptrace-stop active, the tracee's task goroutine
@@ -805,39 +867,39 @@ func (t *Task) Ptrace(req int64, pid ThreadID, addr, data usermem.Addr) error {
 	case linux.PTRACE_DETACH:
 		if err := t.ptraceDetach(target, linux.Signal(data)); err != nil {
 			target.ptraceUnfreeze()
-			return err
+			return 0, err
 		}
-		return nil
+		return 0, nil
 	case linux.PTRACE_CONT:
 		if err := target.ptraceUnstop(ptraceSyscallNone, false, linux.Signal(data)); err != nil {
 			target.ptraceUnfreeze()
-			return err
+			return 0, err
 		}
-		return nil
+		return 0, nil
 	case linux.PTRACE_SYSCALL:
 		if err := target.ptraceUnstop(ptraceSyscallIntercept, false, linux.Signal(data)); err != nil {
 			target.ptraceUnfreeze()
-			return err
+			return 0, err
 		}
-		return nil
+		return 0, nil
 	case linux.PTRACE_SINGLESTEP:
 		if err := target.ptraceUnstop(ptraceSyscallNone, true, linux.Signal(data)); err != nil {
 			target.ptraceUnfreeze()
-			return err
+			return 0, err
 		}
-		return nil
+		return 0, nil
 	case linux.PTRACE_SYSEMU:
 		if err := target.ptraceUnstop(ptraceSyscallEmu, false, linux.Signal(data)); err != nil {
 			target.ptraceUnfreeze()
-			return err
+			return 0, err
 		}
-		return nil
+		return 0, nil
 	case linux.PTRACE_SYSEMU_SINGLESTEP:
 		if err := target.ptraceUnstop(ptraceSyscallEmu, true, linux.Signal(data)); err != nil {
 			target.ptraceUnfreeze()
-			return err
+			return 0, err
 		}
-		return nil
+		return 0, nil
 	}
 	// All other ptrace requests expect us to unfreeze the stop.
 	defer target.ptraceUnfreeze()
@@ -852,27 +914,27 @@ func (t *Task) Ptrace(req int64, pid ThreadID, addr, data usermem.Addr) error {
 		if _, err := usermem.CopyObjectIn(t, target.MemoryManager(), addr, word, usermem.IOOpts{
 			IgnorePermissions: true,
 		}); err != nil {
-			return err
+			return 0, err
 		}
 		_, err := t.CopyOut(data, word)
-		return err
+		return 0, err
 
 	case linux.PTRACE_POKETEXT, linux.PTRACE_POKEDATA:
 		_, err := usermem.CopyObjectOut(t, target.MemoryManager(), addr, t.Arch().Native(uintptr(data)), usermem.IOOpts{
 			IgnorePermissions: true,
 		})
-		return err
+		return 0, err
 
 	case linux.PTRACE_PEEKUSR: // aka PTRACE_PEEKUSER
 		n, err := target.Arch().PtracePeekUser(uintptr(addr))
 		if err != nil {
-			return err
+			return 0, err
 		}
 		_, err = t.CopyOut(data, n)
-		return err
+		return 0, err
 
 	case linux.PTRACE_POKEUSR: // aka PTRACE_POKEUSER
-		return target.Arch().PtracePokeUser(uintptr(addr), uintptr(data))
+		return 0, target.Arch().PtracePokeUser(uintptr(addr), uintptr(data))
 
 	case linux.PTRACE_GETREGS:
 		// "Copy the tracee's general-purpose ... registers ... to the address
@@ -886,7 +948,7 @@ func (t *Task) Ptrace(req int64, pid ThreadID, addr, data usermem.Addr) error {
 				AddressSpaceActive: true,
 			},
 		})
-		return err
+		return 0, err
 
 	case linux.PTRACE_GETFPREGS:
 		_, err := target.Arch().PtraceGetFPRegs(&usermem.IOReadWriter{
@@ -897,7 +959,7 @@ func (t *Task) Ptrace(req int64, pid ThreadID, addr, data usermem.Addr) error {
 				AddressSpaceActive: true,
 			},
 		})
-		return err
+		return 0, err
 
 	case linux.PTRACE_GETREGSET:
 		// "Read the tracee's registers. addr specifies, in an
@@ -907,7 +969,7 @@ func (t *Task) Ptrace(req int64, pid ThreadID, addr, data usermem.Addr) error {
 		// to indicate the actual number of bytes returned." - ptrace(2)
 		ars, err := t.CopyInIovecs(data, 1)
 		if err != nil {
-			return err
+			return 0, err
 		}
 		ar := ars.Head()
 		n, err := target.Arch().PtraceGetRegSet(uintptr(addr), &usermem.IOReadWriter{
@@ -919,7 +981,7 @@ func (t *Task) Ptrace(req int64, pid ThreadID, addr, data usermem.Addr) error {
 			},
 		}, int(ar.Length()))
 		if err != nil {
-			return err
+			return 0, err
 		}
 
 		// Update iovecs to represent the range of the written register set.
@@ -928,7 +990,7 @@ func (t *Task) Ptrace(req int64, pid ThreadID, addr, data usermem.Addr) error {
 			panic(fmt.Sprintf("%#x + %#x overflows. Invalid reg size > %#x", ar.Start, n, ar.Length()))
 		}
 		ar.End = end
-		return t.CopyOutIovecs(data, usermem.AddrRangeSeqOf(ar))
+		return 0, t.CopyOutIovecs(data, usermem.AddrRangeSeqOf(ar))
 
 	case linux.PTRACE_SETREGS:
 		_, err := target.Arch().PtraceSetRegs(&usermem.IOReadWriter{
@@ -939,7 +1001,7 @@ func (t *Task) Ptrace(req int64, pid ThreadID, addr, data usermem.Addr) error {
 				AddressSpaceActive: true,
 			},
 		})
-		return err
+		return 0, err
 
 	case linux.PTRACE_SETFPREGS:
 		_, err := target.Arch().PtraceSetFPRegs(&usermem.IOReadWriter{
@@ -950,12 +1012,12 @@ func (t *Task) Ptrace(req int64, pid ThreadID, addr, data usermem.Addr) error {
 				AddressSpaceActive: true,
 			},
 		})
-		return err
+		return 0, err
 
 	case linux.PTRACE_SETREGSET:
 		ars, err := t.CopyInIovecs(data, 1)
 		if err != nil {
-			return err
+			return 0, err
 		}
 		ar := ars.Head()
 		n, err := target.Arch().PtraceSetRegSet(uintptr(addr), &usermem.IOReadWriter{
@@ -967,51 +1029,51 @@ func (t *Task) Ptrace(req int64, pid ThreadID, addr, data usermem.Addr) error {
 			},
 		}, int(ar.Length()))
 		if err != nil {
-			return err
+			return 0, err
 		}
 		ar.End -= usermem.Addr(n)
-		return t.CopyOutIovecs(data, usermem.AddrRangeSeqOf(ar))
+		return 0, t.CopyOutIovecs(data, usermem.AddrRangeSeqOf(ar))
 
 	case linux.PTRACE_GETSIGINFO:
 		t.tg.pidns.owner.mu.RLock()
 		defer t.tg.pidns.owner.mu.RUnlock()
 		if target.ptraceSiginfo == nil {
-			return syserror.EINVAL
+			return 0, syserror.EINVAL
 		}
 		_, err := t.CopyOut(data, target.ptraceSiginfo)
-		return err
+		return 0, err
 
 	case linux.PTRACE_SETSIGINFO:
 		var info arch.SignalInfo
 		if _, err := t.CopyIn(data, &info); err != nil {
-			return err
+			return 0, err
 		}
 		t.tg.pidns.owner.mu.RLock()
 		defer t.tg.pidns.owner.mu.RUnlock()
 		if target.ptraceSiginfo == nil {
-			return syserror.EINVAL
+			return 0, syserror.EINVAL
 		}
 		target.ptraceSiginfo = &info
-		return nil
+		return 0, nil
 
 	case linux.PTRACE_GETSIGMASK:
 		if addr != linux.SignalSetSize {
-			return syserror.EINVAL
+			return 0, syserror.EINVAL
 		}
 		_, err := t.CopyOut(data, target.SignalMask())
-		return err
+		return 0, err
 
 	case linux.PTRACE_SETSIGMASK:
 		if addr != linux.SignalSetSize {
-			return syserror.EINVAL
+			return 0, syserror.EINVAL
 		}
 		var mask linux.SignalSet
 		if _, err := t.CopyIn(data, &mask); err != nil {
-			return err
+			return 0, err
 		}
 		// The target's task goroutine is stopped, so this is safe:
 		target.SetSignalMask(mask &^ UnblockableSignals)
-		return nil
+		return 0, nil
 
 	case linux.PTRACE_SETOPTIONS:
 		t.tg.pidns.owner.mu.Lock()
@@ -1026,7 +1088,7 @@ func (t *Task) Ptrace(req int64, pid ThreadID, addr, data usermem.Addr) error {
 			linux.PTRACE_O_TRACEVFORK |
 			linux.PTRACE_O_TRACEVFORKDONE)
 		if uintptr(data)&^validOpts != 0 {
-			return syserror.EINVAL
+			return 0, syserror.EINVAL
 		}
 		target.ptraceOpts = ptraceOptions{
 			ExitKill:       data&linux.PTRACE_O_EXITKILL != 0,
@@ -1039,16 +1101,22 @@ func (t *Task) Ptrace(req int64, pid ThreadID, addr, data usermem.Addr) error {
 			TraceVfork:     data&linux.PTRACE_O_TRACEVFORK != 0,
 			TraceVforkDone: data&linux.PTRACE_O_TRACEVFORKDONE != 0,
 		}
-		return nil
+		return 0, nil
 
 	case linux.PTRACE_GETEVENTMSG:
 		t.tg.pidns.owner.mu.RLock()
 		defer t.tg.pidns.owner.mu.RUnlock()
 		_, err := t.CopyOut(usermem.Addr(data), target.ptraceEventMsg)
-		return err
+		return 0, err
+
+	case linux.PTRACE_SECCOMP_GET_FILTER:
+		return t.ptraceSeccompGetFilter(target, uint64(addr), data)
+
+	case linux.PTRACE_SECCOMP_GET_METADATA:
+		return t.ptraceSeccompGetMetadata(target, uint64(addr), data)
 
 	default:
 		// PEEKSIGINFO is unimplemented but seems to have no users anywhere.
-		return syserror.EIO
+		return 0, syserror.EIO
 	}
 }
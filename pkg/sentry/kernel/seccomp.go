@@ -15,18 +15,230 @@
 package kernel
 
 import (
+	encbinary "encoding/binary"
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
+	"unsafe"
 
 	"gvisor.googlesource.com/gvisor/pkg/abi/linux"
 	"gvisor.googlesource.com/gvisor/pkg/binary"
 	"gvisor.googlesource.com/gvisor/pkg/bpf"
+	"gvisor.googlesource.com/gvisor/pkg/log"
+	"gvisor.googlesource.com/gvisor/pkg/metric"
 	"gvisor.googlesource.com/gvisor/pkg/sentry/arch"
+	"gvisor.googlesource.com/gvisor/pkg/sentry/fs"
+	"gvisor.googlesource.com/gvisor/pkg/sentry/kernel/kdefs"
 	"gvisor.googlesource.com/gvisor/pkg/sentry/usermem"
 	"gvisor.googlesource.com/gvisor/pkg/syserror"
 )
 
+// CompileSeccompFilters is a flag used to enable or disable lowering
+// application seccomp-bpf filters with bpf.NewExecutable when they are
+// installed, which makes evaluating them faster than interpreting them with
+// bpf.Exec. Valid values are 0 or 1.
+//
+// CompileSeccompFilters must be accessed atomically.
+var CompileSeccompFilters uint32
+
+// SeccompTraceToUserNotif is a flag used to deliver SECCOMP_RET_TRACE results
+// to a seccomp user notification listener. If it is 1, a system call for
+// which the task's filters return SECCOMP_RET_TRACE while it has no ptracer
+// and its thread group has no SeccompTraceSink is sent as a notification to
+// the listener of the task's filters, if one exists, as if the filters had
+// returned SECCOMP_RET_USER_NOTIF, rather than failing with ENOSYS. This lets
+// a single supervisor handle profiles written for ptrace-based tracers. If it
+// is 0, SECCOMP_RET_TRACE behaves as in Linux. Valid values are 0 or 1.
+//
+// SeccompTraceToUserNotif must be accessed atomically.
+var SeccompTraceToUserNotif uint32
+
+// IgnoreSeccompFilters is a flag used to disable enforcement of application
+// seccomp-bpf filters. If it is 1, every system call is allowed as if all
+// application filters returned SECCOMP_RET_ALLOW. Applications can still
+// install filters, and SeccompMode and GetSeccompFilters still report them, so
+// applications can't detect that their filters are not enforced.
+// SECCOMP_MODE_STRICT and baseline filters (see InstallBaselineSyscallFilter)
+// are still enforced. Valid values are 0 or 1.
+//
+// The application's filters are part of its own defense in depth: setting
+// IgnoreSeccompFilters exposes the sentry's full system call surface to code
+// that the application expected to be confined (e.g. sandboxed renderers or
+// untrusted plugins), leaving only the sandbox itself to contain it. It should
+// only be used for debugging.
+//
+// IgnoreSeccompFilters must be accessed atomically.
+var IgnoreSeccompFilters uint32
+
+// DenySeccompExecErrors is a flag used to select the result of an application
+// seccomp-bpf filter whose execution fails, which can only happen for programs
+// that should have been rejected when they were installed. If it is 0, the
+// filter returns SECCOMP_RET_KILL_THREAD, as Linux would for a program that
+// reads past the end of struct seccomp_data. If it is 1, the filter returns
+// SECCOMP_RET_ERRNO with EPERM instead, so that a bug in the sentry's BPF
+// interpreter fails the system call rather than the task. In either case the
+// error is logged and counted by seccompExecErrorMetric. Valid values are 0 or
+// 1.
+//
+// DenySeccompExecErrors must be accessed atomically.
+var DenySeccompExecErrors uint32
+
+// maxSyscallFilterInstructions is the maximum combined length of a task's
+// system call filters, as computed by syscallFiltersLength. It is equal to
+// Linux's MAX_INSNS_PER_PATH.
 const maxSyscallFilterInstructions = 1 << 15
 
+// syscallFilterOverhead is the number of instructions that each system call
+// filter other than the most recently installed one contributes to
+// syscallFiltersLength in addition to its own length, accounting for the
+// per-filter overhead of walking the filter chain.
+const syscallFilterOverhead = 4
+
+// MaxSeccompFilterBytes is the maximum amount of memory, in bytes, that a
+// task's system call filters may consume, as estimated by
+// syscallFiltersBytes. Installing a filter that would exceed it fails with
+// ENOMEM. This complements maxSyscallFilterInstructions, which bounds the
+// filters' instructions but not the sentry's per-filter data structures, so
+// that many small filters can't consume a disproportionate amount of memory
+// on hosts running many sandboxes. If MaxSeccompFilterBytes is 0, the memory
+// consumed by a task's filters is limited only by
+// maxSyscallFilterInstructions.
+//
+// MaxSeccompFilterBytes must be accessed atomically.
+var MaxSeccompFilterBytes uint64
+
+const (
+	// seccompLogRate and seccompLogBurst are the default limits on the rate
+	// at which seccomp log records are emitted: seccompLogBurst records may be
+	// emitted at once, after which records are allowed at seccompLogRate
+	// records per second. These match the average rate allowed by Linux's
+	// DEFAULT_RATELIMIT_INTERVAL and DEFAULT_RATELIMIT_BURST.
+	seccompLogRate  = 2
+	seccompLogBurst = 10
+)
+
+// Counters of seccomp-bpf filter results, by action. There is no counter for
+// SECCOMP_RET_ALLOW, which is by far the most common result.
+var (
+	seccompTrapMetric        = metric.MustCreateNewUint64Metric("/seccomp/trap", false /* sync */, "Number of syscalls for which seccomp filters returned SECCOMP_RET_TRAP.")
+	seccompErrnoMetric       = metric.MustCreateNewUint64Metric("/seccomp/errno", false /* sync */, "Number of syscalls for which seccomp filters returned SECCOMP_RET_ERRNO.")
+	seccompUserNotifMetric   = metric.MustCreateNewUint64Metric("/seccomp/user_notif", false /* sync */, "Number of syscalls for which seccomp filters returned SECCOMP_RET_USER_NOTIF.")
+	seccompTraceMetric       = metric.MustCreateNewUint64Metric("/seccomp/trace", false /* sync */, "Number of syscalls for which seccomp filters returned SECCOMP_RET_TRACE.")
+	seccompLogMetric         = metric.MustCreateNewUint64Metric("/seccomp/log", false /* sync */, "Number of syscalls for which seccomp filters returned SECCOMP_RET_LOG.")
+	seccompKillThreadMetric  = metric.MustCreateNewUint64Metric("/seccomp/kill_thread", true /* sync */, "Number of syscalls for which seccomp filters returned SECCOMP_RET_KILL_THREAD.")
+	seccompKillProcessMetric = metric.MustCreateNewUint64Metric("/seccomp/kill_process", true /* sync */, "Number of syscalls for which seccomp filters returned SECCOMP_RET_KILL_PROCESS or an invalid action.")
+)
+
+// seccompExecErrorMetric counts executions of seccomp-bpf filters that
+// failed, whose results are determined by DenySeccompExecErrors.
+var seccompExecErrorMetric = metric.MustCreateNewUint64Metric("/seccomp/exec_error", false /* sync */, "Number of seccomp filter executions that failed with an error.")
+
+// seccompFilterBytesInstalledMetric counts the memory, as estimated by
+// syscallFilter.size, of every system call filter ever installed. Filters
+// shared between tasks, as after fork(2) or SECCOMP_FILTER_FLAG_TSYNC, are
+// counted once. The count is cumulative: it doesn't decrease when filters are
+// released, so it measures installation churn rather than current usage,
+// which is reported for each task by Task.SeccompFilterBytes.
+var seccompFilterBytesInstalledMetric = metric.MustCreateNewUint64Metric("/seccomp/filter_bytes_installed", false /* sync */, "Cumulative number of bytes of memory of installed seccomp filters, counting filters shared between tasks once.")
+
+// seccompLogSuppressedMetric counts seccomp log records that were dropped by
+// seccompLogLimiter.
+var seccompLogSuppressedMetric = metric.MustCreateNewUint64Metric("/seccomp/log_suppressed", false /* sync */, "Number of seccomp log records that were not emitted due to rate limiting.")
+
+// seccompLogLimiter rate-limits seccomp log records across all tasks.
+var seccompLogLimiter = newLogRateLimiter(seccompLogRate, seccompLogBurst)
+
+// Counters of filter synchronizations by SyncSyscallFiltersToThreadGroup
+// (SECCOMP_FILTER_FLAG_TSYNC). Synchronizations that fail for reasons other
+// than a conflicting thread, such as an invalid program, are not counted.
+var (
+	seccompTsyncMetric        = metric.MustCreateNewUint64Metric("/seccomp/tsync", false /* sync */, "Number of successful seccomp filter synchronizations across thread groups.")
+	seccompTsyncAbortedMetric = metric.MustCreateNewUint64Metric("/seccomp/tsync_aborted", false /* sync */, "Number of seccomp filter synchronizations across thread groups aborted because of a thread with conflicting filters.")
+	seccompTsyncThreadsMetric = metric.MustCreateNewUint64Metric("/seccomp/tsync_threads", false /* sync */, "Number of threads whose seccomp filters were set by successful synchronizations, including the synchronizing threads.")
+)
+
+// SetSeccompLogRateLimit sets the maximum rate at which seccomp log records
+// are emitted, across all tasks, to rate records per second, with bursts of
+// up to burst records.
+func SetSeccompLogRateLimit(rate float64, burst int) {
+	seccompLogLimiter.setLimit(rate, burst)
+}
+
+// logRateLimiter is a token bucket rate limiter.
+type logRateLimiter struct {
+	mu sync.Mutex
+
+	// rate is the rate at which tokens are added to the bucket, in tokens per
+	// second.
+	rate float64
+
+	// burst is the capacity of the bucket.
+	burst float64
+
+	// tokens is the number of tokens in the bucket as of last.
+	tokens float64
+
+	// last is the last time at which tokens was updated.
+	last time.Time
+}
+
+// newLogRateLimiter returns a logRateLimiter that allows rate events per
+// second, with bursts of up to burst events.
+func newLogRateLimiter(rate float64, burst int) *logRateLimiter {
+	return &logRateLimiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+	}
+}
+
+// setLimit changes the limits of l.
+func (l *logRateLimiter) setLimit(rate float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate = rate
+	l.burst = float64(burst)
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+}
+
+// allow returns true if an event may occur now.
+func (l *logRateLimiter) allow() bool {
+	return l.allowAt(time.Now())
+}
+
+// allowAt returns true if an event may occur at time now, which must not
+// precede the time passed to any previous call.
+func (l *logRateLimiter) allowAt(now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// seccompLogAllowed returns true if a seccomp log record may be emitted now.
+// If it returns false, the record is counted by seccompLogSuppressedMetric.
+func seccompLogAllowed() bool {
+	if !seccompLogLimiter.allow() {
+		seccompLogSuppressedMetric.Increment()
+		return false
+	}
+	return true
+}
+
 type seccompResult int
 
 const (
@@ -40,9 +252,19 @@ const (
 	// with the exit status indicating that the task was killed by SIGSYS.
 	seccompResultKill
 
+	// seccompResultKillProcess indicates that the task's thread group should
+	// be killed immediately, with the exit status indicating that the thread
+	// group was killed by SIGSYS.
+	seccompResultKillProcess
+
 	// seccompResultTrace indicates that a ptracer was successfully notified as
 	// a result of a SECCOMP_RET_TRACE.
 	seccompResultTrace
+
+	// seccompResultKillStrict indicates that the task should be killed
+	// immediately, with the exit status indicating that the task was killed
+	// by SIGKILL, as for a forbidden syscall in SECCOMP_MODE_STRICT.
+	seccompResultKillStrict
 )
 
 // seccompData is equivalent to struct seccomp_data, which contains the data
@@ -62,42 +284,277 @@ type seccompData struct {
 	args [6]uint64
 }
 
-func (d *seccompData) asBPFInput() bpf.Input {
-	return bpf.InputBytes{binary.Marshal(nil, usermem.ByteOrder, d), usermem.ByteOrder}
+// syscallFilter is a seccomp-bpf filter installed by a task.
+//
+// +stateify savable
+type syscallFilter struct {
+	// program is the filter's BPF program, as installed by the task.
+	program bpf.Program
+
+	// optimized is program optimized by bpf.Optimize, which is executed in
+	// its place. program is retained since it is observable through
+	// PTRACE_SECCOMP_GET_FILTER and counts towards the total filter length.
+	optimized bpf.Program `state:"nosave"`
+
+	// listener receives notifications for system calls for which program
+	// returns SECCOMP_RET_USER_NOTIF. If listener is nil, such system calls
+	// fail with ENOSYS.
+	listener *SeccompListener
+
+	// flags is the set of per-filter SECCOMP_FILTER_FLAG_* flags that were
+	// specified when the filter was installed: SECCOMP_FILTER_FLAG_LOG and
+	// SECCOMP_FILTER_FLAG_SPEC_ALLOW. The latter has no effect, but is
+	// recorded so that it is reported by PTRACE_SECCOMP_GET_METADATA.
+	flags uint32
+
+	// cache caches program's results for system calls for which they are
+	// constant.
+	cache seccompCache
+
+	// executable is optimized lowered by bpf.NewExecutable, or nil if
+	// CompileSeccompFilters was not set when the filter was installed or
+	// restored.
+	executable *bpf.Executable `state:"nosave"`
+
+	// allowlist evaluates program without interpreting it, or is nil if
+	// program does not have the form described by seccompAllowlist.
+	allowlist *seccompAllowlist `state:"nosave"`
+
+	// size is an estimate of the memory consumed by the filter, in bytes,
+	// which is charged against MaxSeccompFilterBytes. size is immutable.
+	size uint64 `state:"nosave"`
+
+	// If baseline is true, the filter was installed by the sentry rather
+	// than by the application, using InstallBaselineSyscallFilter. Baseline
+	// filters precede every filter installed by the application, and are
+	// hidden from it.
+	baseline bool
+}
+
+// newSyscallFilter returns a syscallFilter for BPF program p with per-filter
+// flags flags, for use by tasks using t's syscall table.
+func (t *Task) newSyscallFilter(p bpf.Program, flags uint32) *syscallFilter {
+	return newSyscallFilterForTable(p, flags, t.SyscallTable())
+}
+
+// newSyscallFilterForTable returns a new syscallFilter for program p with
+// per-filter flags flags, for use by tasks using syscall table st.
+func newSyscallFilterForTable(p bpf.Program, flags uint32, st *SyscallTable) *syscallFilter {
+	f := &syscallFilter{
+		program: p,
+		flags:   flags,
+		cache:   newSeccompCache(p, st.AuditNumber, len(st.lookup)),
+	}
+	f.afterLoad()
+	return f
+}
+
+// afterLoad is invoked by stateify.
+func (f *syscallFilter) afterLoad() {
+	// Identical programs are usually installed in many tasks, so share their
+	// compiled forms rather than recompiling them.
+	compile := atomic.LoadUint32(&CompileSeccompFilters) != 0
+	compiled := seccompCompiledPrograms.get(f.program, compile)
+	f.optimized = compiled.optimized
+	f.allowlist = compiled.allowlist
+	if compile {
+		f.executable = compiled.executable
+	}
+	f.size = f.memoryUsage()
+}
+
+// memoryUsage returns an estimate of the memory consumed by f, in bytes: the
+// filter itself, its program and optimized program, and its cache. The
+// compiled executable and allowlist, which are optional, are not included, so
+// that whether a filter can be installed doesn't depend on the sentry's
+// configuration.
+func (f *syscallFilter) memoryUsage() uint64 {
+	size := uint64(unsafe.Sizeof(*f))
+	size += uint64(f.program.Length()+f.optimized.Length()) * uint64(bpfInstructionSize)
+	size += uint64(len(f.cache.constant)+len(f.cache.allowed))*8 + uint64(len(f.cache.results))*4
+	return size
+}
+
+// exec executes f's program over input.
+func (f *syscallFilter) exec(input bpf.Input) (uint32, error) {
+	if f.executable != nil {
+		return f.executable.Exec(input)
+	}
+	return bpf.Exec(f.optimized, input)
+}
+
+// SeccompDataSize is the size of struct seccomp_data in bytes.
+const SeccompDataSize = 64
+
+// seccompDataByteOrder is the byte order of seccompData as seen by BPF
+// programs. As in Linux, where the kernel populates struct seccomp_data
+// natively and BPF_LD loads convert from network byte order only for socket
+// filters, seccomp-bpf programs see seccomp_data in the guest's native byte
+// order. Thus 32-bit loads of nr and arch yield their values regardless of
+// endianness, while the halves of 64-bit fields (instruction_pointer and
+// args) are ordered as in the guest: on a big-endian architecture, the high
+// half of args[0] is at offset 16 and its low half at offset 20.
+var seccompDataByteOrder encbinary.ByteOrder = usermem.ByteOrder
+
+// SeccompNotifSize is the size of struct seccomp_notif in bytes.
+const SeccompNotifSize = 16 + SeccompDataSize
+
+// MarshalSeccompData writes d to buf, which must be at least SeccompDataSize
+// bytes long, with the layout of struct seccomp_data in byte order order. It
+// is the single definition of that layout, shared by seccomp-bpf filter input
+// and user notifications. The result is identical to that of binary.Marshal,
+// but MarshalSeccompData neither allocates nor uses reflection.
+func MarshalSeccompData(buf []byte, order encbinary.ByteOrder, d *linux.SeccompData) {
+	order.PutUint32(buf[0:], uint32(d.Nr))
+	order.PutUint32(buf[4:], d.Arch)
+	order.PutUint64(buf[8:], d.InstructionPointer)
+	for i, arg := range d.Args {
+		order.PutUint64(buf[16+8*i:], arg)
+	}
+}
+
+// UnmarshalSeccompData reads d from buf, which must be at least
+// SeccompDataSize bytes long, as written by MarshalSeccompData with byte order
+// order.
+func UnmarshalSeccompData(buf []byte, order encbinary.ByteOrder, d *linux.SeccompData) {
+	d.Nr = int32(order.Uint32(buf[0:]))
+	d.Arch = order.Uint32(buf[4:])
+	d.InstructionPointer = order.Uint64(buf[8:])
+	for i := range d.Args {
+		d.Args[i] = order.Uint64(buf[16+8*i:])
+	}
 }
 
-func seccompSiginfo(t *Task, errno, sysno int32, ip usermem.Addr) *arch.SignalInfo {
+// MarshalSeccompNotif writes n to buf, which must be at least
+// SeccompNotifSize bytes long, with the layout of struct seccomp_notif in byte
+// order order. n.Data is written by MarshalSeccompData.
+func MarshalSeccompNotif(buf []byte, order encbinary.ByteOrder, n *linux.SeccompNotif) {
+	order.PutUint64(buf[0:], n.ID)
+	order.PutUint32(buf[8:], n.Pid)
+	order.PutUint32(buf[12:], n.Flags)
+	MarshalSeccompData(buf[16:], order, &n.Data)
+}
+
+// toABI returns d as a struct seccomp_data.
+func (d *seccompData) toABI() linux.SeccompData {
+	return linux.SeccompData{
+		Nr:                 d.nr,
+		Arch:               d.arch,
+		InstructionPointer: d.instructionPointer,
+		Args:               d.args,
+	}
+}
+
+// marshal writes d to buf, as by MarshalSeccompData.
+func (d *seccompData) marshal(buf []byte, order encbinary.ByteOrder) {
+	sd := d.toABI()
+	MarshalSeccompData(buf, order, &sd)
+}
+
+// seccompInput is a reusable bpf.Input for seccompData, which allows filters
+// to be evaluated without allocation.
+type seccompInput struct {
+	buf [SeccompDataSize]byte
+	in  bpf.InputBytes
+}
+
+// load marshals d into i, and returns i as a bpf.Input. The returned Input is
+// only valid until the next call to load.
+func (i *seccompInput) load(d *seccompData) bpf.Input {
+	d.marshal(i.buf[:], seccompDataByteOrder)
+	i.in = bpf.InputBytes{Data: i.buf[:], Order: seccompDataByteOrder}
+	return &i.in
+}
+
+// seccompSiginfo returns the SIGSYS sent by SECCOMP_RET_TRAP, with si_errno
+// errno, for the system call described by data, as for Linux's
+// kernel/seccomp.c:seccomp_init_siginfo(). This is the only signal that
+// seccomp delivers to the application, and its si_code is always SYS_SECCOMP,
+// which distinguishes it from a SIGSYS sent by kill(2) (SI_USER) or another
+// task's tgkill(2) (SI_TKILL). (Linux also fills in a SIGSYS for the kill
+// actions, but only for core dumps, since no handler can run.)
+func seccompSiginfo(data *seccompData, errno int32) *arch.SignalInfo {
 	si := &arch.SignalInfo{
 		Signo: int32(linux.SIGSYS),
 		Errno: errno,
 		Code:  arch.SYS_SECCOMP,
 	}
-	si.SetCallAddr(uint64(ip))
-	si.SetSyscall(sysno)
-	si.SetArch(t.SyscallTable().AuditNumber)
+	si.SetCallAddr(data.instructionPointer)
+	si.SetSyscall(data.nr)
+	si.SetArch(data.arch)
 	return si
 }
 
-// checkSeccompSyscall applies the task's seccomp filters before the execution
-// of syscall sysno at instruction pointer ip. (These parameters must be passed
-// in because vsyscalls do not use the values in t.Arch().)
+// checkSeccompSyscall applies the task's seccomp filters, or the restrictions
+// of SECCOMP_MODE_STRICT, before the execution of syscall sysno at instruction
+// pointer ip. (These parameters must be passed in because vsyscalls do not use
+// the values in t.Arch().)
 //
 // Preconditions: The caller must be running on the task goroutine.
 func (t *Task) checkSeccompSyscall(sysno int32, args arch.SyscallArguments, ip usermem.Addr) seccompResult {
-	result := t.evaluateSyscallFilters(sysno, args, ip)
-	switch result & linux.SECCOMP_RET_ACTION {
+	if t.seccompStrict {
+		// The only filters of a task in SECCOMP_MODE_STRICT are baseline
+		// filters.
+		if r := t.checkSeccompStrict(sysno, args, ip); r != seccompResultAllow || len(t.syscallFilterChain()) == 0 {
+			return r
+		}
+	}
+
+	data := t.seccompData(sysno, args, ip)
+	result, filter := t.evaluateSyscallFilters(&data)
+	action, retData := splitSeccompResult(result)
+	if filter != nil && filter.flags&linux.SECCOMP_FILTER_FLAG_LOG != 0 && action != linux.SECCOMP_RET_ALLOW && action != linux.SECCOMP_RET_LOG {
+		// "All filter return actions except SECCOMP_RET_ALLOW should be
+		// logged." - seccomp(2), on SECCOMP_FILTER_FLAG_LOG.
+		// (SECCOMP_RET_LOG is logged below.)
+		t.seccompLog(&data, result)
+	}
+	if seccompAuditLogged(action, filter) {
+		t.seccompAudit(&data, action, seccompAuditSignal(action))
+	}
+	switch action {
 	case linux.SECCOMP_RET_TRAP:
 		// "Results in the kernel sending a SIGSYS signal to the triggering
 		// task without executing the system call. ... The SECCOMP_RET_DATA
 		// portion of the return value will be passed as si_errno." -
 		// Documentation/prctl/seccomp_filter.txt
-		t.SendSignal(seccompSiginfo(t, int32(result&linux.SECCOMP_RET_DATA), sysno, ip))
+		seccompTrapMetric.Increment()
+		// As in Linux's force_sig_seccomp(), SIGSYS is delivered even if it
+		// is blocked or ignored, in which case the default action (dumping
+		// core) applies. The signal is forced and sent under a single
+		// critical section, so that another thread in the thread group can't
+		// ignore SIGSYS again in between and cause it to be discarded. Either
+		// way, the system call is not executed.
+		info := seccompSiginfo(&data, int32(retData))
+		t.tg.pidns.owner.mu.RLock()
+		t.tg.signalHandlers.mu.Lock()
+		t.forceSignalLocked(linux.SIGSYS, false /* unconditional */)
+		t.sendSignalLocked(info, false /* group */)
+		t.tg.signalHandlers.mu.Unlock()
+		t.tg.pidns.owner.mu.RUnlock()
 		return seccompResultDeny
 
 	case linux.SECCOMP_RET_ERRNO:
 		// "Results in the lower 16-bits of the return value being passed to
 		// userland as the errno without executing the system call."
-		t.Arch().SetReturn(-uintptr(result & linux.SECCOMP_RET_DATA))
+		seccompErrnoMetric.Increment()
+		t.Arch().SetReturn(-uintptr(seccompErrno(result)))
+		return seccompResultDeny
+
+	case linux.SECCOMP_RET_USER_NOTIF:
+		// "Results in a struct seccomp_notif message sent on the userspace
+		// notification fd, if it is attached, or -ENOSYS if it is not." -
+		// Documentation/userspace-api/seccomp_filter.rst
+		seccompUserNotifMetric.Increment()
+		if filter.listener == nil {
+			// This useless-looking temporary is needed because Go.
+			tmp := uintptr(syscall.ENOSYS)
+			t.Arch().SetReturn(-tmp)
+			return seccompResultDeny
+		}
+		if filter.listener.notify(t, &data) {
+			return seccompResultAllow
+		}
 		return seccompResultDeny
 
 	case linux.SECCOMP_RET_TRACE:
@@ -105,57 +562,467 @@ func (t *Task) checkSeccompSyscall(sysno int32, args arch.SyscallArguments, ip u
 		// notify a ptrace()-based tracer prior to executing the system call.
 		// If there is no tracer present, -ENOSYS is returned to userland and
 		// the system call is not executed."
-		if t.ptraceSeccomp(uint16(result & linux.SECCOMP_RET_DATA)) {
+		seccompTraceMetric.Increment()
+		msg := retData
+		if t.ptraceSeccomp(msg) {
 			return seccompResultTrace
 		}
-		// This useless-looking temporary is needed because Go.
-		tmp := uintptr(syscall.ENOSYS)
-		t.Arch().SetReturn(-tmp)
+		// Without a ptracer, defer to the thread group's SeccompTraceSink,
+		// if any.
+		if ok, err := t.seccompTrace(&data, msg); ok {
+			if err == nil {
+				return seccompResultAllow
+			}
+			t.setSyscallError(err, int(sysno))
+			return seccompResultDeny
+		}
+		if atomic.LoadUint32(&SeccompTraceToUserNotif) != 0 {
+			if l := t.seccompListener(); l != nil {
+				if l.notify(t, &data) {
+					return seccompResultAllow
+				}
+				return seccompResultDeny
+			}
+		}
+		// Fail the syscall in the same way as an unimplemented syscall.
+		t.setSyscallError(syscall.ENOSYS, int(sysno))
 		return seccompResultDeny
 
+	case linux.SECCOMP_RET_LOG:
+		// "Results in the system call being executed after it is logged."
+		seccompLogMetric.Increment()
+		t.seccompLog(&data, result)
+		return seccompResultAllow
+
 	case linux.SECCOMP_RET_ALLOW:
 		// "Results in the system call being executed."
 		return seccompResultAllow
 
-	case linux.SECCOMP_RET_KILL:
+	case linux.SECCOMP_RET_KILL_THREAD:
 		// "Results in the task exiting immediately without executing the
 		// system call. The exit status of the task will be SIGSYS, not
 		// SIGKILL."
+		seccompKillThreadMetric.Increment()
+		t.seccompLogKill(&data, filter, result)
+		return seccompResultKill
+
+	case linux.SECCOMP_RET_KILL_PROCESS:
+		// "Results in the entire process exiting immediately without
+		// executing the system call. The exit status of the task (status &
+		// 0x7f) will be SIGSYS, not SIGKILL." -
+		// Documentation/userspace-api/seccomp_filter.rst
 		fallthrough
 	default: // consistent with Linux
-		return seccompResultKill
+		seccompKillProcessMetric.Increment()
+		t.seccompLogKill(&data, filter, result)
+		return seccompResultKillProcess
+	}
+}
+
+// seccompListener returns the listener of the task's system call filters, or
+// nil if none of them has a listener.
+func (t *Task) seccompListener() *SeccompListener {
+	return syscallFiltersListener(t.syscallFilterChain())
+}
+
+// syscallFiltersListener returns the listener of filters, or nil if none of
+// them has a listener.
+func syscallFiltersListener(filters []*syscallFilter) *SeccompListener {
+	// There is at most one listener in a filter chain.
+	for _, filter := range filters {
+		if filter.listener != nil {
+			return filter.listener
+		}
+	}
+	return nil
+}
+
+// splitSeccompResult splits result, a value returned by a seccomp-bpf filter,
+// into its action, the SECCOMP_RET_ACTION_FULL portion, and its data, the
+// 16-bit SECCOMP_RET_DATA portion. Every interpretation of a filter's result
+// uses it, so that all actions treat their data identically.
+func splitSeccompResult(result uint32) (action uint32, data uint16) {
+	return result & linux.SECCOMP_RET_ACTION_FULL, uint16(result & linux.SECCOMP_RET_DATA)
+}
+
+// seccompErrno returns the errno specified by SECCOMP_RET_ERRNO result result.
+// As in Linux, the errno is clamped to MAX_ERRNO, so that it can't be mistaken
+// for a non-error return value.
+func seccompErrno(result uint32) uint32 {
+	_, data := splitSeccompResult(result)
+	errno := uint32(data)
+	if errno > linux.MAX_ERRNO {
+		errno = linux.MAX_ERRNO
+	}
+	return errno
+}
+
+// checkSeccompStrict implements checkSeccompSyscall for tasks in
+// SECCOMP_MODE_STRICT.
+//
+// Preconditions: The caller must be running on the task goroutine.
+func (t *Task) checkSeccompStrict(sysno int32, args arch.SyscallArguments, ip usermem.Addr) seccompResult {
+	// "The only system calls that the calling thread is permitted to make
+	// are read(2), write(2), _exit(2) (but not exit_group(2)), and
+	// sigreturn(2). Other system calls result in the delivery of a SIGKILL
+	// signal." - seccomp(2)
+	if containsSyscall(t.SyscallTable().SeccompStrict, sysno) {
+		return seccompResultAllow
+	}
+	data := t.seccompData(sysno, args, ip)
+	t.seccompLog(&data, linux.SECCOMP_RET_KILL_THREAD)
+	t.seccompAudit(&data, linux.SECCOMP_RET_KILL_THREAD, linux.SIGKILL)
+	return seccompResultKillStrict
+}
+
+// seccompLog logs the application of a seccomp filter that returned result to
+// the system call described by data, subject to seccompLogLimiter.
+func (t *Task) seccompLog(data *seccompData, result uint32) {
+	if !seccompLogAllowed() {
+		return
+	}
+	t.Infof("seccomp: %s ret=%#x", t.seccompLogFields(data, result), result)
+}
+
+// seccompLogKill logs the disassembly of filter, whose result, result, caused
+// the system call described by data to kill the task or its thread group, if
+// debug logging is enabled.
+func (t *Task) seccompLogKill(data *seccompData, filter *syscallFilter, result uint32) {
+	if !log.IsLogging(log.Debug) || !seccompLogAllowed() {
+		return
 	}
+	t.Debugf("seccomp: %s killed by filter:\n%s", t.seccompLogFields(data, result), bpf.Disassemble(filter.program))
 }
 
-func (t *Task) evaluateSyscallFilters(sysno int32, args arch.SyscallArguments, ip usermem.Addr) uint32 {
+// seccompLogFields returns the fields that identify the task, the system call
+// described by data, and the seccomp action in result in seccomp log records.
+// Thread IDs are relative to the root PID namespace.
+func (t *Task) seccompLogFields(data *seccompData, result uint32) string {
+	action, _ := splitSeccompResult(result)
+	root := t.tg.pidns.owner.Root
+	return fmt.Sprintf("pid=%d tid=%d syscall=%s nr=%d arch=%#x ip=%#x action=%s", root.IDOfThreadGroup(t.tg), root.IDOfTask(t), t.SyscallTable().LookupName(uintptr(data.nr)), data.nr, data.arch, data.instructionPointer, seccompActionName(action))
+}
+
+// seccompActionName returns the name of the SECCOMP_RET_* action.
+func seccompActionName(action uint32) string {
+	switch action {
+	case linux.SECCOMP_RET_KILL_PROCESS:
+		return "kill_process"
+	case linux.SECCOMP_RET_KILL_THREAD:
+		return "kill_thread"
+	case linux.SECCOMP_RET_TRAP:
+		return "trap"
+	case linux.SECCOMP_RET_ERRNO:
+		return "errno"
+	case linux.SECCOMP_RET_USER_NOTIF:
+		return "user_notif"
+	case linux.SECCOMP_RET_TRACE:
+		return "trace"
+	case linux.SECCOMP_RET_LOG:
+		return "log"
+	case linux.SECCOMP_RET_ALLOW:
+		return "allow"
+	default:
+		return fmt.Sprintf("%#x", action)
+	}
+}
+
+// seccompActions contains every SECCOMP_RET_* action defined by Linux, in
+// decreasing order of precedence.
+var seccompActions = []uint32{
+	linux.SECCOMP_RET_KILL_PROCESS,
+	linux.SECCOMP_RET_KILL_THREAD,
+	linux.SECCOMP_RET_TRAP,
+	linux.SECCOMP_RET_ERRNO,
+	linux.SECCOMP_RET_USER_NOTIF,
+	linux.SECCOMP_RET_TRACE,
+	linux.SECCOMP_RET_LOG,
+	linux.SECCOMP_RET_ALLOW,
+}
+
+// SeccompAction is a SECCOMP_RET_* action supported by seccomp-bpf filters.
+type SeccompAction struct {
+	// Action is the action's SECCOMP_RET_* value, with no data.
+	Action uint32
+
+	// Name is the action's name, as listed in Linux's
+	// /proc/sys/kernel/seccomp/actions_avail.
+	Name string
+}
+
+// SeccompActionsAvailable returns the SECCOMP_RET_* actions supported by
+// seccomp-bpf filters in this sentry, in decreasing order of precedence. These
+// are exactly the actions for which SeccompActionAvailable, and hence
+// seccomp(SECCOMP_GET_ACTION_AVAIL), reports support, so embedders and test
+// harnesses can use it to decide which profiles can be installed without
+// making system calls on behalf of a task.
+func SeccompActionsAvailable() []SeccompAction {
+	var actions []SeccompAction
+	for _, action := range seccompActions {
+		if SeccompActionAvailable(action) {
+			actions = append(actions, SeccompAction{
+				Action: action,
+				Name:   seccompActionName(action),
+			})
+		}
+	}
+	return actions
+}
+
+// seccompActionKills returns true if checkSeccompSyscall kills the task, or
+// its thread group, for action, which must be a SECCOMP_RET_* action with no
+// data. As in Linux, invalid actions kill the thread group.
+func seccompActionKills(action uint32) bool {
+	switch action {
+	case linux.SECCOMP_RET_KILL_PROCESS, linux.SECCOMP_RET_KILL_THREAD:
+		return true
+	default:
+		return !SeccompActionAvailable(action)
+	}
+}
+
+// SeccompActionAvailable returns true if checkSeccompSyscall supports action,
+// which must be a SECCOMP_RET_* action with no data.
+func SeccompActionAvailable(action uint32) bool {
+	switch action {
+	case linux.SECCOMP_RET_KILL_PROCESS,
+		linux.SECCOMP_RET_KILL_THREAD,
+		linux.SECCOMP_RET_TRAP,
+		linux.SECCOMP_RET_ERRNO,
+		linux.SECCOMP_RET_USER_NOTIF,
+		linux.SECCOMP_RET_TRACE,
+		linux.SECCOMP_RET_LOG,
+		linux.SECCOMP_RET_ALLOW:
+		return true
+	default:
+		return false
+	}
+}
+
+// seccompData returns the seccompData describing syscall sysno, invoked with
+// the given arguments at instruction pointer ip. The reported architecture is
+// that of the task's syscall table, which determines the syscall's calling
+// convention.
+func (t *Task) seccompData(sysno int32, args arch.SyscallArguments, ip usermem.Addr) seccompData {
 	data := seccompData{
 		nr:                 sysno,
 		arch:               t.tc.st.AuditNumber,
 		instructionPointer: uint64(ip),
 	}
+	// As in Linux, the arguments of syscalls using a 32-bit calling
+	// convention are the (zero-extended) 32-bit register values.
+	compat := data.arch&linux.AUDIT_ARCH_64BIT == 0
 	// data.args is []uint64 and args is []arch.SyscallArgument (uintptr), so
 	// we can't do any slicing tricks or even use copy/append here.
 	for i, arg := range args {
 		if i >= len(data.args) {
 			break
 		}
-		data.args[i] = arg.Uint64()
+		if compat {
+			data.args[i] = uint64(arg.Uint())
+		} else {
+			data.args[i] = arg.Uint64()
+		}
 	}
-	input := data.asBPFInput()
+	return data
+}
 
-	ret := uint32(linux.SECCOMP_RET_ALLOW)
-	f := t.syscallFilters.Load()
-	if f == nil {
+// seccompExecErrorResult returns the result of a seccomp-bpf filter whose
+// execution failed, as selected by DenySeccompExecErrors.
+func seccompExecErrorResult() uint32 {
+	if atomic.LoadUint32(&DenySeccompExecErrors) != 0 {
+		return linux.SECCOMP_RET_ERRNO | uint32(syscall.EPERM)
+	}
+	return linux.SECCOMP_RET_KILL_THREAD
+}
+
+// seccompExecErrorString describes err, an error returned by executing f. If
+// err is a bpf.Error, the description includes the kind of error and the
+// failing instruction, which is an instruction of f.optimized: its index need
+// not be that of any instruction of the installed program.
+func seccompExecErrorString(f *syscallFilter, err error) string {
+	e, ok := err.(bpf.Error)
+	if !ok {
+		return err.Error()
+	}
+	insns := f.optimized.Instructions()
+	if e.PC < 0 || e.PC >= len(insns) {
+		return fmt.Sprintf("%s at instruction %d of optimized program", e.Kind(), e.PC)
+	}
+	inst, derr := bpf.Decode(insns[e.PC])
+	if derr != nil {
+		inst = fmt.Sprintf("%+v", insns[e.PC])
+	}
+	return fmt.Sprintf("%s at instruction %d of optimized program (%s)", e.Kind(), e.PC, inst)
+}
+
+// EvaluateSyscallFilters returns the result, a SECCOMP_RET_* action combined
+// with its SECCOMP_RET_DATA, of applying the task's seccomp filters to system
+// call sysno with arguments args at instruction pointer ip, using the calling
+// convention of the task's syscall table. If the task has no filters, the
+// result is SECCOMP_RET_ALLOW; as before system calls, SECCOMP_MODE_STRICT is
+// not considered, and IgnoreSeccompFilters is respected.
+//
+// Unlike the check made before each system call, EvaluateSyscallFilters does
+// not act on the result: it sends no signals, sets no return values, and
+// neither notifies listeners or tracers nor logs. Nor does it update the
+// task's result cache or CountSeccompEvaluations, or log or count filters
+// whose execution fails, so that its evaluations can't be mistaken for
+// system calls. This allows tests to check the decisions of a policy without
+// running a guest.
+//
+// Preconditions: The caller must be running on the task goroutine.
+func (t *Task) EvaluateSyscallFilters(sysno int32, args arch.SyscallArguments, ip usermem.Addr) uint32 {
+	data := t.seccompData(sysno, args, ip)
+	var in seccompInput
+	ret, _, _ := evaluateSyscallFilterChain(t.enforcedSyscallFilterChain(), &data, &in, nil)
+	return ret
+}
+
+// SeccompPolicy is a snapshot of a task's seccomp policy, as returned by
+// Task.SeccompPolicy. It returns the result, a SECCOMP_RET_* action combined
+// with its SECCOMP_RET_DATA, that the policy selects for the system call
+// described by data.
+type SeccompPolicy func(data linux.SeccompData) uint32
+
+// SeccompPolicy returns the task's current seccomp policy, including baseline
+// filters and SECCOMP_MODE_STRICT, as a decision function. The policy is a
+// consistent snapshot: filters installed afterward, by the task or by
+// SECCOMP_FILTER_FLAG_TSYNC, don't change it. This allows the policies of
+// different tasks, or a task's policy and an expected one, to be compared by
+// evaluating both over the same inputs.
+//
+// Unlike EvaluateSyscallFilters, the returned policy doesn't read the task's
+// state, so it may be retained and called from any goroutine, concurrently.
+// It depends only on its argument: IgnoreSeccompFilters is not considered,
+// and the policy of a task in SECCOMP_MODE_STRICT kills the task for a system
+// call not permitted by its syscall table, whatever data.Arch. If the task
+// has no policy, the returned policy always returns SECCOMP_RET_ALLOW.
+//
+// SeccompPolicy may be called from any goroutine.
+func (t *Task) SeccompPolicy() SeccompPolicy {
+	t.tg.seccompMu.Lock()
+	t.seccompMu.Lock()
+	strict := t.seccompStrict
+	filters := t.syscallFilterChain()
+	var allowed []uintptr
+	if strict {
+		t.mu.Lock()
+		allowed = t.SyscallTable().SeccompStrict
+		t.mu.Unlock()
+	}
+	t.seccompMu.Unlock()
+	t.tg.seccompMu.Unlock()
+
+	return func(sd linux.SeccompData) uint32 {
+		if strict && !containsSyscall(allowed, sd.Nr) {
+			return linux.SECCOMP_RET_KILL_THREAD
+		}
+		data := seccompData{
+			nr:                 sd.Nr,
+			arch:               sd.Arch,
+			instructionPointer: sd.InstructionPointer,
+			args:               sd.Args,
+		}
+		var in seccompInput
+		ret, _, _ := evaluateSyscallFilterChain(filters, &data, &in, nil)
 		return ret
 	}
+}
+
+// containsSyscall returns true if sysnos contains sysno.
+func containsSyscall(sysnos []uintptr, sysno int32) bool {
+	for _, s := range sysnos {
+		if uintptr(sysno) == s {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluateSyscallFilters returns the result of applying the task's seccomp
+// filters to data, along with the filter that determined the result. If
+// IgnoreSeccompFilters is set, only the task's baseline filters are applied.
+// The returned filter is nil if and only if no filters were applied. Of
+// filters returning the same action, the most recently installed one
+// determines the result.
+func (t *Task) evaluateSyscallFilters(data *seccompData) (uint32, *syscallFilter) {
+	ret := uint32(linux.SECCOMP_RET_ALLOW)
+	filters := t.enforcedSyscallFilterChain()
+	if len(filters) == 0 {
+		return ret, nil
+	}
+	if atomic.LoadUint32(&CountSeccompEvaluations) != 0 {
+		countSeccompEvaluation(data.nr)
+	}
 
 	// "Every filter successfully installed will be evaluated (in reverse
 	// order) for each system call the task makes." - kernel/seccomp.c
-	for i := len(f.([]bpf.Program)) - 1; i >= 0; i-- {
-		thisRet, err := bpf.Exec(f.([]bpf.Program)[i], input)
-		if err != nil {
-			t.Debugf("seccomp-bpf filter %d returned error: %v", i, err)
-			thisRet = linux.SECCOMP_RET_KILL
+	if t.seccompAllowed.contains(filters, data) {
+		// Evaluating the filters would return the result of the most
+		// recently installed filter, since all filters return the same
+		// result.
+		return ret, filters[len(filters)-1]
+	}
+	cacheResults := atomic.LoadUint32(&CacheSeccompResults) != 0
+	if cacheResults {
+		if ret, filter, ok := t.seccompResults.lookup(filters, data); ok {
+			return ret, filter
+		}
+	}
+	ret, filter, executed := evaluateSyscallFilterChain(filters, data, &t.seccompInput, func(i int, err error, result uint32) {
+		seccompExecErrorMetric.Increment()
+		if seccompLogAllowed() {
+			t.Warningf("seccomp-bpf filter %d failed: %s; using result %#x", i, seccompExecErrorString(filters[i], err), result)
+		}
+	})
+
+	if cacheResults && executed {
+		// At least one filter was executed, so caching the result saves
+		// more than a constant-time lookup.
+		t.seccompResults.insert(filters, data, ret, filter)
+	}
+	return ret, filter
+}
+
+// enforcedSyscallFilterChain returns the filters of syscallFilterChain that
+// are enforced: all of them, or only baseline filters if IgnoreSeccompFilters
+// is set.
+func (t *Task) enforcedSyscallFilterChain() []*syscallFilter {
+	filters := t.syscallFilterChain()
+	if atomic.LoadUint32(&IgnoreSeccompFilters) != 0 {
+		// Baseline filters are the sentry's, not the application's, so they
+		// are enforced regardless.
+		filters = filters[:len(filters)-len(guestSyscallFilters(filters))]
+	}
+	return filters
+}
+
+// evaluateSyscallFilterChain returns the result of applying filters to data,
+// along with the filter that determined the result, or nil if filters is
+// empty. in holds data for any filter that must be executed; executed is true
+// if one was. If execError is not nil, it is called with the index, error and
+// substituted result of each filter whose execution fails.
+func evaluateSyscallFilterChain(filters []*syscallFilter, data *seccompData, in *seccompInput, execError func(i int, err error, result uint32)) (ret uint32, filter *syscallFilter, executed bool) {
+	var input bpf.Input
+	ret = linux.SECCOMP_RET_ALLOW
+	for i := len(filters) - 1; i >= 0; i-- {
+		thisRet, ok := filters[i].cache.lookup(data)
+		if !ok && filters[i].allowlist != nil {
+			thisRet, ok = filters[i].allowlist.evaluate(data), true
+		}
+		if !ok {
+			if input == nil {
+				input = in.load(data)
+			}
+			var err error
+			thisRet, err = filters[i].exec(input)
+			if err != nil {
+				thisRet = seccompExecErrorResult()
+				if execError != nil {
+					execError(i, err, thisRet)
+				}
+			}
 		}
 		// "If multiple filters exist, the return value for the evaluation of a
 		// given system call will always use the highest precedent value." -
@@ -167,81 +1034,908 @@ func (t *Task) evaluateSyscallFilters(sysno int32, args arch.SyscallArguments, i
 		//
 		// "The ordering ensures that a min_t() over composed return values
 		// always selects the least permissive choice." -
-		// include/uapi/linux/seccomp.h
-		if (thisRet & linux.SECCOMP_RET_ACTION) < (ret & linux.SECCOMP_RET_ACTION) {
+		// Documentation/userspace-api/seccomp_filter.rst
+		//
+		// The comparison is signed, so that SECCOMP_RET_KILL_PROCESS takes
+		// precedence over all other actions. It is also strict, and of
+		// actions only: as in Linux's kernel/seccomp.c:seccomp_run_filters(),
+		// when several filters return the same action, the result, including
+		// its SECCOMP_RET_DATA (such as the si_errno of SECCOMP_RET_TRAP), and
+		// the filter whose flags apply are those of the most recently
+		// installed of them, which is evaluated first.
+		thisAction, _ := splitSeccompResult(thisRet)
+		action, _ := splitSeccompResult(ret)
+		if filter == nil || int32(thisAction) < int32(action) {
 			ret = thisRet
+			action = thisAction
+			filter = filters[i]
+		}
+		if action == linux.SECCOMP_RET_KILL_PROCESS {
+			// No other result can take precedence, so the remaining
+			// filters need not be evaluated.
+			break
 		}
 	}
+	return ret, filter, input != nil
+}
 
-	return ret
+// AppendSyscallFilter adds BPF program p as a system call filter. flags is
+// the set of per-filter SECCOMP_FILTER_FLAG_* flags to apply to p.
+//
+// Preconditions: The caller must be running on the task goroutine.
+func (t *Task) AppendSyscallFilter(p bpf.Program, flags uint32) error {
+	return t.appendSyscallFilter(t.newSyscallFilter(p, flags))
 }
 
-// AppendSyscallFilter adds BPF program p as a system call filter.
+// AppendSyscallFilters adds the programs of filters as system call filters,
+// with their per-filter flags, in order, as if by successive calls to
+// AppendSyscallFilter. The filters are installed atomically: if any of them
+// can't be installed, including because their combined length exceeds the
+// limit on the total length of the task's filters, none of them are, and
+// AppendSyscallFilters returns the error that AppendSyscallFilter would. If
+// filters is empty, AppendSyscallFilters does nothing.
 //
 // Preconditions: The caller must be running on the task goroutine.
-func (t *Task) AppendSyscallFilter(p bpf.Program) error {
-	// Cap the combined length of all syscall filters (plus a penalty of 4
-	// instructions per filter beyond the first) to
-	// maxSyscallFilterInstructions. (This restriction is inherited from
-	// Linux.)
-	totalLength := p.Length()
-	var newFilters []bpf.Program
-
-	// While syscallFilters are an atomic.Value we must take the mutex to
-	// prevent our read-copy-update from happening while another task
-	// is syncing syscall filters to us, this keeps the filters in a
-	// consistent state.
+func (t *Task) AppendSyscallFilters(filters []SeccompFilter) error {
+	if len(filters) == 0 {
+		return nil
+	}
+	fs := make([]*syscallFilter, len(filters))
+	for i, f := range filters {
+		fs[i] = t.newSyscallFilter(f.Program, f.Flags)
+	}
+	return t.appendSyscallFilter(fs...)
+}
+
+// InstallSeccompFilter installs the seccomp-bpf filter encoded in buf, as an
+// array of struct sock_filter in usermem.ByteOrder (the format written by
+// libseccomp's seccomp_export_bpf()), as a system call filter for the task.
+// It allows embedders of the sentry to install seccomp policies in tasks that
+// they create, without the tasks calling seccomp(2) themselves.
+//
+// flags may contain SECCOMP_FILTER_FLAG_LOG, SECCOMP_FILTER_FLAG_SPEC_ALLOW
+// (which is recorded but has no effect, as for seccomp(2)), and
+// SECCOMP_FILTER_FLAG_TSYNC to also install the filter in every other thread of
+// the task's thread group, as for SyncSyscallFiltersToThreadGroup. Errors are
+// those of seccomp(2): EINVAL if flags contains any other flag, buf is not a
+// valid seccomp filter, or the task is in SECCOMP_MODE_STRICT; EACCES if the
+// task has neither no_new_privs set nor CAP_SYS_ADMIN in its user namespace;
+// ENOMEM if the task's filters would exceed the maximum total length; and, for
+// SECCOMP_FILTER_FLAG_TSYNC, a *SyscallFilterSyncError if another thread's
+// filters prevent synchronization.
+//
+// Unlike AppendSyscallFilter, InstallSeccompFilter may be called from any
+// goroutine, e.g. by sandbox setup code before the task is started. However,
+// it must not be called concurrently with an execve(2) by the task.
+func (t *Task) InstallSeccompFilter(buf []byte, flags uint32) error {
+	if flags&^(linux.SECCOMP_FILTER_FLAG_TSYNC|linux.SECCOMP_FILTER_FLAG_LOG|linux.SECCOMP_FILTER_FLAG_SPEC_ALLOW) != 0 {
+		return syserror.EINVAL
+	}
+	if len(buf) == 0 || len(buf)%bpfInstructionSize != 0 || len(buf)/bpfInstructionSize > bpf.MaxInstructions {
+		return syserror.EINVAL
+	}
+	insns := make([]linux.BPFInstruction, len(buf)/bpfInstructionSize)
+	binary.Unmarshal(buf, usermem.ByteOrder, insns)
+	p, err := bpf.Compile(insns)
+	if err != nil {
+		return syserror.EINVAL
+	}
+
 	t.mu.Lock()
-	defer t.mu.Unlock()
-	if sf := t.syscallFilters.Load(); sf != nil {
-		oldFilters := sf.([]bpf.Program)
-		for _, f := range oldFilters {
-			totalLength += f.Length() + 4
-		}
-		newFilters = append(newFilters, oldFilters...)
+	st := t.tc.st
+	t.mu.Unlock()
+	f := newSyscallFilterForTable(p, flags&^linux.SECCOMP_FILTER_FLAG_TSYNC, st)
+	if flags&linux.SECCOMP_FILTER_FLAG_TSYNC != 0 {
+		return t.syncSyscallFiltersToThreadGroup(f)
 	}
+	return t.appendSyscallFilter(f)
+}
 
-	if totalLength > maxSyscallFilterInstructions {
+// InstallBaselineSyscallFilter installs BPF program p as a baseline system
+// call filter for the task: a filter owned by the sentry rather than the
+// application, which embedders can use to guarantee a floor under any policy
+// the application installs. As for any filter, every system call's result is
+// the highest-precedence result of all of the task's filters, so the
+// application can't override the baseline filter's decisions by installing
+// filters that allow the system call. Nor can it remove the baseline filter,
+// which is inherited, synchronized and restored like other filters. Unlike
+// other filters, the baseline filter also applies in SECCOMP_MODE_STRICT, to
+// system calls that strict mode allows.
+//
+// Baseline filters are hidden from the application: they aren't included in
+// SeccompMode, SeccompFilterCount, GetSeccompFilters or
+// PTRACE_SECCOMP_GET_FILTER, so that the task may still enter either seccomp
+// mode. They do count towards the limits on the length and memory usage of the
+// task's filters.
+//
+// Baseline filters must precede the application's filters, so if the task has
+// already installed filters, InstallBaselineSyscallFilter returns EBUSY. If p
+// is not a valid seccomp filter, it returns EINVAL. Like InstallSeccompFilter,
+// InstallBaselineSyscallFilter may be called from any goroutine, but must not
+// be called concurrently with an execve(2) by the task.
+func (t *Task) InstallBaselineSyscallFilter(p bpf.Program) error {
+	if err := checkSeccompProgram(p); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	st := t.tc.st
+	t.mu.Unlock()
+	f := newSyscallFilterForTable(p, 0, st)
+	f.baseline = true
+
+	t.tg.seccompMu.Lock()
+	defer t.tg.seccompMu.Unlock()
+	t.seccompMu.Lock()
+	defer t.seccompMu.Unlock()
+	oldFilters := t.syscallFilterChain()
+	if len(guestSyscallFilters(oldFilters)) != 0 {
+		return syserror.EBUSY
+	}
+	newFilters := make([]*syscallFilter, len(oldFilters), len(oldFilters)+1)
+	copy(newFilters, oldFilters)
+	newFilters = append(newFilters, f)
+	if syscallFiltersLength(newFilters) > maxSyscallFilterInstructions {
 		return syserror.ENOMEM
 	}
+	t.divergeSeccompLocked()
+	t.syscallFilters.Store(newFilters)
+	seccompFilterBytesInstalledMetric.IncrementBy(f.size)
+	t.warnAlwaysKills(len(newFilters)-1, []*syscallFilter{f})
+	return nil
+}
+
+// guestSyscallFilters returns filters, a filter chain, without its baseline
+// filters, which are the filters visible to the application.
+func guestSyscallFilters(filters []*syscallFilter) []*syscallFilter {
+	i := 0
+	for i < len(filters) && filters[i].baseline {
+		i++
+	}
+	return filters[i:]
+}
 
-	newFilters = append(newFilters, p)
+// InstallSeccompFilters installs filters, as returned by
+// UnmarshalSeccompFilterBundle, as system call filters for the task, in
+// order. As for AppendSyscallFilters, the filters are installed atomically,
+// and errors are those of AppendSyscallFilter, except that the task needn't
+// have no_new_privs set or CAP_SYS_ADMIN: the filters are installed by the
+// sentry's embedder on the application's behalf, as a container runtime
+// installs its profile before dropping the container's capabilities, and
+// setting no_new_privs would change the meaning of the application's execve
+// calls.
+//
+// Like InstallSeccompFilter, and unlike AppendSyscallFilters,
+// InstallSeccompFilters may be called from any goroutine, but must not be
+// called concurrently with an execve(2) by the task.
+func (t *Task) InstallSeccompFilters(filters []SeccompFilter) error {
+	if len(filters) == 0 {
+		return nil
+	}
+	t.mu.Lock()
+	st := t.tc.st
+	t.mu.Unlock()
+	fs := make([]*syscallFilter, len(filters))
+	for i, f := range filters {
+		fs[i] = newSyscallFilterForTable(f.Program, f.Flags, st)
+	}
+	return t.appendSyscallFilterChecked(false /* checkPrivilege */, fs...)
+}
+
+// AppendSyscallFilterWithListener adds BPF program p as a system call filter,
+// and returns a new SeccompListener that receives the notifications generated
+// by SECCOMP_RET_USER_NOTIF actions from p. If any of the task's existing
+// filters already has a listener, AppendSyscallFilterWithListener returns
+// EBUSY.
+//
+// Preconditions: The caller must be running on the task goroutine.
+func (t *Task) AppendSyscallFilterWithListener(p bpf.Program, flags uint32) (*SeccompListener, error) {
+	l := NewSeccompListener()
+	f := t.newSyscallFilter(p, flags)
+	f.listener = l
+	if err := t.appendSyscallFilter(f); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// SyncSyscallFiltersToThreadGroupWithListener is equivalent to
+// SyncSyscallFiltersToThreadGroup, except that the new filter has a new
+// SeccompListener, as for AppendSyscallFilterWithListener, which is returned
+// if synchronization succeeds. Since the filter chain is shared by every
+// thread in the thread group, so is the listener. If any of the task's
+// existing filters already has a listener, it returns EBUSY.
+//
+// Preconditions: The caller must be running on the task goroutine.
+func (t *Task) SyncSyscallFiltersToThreadGroupWithListener(p bpf.Program, flags uint32) (*SeccompListener, error) {
+	l := NewSeccompListener()
+	f := t.newSyscallFilter(p, flags)
+	f.listener = l
+	if err := t.syncSyscallFiltersToThreadGroup(f); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// NewSeccompListenerFD is equivalent to AppendSyscallFilterWithListener, or to
+// SyncSyscallFiltersToThreadGroupWithListener if tsync is true, except that
+// the new listener is represented by the file returned by newFile, which is
+// installed in the task's FDMap with the given flags. NewSeccompListenerFD
+// returns the file's new file descriptor.
+//
+// As in Linux, the file descriptor is allocated before the filter is
+// installed, so if allocation fails (e.g. with EMFILE because of
+// RLIMIT_NOFILE), the task's filters are unchanged; otherwise the filter
+// would be installed without any way to receive its notifications. If the
+// filter can't be installed, the file descriptor is removed again.
+//
+// Preconditions: The caller must be running on the task goroutine.
+func (t *Task) NewSeccompListenerFD(p bpf.Program, flags uint32, tsync bool, newFile func(*SeccompListener) *fs.File, fdFlags FDFlags) (kdefs.FD, error) {
+	l := NewSeccompListener()
+	file := newFile(l)
+	defer file.DecRef()
+	fd, err := t.FDMap().NewFDFrom(0, file, fdFlags, t.ThreadGroup().Limits())
+	if err != nil {
+		return 0, err
+	}
+	f := t.newSyscallFilter(p, flags)
+	f.listener = l
+	if tsync {
+		err = t.syncSyscallFiltersToThreadGroup(f)
+	} else {
+		err = t.appendSyscallFilter(f)
+	}
+	if err != nil {
+		// Another thread may have replaced fd in the meantime, so only remove
+		// it if it still refers to file.
+		t.FDMap().RemoveIf(func(f *fs.File, _ FDFlags) bool {
+			return f == file
+		})
+		return 0, err
+	}
+	return fd, nil
+}
+
+// appendSyscallFilter adds fs, in order, to the task's system call filters.
+func (t *Task) appendSyscallFilter(fs ...*syscallFilter) error {
+	return t.appendSyscallFilterChecked(true /* checkPrivilege */, fs...)
+}
+
+// appendSyscallFilterChecked is equivalent to appendSyscallFilter, except that
+// if checkPrivilege is false, the task needn't have no_new_privs set or
+// CAP_SYS_ADMIN.
+func (t *Task) appendSyscallFilterChecked(checkPrivilege bool, fs ...*syscallFilter) error {
+	// While syscallFilters are an atomic.Value we must take the mutexes to
+	// prevent our read-copy-update from happening while another task
+	// is syncing syscall filters to the thread group, this keeps the
+	// filters in a consistent state.
+	t.tg.seccompMu.Lock()
+	defer t.tg.seccompMu.Unlock()
+	t.seccompMu.Lock()
+	defer t.seccompMu.Unlock()
+	newFilters, err := t.appendedSyscallFiltersLocked(checkPrivilege, fs...)
+	if err != nil {
+		return err
+	}
+	// The new filters apply to this task alone, so it diverges from its
+	// thread group's filters if it hasn't already.
+	t.divergeSeccompLocked()
 	t.syscallFilters.Store(newFilters)
+	if l := syscallFiltersListener(fs); l != nil {
+		l.addUsers(1)
+	}
+	seccompFilterBytesInstalledMetric.IncrementBy(syscallFiltersBytes(fs))
+	t.warnAlwaysKills(len(newFilters)-len(fs), fs)
+	return nil
+}
+
+// ownSyscallFilters returns the task's own chain of system call filters, which
+// is empty unless the task has diverged from its thread group.
+func (t *Task) ownSyscallFilters() []*syscallFilter {
+	if f := t.syscallFilters.Load(); f != nil {
+		return f.([]*syscallFilter)
+	}
+	return nil
+}
+
+// syscallFilterChain returns the system call filters applicable to the task,
+// in the order in which they were installed: its own chain if it has diverged
+// from its thread group, or its thread group's otherwise. The returned slice
+// is immutable.
+func (t *Task) syscallFilterChain() []*syscallFilter {
+	if filters := t.ownSyscallFilters(); len(filters) != 0 {
+		return filters
+	}
+	if f := t.tg.syscallFilters.Load(); f != nil {
+		return f.([]*syscallFilter)
+	}
 	return nil
 }
 
-// SyncSyscallFiltersToThreadGroup will copy this task's filters to all other
-// threads in our thread group.
-func (t *Task) SyncSyscallFiltersToThreadGroup() error {
-	f := t.syscallFilters.Load()
+// divergeSeccompLocked records that the task is about to stop using its thread
+// group's system call filters, by installing its own or entering
+// SECCOMP_MODE_STRICT, so that TSYNC checks it. It has no effect if the task
+// has already diverged.
+//
+// Preconditions: t.tg.seccompMu and t.seccompMu must be locked.
+func (t *Task) divergeSeccompLocked() {
+	if !t.seccompStrict && len(t.ownSyscallFilters()) == 0 {
+		t.tg.seccompDiverged++
+	}
+}
 
+// warnAlwaysKills logs a warning for each filter in fs that kills the task
+// for every system call, which makes the task exit on its next system call.
+// The filters are installed regardless, since the task may intend this. The
+// first filter in fs has index first among the task's filters.
+func (t *Task) warnAlwaysKills(first int, fs []*syscallFilter) {
+	for i, f := range fs {
+		if f.cache.alwaysKills() {
+			t.Warningf("Installed seccomp-bpf filter %d kills every system call, so the task will be killed by its next system call; the filter may be missing its allow rules", first+i)
+		}
+	}
+}
+
+// appendedSyscallFiltersLocked returns a new slice containing the task's system
+// call filters with fs appended. If checkPrivilege is true and the task has
+// neither no_new_privs set nor CAP_SYS_ADMIN in its user namespace,
+// appendedSyscallFiltersLocked returns EACCES. If the program of any filter in
+// fs is not a valid seccomp filter, or the task is in SECCOMP_MODE_STRICT,
+// appendedSyscallFiltersLocked returns EINVAL. If more than one of the
+// resulting filters has a listener, it returns EBUSY, and if their combined
+// length or estimated memory usage is too large, it returns ENOMEM.
+//
+// Preconditions: t.tg.seccompMu and t.seccompMu must be locked.
+func (t *Task) appendedSyscallFiltersLocked(checkPrivilege bool, fs ...*syscallFilter) ([]*syscallFilter, error) {
+	if checkPrivilege {
+		if err := t.checkSeccompFilterPrivilegeLocked(); err != nil {
+			return nil, err
+		}
+	}
+	for _, f := range fs {
+		if err := checkSeccompProgram(f.program); err != nil {
+			return nil, err
+		}
+	}
+	if err := t.seccompMayAssignModeLocked(linux.SECCOMP_MODE_FILTER); err != nil {
+		return nil, err
+	}
+
+	oldFilters := t.syscallFilterChain()
+	// oldFilters may be shared with other tasks, so copy it rather than
+	// appending to it in place.
+	newFilters := make([]*syscallFilter, len(oldFilters), len(oldFilters)+len(fs))
+	copy(newFilters, oldFilters)
+	newFilters = append(newFilters, fs...)
+
+	// As in Linux, only one filter in a given filter chain may have a
+	// listener.
+	listeners := 0
+	for _, f := range newFilters {
+		if f.listener != nil {
+			listeners++
+		}
+	}
+	if listeners > 1 {
+		return nil, syserror.EBUSY
+	}
+
+	if syscallFiltersLength(newFilters) > maxSyscallFilterInstructions {
+		return nil, syserror.ENOMEM
+	}
+	if max := atomic.LoadUint64(&MaxSeccompFilterBytes); max != 0 && syscallFiltersBytes(newFilters) > max {
+		return nil, syserror.ENOMEM
+	}
+	return newFilters, nil
+}
+
+// CheckSeccompFilterPrivilege returns EACCES if the task may not install
+// system call filters because it has neither no_new_privs set nor
+// CAP_SYS_ADMIN in its user namespace. seccomp(SECCOMP_SET_MODE_FILTER) calls
+// it before reading the filter program, so that, as in Linux, an unprivileged
+// task fails with EACCES even if its program is invalid.
+func (t *Task) CheckSeccompFilterPrivilege() error {
+	t.tg.seccompMu.Lock()
+	defer t.tg.seccompMu.Unlock()
+	t.seccompMu.Lock()
+	defer t.seccompMu.Unlock()
+	return t.checkSeccompFilterPrivilegeLocked()
+}
+
+// checkSeccompFilterPrivilegeLocked implements CheckSeccompFilterPrivilege.
+//
+// Preconditions: t.tg.seccompMu and t.seccompMu must be locked.
+func (t *Task) checkSeccompFilterPrivilegeLocked() error {
+	// "Prior to use, the task must call prctl(PR_SET_NO_NEW_PRIVS, 1) or run
+	// with CAP_SYS_ADMIN privileges in its namespace. If these are not true,
+	// -EACCES will be returned." - Documentation/prctl/seccomp_filter.txt
+	if !t.noNewPrivsLocked() && !t.HasCapability(linux.CAP_SYS_ADMIN) {
+		return syserror.EACCES
+	}
+	return nil
+}
+
+// seccompMayAssignModeLocked returns EINVAL if the task may not enter seccomp
+// mode mode, as for Linux's kernel/seccomp.c:seccomp_may_assign_mode(). A task
+// in SECCOMP_MODE_NONE may enter either SECCOMP_MODE_STRICT or
+// SECCOMP_MODE_FILTER, and a task that has entered a mode may re-enter it
+// (installing additional filters, or setting SECCOMP_MODE_STRICT again, which
+// has no effect), but a task may never switch between SECCOMP_MODE_STRICT and
+// SECCOMP_MODE_FILTER or leave either of them.
+//
+// Preconditions: t.seccompMu must be locked.
+func (t *Task) seccompMayAssignModeLocked(mode int) error {
+	if cur := t.seccompModeLocked(); cur != linux.SECCOMP_MODE_NONE && cur != mode {
+		return syserror.EINVAL
+	}
+	return nil
+}
+
+// checkSeccompProgram returns EINVAL if p uses instructions that are not
+// permitted in seccomp filters, as for Linux's
+// kernel/seccomp.c:seccomp_check_filter(). p has already been validated as a
+// BPF program by bpf.Compile; seccomp additionally restricts loads to aligned
+// words within struct seccomp_data, and forbids instructions that are
+// meaningless for seccomp (such as BPF_MOD and loads relative to X).
+func checkSeccompProgram(p bpf.Program) error {
+	for _, i := range p.Instructions() {
+		switch i.OpCode {
+		case bpf.Ld | bpf.W | bpf.Abs:
+			// This also rejects the negative offsets that socket filters
+			// use to load ancillary data and packet headers
+			// (linux.SKF_AD_OFF etc.), which are out of bounds as uint32s.
+			if i.K >= SeccompDataSize || i.K%4 != 0 {
+				return syserror.EINVAL
+			}
+		case bpf.Ld | bpf.W | bpf.Len, bpf.Ldx | bpf.W | bpf.Len,
+			bpf.Ret | bpf.K, bpf.Ret | bpf.A,
+			bpf.Alu | bpf.Add | bpf.K, bpf.Alu | bpf.Add | bpf.X,
+			bpf.Alu | bpf.Sub | bpf.K, bpf.Alu | bpf.Sub | bpf.X,
+			bpf.Alu | bpf.Mul | bpf.K, bpf.Alu | bpf.Mul | bpf.X,
+			bpf.Alu | bpf.Div | bpf.K, bpf.Alu | bpf.Div | bpf.X,
+			bpf.Alu | bpf.And | bpf.K, bpf.Alu | bpf.And | bpf.X,
+			bpf.Alu | bpf.Or | bpf.K, bpf.Alu | bpf.Or | bpf.X,
+			bpf.Alu | bpf.Xor | bpf.K, bpf.Alu | bpf.Xor | bpf.X,
+			bpf.Alu | bpf.Lsh | bpf.K, bpf.Alu | bpf.Lsh | bpf.X,
+			bpf.Alu | bpf.Rsh | bpf.K, bpf.Alu | bpf.Rsh | bpf.X,
+			bpf.Alu | bpf.Neg,
+			bpf.Ld | bpf.Imm, bpf.Ldx | bpf.Imm,
+			bpf.Misc | bpf.Tax, bpf.Misc | bpf.Txa,
+			bpf.Ld | bpf.Mem, bpf.Ldx | bpf.Mem,
+			bpf.St, bpf.Stx,
+			bpf.Jmp | bpf.Ja,
+			bpf.Jmp | bpf.Jeq | bpf.K, bpf.Jmp | bpf.Jeq | bpf.X,
+			bpf.Jmp | bpf.Jge | bpf.K, bpf.Jmp | bpf.Jge | bpf.X,
+			bpf.Jmp | bpf.Jgt | bpf.K, bpf.Jmp | bpf.Jgt | bpf.X,
+			bpf.Jmp | bpf.Jset | bpf.K, bpf.Jmp | bpf.Jset | bpf.X:
+			// Allowed.
+		default:
+			return syserror.EINVAL
+		}
+	}
+	return nil
+}
+
+// syscallFiltersLength returns the combined length of filters, as limited by
+// maxSyscallFilterInstructions. Since bpf.Program.Length is O(1), this is
+// linear in the number of filters, which the limit bounds to
+// maxSyscallFilterInstructions / (syscallFilterOverhead + 1). The sum
+// saturates at maxInt rather than overflowing, so that no combination of
+// lengths can wrap around to one within the limit.
+func syscallFiltersLength(filters []*syscallFilter) int {
+	// As in Linux's kernel/seccomp.c:seccomp_attach_filter(), every filter
+	// but the most recently installed one incurs a penalty of
+	// syscallFilterOverhead instructions.
+	var totalLength int
+	for i, f := range filters {
+		totalLength = addSyscallFiltersLength(totalLength, f.program.Length())
+		if i != len(filters)-1 {
+			totalLength = addSyscallFiltersLength(totalLength, syscallFilterOverhead)
+		}
+	}
+	return totalLength
+}
+
+// maxInt is the maximum value of an int.
+const maxInt = int(^uint(0) >> 1)
+
+// addSyscallFiltersLength returns total + n, or maxInt if the sum would
+// overflow. total and n must be non-negative.
+func addSyscallFiltersLength(total, n int) int {
+	if n > maxInt-total {
+		return maxInt
+	}
+	return total + n
+}
+
+// syscallFiltersBytes returns the estimated memory consumed by filters, in
+// bytes, as limited by MaxSeccompFilterBytes.
+func syscallFiltersBytes(filters []*syscallFilter) uint64 {
+	var total uint64
+	for _, f := range filters {
+		total += f.size
+	}
+	return total
+}
+
+// SyscallFilterSyncError is returned by SyncSyscallFiltersToThreadGroup when
+// another thread's system call filters prevent synchronization.
+type SyscallFilterSyncError struct {
+	// TID is the ID of the thread that prevented synchronization, in the PID
+	// namespace of the synchronizing task.
+	TID ThreadID
+}
+
+// Error implements error.Error.
+func (e *SyscallFilterSyncError) Error() string {
+	return fmt.Sprintf("seccomp filters of thread %d can't be synchronized", e.TID)
+}
+
+// SyncSyscallFiltersToThreadGroup adds BPF program p as a system call filter
+// with per-filter flags flags, as for AppendSyscallFilter, and copies the
+// task's resulting filters to all other threads in its thread group, as for
+// SECCOMP_FILTER_FLAG_TSYNC.
+//
+// Synchronization is all-or-nothing: if another thread's filters are not an
+// ancestor of this task's filters, no filters are changed, and
+// SyncSyscallFiltersToThreadGroup returns a *SyscallFilterSyncError
+// identifying that thread.
+//
+// Preconditions: The caller must be running on the task goroutine.
+func (t *Task) SyncSyscallFiltersToThreadGroup(p bpf.Program, flags uint32) error {
+	return t.syncSyscallFiltersToThreadGroup(t.newSyscallFilter(p, flags))
+}
+
+// syncSyscallFiltersToThreadGroup implements SyncSyscallFiltersToThreadGroup
+// for filter f.
+func (t *Task) syncSyscallFiltersToThreadGroup(f *syscallFilter) error {
 	t.tg.pidns.owner.mu.RLock()
 	defer t.tg.pidns.owner.mu.RUnlock()
 
-	// Note: No new privs is always assumed to be set.
+	// Lock the thread group's seccomp state, so that no thread can append a
+	// filter or enter SECCOMP_MODE_STRICT while we are validating or
+	// syncing. Each thread's mu is not locked, so that other users of it are
+	// not blocked by the sync.
+	tg := t.tg
+	tg.seccompMu.Lock()
+	defer tg.seccompMu.Unlock()
+	t.seccompMu.Lock()
+	defer t.seccompMu.Unlock()
+
+	newFilters, err := t.appendedSyscallFiltersLocked(true /* checkPrivilege */, f)
+	if err != nil {
+		return err
+	}
+
+	if ot := t.unsyncableTaskLocked(); ot != nil {
+		tid := tg.pidns.tids[ot]
+		seccompTsyncAbortedMetric.Increment()
+		t.Debugf("Aborting seccomp filter synchronization: filters of thread %d conflict", tid)
+		return &SyscallFilterSyncError{TID: tid}
+	}
+
+	// Threads whose filters didn't include the listener of newFilters
+	// become its users.
+	if l := syscallFiltersListener(newFilters); l != nil {
+		l.addUsers(t.listenerGainsLocked(l))
+	}
+
+	// newFilters is immutable, so it can be shared by every thread in the
+	// group. It extends the own filters of every thread that has diverged,
+	// so store it before discarding theirs, so that no thread ever runs
+	// without filters that it had.
+	tg.syscallFilters.Store(newFilters)
+	t.syscallFilters.Store([]*syscallFilter(nil))
+	if tg.seccompDiverged != 0 {
+		for ot := tg.tasks.Front(); ot != nil; ot = ot.Next() {
+			if ot != t {
+				ot.seccompMu.Lock()
+				ot.syscallFilters.Store([]*syscallFilter(nil))
+				ot.seccompMu.Unlock()
+			}
+		}
+		tg.seccompDiverged = 0
+	}
+	// As in Linux, synchronized threads also inherit no_new_privs, so that
+	// they can't gain privileges that the filters don't expect.
+	if t.noNewPrivsLocked() {
+		tg.seccompNoNewPrivs = true
+	}
+	seccompFilterBytesInstalledMetric.IncrementBy(f.size)
+	t.warnAlwaysKills(len(newFilters)-1, []*syscallFilter{f})
+	seccompTsyncMetric.Increment()
+	seccompTsyncThreadsMetric.IncrementBy(uint64(tg.tasksCount))
+	t.Debugf("Synchronized seccomp filters to %d threads", tg.tasksCount)
+	return nil
+}
+
+// unsyncableTaskLocked returns a thread in t's thread group whose filters
+// can't be replaced by t's, because they are not an ancestor of t's, or nil
+// if no such thread exists. "Ancestor" is used in the same sense as in Linux:
+// every filter installed by the other thread must also be installed by t, in
+// the same order, which is true of filters inherited from a common parent or
+// previously synchronized.
+//
+// Preconditions: The owning TaskSet.mu, t.tg.seccompMu and t.seccompMu must
+// be locked.
+func (t *Task) unsyncableTaskLocked() *Task {
+	if t.tg.seccompDiverged == 0 {
+		// Every thread uses the thread group's filters, which are an
+		// ancestor of every thread's.
+		return nil
+	}
+	filters := t.syscallFilterChain()
 	for ot := t.tg.tasks.Front(); ot != nil; ot = ot.Next() {
-		if ot.ThreadID() != t.ThreadID() {
-			// We must take the other task's mutex to prevent it from
-			// appending to its own syscall filters while we're syncing.
-			ot.mu.Lock()
-			var copiedFilters []bpf.Program
-			if f != nil {
-				copiedFilters = append(copiedFilters, f.([]bpf.Program)...)
+		if ot == t {
+			continue
+		}
+		// ot.seccompStrict and ot.syscallFilters can't change while
+		// t.tg.seccompMu is locked.
+		if ot.seccompStrict {
+			return ot
+		}
+		otherFilters := ot.syscallFilterChain()
+		if len(otherFilters) > len(filters) {
+			return ot
+		}
+		for i, of := range otherFilters {
+			if of != filters[i] {
+				return ot
 			}
-			ot.syscallFilters.Store(copiedFilters)
-			ot.mu.Unlock()
 		}
 	}
 	return nil
 }
 
+// listenerGainsLocked returns the number of threads in t's thread group whose
+// filters don't include listener l, and so would gain it if filters including
+// it were synchronized to the thread group.
+//
+// Preconditions: As for unsyncableTaskLocked.
+func (t *Task) listenerGainsLocked(l *SeccompListener) int {
+	if t.tg.seccompDiverged == 0 {
+		// Every thread's filters are t's.
+		if syscallFiltersListener(t.syscallFilterChain()) == l {
+			return 0
+		}
+		return t.tg.tasksCount
+	}
+	gains := 0
+	for ot := t.tg.tasks.Front(); ot != nil; ot = ot.Next() {
+		if syscallFiltersListener(ot.syscallFilterChain()) != l {
+			gains++
+		}
+	}
+	return gains
+}
+
+// inheritSeccompLocked copies t's seccomp filters and no_new_privs bit to nt,
+// a new task created by cloning t.
+//
+// "If fork/clone and execve are allowed by @prog, any child processes will
+// be constrained to the same filters and system call ABI as the parent." -
+// Documentation/prctl/seccomp_filter.txt
+//
+// Filter slices are immutable, so nt shares t's. If nt is in t's thread
+// group, it uses the thread group's filters, and also shares t's own if t
+// has diverged; otherwise, t's filters become those of nt's new thread group.
+//
+// Preconditions: The owning TaskSet.mu must be locked for writing.
+// nt.seccompMu must not be locked.
+func (t *Task) inheritSeccompLocked(nt *Task) {
+	t.tg.seccompMu.Lock()
+	defer t.tg.seccompMu.Unlock()
+	t.seccompMu.Lock()
+	defer t.seccompMu.Unlock()
+	if nt.tg == t.tg {
+		filters := t.ownSyscallFilters()
+		if len(filters) != 0 {
+			nt.syscallFilters.Store(filters)
+		}
+		if len(filters) != 0 || nt.seccompStrict {
+			t.tg.seccompDiverged++
+		}
+	} else {
+		// nt.tg isn't yet visible to other tasks, so it needn't be locked.
+		if filters := t.syscallFilterChain(); len(filters) != 0 {
+			nt.tg.syscallFilters.Store(filters)
+		}
+		if nt.seccompStrict {
+			nt.tg.seccompDiverged++
+		}
+	}
+	// nt can send notifications to t's listener, if any, so t's supervisor
+	// must remain able to receive them until nt is reaped.
+	if l := t.seccompListener(); l != nil {
+		l.addUsers(1)
+	}
+	if t.noNewPrivsLocked() {
+		nt.noNewPrivs = true
+	}
+}
+
+// releaseSeccompLocked releases the task's use of the listener of its system
+// call filters, if any, as the task is reaped. As in Linux, the task uses its
+// filters, which are still subject to TSYNC, until it is reaped rather than
+// until it exits.
+//
+// Preconditions: The owning TaskSet.mu must be locked for writing.
+func (t *Task) releaseSeccompLocked() {
+	if l := t.seccompListener(); l != nil {
+		l.removeUser()
+	}
+}
+
+// SetSeccompStrict places the task in SECCOMP_MODE_STRICT, in which it may
+// only invoke read, write, exit and sigreturn. Unlike installing a filter,
+// this requires no privilege. If the task is in SECCOMP_MODE_FILTER,
+// SetSeccompStrict returns EINVAL; if it is already in SECCOMP_MODE_STRICT,
+// SetSeccompStrict has no effect.
+//
+// Preconditions: The caller must be running on the task goroutine.
+func (t *Task) SetSeccompStrict() error {
+	t.tg.seccompMu.Lock()
+	defer t.tg.seccompMu.Unlock()
+	t.seccompMu.Lock()
+	defer t.seccompMu.Unlock()
+	if err := t.seccompMayAssignModeLocked(linux.SECCOMP_MODE_STRICT); err != nil {
+		return err
+	}
+	t.divergeSeccompLocked()
+	t.seccompStrict = true
+	return nil
+}
+
+// ResetSeccomp removes every seccomp filter of the task's thread group,
+// including baseline filters, and takes the task out of SECCOMP_MODE_STRICT,
+// so that its seccomp mode is SECCOMP_MODE_NONE. Filters are removed from all
+// threads, since threads may share them (as after SECCOMP_FILTER_FLAG_TSYNC);
+// other threads in SECCOMP_MODE_STRICT remain in it. no_new_privs is not
+// reset.
+//
+// ResetSeccomp is only for the sentry's management of task lifecycles, such
+// as tearing down a reused task context, and for tests. Linux deliberately
+// provides no way to remove seccomp filters, and filtered applications and
+// their supervisors depend on this: ResetSeccomp must NEVER be reachable from
+// a system call, or from any other path that the application can influence.
+// To catch such misuse, ResetSeccomp panics unless every thread in the group
+// is stopped or has no task goroutine, which excludes any thread that is
+// executing a system call.
+func (t *Task) ResetSeccomp() {
+	t.tg.pidns.owner.mu.RLock()
+	defer t.tg.pidns.owner.mu.RUnlock()
+
+	tg := t.tg
+	tg.seccompMu.Lock()
+	defer tg.seccompMu.Unlock()
+	for ot := tg.tasks.Front(); ot != nil; ot = ot.Next() {
+		if state := ot.TaskGoroutineSchedInfo().State; state != TaskGoroutineNonexistent && state != TaskGoroutineStopped {
+			panic(fmt.Sprintf("ResetSeccomp called while thread %d has task goroutine state %d", tg.pidns.tids[ot], state))
+		}
+	}
+
+	strict := 0
+	for ot := tg.tasks.Front(); ot != nil; ot = ot.Next() {
+		ot.seccompMu.Lock()
+		// ot is no longer a user of its filters' listener, if any, and its
+		// release when it is reaped will find no listener.
+		if l := ot.seccompListener(); l != nil {
+			l.removeUser()
+		}
+		ot.syscallFilters.Store([]*syscallFilter(nil))
+		if ot == t {
+			ot.seccompStrict = false
+		} else if ot.seccompStrict {
+			strict++
+		}
+		ot.seccompMu.Unlock()
+	}
+	tg.syscallFilters.Store([]*syscallFilter(nil))
+	tg.seccompDiverged = strict
+	t.Debugf("Reset seccomp filters of %d threads", tg.tasksCount)
+}
+
 // SeccompMode returns a SECCOMP_MODE_* constant indicating the task's current
 // seccomp syscall filtering mode, appropriate for both prctl(PR_GET_SECCOMP)
 // and /proc/[pid]/status.
 func (t *Task) SeccompMode() int {
-	f := t.syscallFilters.Load()
-	if f != nil && len(f.([]bpf.Program)) > 0 {
+	t.seccompMu.Lock()
+	defer t.seccompMu.Unlock()
+	return t.seccompModeLocked()
+}
+
+// seccompModeLocked implements SeccompMode.
+//
+// Preconditions: t.seccompMu must be locked.
+func (t *Task) seccompModeLocked() int {
+	if t.seccompStrict {
+		return linux.SECCOMP_MODE_STRICT
+	}
+	if len(guestSyscallFilters(t.syscallFilterChain())) > 0 {
 		return linux.SECCOMP_MODE_FILTER
 	}
 	return linux.SECCOMP_MODE_NONE
 }
+
+// SeccompFilterCount returns the number of seccomp-bpf filters applicable to
+// the task, excluding baseline filters, as reported by the Seccomp_filters line
+// of /proc/[pid]/status.
+func (t *Task) SeccompFilterCount() int {
+	return len(guestSyscallFilters(t.syscallFilterChain()))
+}
+
+// SeccompStatus returns the lines of /proc/[pid]/status describing the task's
+// no_new_privs bit and seccomp state, NoNewPrivs, Seccomp and
+// Seccomp_filters, as written by Linux's task_seccomp().
+func (t *Task) SeccompStatus() string {
+	noNewPrivs := 0
+	if t.NoNewPrivs() {
+		noNewPrivs = 1
+	}
+	return fmt.Sprintf("NoNewPrivs:\t%d\nSeccomp:\t%d\nSeccomp_filters:\t%d\n", noNewPrivs, t.SeccompMode(), t.SeccompFilterCount())
+}
+
+// SeccompFilterInstructions returns the combined length of the seccomp-bpf
+// filters applicable to the task, including per-filter overhead, and the limit
+// on that length beyond which installing another filter fails with ENOMEM.
+func (t *Task) SeccompFilterInstructions() (current, limit int) {
+	return syscallFiltersLength(t.syscallFilterChain()), maxSyscallFilterInstructions
+}
+
+// SeccompFilterBytes returns the estimated memory consumed by the seccomp-bpf
+// filters applicable to the task, in bytes, and the limit on that memory beyond
+// which installing another filter fails with ENOMEM, or 0 if there is no such
+// limit. Filters shared with other tasks are included in full.
+func (t *Task) SeccompFilterBytes() (current, limit uint64) {
+	limit = atomic.LoadUint64(&MaxSeccompFilterBytes)
+	return syscallFiltersBytes(t.syscallFilterChain()), limit
+}
+
+// SeccompFilter is a seccomp-bpf filter installed by a task, as returned by
+// GetSeccompFilters.
+type SeccompFilter struct {
+	// Program is the filter's BPF program.
+	Program bpf.Program
+
+	// Flags is the set of per-filter SECCOMP_FILTER_FLAG_* flags that were
+	// specified when the filter was installed.
+	Flags uint32
+}
+
+// GetSeccompFilters returns the seccomp-bpf filters applicable to the task,
+// excluding baseline filters, in the order in which they were installed, or
+// nil if the task has no such filters.
+// Since bpf.Programs are immutable, the returned slice shares no mutable state
+// with the task. GetSeccompFilters may be called from any goroutine.
+func (t *Task) GetSeccompFilters() []SeccompFilter {
+	filters := guestSyscallFilters(t.syscallFilterChain())
+	if len(filters) == 0 {
+		return nil
+	}
+	out := make([]SeccompFilter, len(filters))
+	for i, filter := range filters {
+		out[i] = SeccompFilter{
+			Program: filter.program,
+			Flags:   filter.flags,
+		}
+	}
+	return out
+}
+
+// seccompFilter returns the seccomp-bpf filter applicable to the task with the
+// given index, where (as for PTRACE_SECCOMP_GET_FILTER) index 0 is the most
+// recently installed filter, excluding baseline filters. If the task has no
+// such filters, seccompFilter returns EINVAL; if index is out of range, it
+// returns ENOENT.
+//
+// Preconditions: The task goroutine must be stopped, or the caller must be
+// running on the task goroutine.
+func (t *Task) seccompFilter(index uint64) (*syscallFilter, error) {
+	filters := guestSyscallFilters(t.syscallFilterChain())
+	if len(filters) == 0 {
+		return nil, syserror.EINVAL
+	}
+	if index >= uint64(len(filters)) {
+		return nil, syserror.ENOENT
+	}
+	return filters[len(filters)-1-int(index)], nil
+}
@@ -0,0 +1,136 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kernel
+
+import (
+	"gvisor.googlesource.com/gvisor/pkg/bpf"
+)
+
+// seccompDataArchOffset is the offset of the arch field in seccompData.
+const seccompDataArchOffset = 4
+
+// seccompAllowlist evaluates seccomp-bpf programs of the canonical form used
+// to deny all system calls except a fixed set:
+//
+//	ld [4]				; optional
+//	jeq #<arch>, <continue>, <ret #archAction>
+//	ld [0]
+//	jeq #<nr>, <ret #action>, <continue>	; repeated
+//	ret #defaultAction
+//
+// where the Ret instructions may appear anywhere in the program. Such
+// programs are evaluated with a single map lookup, without interpreting BPF.
+// Since syscallFilters are shared between tasks, so is their seccompAllowlist.
+//
+// seccompAllowlist is immutable.
+type seccompAllowlist struct {
+	// checkArch is true if the program checks the system call's architecture.
+	checkArch bool
+
+	// arch is the AUDIT_ARCH_* value required by the program if checkArch is
+	// true.
+	arch uint32
+
+	// archAction is the program's result for system calls with any other
+	// architecture.
+	archAction uint32
+
+	// actions maps system call numbers to the program's results.
+	actions map[int32]uint32
+
+	// defaultAction is the program's result for system calls not in actions.
+	defaultAction uint32
+}
+
+// newSeccompAllowlist returns a seccompAllowlist equivalent to p, or nil if p
+// does not have the canonical form described by seccompAllowlist.
+func newSeccompAllowlist(p bpf.Program) *seccompAllowlist {
+	insns := p.Instructions()
+	// ret returns the value returned by the instruction at pc, if it is a
+	// Ret|K instruction.
+	ret := func(pc int) (uint32, bool) {
+		if pc >= len(insns) || insns[pc].OpCode != bpf.Ret|bpf.K {
+			return 0, false
+		}
+		return insns[pc].K, true
+	}
+
+	a := &seccompAllowlist{
+		actions: make(map[int32]uint32),
+	}
+	// loaded is the offset of the seccompData field in A, or -1 if A does not
+	// contain a field.
+	loaded := -1
+	for pc := 0; pc < len(insns); {
+		i := insns[pc]
+		switch {
+		case i.OpCode == bpf.Ld|bpf.Abs|bpf.W && i.K == seccompDataArchOffset:
+			if a.checkArch || loaded != -1 {
+				// The architecture must be checked once, before the system
+				// call number.
+				return nil
+			}
+			loaded = seccompDataArchOffset
+			pc++
+
+		case i.OpCode == bpf.Ld|bpf.Abs|bpf.W && i.K == 0:
+			loaded = 0
+			pc++
+
+		case i.OpCode == bpf.Jmp|bpf.Jeq|bpf.K && loaded == seccompDataArchOffset && !a.checkArch:
+			action, ok := ret(pc + int(i.JumpIfFalse) + 1)
+			if !ok {
+				return nil
+			}
+			a.checkArch = true
+			a.arch = i.K
+			a.archAction = action
+			pc += int(i.JumpIfTrue) + 1
+
+		case i.OpCode == bpf.Jmp|bpf.Jeq|bpf.K && loaded == 0:
+			action, ok := ret(pc + int(i.JumpIfTrue) + 1)
+			if !ok {
+				return nil
+			}
+			// Only the first comparison against each system call number is
+			// reachable.
+			if _, ok := a.actions[int32(i.K)]; !ok {
+				a.actions[int32(i.K)] = action
+			}
+			pc += int(i.JumpIfFalse) + 1
+
+		case i.OpCode == bpf.Ret|bpf.K:
+			a.defaultAction = i.K
+			return a
+
+		default:
+			return nil
+		}
+	}
+	// Unreachable for valid programs, which end with a Ret instruction.
+	return nil
+}
+
+// evaluate returns the result of a's program for the system call described by
+// data.
+func (a *seccompAllowlist) evaluate(data *seccompData) uint32 {
+	if a.checkArch && data.arch != a.arch {
+		return a.archAction
+	}
+	if action, ok := a.actions[data.nr]; ok {
+		return action
+	}
+	return a.defaultAction
+}
@@ -0,0 +1,166 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kernel
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"gvisor.googlesource.com/gvisor/pkg/abi/linux"
+	"gvisor.googlesource.com/gvisor/pkg/eventchannel"
+	"gvisor.googlesource.com/gvisor/pkg/metric"
+	apb "gvisor.googlesource.com/gvisor/pkg/sentry/kernel/audit/audit_go_proto"
+)
+
+const (
+	// seccompAuditRate and seccompAuditBurst limit the rate at which
+	// AUDIT_SECCOMP records are emitted, so that applications that log all
+	// system calls with SECCOMP_RET_LOG can't flood the event channel.
+	seccompAuditRate  = 100
+	seccompAuditBurst = 100
+
+	// auditUnset is the value of Linux's AUDIT_UID_UNSET and
+	// AUDIT_SID_UNSET, which are reported as the login UID and session ID of
+	// all tasks, since the sentry doesn't implement them.
+	auditUnset = ^uint32(0)
+)
+
+// seccompAuditSuppressedMetric counts AUDIT_SECCOMP records that were dropped
+// by seccompAuditLimiter.
+var seccompAuditSuppressedMetric = metric.MustCreateNewUint64Metric("/seccomp/audit_suppressed", false /* sync */, "Number of seccomp audit records that were not emitted due to rate limiting.")
+
+// seccompAuditLimiter rate-limits AUDIT_SECCOMP records across all tasks.
+var seccompAuditLimiter = newLogRateLimiter(seccompAuditRate, seccompAuditBurst)
+
+// seccompAuditSerial is the serial number of the last AUDIT_SECCOMP record.
+// seccompAuditSerial must be accessed atomically.
+var seccompAuditSerial uint64
+
+// seccompAuditLogged returns true if action, returned by filter, generates an
+// AUDIT_SECCOMP record. As in Linux's seccomp_log() with the default
+// /proc/sys/kernel/seccomp/actions_logged, actions that kill the task and
+// SECCOMP_RET_LOG are always logged, and all other actions except
+// SECCOMP_RET_ALLOW are logged if the filter was installed with
+// SECCOMP_FILTER_FLAG_LOG.
+func seccompAuditLogged(action uint32, filter *syscallFilter) bool {
+	switch action {
+	case linux.SECCOMP_RET_ALLOW:
+		return false
+	case linux.SECCOMP_RET_LOG:
+		return true
+	case linux.SECCOMP_RET_TRAP, linux.SECCOMP_RET_ERRNO, linux.SECCOMP_RET_USER_NOTIF, linux.SECCOMP_RET_TRACE:
+		return filter.flags&linux.SECCOMP_FILTER_FLAG_LOG != 0
+	default: // SECCOMP_RET_KILL_THREAD, SECCOMP_RET_KILL_PROCESS or invalid
+		return true
+	}
+}
+
+// seccompAuditSignal returns the signal that action sends to the task, as
+// reported in AUDIT_SECCOMP records.
+func seccompAuditSignal(action uint32) linux.Signal {
+	switch action {
+	case linux.SECCOMP_RET_ALLOW, linux.SECCOMP_RET_LOG, linux.SECCOMP_RET_ERRNO, linux.SECCOMP_RET_USER_NOTIF, linux.SECCOMP_RET_TRACE:
+		return 0
+	default: // SECCOMP_RET_TRAP or an action that kills the task
+		return linux.SIGSYS
+	}
+}
+
+// seccompAudit emits an AUDIT_SECCOMP record via the event channel for the
+// system call described by data, for which seccomp returned action, causing
+// sig (if not 0) to be sent to t.
+func (t *Task) seccompAudit(data *seccompData, action uint32, sig linux.Signal) {
+	if !seccompAuditLimiter.allow() {
+		seccompAuditSuppressedMetric.Increment()
+		return
+	}
+	ev := t.seccompAuditEvent(data, action, sig)
+	ev.Record = formatSeccompAudit(time.Now(), atomic.AddUint64(&seccompAuditSerial, 1), ev)
+	eventchannel.Emit(ev)
+}
+
+// seccompAuditEvent returns an AUDIT_SECCOMP event without its Record.
+func (t *Task) seccompAuditEvent(data *seccompData, action uint32, sig linux.Signal) *apb.SeccompAudit {
+	creds := t.Credentials()
+	return &apb.SeccompAudit{
+		Uid:     uint32(creds.RealKUID),
+		Gid:     uint32(creds.RealKGID),
+		Pid:     int32(t.tg.pidns.owner.Root.IDOfThreadGroup(t.tg)),
+		Comm:    t.Name(),
+		Exe:     t.seccompAuditExe(),
+		Sig:     int32(sig),
+		Arch:    data.arch,
+		Syscall: data.nr,
+		Compat:  data.arch&linux.AUDIT_ARCH_64BIT == 0,
+		Ip:      data.instructionPointer,
+		Code:    action,
+	}
+}
+
+// seccompAuditExe returns the path of t's executable, or an empty string if t
+// has none.
+func (t *Task) seccompAuditExe() string {
+	mm := t.MemoryManager()
+	if mm == nil {
+		return ""
+	}
+	exe := mm.Executable()
+	if exe == nil {
+		return ""
+	}
+	defer exe.DecRef()
+	fsc := t.FSContext()
+	if fsc == nil {
+		return ""
+	}
+	root := fsc.RootDirectory()
+	if root == nil {
+		return ""
+	}
+	defer root.DecRef()
+	name, _ := exe.FullName(root)
+	return name
+}
+
+// formatSeccompAudit returns ev as a line of the Linux audit log, as written
+// by auditd, with timestamp now and serial number serial. Fields are
+// formatted as by Linux's audit_seccomp().
+func formatSeccompAudit(now time.Time, serial uint64, ev *apb.SeccompAudit) string {
+	exe := "(null)"
+	if ev.Exe != "" {
+		exe = auditUntrustedString(ev.Exe)
+	}
+	compat := 0
+	if ev.Compat {
+		compat = 1
+	}
+	return fmt.Sprintf("type=SECCOMP msg=audit(%d.%03d:%d): auid=%d uid=%d gid=%d ses=%d pid=%d comm=%s exe=%s sig=%d arch=%x syscall=%d compat=%d ip=%#x code=%#x",
+		now.Unix(), now.Nanosecond()/int(time.Millisecond), serial,
+		auditUnset, ev.Uid, ev.Gid, auditUnset, ev.Pid, auditUntrustedString(ev.Comm), exe,
+		ev.Sig, ev.Arch, ev.Syscall, compat, ev.Ip, ev.Code)
+}
+
+// auditUntrustedString formats s as a field value, as by Linux's
+// audit_log_untrustedstring(): s is quoted, unless it contains a double quote,
+// space, or control or non-ASCII character, in which case it is hex-encoded.
+func auditUntrustedString(s string) string {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c == '"' || c < 0x21 || c > 0x7e {
+			return fmt.Sprintf("%X", s)
+		}
+	}
+	return `"` + s + `"`
+}
@@ -0,0 +1,162 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kernel
+
+import (
+	"syscall"
+	"testing"
+
+	"gvisor.googlesource.com/gvisor/pkg/abi/linux"
+	"gvisor.googlesource.com/gvisor/pkg/bpf"
+	"gvisor.googlesource.com/gvisor/pkg/sentry/arch"
+)
+
+// seccompBenchmarkSmallProgram returns a small filter that allows read(2),
+// fails write(2) with EPERM, and kills the process for all other system
+// calls.
+func seccompBenchmarkSmallProgram(b *testing.B) bpf.Program {
+	p, err := bpf.Compile([]linux.BPFInstruction{
+		bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 0), // nr
+		bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, 0, 0, 1),
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ALLOW),
+		bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, 1, 0, 1),
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ERRNO|uint32(syscall.EPERM)),
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_KILL_PROCESS),
+	})
+	if err != nil {
+		b.Fatalf("bpf.Compile failed: %v", err)
+	}
+	return p
+}
+
+// seccompBenchmarkProfile returns the program of the profile called name in
+// seccompProfiles.
+func seccompBenchmarkProfile(b *testing.B, name string) bpf.Program {
+	for _, profile := range seccompProfiles {
+		if profile.name == name {
+			return seccompProfileProgram(b, profile.name, profile.program)
+		}
+	}
+	b.Fatalf("no profile %q", name)
+	panic("unreachable")
+}
+
+// seccompBenchmarkCase is a system call evaluated by the seccomp decision path
+// benchmarks.
+type seccompBenchmarkCase struct {
+	// name is the name of the sub-benchmark.
+	name string
+
+	// programs are the filters installed in the task, in order, or nil for
+	// none.
+	programs func(b *testing.B) []bpf.Program
+
+	// nr is the x86_64 system call number.
+	nr int32
+
+	// action is the SECCOMP_RET_* action that the filters return for the
+	// system call. checkSeccompSyscall is not benchmarked for actions that
+	// kill the task, since it audits each kill.
+	action uint32
+}
+
+// seccompBenchmarkCases covers no filters, one small filter, one large
+// libseccomp-generated filter and a stack of filters, with each of their
+// outcomes.
+var seccompBenchmarkCases = func() []seccompBenchmarkCase {
+	none := func(*testing.B) []bpf.Program { return nil }
+	small := func(b *testing.B) []bpf.Program {
+		return []bpf.Program{seccompBenchmarkSmallProgram(b)}
+	}
+	docker := func(b *testing.B) []bpf.Program {
+		return []bpf.Program{seccompBenchmarkProfile(b, "docker")}
+	}
+	restricted := func(b *testing.B) []bpf.Program {
+		return []bpf.Program{seccompBenchmarkProfile(b, "restricted")}
+	}
+	stacked := func(b *testing.B) []bpf.Program {
+		return []bpf.Program{
+			seccompBenchmarkProfile(b, "docker"),
+			seccompBenchmarkSmallProgram(b),
+			seccompBenchmarkProfile(b, "podman"),
+			seccompBenchmarkSmallProgram(b),
+		}
+	}
+	return []seccompBenchmarkCase{
+		{name: "NoFilters", programs: none, nr: 0, action: linux.SECCOMP_RET_ALLOW},
+		{name: "Small/Allow", programs: small, nr: 0, action: linux.SECCOMP_RET_ALLOW},
+		{name: "Small/Errno", programs: small, nr: 1, action: linux.SECCOMP_RET_ERRNO},
+		{name: "Small/Kill", programs: small, nr: 2, action: linux.SECCOMP_RET_KILL_PROCESS},
+		{name: "Libseccomp/Allow", programs: docker, nr: 0, action: linux.SECCOMP_RET_ALLOW},
+		{name: "Libseccomp/Errno", programs: docker, nr: 0xa9 /* reboot */, action: linux.SECCOMP_RET_ERRNO},
+		{name: "Libseccomp/Kill", programs: restricted, nr: 0x3e8 /* unknown */, action: linux.SECCOMP_RET_KILL_PROCESS},
+		{name: "Stacked/Allow", programs: stacked, nr: 0, action: linux.SECCOMP_RET_ALLOW},
+		{name: "Stacked/Errno", programs: stacked, nr: 1, action: linux.SECCOMP_RET_ERRNO},
+		{name: "Stacked/Kill", programs: stacked, nr: 0xa9, action: linux.SECCOMP_RET_KILL_PROCESS},
+	}
+}()
+
+// seccompBenchmarkArgs are the arguments of every system call in
+// seccompBenchmarkCases.
+var seccompBenchmarkArgs = arch.SyscallArguments{{Value: 1}, {Value: 0x1000}, {Value: 10}}
+
+// newSeccompBenchmarkTask returns a task with the filters of c installed,
+// after checking that they return c.action.
+func newSeccompBenchmarkTask(b *testing.B, c seccompBenchmarkCase) *Task {
+	task := newSeccompTestTask()
+	var filters []*syscallFilter
+	for _, p := range c.programs(b) {
+		filters = append(filters, task.newSyscallFilter(p, 0))
+	}
+	task.syscallFilters.Store(filters)
+	if action, _ := splitSeccompResult(task.EvaluateSyscallFilters(c.nr, seccompBenchmarkArgs, 0)); action != c.action {
+		b.Fatalf("filters returned action %#x, want %#x", action, c.action)
+	}
+	return task
+}
+
+// BenchmarkSeccompEvaluate benchmarks evaluateSyscallFilters for each of
+// seccompBenchmarkCases.
+func BenchmarkSeccompEvaluate(b *testing.B) {
+	for _, c := range seccompBenchmarkCases {
+		b.Run(c.name, func(b *testing.B) {
+			task := newSeccompBenchmarkTask(b, c)
+			data := task.seccompData(c.nr, seccompBenchmarkArgs, 0)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				task.evaluateSyscallFilters(&data)
+			}
+		})
+	}
+}
+
+// BenchmarkSeccompCheck benchmarks checkSeccompSyscall, which also acts on
+// the result, for each of seccompBenchmarkCases that doesn't kill the task.
+func BenchmarkSeccompCheck(b *testing.B) {
+	for _, c := range seccompBenchmarkCases {
+		if seccompActionKills(c.action) {
+			continue
+		}
+		b.Run(c.name, func(b *testing.B) {
+			task := newSeccompBenchmarkTask(b, c)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				task.checkSeccompSyscall(c.nr, seccompBenchmarkArgs, 0)
+			}
+		})
+	}
+}
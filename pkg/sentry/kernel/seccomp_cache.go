@@ -0,0 +1,291 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kernel
+
+import (
+	"gvisor.googlesource.com/gvisor/pkg/abi/linux"
+	"gvisor.googlesource.com/gvisor/pkg/bpf"
+)
+
+// seccompDataNrArchSize is the size in bytes of the nr and arch fields at the
+// beginning of seccompData.
+const seccompDataNrArchSize = 8
+
+// seccompCache records the results of a seccomp-bpf program for system calls
+// for which the program's result does not depend on the system call's
+// arguments or instruction pointer. This is analogous to Linux's
+// seccomp_cache_prepare(), except that all actions (not just
+// SECCOMP_RET_ALLOW) are cached.
+//
+// seccompCache is immutable.
+//
+// +stateify savable
+type seccompCache struct {
+	// arch is the AUDIT_ARCH_* value for which the cache is valid.
+	arch uint32
+
+	// constant is a bitmap of system call numbers for which results contains
+	// the program's result.
+	constant []uint64
+
+	// results contains the program's result for each system call number in
+	// constant, indexed by system call number.
+	results []uint32
+
+	// allowed is a bitmap of system call numbers in constant for which the
+	// program's result is SECCOMP_RET_ALLOW.
+	allowed []uint64
+}
+
+// newSeccompCache returns a seccompCache for p, covering system calls numbered
+// less than numSyscalls with architecture arch.
+func newSeccompCache(p bpf.Program, arch uint32, numSyscalls int) seccompCache {
+	c := seccompCache{
+		arch:     arch,
+		constant: make([]uint64, (numSyscalls+63)/64),
+		results:  make([]uint32, numSyscalls),
+		allowed:  make([]uint64, (numSyscalls+63)/64),
+	}
+	for nr := 0; nr < numSyscalls; nr++ {
+		data := seccompData{
+			nr:   int32(nr),
+			arch: arch,
+		}
+		buf := make([]byte, SeccompDataSize)
+		data.marshal(buf, seccompDataByteOrder)
+		in := seccompCacheInput{
+			InputBytes: bpf.InputBytes{buf, seccompDataByteOrder},
+		}
+		ret, err := bpf.Exec(p, &in)
+		if err != nil || in.other {
+			// Fall back to full evaluation for this system call.
+			continue
+		}
+		// p only inspected the system call number and architecture, so since
+		// it is deterministic, it returns ret for all system calls with the
+		// same number and architecture.
+		c.constant[nr/64] |= 1 << uint(nr%64)
+		c.results[nr] = ret
+		if ret == linux.SECCOMP_RET_ALLOW {
+			c.allowed[nr/64] |= 1 << uint(nr%64)
+		}
+	}
+	return c
+}
+
+// lookup returns the cached result for the system call described by data, if
+// one exists.
+func (c *seccompCache) lookup(data *seccompData) (uint32, bool) {
+	if data.arch != c.arch || data.nr < 0 || int(data.nr) >= len(c.results) {
+		return 0, false
+	}
+	if c.constant[data.nr/64]&(1<<uint(data.nr%64)) == 0 {
+		return 0, false
+	}
+	return c.results[data.nr], true
+}
+
+// alwaysKills returns true if c shows that its program kills the task for
+// every system call in the syscall table for which it was created, which is
+// almost certainly a mistake, such as a deny-all filter missing its allow
+// rules. alwaysKills is conservative: it returns false if the program's
+// result for any of those system calls depends on anything but its number and
+// architecture, or if c is empty.
+func (c *seccompCache) alwaysKills() bool {
+	if len(c.results) == 0 {
+		return false
+	}
+	for nr, ret := range c.results {
+		action, _ := splitSeccompResult(ret)
+		if c.constant[nr/64]&(1<<uint(nr%64)) == 0 || !seccompActionKills(action) {
+			return false
+		}
+	}
+	return true
+}
+
+// seccompCacheInput is a bpf.Input over a marshalled seccompData that records
+// whether any field other than nr or arch was loaded.
+type seccompCacheInput struct {
+	bpf.InputBytes
+
+	// other is true if any field other than nr or arch was loaded.
+	other bool
+}
+
+// load records a load of size bytes at offset off.
+func (i *seccompCacheInput) load(off, size uint32) {
+	if uint64(off)+uint64(size) > seccompDataNrArchSize {
+		i.other = true
+	}
+}
+
+// Load32 implements bpf.Input.Load32.
+func (i *seccompCacheInput) Load32(off uint32) (uint32, bool) {
+	i.load(off, 4)
+	return i.InputBytes.Load32(off)
+}
+
+// Load16 implements bpf.Input.Load16.
+func (i *seccompCacheInput) Load16(off uint32) (uint16, bool) {
+	i.load(off, 2)
+	return i.InputBytes.Load16(off)
+}
+
+// Load8 implements bpf.Input.Load8.
+func (i *seccompCacheInput) Load8(off uint32) (uint8, bool) {
+	i.load(off, 1)
+	return i.InputBytes.Load8(off)
+}
+
+// seccompAllowSet is the set of system calls that all of a task's seccomp-bpf
+// filters allow regardless of the system call's arguments and instruction
+// pointer, as determined by their seccompCaches. evaluateSyscallFilters
+// returns SECCOMP_RET_ALLOW for such system calls without consulting each
+// filter, which benefits workloads dominated by unconditionally allowed system
+// calls such as read(2) and write(2). Since a system call is only included if
+// every filter's result for it is constant, the set never includes a system
+// call that any filter restricts based on its arguments.
+//
+// Like seccompResultCache, a seccompAllowSet remembers the filter chain from
+// which it was computed, and is recomputed when it is used with any other.
+type seccompAllowSet struct {
+	// filters is the filter chain from which allowed was computed.
+	filters []*syscallFilter
+
+	// arch is the AUDIT_ARCH_* value of system calls in allowed.
+	arch uint32
+
+	// allowed is a bitmap of system call numbers allowed by all filters. If
+	// the filters' seccompCaches are for different architectures, allowed is
+	// empty.
+	allowed []uint64
+}
+
+// reset recomputes s for filters, which must not be empty.
+func (s *seccompAllowSet) reset(filters []*syscallFilter) {
+	*s = seccompAllowSet{
+		filters: filters,
+		arch:    filters[0].cache.arch,
+		allowed: append([]uint64(nil), filters[0].cache.allowed...),
+	}
+	for _, f := range filters[1:] {
+		if f.cache.arch != s.arch {
+			s.allowed = nil
+			return
+		}
+		if len(f.cache.allowed) < len(s.allowed) {
+			s.allowed = s.allowed[:len(f.cache.allowed)]
+		}
+		for i := range s.allowed {
+			s.allowed[i] &= f.cache.allowed[i]
+		}
+	}
+}
+
+// contains returns true if all filters in filters, which must not be empty,
+// allow the system call described by data regardless of its arguments and
+// instruction pointer.
+func (s *seccompAllowSet) contains(filters []*syscallFilter, data *seccompData) bool {
+	if !sameFilters(s.filters, filters) {
+		s.reset(filters)
+	}
+	if data.arch != s.arch || data.nr < 0 || int(data.nr/64) >= len(s.allowed) {
+		return false
+	}
+	return s.allowed[data.nr/64]&(1<<uint(data.nr%64)) != 0
+}
+
+// CacheSeccompResults is a flag used to enable or disable caching the results
+// of each task's seccomp-bpf filters for its most recent distinct system
+// calls, which benefits workloads that repeat system calls with identical
+// arguments. Valid values are 0 or 1.
+//
+// CacheSeccompResults must be accessed atomically.
+var CacheSeccompResults uint32
+
+// seccompResultCacheSize is the number of system calls whose results are
+// cached by a seccompResultCache.
+const seccompResultCacheSize = 8
+
+// seccompResultCacheEntry is a cached result in a seccompResultCache.
+type seccompResultCacheEntry struct {
+	// data describes the system call.
+	data seccompData
+
+	// ret and filter are the results of evaluateSyscallFilters for data.
+	ret    uint32
+	filter *syscallFilter
+}
+
+// seccompResultCache is a least-recently-used cache of the results of a
+// task's seccomp-bpf filters. Since results depend on the entire system call,
+// including the instruction pointer, entries are keyed by seccompData.
+//
+// Entries are valid only for the filter chain for which they were computed.
+// Since filter chains are immutable, a seccompResultCache remembers the chain
+// and discards its entries when it is used with any other, which makes it
+// unnecessary to invalidate the cache when filters are installed, even by
+// another task with SECCOMP_FILTER_FLAG_TSYNC.
+type seccompResultCache struct {
+	// filters is the filter chain for which entries are valid.
+	filters []*syscallFilter
+
+	// entries contains the first len cached results, from most to least
+	// recently used.
+	entries [seccompResultCacheSize]seccompResultCacheEntry
+	len     int
+}
+
+// sameFilters returns true if a and b are the same filter chain.
+func sameFilters(a, b []*syscallFilter) bool {
+	return len(a) == len(b) && (len(a) == 0 || &a[0] == &b[0])
+}
+
+// lookup returns the cached results of filters for the system call described
+// by data, if they exist.
+func (c *seccompResultCache) lookup(filters []*syscallFilter, data *seccompData) (uint32, *syscallFilter, bool) {
+	if !sameFilters(c.filters, filters) {
+		return 0, nil, false
+	}
+	for i := 0; i < c.len; i++ {
+		if c.entries[i].data == *data {
+			e := c.entries[i]
+			// Move the entry to the front.
+			copy(c.entries[1:i+1], c.entries[:i])
+			c.entries[0] = e
+			return e.ret, e.filter, true
+		}
+	}
+	return 0, nil, false
+}
+
+// insert caches the results ret and filter of filters for the system call
+// described by data, evicting the least recently used entry if the cache is
+// full.
+func (c *seccompResultCache) insert(filters []*syscallFilter, data *seccompData, ret uint32, filter *syscallFilter) {
+	if !sameFilters(c.filters, filters) {
+		*c = seccompResultCache{filters: filters}
+	}
+	if c.len < seccompResultCacheSize {
+		c.len++
+	}
+	copy(c.entries[1:c.len], c.entries[:c.len-1])
+	c.entries[0] = seccompResultCacheEntry{
+		data:   *data,
+		ret:    ret,
+		filter: filter,
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kernel
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+
+	"gvisor.googlesource.com/gvisor/pkg/abi/linux"
+	"gvisor.googlesource.com/gvisor/pkg/binary"
+	"gvisor.googlesource.com/gvisor/pkg/bpf"
+	"gvisor.googlesource.com/gvisor/pkg/metric"
+)
+
+// maxSeccompCompiledPrograms is the maximum number of programs whose compiled
+// forms are retained by seccompCompiledPrograms. Container workloads usually
+// install a handful of distinct profiles, each in many tasks, so this is
+// large enough that installing a profile again rarely recompiles it.
+const maxSeccompCompiledPrograms = 64
+
+// seccompCompiledHitMetric counts installations of system call filters whose
+// compiled form was found in seccompCompiledPrograms.
+var seccompCompiledHitMetric = metric.MustCreateNewUint64Metric("/seccomp/compiled_cache_hits", false /* sync */, "Number of seccomp filter installations that reused a previously compiled identical filter.")
+
+// seccompCompiled is the compiled form of a seccomp-bpf program: everything
+// derived from the program alone that is needed to evaluate it. Identical
+// programs, such as those of a profile installed in many tasks, share a
+// seccompCompiled. seccompCompiled is immutable.
+type seccompCompiled struct {
+	// insns are the program's instructions, which are compared on lookup so
+	// that a hash collision can't return another program's compiled form.
+	insns []linux.BPFInstruction
+
+	// optimized is the program optimized by bpf.Optimize.
+	optimized bpf.Program
+
+	// executable is optimized lowered by bpf.NewExecutable, or nil if it has
+	// not been compiled.
+	executable *bpf.Executable
+
+	// allowlist evaluates the program without interpreting it, or is nil if
+	// the program does not have the form described by seccompAllowlist.
+	allowlist *seccompAllowlist
+}
+
+// seccompProgramKey is the key of a program in seccompCompiledCache: the
+// SHA-256 digest of all fields of each of its instructions.
+type seccompProgramKey [sha256.Size]byte
+
+// seccompProgramKeyOf returns the seccompProgramKey of insns. The encoding is
+// fixed-width and little-endian, so that it covers every field of every
+// instruction, and the digest includes the program's length.
+func seccompProgramKeyOf(insns []linux.BPFInstruction) seccompProgramKey {
+	return sha256.Sum256(binary.Marshal(nil, binary.LittleEndian, insns))
+}
+
+// seccompCompiledEntry is an element of seccompCompiledCache.lru.
+type seccompCompiledEntry struct {
+	key      seccompProgramKey
+	compiled *seccompCompiled
+}
+
+// seccompCompiledCache is a bounded cache of the compiled forms of seccomp-bpf
+// programs, keyed by the programs' contents, which evicts the least recently
+// used program when full. It is safe for concurrent use.
+type seccompCompiledCache struct {
+	// max is the maximum number of entries in the cache. max is immutable.
+	max int
+
+	// mu protects the fields below.
+	mu sync.Mutex
+
+	// entries maps the keys of cached programs to their elements in lru.
+	entries map[seccompProgramKey]*list.Element
+
+	// lru contains a *seccompCompiledEntry for each cached program, from most
+	// to least recently used.
+	lru list.List
+}
+
+// seccompCompiledPrograms caches the compiled forms of every task's system
+// call filters.
+var seccompCompiledPrograms = newSeccompCompiledCache(maxSeccompCompiledPrograms)
+
+// newSeccompCompiledCache returns an empty seccompCompiledCache holding at
+// most max programs.
+func newSeccompCompiledCache(max int) *seccompCompiledCache {
+	return &seccompCompiledCache{
+		max:     max,
+		entries: make(map[seccompProgramKey]*list.Element),
+	}
+}
+
+// get returns the compiled form of p, compiling it if it is not cached. If
+// executable is true, the returned form includes an executable.
+func (c *seccompCompiledCache) get(p bpf.Program, executable bool) *seccompCompiled {
+	insns := p.Instructions()
+	key := seccompProgramKeyOf(insns)
+
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok {
+		compiled := e.Value.(*seccompCompiledEntry).compiled
+		if sameBPFInstructions(compiled.insns, insns) && (compiled.executable != nil || !executable) {
+			c.lru.MoveToFront(e)
+			c.mu.Unlock()
+			seccompCompiledHitMetric.Increment()
+			return compiled
+		}
+	}
+	c.mu.Unlock()
+
+	// Compile without holding c.mu, so that compiling a large program doesn't
+	// delay installations of other programs. If the program is compiled
+	// concurrently, the last compiled form to be inserted is retained; the
+	// others remain valid for the filters that use them.
+	compiled := &seccompCompiled{
+		insns:     insns,
+		optimized: bpf.Optimize(p),
+		allowlist: newSeccompAllowlist(p),
+	}
+	if executable {
+		compiled.executable = bpf.NewExecutable(compiled.optimized)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		e.Value.(*seccompCompiledEntry).compiled = compiled
+		c.lru.MoveToFront(e)
+		return compiled
+	}
+	c.entries[key] = c.lru.PushFront(&seccompCompiledEntry{key: key, compiled: compiled})
+	if c.lru.Len() > c.max {
+		e := c.lru.Back()
+		c.lru.Remove(e)
+		delete(c.entries, e.Value.(*seccompCompiledEntry).key)
+	}
+	return compiled
+}
+
+// len returns the number of programs in the cache.
+func (c *seccompCompiledCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// sameBPFInstructions returns true if a and b are identical.
+func sameBPFInstructions(a, b []linux.BPFInstruction) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,145 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kernel
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"gvisor.googlesource.com/gvisor/pkg/abi/linux"
+	"gvisor.googlesource.com/gvisor/pkg/bpf"
+)
+
+// seccompFilterFlagNames are the names of the per-filter
+// SECCOMP_FILTER_FLAG_* flags reported by GetSeccompFilters.
+var seccompFilterFlagNames = []struct {
+	flag uint32
+	name string
+}{
+	{linux.SECCOMP_FILTER_FLAG_LOG, "log"},
+	{linux.SECCOMP_FILTER_FLAG_SPEC_ALLOW, "spec_allow"},
+	{linux.SECCOMP_FILTER_FLAG_NEW_LISTENER, "new_listener"},
+}
+
+// SeccompDump returns a human-readable description of the task's seccomp mode
+// and, in SECCOMP_MODE_FILTER, its usage of the filter instruction limit and
+// the disassembly of each of its filters in the order in which they were
+// installed. Comparisons against the system call number are annotated with the
+// name of the system call in the task's syscall table, and returns with the
+// name of their action. SeccompDump may be called from any goroutine.
+func (t *Task) SeccompDump() string {
+	t.seccompMu.Lock()
+	strict := t.seccompStrict
+	t.seccompMu.Unlock()
+	t.mu.Lock()
+	st := t.tc.st
+	t.mu.Unlock()
+
+	if strict {
+		return "mode: strict\n"
+	}
+	filters := t.GetSeccompFilters()
+	if len(filters) == 0 {
+		return "mode: none\n"
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "mode: filter\nfilters: %d\n", len(filters))
+	current, limit := t.SeccompFilterInstructions()
+	fmt.Fprintf(&b, "instructions: %d/%d\n", current, limit)
+	for i, f := range filters {
+		fmt.Fprintf(&b, "\nfilter %d: %d instructions, flags %s\n", i, f.Program.Length(), seccompFilterFlagsString(f.Flags))
+		insns := f.Program.Instructions()
+		lines := strings.Split(strings.TrimSuffix(bpf.Disassemble(f.Program), "\n"), "\n")
+		// nrLoaded is true if the last instruction before pc to modify A, in
+		// program order, loaded the system call number. This is exact for the
+		// straight-line comparisons generated by most filter compilers.
+		nrLoaded := false
+		for pc, line := range lines {
+			b.WriteString(line)
+			i := insns[pc]
+			switch {
+			case i.OpCode == bpf.Ret|bpf.K:
+				fmt.Fprintf(&b, "\t; %s", seccompRetString(i.K))
+			case bpf.InstructionClass(i.OpCode) == bpf.Jmp && i.OpCode != bpf.Jmp|bpf.Ja && i.OpCode&bpf.X == 0 && nrLoaded:
+				fmt.Fprintf(&b, "\t; %s", st.LookupName(uintptr(i.K)))
+			}
+			switch bpf.InstructionClass(i.OpCode) {
+			case bpf.Ld, bpf.Alu, bpf.Misc:
+				nrLoaded = i.OpCode == bpf.Ld|bpf.Abs|bpf.W && i.K == 0
+			}
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+// SeccompFilterSummary returns a human-readable description of the task's
+// seccomp mode and, in SECCOMP_MODE_FILTER, one line for each of its filters,
+// in the order in which they were installed, giving the filter's index, its
+// length in instructions and the SECCOMP_FILTER_FLAG_* flags with which it was
+// installed. Unlike SeccompDump, the mode and filters are read atomically, so
+// the summary never mixes states before and after a concurrent change to the
+// task's filters. SeccompFilterSummary may be called from any goroutine.
+func (t *Task) SeccompFilterSummary() string {
+	t.tg.seccompMu.Lock()
+	t.seccompMu.Lock()
+	strict := t.seccompStrict
+	filters := guestSyscallFilters(t.syscallFilterChain())
+	t.seccompMu.Unlock()
+	t.tg.seccompMu.Unlock()
+
+	if strict {
+		return "mode: strict\n"
+	}
+	if len(filters) == 0 {
+		return "mode: none\n"
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "mode: filter\nfilters: %d\n", len(filters))
+	for i, f := range filters {
+		fmt.Fprintf(&b, "filter %d: %d instructions, flags %s\n", i, f.program.Length(), seccompFilterFlagsString(f.flags))
+	}
+	return b.String()
+}
+
+// seccompFilterFlagsString returns the names of the SECCOMP_FILTER_FLAG_*
+// flags in flags.
+func seccompFilterFlagsString(flags uint32) string {
+	var names []string
+	for _, f := range seccompFilterFlagNames {
+		if flags&f.flag != 0 {
+			names = append(names, f.name)
+			flags &^= f.flag
+		}
+	}
+	if flags != 0 {
+		names = append(names, fmt.Sprintf("%#x", flags))
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, "|")
+}
+
+// seccompRetString returns a description of the seccomp-bpf return value
+// ret, consisting of its action and, if it has any, its data.
+func seccompRetString(ret uint32) string {
+	action, data := splitSeccompResult(ret)
+	if data != 0 {
+		return fmt.Sprintf("%s %d", seccompActionName(action), data)
+	}
+	return seccompActionName(action)
+}
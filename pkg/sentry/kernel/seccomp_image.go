@@ -0,0 +1,194 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kernel
+
+import (
+	"fmt"
+
+	"gvisor.googlesource.com/gvisor/pkg/abi/linux"
+	"gvisor.googlesource.com/gvisor/pkg/binary"
+	"gvisor.googlesource.com/gvisor/pkg/bpf"
+	"gvisor.googlesource.com/gvisor/pkg/sentry/usermem"
+)
+
+// seccompFilterRecord is the header of each filter serialized by
+// MarshalSeccompFilters. It is followed by Len instructions, each in the
+// layout of struct sock_filter, so that the filter can be reinstalled by
+// pointing a struct sock_fprog at them.
+type seccompFilterRecord struct {
+	// Flags is the set of per-filter SECCOMP_FILTER_FLAG_* flags with which
+	// the filter must be reinstalled.
+	Flags uint32
+
+	// Len is the number of instructions in the filter, as in
+	// sock_fprog::len.
+	Len uint16
+
+	_ [2]byte
+}
+
+// seccompFilterRecordSize is the size of a seccompFilterRecord.
+var seccompFilterRecordSize = int(binary.Size(seccompFilterRecord{}))
+
+// bpfInstructionSize is the size of a struct sock_filter.
+var bpfInstructionSize = int(binary.Size(linux.BPFInstruction{}))
+
+// MarshalSeccompFilters serializes filters, as returned by GetSeccompFilters,
+// for checkpointing tools such as CRIU. Each filter is encoded as its flags
+// and instruction count, followed by its instructions as an array of struct
+// sock_filter, in the sentry's byte order. Filters are encoded in the order in
+// which they were installed, so installing each of them in turn (with
+// seccomp(SECCOMP_SET_MODE_FILTER, flags, &fprog)) recreates the original
+// filter chain.
+func MarshalSeccompFilters(filters []SeccompFilter) []byte {
+	var buf []byte
+	for _, f := range filters {
+		insns := f.Program.Instructions()
+		buf = binary.Marshal(buf, usermem.ByteOrder, &seccompFilterRecord{
+			Flags: f.Flags,
+			Len:   uint16(len(insns)),
+		})
+		buf = binary.Marshal(buf, usermem.ByteOrder, insns)
+	}
+	return buf
+}
+
+// UnmarshalSeccompFilters deserializes filters serialized by
+// MarshalSeccompFilters. It returns an error if buf is malformed or contains
+// an invalid BPF program.
+func UnmarshalSeccompFilters(buf []byte) ([]SeccompFilter, error) {
+	var filters []SeccompFilter
+	for len(buf) != 0 {
+		if len(buf) < seccompFilterRecordSize {
+			return nil, fmt.Errorf("truncated header for filter %d", len(filters))
+		}
+		var r seccompFilterRecord
+		binary.Unmarshal(buf[:seccompFilterRecordSize], usermem.ByteOrder, &r)
+		buf = buf[seccompFilterRecordSize:]
+
+		size := int(r.Len) * bpfInstructionSize
+		if len(buf) < size {
+			return nil, fmt.Errorf("truncated instructions for filter %d: got %d bytes, want %d", len(filters), len(buf), size)
+		}
+		insns := make([]linux.BPFInstruction, r.Len)
+		binary.Unmarshal(buf[:size], usermem.ByteOrder, insns)
+		buf = buf[size:]
+
+		p, err := bpf.Compile(insns)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter %d: %v", len(filters), err)
+		}
+		filters = append(filters, SeccompFilter{Program: p, Flags: r.Flags})
+	}
+	return filters, nil
+}
+
+const (
+	// seccompFilterBundleMagic identifies a seccomp filter bundle. It is
+	// encoded as the bytes "SCMP" in the sentry's byte order.
+	seccompFilterBundleMagic = 0x504d4353
+
+	// SeccompFilterBundleVersion is the version of the seccomp filter bundle
+	// format written by MarshalSeccompFilterBundle. It must be incremented
+	// whenever the format changes incompatibly.
+	SeccompFilterBundleVersion = 1
+)
+
+// seccompFilterBundleHeader is the header of a seccomp filter bundle, as
+// written by MarshalSeccompFilterBundle. It is followed by Size bytes
+// containing Count filters in the format of MarshalSeccompFilters.
+type seccompFilterBundleHeader struct {
+	// Magic is seccompFilterBundleMagic.
+	Magic uint32
+
+	// Version is the bundle's format version.
+	Version uint32
+
+	// Count is the number of filters in the bundle.
+	Count uint32
+
+	// Size is the size in bytes of the filters following the header.
+	Size uint32
+}
+
+// seccompFilterBundleHeaderSize is the size of a seccompFilterBundleHeader.
+var seccompFilterBundleHeaderSize = int(binary.Size(seccompFilterBundleHeader{}))
+
+// MarshalSeccompFilterBundle serializes filters as a seccomp filter bundle: a
+// versioned, self-delimiting encoding of the filters' programs and flags that
+// can be precompiled from a policy (such as an OCI seccomp profile) and
+// installed in a task at startup with InstallSeccompFilters, without the task
+// installing each filter itself.
+func MarshalSeccompFilterBundle(filters []SeccompFilter) []byte {
+	records := MarshalSeccompFilters(filters)
+	buf := binary.Marshal(nil, usermem.ByteOrder, &seccompFilterBundleHeader{
+		Magic:   seccompFilterBundleMagic,
+		Version: SeccompFilterBundleVersion,
+		Count:   uint32(len(filters)),
+		Size:    uint32(len(records)),
+	})
+	return append(buf, records...)
+}
+
+// UnmarshalSeccompFilterBundle deserializes a seccomp filter bundle written by
+// MarshalSeccompFilterBundle. Bundles are validated strictly: it returns an
+// error if buf has an unknown magic number or version, is truncated or has
+// trailing data, contains a program that is not a valid seccomp filter or a
+// filter flag that can't be installed with the filter, or contains more
+// instructions than a task's filters may have in total.
+func UnmarshalSeccompFilterBundle(buf []byte) ([]SeccompFilter, error) {
+	if len(buf) < seccompFilterBundleHeaderSize {
+		return nil, fmt.Errorf("truncated header: got %d bytes, want %d", len(buf), seccompFilterBundleHeaderSize)
+	}
+	var h seccompFilterBundleHeader
+	binary.Unmarshal(buf[:seccompFilterBundleHeaderSize], usermem.ByteOrder, &h)
+	buf = buf[seccompFilterBundleHeaderSize:]
+	if h.Magic != seccompFilterBundleMagic {
+		return nil, fmt.Errorf("invalid magic number %#x", h.Magic)
+	}
+	if h.Version != SeccompFilterBundleVersion {
+		return nil, fmt.Errorf("unsupported version %d, want %d", h.Version, SeccompFilterBundleVersion)
+	}
+	if uint64(h.Size) != uint64(len(buf)) {
+		return nil, fmt.Errorf("got %d bytes of filters, want %d", len(buf), h.Size)
+	}
+	// Each filter other than the last contributes at least
+	// syscallFilterOverhead+1 instructions to syscallFiltersLength.
+	if h.Count > maxSyscallFilterInstructions/(syscallFilterOverhead+1)+1 {
+		return nil, fmt.Errorf("too many filters: %d", h.Count)
+	}
+
+	filters, err := UnmarshalSeccompFilters(buf)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(filters)) != uint64(h.Count) {
+		return nil, fmt.Errorf("got %d filters, want %d", len(filters), h.Count)
+	}
+	fs := make([]*syscallFilter, len(filters))
+	for i, f := range filters {
+		if f.Flags&^(linux.SECCOMP_FILTER_FLAG_LOG|linux.SECCOMP_FILTER_FLAG_SPEC_ALLOW) != 0 {
+			return nil, fmt.Errorf("invalid flags %#x for filter %d", f.Flags, i)
+		}
+		if err := checkSeccompProgram(f.Program); err != nil {
+			return nil, fmt.Errorf("filter %d is not a valid seccomp filter", i)
+		}
+		fs[i] = &syscallFilter{program: f.Program}
+	}
+	if l := syscallFiltersLength(fs); l > maxSyscallFilterInstructions {
+		return nil, fmt.Errorf("filters have length %d, exceeding limit %d", l, maxSyscallFilterInstructions)
+	}
+	return filters, nil
+}
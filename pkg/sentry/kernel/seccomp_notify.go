@@ -0,0 +1,639 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kernel
+
+import (
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"gvisor.googlesource.com/gvisor/pkg/abi/linux"
+	"gvisor.googlesource.com/gvisor/pkg/sentry/fs"
+	"gvisor.googlesource.com/gvisor/pkg/sentry/kernel/kdefs"
+	"gvisor.googlesource.com/gvisor/pkg/syserror"
+	"gvisor.googlesource.com/gvisor/pkg/waiter"
+)
+
+// MaxSeccompNotifications is the maximum number of outstanding notifications,
+// which have been sent but not yet responded to or withdrawn, of each
+// SeccompListener. Once a listener has this many outstanding notifications,
+// tasks that would send it more block until the supervisor catches up,
+// rather than consuming memory without bound. If MaxSeccompNotifications is
+// 0, the number of outstanding notifications is unlimited.
+//
+// MaxSeccompNotifications must be accessed atomically.
+var MaxSeccompNotifications uint32 = 1024
+
+// seccompNotificationState is the state of a seccompNotification.
+type seccompNotificationState int
+
+const (
+	// seccompNotificationPending indicates that a notification has not yet
+	// been received by the supervisor.
+	seccompNotificationPending seccompNotificationState = iota
+
+	// seccompNotificationSent indicates that a notification has been received
+	// by the supervisor, which has not yet responded to it.
+	seccompNotificationSent
+
+	// seccompNotificationReplied indicates that a notification has been
+	// responded to, or is about to be responded to by a
+	// SECCOMP_IOCTL_NOTIF_ADDFD request with SECCOMP_ADDFD_FLAG_SEND, or that
+	// its listener has been released.
+	seccompNotificationReplied
+)
+
+// seccompNotification is a single system call awaiting a decision from a
+// seccomp user notification supervisor.
+type seccompNotification struct {
+	// id is the notification's identifier, which is unique within the
+	// Kernel. id is immutable.
+	id uint64
+
+	// task is the notifying task. task is immutable.
+	task *Task
+
+	// data describes the notifying system call. data is immutable.
+	data seccompData
+
+	// wake is signalled, without blocking, when state becomes
+	// seccompNotificationReplied or addFDs becomes non-empty. wake has a
+	// buffer of 1, so that a signal sent while the notifying task is not
+	// blocked is not lost.
+	wake chan struct{}
+
+	// All fields below are protected by SeccompListener.mu.
+
+	// state is the notification's current state.
+	state seccompNotificationState
+
+	// addFDs is the queue of SECCOMP_IOCTL_NOTIF_ADDFD requests that have
+	// not yet been taken by the notifying task, in order of arrival.
+	addFDs []*seccompAddFDRequest
+
+	// resp is the supervisor's response. resp is only meaningful if state is
+	// seccompNotificationReplied and listenerReleased is false.
+	resp linux.SeccompNotifResp
+
+	// listenerReleased is true if the notification's listener was released
+	// before the supervisor responded to it.
+	listenerReleased bool
+}
+
+// wakeTask wakes n's notifying task, if it is blocked.
+func (n *seccompNotification) wakeTask() {
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+}
+
+// SeccompAddFD describes a file to be installed in the file descriptor table
+// of a notifying task, as by ioctl(SECCOMP_IOCTL_NOTIF_ADDFD).
+type SeccompAddFD struct {
+	// File is the file to install.
+	File *fs.File
+
+	// SetFD indicates that File should be installed at FD, replacing any
+	// file already installed there, rather than at the lowest available file
+	// descriptor.
+	SetFD bool
+
+	// FD is the file descriptor at which to install File if SetFD is true.
+	FD kdefs.FD
+
+	// Flags are the flags of the new file descriptor.
+	Flags FDFlags
+
+	// Send indicates that once File has been installed, the notification
+	// should be responded to with the new file descriptor as the system
+	// call's return value.
+	Send bool
+}
+
+// seccompAddFDRequest is a SeccompAddFD awaiting the notifying task.
+type seccompAddFDRequest struct {
+	SeccompAddFD
+
+	// done is closed when the request has been completed, after which fd
+	// and err are valid.
+	done chan struct{}
+
+	// fd is the file descriptor at which File was installed.
+	fd kdefs.FD
+
+	// err is the error that prevented File from being installed, if any.
+	err error
+}
+
+// install installs req.File in the file descriptor table of t.
+//
+// Preconditions: The caller must be running on the task goroutine of t.
+func (req *seccompAddFDRequest) install(t *Task) {
+	if req.SetFD {
+		req.fd = req.FD
+		req.err = t.FDMap().NewFDAt(req.FD, req.File, req.Flags, t.ThreadGroup().Limits())
+		return
+	}
+	req.fd, req.err = t.FDMap().NewFDFrom(0, req.File, req.Flags, t.ThreadGroup().Limits())
+}
+
+// SeccompListener is the kernel side of a seccomp user notification listener.
+// Tasks whose filters return SECCOMP_RET_USER_NOTIF block until a supervisor
+// receives the notification (Recv) and responds to it (Send).
+//
+// +stateify savable
+type SeccompListener struct {
+	// queue is notified when notifications become pending.
+	queue waiter.Queue `state:"zerovalue"`
+
+	// mu protects the fields below.
+	mu sync.Mutex `state:"nosave"`
+
+	// pending is the queue of notifications that have not yet been received
+	// by the supervisor, in order of arrival.
+	//
+	// pending is not saved, since notifying tasks are interrupted (and their
+	// system calls later restarted) when the kernel is paused.
+	pending []*seccompNotification `state:"nosave"`
+
+	// sent maps the IDs of notifications that have been received by the
+	// supervisor, but not yet responded to, to those notifications.
+	sent map[uint64]*seccompNotification `state:"nosave"`
+
+	// waiters is the queue of tasks waiting for the number of outstanding
+	// notifications to fall below MaxSeccompNotifications, in order of
+	// arrival, represented by channels that wake them. The first waiter is
+	// woken whenever there may be room for its notification; each waiter
+	// that leaves the queue wakes the next, so that wakeups are not lost.
+	//
+	// waiters is not saved for the same reason as pending.
+	waiters []chan struct{} `state:"nosave"`
+
+	// released is true if Release has been called.
+	released bool
+
+	// users is the number of tasks, including zombies that have not yet
+	// been reaped, whose system call filters include the listener's filter.
+	// Filters are inherited across fork and clone, so notifications may come
+	// from any of these tasks. users is accessed using atomic memory
+	// operations.
+	users int64
+
+	// orphaned is non-zero if users has fallen to 0, after which no task can
+	// send notifications to the listener. orphaned is accessed using atomic
+	// memory operations.
+	orphaned uint32
+}
+
+// NewSeccompListener returns a new SeccompListener.
+func NewSeccompListener() *SeccompListener {
+	return &SeccompListener{}
+}
+
+// addUsers records that the system call filters of n more tasks include l's
+// filter.
+func (l *SeccompListener) addUsers(n int) {
+	atomic.AddInt64(&l.users, int64(n))
+}
+
+// removeUser records that a task whose system call filters include l's filter
+// has been reaped. As in Linux, once the last such task has been reaped, the
+// listener is orphaned, and its supervisor is notified with EventHUp, since it
+// will receive no further notifications.
+func (l *SeccompListener) removeUser() {
+	if atomic.AddInt64(&l.users, -1) == 0 {
+		atomic.StoreUint32(&l.orphaned, 1)
+		l.queue.Notify(waiter.EventHUp)
+	}
+}
+
+// notify sends a notification for the system call described by data to l's
+// supervisor and blocks until the supervisor responds. If notify returns
+// true, the system call should be executed; otherwise, notify has set its
+// return value.
+//
+// Preconditions: The caller must be running on the task goroutine.
+func (l *SeccompListener) notify(t *Task, data *seccompData) bool {
+	n := &seccompNotification{
+		id:   t.k.generateSeccompNotifID(),
+		task: t,
+		data: *data,
+		wake: make(chan struct{}, 1),
+	}
+
+	l.mu.Lock()
+	for !l.released && l.fullLocked() {
+		// Wait for the supervisor to make room for the notification. The
+		// task would be blocked waiting for a response anyway.
+		wake := make(chan struct{}, 1)
+		l.waiters = append(l.waiters, wake)
+		l.mu.Unlock()
+		err := t.Block(wake)
+		l.mu.Lock()
+		l.removeWaiterLocked(wake)
+		if err != nil {
+			// Restart the system call once the interruption has been handled,
+			// as for a withdrawn notification.
+			l.mu.Unlock()
+			t.Arch().SetReturn(uintptr(-t.ExtractErrno(ERESTARTSYS, int(data.nr))))
+			t.haveSyscallReturn = true
+			return false
+		}
+	}
+	if l.released {
+		l.mu.Unlock()
+		// This useless-looking temporary is needed because Go.
+		tmp := uintptr(syscall.ENOSYS)
+		t.Arch().SetReturn(-tmp)
+		return false
+	}
+	l.pending = append(l.pending, n)
+	// There may still be room for the next waiter's notification.
+	l.wakeWaiterLocked()
+	l.mu.Unlock()
+	l.queue.Notify(waiter.EventIn)
+
+	for {
+		err := t.Block(n.wake)
+
+		l.mu.Lock()
+		if err != nil && (n.state != seccompNotificationReplied || len(n.addFDs) != 0) {
+			// We were interrupted before the supervisor responded, or
+			// before we installed the file descriptors that it asked for.
+			// Withdraw the notification, fail the outstanding
+			// SECCOMP_IOCTL_NOTIF_ADDFD requests with ESRCH, and restart
+			// the system call once the interruption has been handled, as
+			// Linux does.
+			l.removeLocked(n)
+			for _, req := range n.addFDs {
+				req.err = syserror.ESRCH
+				close(req.done)
+			}
+			n.addFDs = nil
+			l.mu.Unlock()
+			t.Arch().SetReturn(uintptr(-t.ExtractErrno(ERESTARTSYS, int(data.nr))))
+			t.haveSyscallReturn = true
+			return false
+		}
+		if len(n.addFDs) == 0 {
+			if n.state == seccompNotificationReplied {
+				break
+			}
+			l.mu.Unlock()
+			continue
+		}
+		reqs := n.addFDs
+		n.addFDs = nil
+		l.mu.Unlock()
+
+		// Install files without holding l.mu, since replacing an existing
+		// file descriptor may release the last reference on its file, which
+		// may be this listener.
+		for _, req := range reqs {
+			req.install(t)
+		}
+
+		l.mu.Lock()
+		for _, req := range reqs {
+			if req.Send {
+				l.completeSendLocked(n, req)
+			}
+			close(req.done)
+		}
+		l.mu.Unlock()
+	}
+	defer l.mu.Unlock()
+	if n.listenerReleased {
+		// As in Linux, notifications fail with ENOSYS if the supervisor goes
+		// away before responding.
+		tmp := uintptr(syscall.ENOSYS)
+		t.Arch().SetReturn(-tmp)
+		return false
+	}
+	if n.resp.Flags&linux.SECCOMP_USER_NOTIF_FLAG_CONTINUE != 0 {
+		return true
+	}
+	if n.resp.Error != 0 {
+		t.Arch().SetReturn(uintptr(int64(n.resp.Error)))
+	} else {
+		t.Arch().SetReturn(uintptr(n.resp.Val))
+	}
+	return false
+}
+
+// completeSendLocked completes a SECCOMP_IOCTL_NOTIF_ADDFD request with
+// SECCOMP_ADDFD_FLAG_SEND for n, which AddFD has marked as replied to. If the
+// file was installed, the new file descriptor becomes the system call's return
+// value; otherwise, as in Linux, the supervisor may still respond to n.
+//
+// Preconditions: l.mu must be locked.
+func (l *SeccompListener) completeSendLocked(n *seccompNotification, req *seccompAddFDRequest) {
+	if req.err == nil {
+		n.resp = linux.SeccompNotifResp{
+			ID:  n.id,
+			Val: int64(req.fd),
+		}
+		n.wakeTask()
+		return
+	}
+	if l.released {
+		l.replyReleasedLocked(n)
+		return
+	}
+	n.state = seccompNotificationSent
+	l.sent[n.id] = n
+}
+
+// removeLocked removes n from l.
+//
+// Preconditions: l.mu must be locked.
+func (l *SeccompListener) removeLocked(n *seccompNotification) {
+	switch n.state {
+	case seccompNotificationPending:
+		for i, pn := range l.pending {
+			if pn == n {
+				l.pending = append(l.pending[:i], l.pending[i+1:]...)
+				break
+			}
+		}
+	case seccompNotificationSent:
+		delete(l.sent, n.id)
+	}
+	l.wakeWaiterLocked()
+}
+
+// fullLocked returns true if l has MaxSeccompNotifications outstanding
+// notifications.
+//
+// Preconditions: l.mu must be locked.
+func (l *SeccompListener) fullLocked() bool {
+	max := atomic.LoadUint32(&MaxSeccompNotifications)
+	return max != 0 && uint64(len(l.pending)+len(l.sent)) >= uint64(max)
+}
+
+// wakeWaiterLocked wakes the first task waiting to send a notification, if
+// any, and if there is room for its notification.
+//
+// Preconditions: l.mu must be locked.
+func (l *SeccompListener) wakeWaiterLocked() {
+	if len(l.waiters) == 0 || l.fullLocked() {
+		return
+	}
+	select {
+	case l.waiters[0] <- struct{}{}:
+	default:
+	}
+}
+
+// removeWaiterLocked removes wake from l.waiters, and passes on any wakeup
+// that it may have consumed to the next waiter.
+//
+// Preconditions: l.mu must be locked.
+func (l *SeccompListener) removeWaiterLocked(wake chan struct{}) {
+	for i, w := range l.waiters {
+		if w == wake {
+			l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+			break
+		}
+	}
+	l.wakeWaiterLocked()
+}
+
+// Recv dequeues the oldest pending notification, marking it as awaiting a
+// response. Thread IDs in the returned notification are relative to the
+// PID namespace of t, the receiving task. If no notification is pending, Recv
+// returns syserror.ErrWouldBlock. Notifications that have been withdrawn
+// (because their notifying task was interrupted) or responded to are no longer
+// pending, so each notification is received at most once.
+func (l *SeccompListener) Recv(t *Task) (linux.SeccompNotif, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.pending) == 0 {
+		return linux.SeccompNotif{}, syserror.ErrWouldBlock
+	}
+	n := l.pending[0]
+	l.pending = l.pending[1:]
+	n.state = seccompNotificationSent
+	if l.sent == nil {
+		l.sent = make(map[uint64]*seccompNotification)
+	}
+	l.sent[n.id] = n
+	return linux.SeccompNotif{
+		ID:   n.id,
+		Pid:  uint32(t.PIDNamespace().IDOfTask(n.task)),
+		Data: n.data.toABI(),
+	}, nil
+}
+
+// Send delivers the supervisor's response to the notification identified by
+// resp.ID, waking the notifying task. If resp.Flags contains
+// SECCOMP_USER_NOTIF_FLAG_CONTINUE, the notifying task executes the system
+// call, so resp may not also specify its result. Otherwise, as in Linux, the
+// system call returns resp.Error if it is non-zero, and resp.Val otherwise.
+//
+// Each notification may be responded to once, after it has been received:
+// Send returns EINPROGRESS if the notification is still pending, and ENOENT
+// if it is unknown, has already been responded to, or has been withdrawn.
+func (l *SeccompListener) Send(resp linux.SeccompNotifResp) error {
+	if resp.Flags&^linux.SECCOMP_USER_NOTIF_FLAG_CONTINUE != 0 {
+		return syserror.EINVAL
+	}
+	if resp.Flags&linux.SECCOMP_USER_NOTIF_FLAG_CONTINUE != 0 && (resp.Error != 0 || resp.Val != 0) {
+		return syserror.EINVAL
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n, ok := l.sentLocked(resp.ID)
+	if !ok {
+		return l.unsentErrorLocked(resp.ID)
+	}
+	delete(l.sent, resp.ID)
+	n.resp = resp
+	n.state = seccompNotificationReplied
+	n.wakeTask()
+	l.wakeWaiterLocked()
+	return nil
+}
+
+// AddFD installs a file in the file descriptor table of the task that sent the
+// notification identified by id, and returns the new file descriptor. As in
+// Linux, the file is installed by the notifying task itself, so AddFD blocks
+// t, the calling task, until the notifying task has done so.
+//
+// If add.Send is true, the notification is responded to with the new file
+// descriptor as its return value once the file has been installed, and no
+// other request may be outstanding for the notification.
+func (l *SeccompListener) AddFD(t *Task, id uint64, add SeccompAddFD) (kdefs.FD, error) {
+	l.mu.Lock()
+	n, ok := l.sentLocked(id)
+	if !ok {
+		defer l.mu.Unlock()
+		return 0, l.unsentErrorLocked(id)
+	}
+	if add.Send {
+		if len(n.addFDs) != 0 {
+			l.mu.Unlock()
+			return 0, syserror.EBUSY
+		}
+		// Prevent other responses while the file is being installed.
+		delete(l.sent, id)
+		n.state = seccompNotificationReplied
+		l.wakeWaiterLocked()
+	}
+	req := &seccompAddFDRequest{
+		SeccompAddFD: add,
+		done:         make(chan struct{}),
+	}
+	n.addFDs = append(n.addFDs, req)
+	n.wakeTask()
+	l.mu.Unlock()
+
+	if err := t.Block(req.done); err != nil {
+		l.mu.Lock()
+		for i, r := range n.addFDs {
+			if r == req {
+				// The notifying task hasn't taken the request yet, so
+				// withdraw it.
+				n.addFDs = append(n.addFDs[:i], n.addFDs[i+1:]...)
+				if req.Send {
+					req.err = err
+					l.completeSendLocked(n, req)
+				}
+				l.mu.Unlock()
+				return 0, ERESTARTSYS
+			}
+		}
+		l.mu.Unlock()
+		// The notifying task is installing the file, which can't be
+		// undone; wait for it to finish.
+		<-req.done
+	}
+	return req.fd, req.err
+}
+
+// sentLocked returns the notification identified by id if it has been
+// received by the supervisor and not yet responded to, and its notifying task
+// can still act on a response.
+//
+// A killed notifying task withdraws its notification once its task goroutine
+// observes the kill, but can't act on a response even before then, so such
+// notifications are treated as withdrawn as soon as the task is killed. This
+// orders responses and kills: if a response is accepted, the notifying task
+// was not yet killed and is woken with the response, and otherwise the
+// response is rejected with ENOENT and has no effect.
+//
+// Preconditions: l.mu must be locked.
+func (l *SeccompListener) sentLocked(id uint64) (*seccompNotification, bool) {
+	n, ok := l.sent[id]
+	if !ok || n.task.killed() {
+		return nil, false
+	}
+	return n, true
+}
+
+// unsentErrorLocked returns the error for a request concerning notification
+// id, which is not awaiting a response: EINPROGRESS if the notification is
+// pending, since the supervisor may not respond to or modify the notifying
+// task before it has received the notification, or ENOENT otherwise.
+//
+// Preconditions: l.mu must be locked.
+func (l *SeccompListener) unsentErrorLocked(id uint64) error {
+	for _, pn := range l.pending {
+		if pn.id == id {
+			return syscall.EINPROGRESS
+		}
+	}
+	return syserror.ENOENT
+}
+
+// IDValid returns nil if id identifies a notification that has been received
+// by the supervisor, and whose notifying task is still awaiting a response.
+// Otherwise, IDValid returns ENOENT. In particular, if the notifying task is
+// interrupted, its notification is withdrawn and its ID becomes invalid, and
+// if the notifying task is killed, its ID becomes invalid immediately, before
+// the task exits and is reaped. Since IDs are never reused, an invalid ID
+// can't become valid again, so a supervisor that checks IDValid after looking
+// up the notifying task (e.g. by opening /proc/[pid]) knows that it has found
+// the right task.
+func (l *SeccompListener) IDValid(id uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.sentLocked(id); !ok {
+		return syserror.ENOENT
+	}
+	return nil
+}
+
+// Readiness implements waiter.Waitable.Readiness.
+func (l *SeccompListener) Readiness(mask waiter.EventMask) waiter.EventMask {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var ready waiter.EventMask
+	if len(l.pending) != 0 {
+		ready |= waiter.EventIn
+	}
+	if len(l.sent) != 0 {
+		ready |= waiter.EventOut
+	}
+	if l.released || atomic.LoadUint32(&l.orphaned) != 0 {
+		ready |= waiter.EventHUp
+	}
+	return mask & ready
+}
+
+// EventRegister implements waiter.Waitable.EventRegister.
+func (l *SeccompListener) EventRegister(e *waiter.Entry, mask waiter.EventMask) {
+	l.queue.EventRegister(e, mask)
+}
+
+// EventUnregister implements waiter.Waitable.EventUnregister.
+func (l *SeccompListener) EventUnregister(e *waiter.Entry) {
+	l.queue.EventUnregister(e)
+}
+
+// Release releases l. All notifications that are pending or awaiting a
+// response, or waiting for room in l, as well as all future notifications, fail
+// with ENOSYS.
+func (l *SeccompListener) Release() {
+	l.mu.Lock()
+	l.released = true
+	for _, n := range l.pending {
+		l.replyReleasedLocked(n)
+	}
+	l.pending = nil
+	for _, n := range l.sent {
+		l.replyReleasedLocked(n)
+	}
+	l.sent = nil
+	// Waiters fail with ENOSYS like future notifications.
+	for _, w := range l.waiters {
+		select {
+		case w <- struct{}{}:
+		default:
+		}
+	}
+	l.mu.Unlock()
+	l.queue.Notify(waiter.EventHUp)
+}
+
+// replyReleasedLocked completes n due to the release of its listener.
+//
+// Preconditions: l.mu must be locked.
+func (l *SeccompListener) replyReleasedLocked(n *seccompNotification) {
+	n.listenerReleased = true
+	n.state = seccompNotificationReplied
+	n.wakeTask()
+}
@@ -0,0 +1,522 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kernel
+
+import (
+	"encoding/hex"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"gvisor.googlesource.com/gvisor/pkg/abi/linux"
+	"gvisor.googlesource.com/gvisor/pkg/binary"
+	"gvisor.googlesource.com/gvisor/pkg/bpf"
+)
+
+// seccompProfileCase is a system call evaluated by a seccompProfile, and the
+// result returned by Linux.
+type seccompProfileCase struct {
+	arch uint32
+	nr   int32
+	arg0 uint64
+	want uint32
+}
+
+// seccompProfiles are container runtime seccomp profiles, compiled to BPF by
+// libseccomp 2.5.4 for the x86_64, x86 and x32 architectures:
+//
+// - "docker" is an abridged version of the default profile of Docker and
+// containerd (which is also Kubernetes' RuntimeDefault profile), for a
+// container without CAP_SYS_ADMIN: system calls fail with EPERM, except for an
+// allowlist, personality(2) with the allowed personas, clone(2) without
+// namespace flags, socket(2) with any domain other than AF_VSOCK, and clone3(2),
+// which fails with ENOSYS.
+//
+// - "podman" is an abridged version of Podman's default profile, which is
+// similar, but fails system calls with ENOSYS by default, and a fixed set of
+// obsolete or dangerous system calls with EPERM.
+//
+// - "restricted" is a minimal allowlist that kills the process by default,
+// including for architectures other than x86_64, fails open(2) and openat(2)
+// with EACCES, and traps ptrace(2).
+//
+// Each program is the output of seccomp_export_bpf(), as an array of struct
+// sock_filter in little-endian byte order. The expected result of each case
+// was obtained by installing the program in a process on Linux 6.18 x86_64,
+// after a filter that failed all system calls with an unused errno, and
+// executing the system call: the result is SECCOMP_RET_ALLOW if the system
+// call failed with the unused errno, SECCOMP_RET_ERRNO if it failed with
+// another errno, SECCOMP_RET_TRAP if it raised a catchable SIGSYS (with the
+// filter's data in si_errno), and SECCOMP_RET_KILL_PROCESS if the process was
+// killed by SIGSYS. x86 system calls were executed using int $0x80.
+var seccompProfiles = []struct {
+	name    string
+	program string
+	cases   []seccompProfileCase
+}{
+	{
+		name: "docker",
+		program: `
+			2000000004000000150001003e0000c005000000400100002000000000000000
+			1500eb00000000001500ea00010000001500e900020000001500e80003000000
+			1500e700040000001500e600050000001500e500060000001500e40007000000
+			1500e300080000001500e200090000001500e1000a0000001500e0000b000000
+			1500df000c0000001500de000d0000001500dd000e0000001500dc000f000000
+			1500db00100000001500da00110000001500d900120000001500d80013000000
+			1500d700140000001500d600150000001500d500160000001500d40017000000
+			1500d300180000001500d200190000001500d1001c0000001500d00020000000
+			1500cf00210000001500ce00220000001500cd00230000001500cc0025000000
+			1500cb00270000001500ca002a0000001500c9002b0000001500c8002c000000
+			1500c7002d0000001500c6002e0000001500c5002f0000001500c40030000000
+			1500c300310000001500c200320000001500c100330000001500c00034000000
+			1500bf00350000001500be00360000001500bd00370000001500bc0039000000
+			1500bb003a0000001500ba003b0000001500b9003c0000001500b8003d000000
+			1500b7003e0000001500b6003f0000001500b500480000001500b40049000000
+			1500b3004a0000001500b2004b0000001500b1004d0000001500b0004e000000
+			1500af004f0000001500ae00500000001500ad00510000001500ac0052000000
+			1500ab00530000001500aa00540000001500a900550000001500a80056000000
+			1500a700570000001500a600580000001500a500590000001500a4005a000000
+			1500a3005b0000001500a2005c0000001500a1005d0000001500a0005e000000
+			15009f005f00000015009e006000000015009d006100000015009c0062000000
+			15009b006600000015009a00680000001500990069000000150098006a000000
+			150097006b000000150096006c000000150095006e000000150094006f000000
+			1500930070000000150092007300000015009100760000001500900078000000
+			15008f007900000015008e007c00000015008d007d00000015008c007e000000
+			15008b008300000015008a0089000000150089008a000000150088008c000000
+			150087009a000000150086009d000000150085009e000000150084009f000000
+			15008300ba00000015008200bf00000015008100c900000015008000ca000000
+			15007f00cc00000015007e00d500000015007d00d900000015007c00da000000
+			15007b00dd00000015007a00e400000015007900e500000015007800e6000000
+			15007700e700000015007600e800000015007500e900000015007400ea000000
+			15007300f700000015007200fe00000015007100010100001500700002010000
+			15006f000401000015006e000601000015006d000701000015006c0008010000
+			15006b000901000015006a000b010000150069000c010000150068000d010000
+			150067000e010000150066000f01000015006500110100001500640018010000
+			1500630019010000150062001c010000150061001d0100001500600020010000
+			15005f002201000015005e002301000015005d002401000015005c0025010000
+			15005b002601000015005a002e010000150059003e010000150058003f010000
+			15005700420100001500560046010000150055004c01000015000001b3010000
+			0600000026000500150052000000004015005100010000401500500002000040
+			15004f000300004015004e000400004015004d000500004015004c0006000040
+			15004b000700004015004a00080000401500490009000040150048000a000040
+			150047000b000040150046000c000040150045000e0000401500440011000040
+			1500430012000040150042001500004015004100160000401500400017000040
+			15003f001800004015003e001900004015003d001c00004015003c0020000040
+			15003b002100004015003a002200004015003900230000401500380025000040
+			1500370027000040150036002a000040150035002b000040150034002c000040
+			1500330030000040150032003100004015003100320000401500300033000040
+			15002f003400004015002e003500004015002d003900004015002c003a000040
+			15002b003c00004015002a003d000040150029003e000040150028003f000040
+			15002700480000401500260049000040150025004a000040150024004b000040
+			150023004d000040150022004e000040150021004f0000401500200050000040
+			15001f005100004015001e005200004015001d005300004015001c0054000040
+			15001b005500004015001a005600004015001900570000401500180058000040
+			1500170059000040150016005a000040150015005b000040150014005c000040
+			150013005d000040150012005e000040150011005f0000401500100060000040
+			15000f006100004015000e006200004015000d006600004015000c0068000040
+			15000b006900004015000a006a000040150009006b000040150008006c000040
+			150007006e000040150006006f00004015000500700000401500040073000040
+			150003007600004015000200780000401500010079000040150000017c000040
+			060000000000ff7f1500ff007d0000401500fe007e0000401500fd0089000040
+			1500fc008a0000401500fb008c0000401500fa009a0000401500f9009d000040
+			1500f8009e0000401500f7009f0000401500f600ba0000401500f500bf000040
+			1500f400c90000401500f300ca0000401500f200cc0000401500f100d5000040
+			1500f000d90000401500ef00da0000401500ee00dd0000401500ed00e4000040
+			1500ec00e50000401500eb00e60000401500ea00e70000401500e900e8000040
+			1500e800e90000401500e700ea0000401500e600fe0000401500e50001010040
+			1500e400020100401500e300040100401500e200060100401500e10007010040
+			1500e000080100401500df00090100401500de000b0100401500dd000c010040
+			1500dc000d0100401500db000e0100401500da000f0100401500d90018010040
+			1500d800190100401500d7001c0100401500d6001d0100401500d50020010040
+			1500d400220100401500d300230100401500d200240100401500d10025010040
+			1500d000260100401500cf002e0100401500ce003e0100401500cd003f010040
+			1500cc00460100401500cb004c0100401500ba00b30100401500c90000020040
+			1500c800010200401500c700020200401500c600030200401500c50004020040
+			1500c400050200401500c300060200401500c200070200401500c10008020040
+			1500c0000d0200401500bf00110200401500be00120200401500bd001d020040
+			1500bc001e0200401500bb00210200401500b000290000401500ab0038000040
+			150000022900000020000000140000001500acb6000000001500000338000000
+			200000001400000054000000000000001500a4b1000000001500aa0087000040
+			150000af8700000020000000140000001500a7ad00000000150000ae03000040
+			20000000000000001500ab00010000001500aa00020000001500a90003000000
+			1500a800040000001500a700050000001500a600060000001500a50008000000
+			1500a400090000001500a3000a0000001500a2000b0000001500a1000c000000
+			1500a0000d00000015009f000f00000015009e001000000015009d0013000000
+			15009c001400000015009b001700000015009a0018000000150099001b000000
+			150098001d000000150097002100000015009600250000001500950026000000
+			150094002700000015009300280000001500920029000000150091002a000000
+			150090002d00000015008f002e00000015008e002f00000015008d0031000000
+			15008c003200000015008b003600000015008a0037000000150089003c000000
+			150088003f000000150087004000000015008600410000001500850042000000
+			150084004c000000150083004d000000150082004e0000001500810050000000
+			150080005200000015007f005300000015007e005500000015007d005a000000
+			15007c005b00000015007b005d00000015007a005e000000150079005f000000
+			1500780060000000150077006300000015007600640000001500750066000000
+			150074006a000000150073006b000000150072006c0000001500710072000000
+			150070007600000015006f007a00000015006e007b00000015006d007c000000
+			15006c007d00000015006b008400000015006a0085000000150069008d000000
+			150068008f000000150067009100000015006600920000001500650093000000
+			1500640094000000150063009e00000015006200a200000015006100a3000000
+			15006000a500000015005f00a800000015005e00ab00000015005d00ac000000
+			15005c00ad00000015005b00ae00000015005a00af00000015005900b4000000
+			15005800b500000015005700b600000015005600b700000015005500b8000000
+			15005400b900000015005300ba00000015005200be00000015005100c0000000
+			15005000c300000015004f00c500000015004e00d400000015004d00db000000
+			15004c00dc00000015004b00dd00000015004a00e000000015004900e5000000
+			15004800f000000015004700f200000015004600fa00000015004500fc000000
+			15004400fe00000015004300ff00000015004200000100001500410002010000
+			150040000901000015003f000a01000015003e000b01000015003d000e010000
+			15003c001c01000015003b002401000015003a00270100001500390028010000
+			150038002a010000150037002d010000150036002e010000150035002f010000
+			1500340031010000150033003201000015003200330100001500310034010000
+			150030003501000015002f003701000015002e003f01000015002d0040010000
+			15002c004301000015002b004401000015002a00480100001500290049010000
+			150028004a010000150027004b010000150026004c0100001500250054010000
+			1500240063010000150023006401000015002200660100001500210068010000
+			150020006901000015001f006a01000015001e006b01000015001d006c010000
+			15001c006d01000015001b006e01000015001a006f0100001500190070010000
+			1500180071010000150017007201000015001600730100001500150074010000
+			15001400750100001500130079010000150012007f0100001500110080010000
+			15000001b3010000060000002600050015000003780000002000000010000000
+			540000000000027e15000b0a0000000015000002670100002000000010000000
+			15000708280000001500000688000000200000001000000015000500ffffffff
+			1500040008000200150003000000020015000200080000001500010000000000
+			0600000001000500060000000000ff7f0600000000000000`,
+		cases: []seccompProfileCase{
+			{linux.AUDIT_ARCH_X86_64, 0, 0, 0x7fff0000},              // read
+			{linux.AUDIT_ARCH_X86_64, 0x1, 0x1, 0x7fff0000},          // write
+			{linux.AUDIT_ARCH_X86_64, 0x27, 0, 0x7fff0000},           // getpid
+			{linux.AUDIT_ARCH_X86_64, 0x65, 0, 0x50001},              // ptrace
+			{linux.AUDIT_ARCH_X86_64, 0xa9, 0, 0x50001},              // reboot
+			{linux.AUDIT_ARCH_X86_64, 0xa5, 0, 0x50001},              // mount
+			{linux.AUDIT_ARCH_X86_64, 0xf6, 0, 0x50001},              // kexec_load
+			{linux.AUDIT_ARCH_X86_64, 0x2, 0, 0x7fff0000},            // open
+			{linux.AUDIT_ARCH_X86_64, 0x101, 0, 0x7fff0000},          // openat
+			{linux.AUDIT_ARCH_X86_64, 0x87, 0, 0x7fff0000},           // personality
+			{linux.AUDIT_ARCH_X86_64, 0x87, 0x8, 0x7fff0000},         // personality
+			{linux.AUDIT_ARCH_X86_64, 0x87, 0xffffffff, 0x7fff0000},  // personality
+			{linux.AUDIT_ARCH_X86_64, 0x87, 0x100000008, 0x50001},    // personality
+			{linux.AUDIT_ARCH_X86_64, 0x87, 0x9, 0x50001},            // personality
+			{linux.AUDIT_ARCH_X86_64, 0x38, 0x11, 0x7fff0000},        // clone
+			{linux.AUDIT_ARCH_X86_64, 0x38, 0x20000, 0x50001},        // clone
+			{linux.AUDIT_ARCH_X86_64, 0x38, 0x10000000, 0x50001},     // clone
+			{linux.AUDIT_ARCH_X86_64, 0x38, 0x100000011, 0x7fff0000}, // clone
+			{linux.AUDIT_ARCH_X86_64, 0x29, 0x2, 0x7fff0000},         // socket
+			{linux.AUDIT_ARCH_X86_64, 0x29, 0x28, 0x50001},           // socket
+			{linux.AUDIT_ARCH_X86_64, 0x29, 0x100000028, 0x7fff0000}, // socket
+			{linux.AUDIT_ARCH_X86_64, 0x1b3, 0, 0x50026},             // clone3
+			{linux.AUDIT_ARCH_X86_64, 0x143, 0, 0x50001},             // userfaultfd
+			{linux.AUDIT_ARCH_X86_64, 0x116, 0, 0x50001},             // vmsplice
+			{linux.AUDIT_ARCH_X86_64, 0x3e8, 0, 0x50001},             // unknown
+			{linux.AUDIT_ARCH_X86_64, 0x40000000, 0, 0x7fff0000},     // x32 read
+			{linux.AUDIT_ARCH_X86_64, 0x40000027, 0, 0x7fff0000},     // x32 getpid
+			{linux.AUDIT_ARCH_X86_64, 0x400000a9, 0, 0x50001},        // x32 reboot
+			{linux.AUDIT_ARCH_I386, 0x3, 0, 0x7fff0000},              // read
+			{linux.AUDIT_ARCH_I386, 0x14, 0, 0x7fff0000},             // getpid
+			{linux.AUDIT_ARCH_I386, 0x1a, 0, 0x50001},                // ptrace
+			{linux.AUDIT_ARCH_I386, 0x58, 0, 0x50001},                // reboot
+			{linux.AUDIT_ARCH_I386, 0x66, 0x1, 0x7fff0000},           // socketcall
+			{linux.AUDIT_ARCH_I386, 0x88, 0, 0x7fff0000},             // personality
+			{linux.AUDIT_ARCH_I386, 0x88, 0xffffffff, 0x7fff0000},    // personality
+			{linux.AUDIT_ARCH_I386, 0x88, 0x9, 0x50001},              // personality
+			{linux.AUDIT_ARCH_I386, 0x78, 0x11, 0x7fff0000},          // clone
+			{linux.AUDIT_ARCH_I386, 0x78, 0x20000, 0x50001},          // clone
+			{linux.AUDIT_ARCH_I386, 0x167, 0x2, 0x7fff0000},          // socket
+			{linux.AUDIT_ARCH_I386, 0x167, 0x28, 0x50001},            // socket
+			{linux.AUDIT_ARCH_I386, 0x71, 0, 0x50001},                // vm86old
+			{linux.AUDIT_ARCH_I386, 0x3e8, 0, 0x50001},               // unknown
+		},
+	},
+	{
+		name: "podman",
+		program: `
+			2000000004000000150001003e0000c005000000550100002000000000000000
+			1500140000000000150013000100000015001200020000001500110003000000
+			150010000400000015000f000500000015000e000600000015000d0007000000
+			15000c000800000015000b000900000015000a000a000000150009000b000000
+			150008000c000000150007000d000000150006000e000000150005000f000000
+			1500040010000000150003001100000015000200120000001500010013000000
+			1500000114000000060000000000ff7f1500ff00150000001500fe0016000000
+			1500fd00170000001500fc00180000001500fb00190000001500fa001c000000
+			1500f900200000001500f800210000001500f700220000001500f60023000000
+			1500f500250000001500f400270000001500f3002a0000001500f2002b000000
+			1500f1002c0000001500f0002d0000001500ef002e0000001500ee002f000000
+			1500ed00300000001500ec00310000001500eb00320000001500ea0033000000
+			1500e900340000001500e800350000001500e700360000001500e60037000000
+			1500e500390000001500e4003a0000001500e3003b0000001500e2003c000000
+			1500e1003d0000001500e0003e0000001500df003f0000001500de0048000000
+			1500dd00490000001500dc004a0000001500db004b0000001500da004d000000
+			1500d9004e0000001500d8004f0000001500d700500000001500d60051000000
+			1500d500520000001500d400530000001500d300540000001500d20055000000
+			1500d100560000001500d000570000001500cf00580000001500ce0059000000
+			1500cd005a0000001500cc005b0000001500cb005c0000001500ca005d000000
+			1500c9005e0000001500c8005f0000001500c700600000001500c60061000000
+			1500c500620000001500c400660000001500c300680000001500c20069000000
+			1500c1006a0000001500c0006b0000001500bf006c0000001500be006e000000
+			1500bd006f0000001500bc00700000001500bb00730000001500ba0076000000
+			1500b900780000001500b800790000001500b7007c0000001500b6007d000000
+			1500b5007e0000001500b400830000001500a0008600000015009f0088000000
+			1500b100890000001500b0008a00000015009c008b0000001500ae008c000000
+			1500ad009a0000001500ac009d0000001500ab009e0000001500aa009f000000
+			15009600a700000015009500a800000015009400b40000001500a600ba000000
+			1500a500bf0000001500a400c90000001500a300ca0000001500a200cc000000
+			1500a100d50000001500a000d900000015009f00da00000015009e00dd000000
+			15009d00e400000015009c00e500000015009b00e600000015009a00e7000000
+			15009900e800000015009800e900000015009700ea00000015008300f6000000
+			15009500f700000015009400fe00000015008000000100001500920001010000
+			1500910002010000150090000401000015008f000601000015008e0007010000
+			15008d000801000015008c000901000015008b000b01000015008a000c010000
+			150089000d010000150088000e010000150087000f0100001500860011010000
+			1500720016010000150071001701000015008300180100001500820019010000
+			150081001c010000150080001d01000015007f002001000015007e0022010000
+			15007d002301000015007c002401000015007b002501000015007a0026010000
+			150079002e010000150078003e010000150077003f0100001500630040010000
+			150075004201000015006100430100001500730046010000150072004c010000
+			1500710000000040150070000100004015006f000200004015006e0003000040
+			15006d000400004015006c000500004015006b000600004015006a0007000040
+			15006900080000401500680009000040150067000a000040150066000b000040
+			150065000c000040150064000e00004015006300110000401500620012000040
+			1500610015000040150060001600004015005f001700004015005e0018000040
+			15005d001900004015005c001c00004015005b002000004015005a0021000040
+			1500590022000040150058002300004015005700250000401500560027000040
+			150055002a000040150054002b000040150053002c0000401500520030000040
+			1500510031000040150050003200004015004f003300004015004e0034000040
+			15004d003500004015004c003900004015004b003a00004015004a003c000040
+			150049003d000040150048003e000040150047003f0000401500460048000040
+			1500450049000040150044004a000040150043004b000040150042004d000040
+			150041004e000040150040004f00004015003f005000004015003e0051000040
+			15003d005200004015003c005300004015003b005400004015003a0055000040
+			1500390056000040150038005700004015003700580000401500360059000040
+			150035005a000040150034005b000040150033005c000040150032005d000040
+			150031005e000040150030005f00004015002f006000004015002e0061000040
+			15002d006200004015002c006600004015002b006800004015002a0069000040
+			150029006a000040150028006b000040150027006c000040150026006e000040
+			150025006f000040150024007000004015002300730000401500220076000040
+			1500210078000040150020007900004015001f007c00004015001e007d000040
+			15001d007e000040150009008800004015001b008900004015001a008a000040
+			150006008b000040150018008c000040150017009a000040150016009d000040
+			150015009e000040150014009f00004015000001a70000400600000001000500
+			1500ff00a800004015001000ba00004015000f00bf00004015000e00c9000040
+			15000d00ca00004015000c00cc00004015000b00d500004015000a00d9000040
+			15000900da00004015000800dd00004015000700e400004015000600e5000040
+			15000500e600004015000400e700004015000300e800004015000200e9000040
+			15000100ea00004015000001fe000040060000000000ff7f1500ec0000010040
+			1500fe00010100401500fd00020100401500fc00040100401500fb0006010040
+			1500fa00070100401500f900080100401500f800090100401500f7000b010040
+			1500f6000c0100401500f5000d0100401500f4000e0100401500f3000f010040
+			1500f200180100401500f100190100401500f0001c0100401500ef001d010040
+			1500ee00200100401500ed00220100401500ec00230100401500eb0024010040
+			1500ea00250100401500e900260100401500e8002e0100401500e7003e010040
+			1500e6003f0100401500d200400100401500d100430100401500e30046010040
+			1500e2004c0100401500e100000200401500e000010200401500df0002020040
+			1500de00030200401500dd00040200401500dc00050200401500db0006020040
+			1500da00070200401500d900080200401500d8000d0200401500c40010020040
+			1500d600110200401500d500120200401500c100140200401500c00015020040
+			1500d2001d0200401500d1001e0200401500d000210200401500c50029000040
+			1500c00038000040150000022900000020000000140000001500c1cb00000000
+			1500000338000000200000001400000054000000000000001500b9c600000000
+			1500bf0087000040150000c48700000020000000140000001500bcc200000000
+			150000c30300004020000000000000001500c000010000001500bf0002000000
+			1500be00030000001500bd00040000001500bc00050000001500bb0006000000
+			1500ba00080000001500b900090000001500b8000a0000001500b7000b000000
+			1500b6000c0000001500b5000d0000001500b4000f0000001500b30010000000
+			15009f00120000001500b100130000001500b000140000001500af0017000000
+			1500ae00180000001500ad001b000000150099001c0000001500ab001d000000
+			1500aa002100000015009600220000001500a800250000001500a70026000000
+			1500a600270000001500a500280000001500a400290000001500a3002a000000
+			1500a2002d0000001500a1002e0000001500a0002f00000015009f0031000000
+			15009e003200000015009d003600000015009c0037000000150088003b000000
+			15009a003c000000150086003e000000150098003f0000001500970040000000
+			1500960041000000150095004200000015008100440000001500800045000000
+			150092004c000000150091004d000000150090004e00000015008f0050000000
+			15008e005200000015008d0053000000150079005400000015008b0055000000
+			15007700560000001500760057000000150088005a000000150087005b000000
+			150086005d000000150085005e000000150084005f0000001500830060000000
+			15008200630000001500810064000000150080006600000015007f006a000000
+			15007e006b00000015007d006c000000150069006d0000001500680071000000
+			15007a007200000015006600730000001500780076000000150077007a000000
+			150076007b000000150075007c000000150074007d0000001500730084000000
+			150072008500000015005e008600000015005d008700000015006f008d000000
+			15006e008f00000015006d009100000015006c009200000015006b0093000000
+			15006a0094000000150069009e00000015006800a200000015006700a3000000
+			15006600a500000015005200a600000015006400a800000015005000a9000000
+			15006200ab00000015006100ac00000015006000ad00000015005f00ae000000
+			15005e00af00000015005d00b400000015005c00b500000015005b00b6000000
+			15005a00b700000015005900b800000015005800b900000015005700ba000000
+			15005600be00000015005500c000000015005400c300000015005300c5000000
+			15005200d400000015005100db00000015005000dc00000015004f00dd000000
+			15004e00e000000015004d00e500000015004c00f000000015004b00f2000000
+			15004a00fa00000015004900fc00000015004800fe00000015004700ff000000
+			150046000001000015004500020100001500440009010000150043000a010000
+			150042000b010000150041000e01000015002d001b01000015003f001c010000
+			15003e002401000015002a002601000015003c002701000015003b0028010000
+			15003a002a010000150039002d010000150038002e010000150037002f010000
+			1500360031010000150035003201000015003400330100001500330034010000
+			1500320035010000150031003701000015001d003c01000015001c003d010000
+			15002e003f01000015002d004001000015002c004301000015002b0044010000
+			15002a00480100001500290049010000150028004a010000150027004b010000
+			150026004c010000150025005401000015002400630100001500230064010000
+			15002200660100001500210068010000150020006901000015001f006a010000
+			15001e006b01000015001d006c01000015001c006d01000015001b006e010000
+			15001a006f010000150019007001000015001800710100001500170072010000
+			1500160073010000150015007401000015001400750100001500000176010000
+			06000000010005001500110079010000150010007f01000015000f0080010000
+			15000003780000002000000010000000540000000000027e15000b0a00000000
+			1500000267010000200000001000000015000708280000001500000688000000
+			200000001000000015000500ffffffff15000400080002001500030000000200
+			150002000800000015000100000000000600000026000500060000000000ff7f
+			0600000000000000`,
+		cases: []seccompProfileCase{
+			{linux.AUDIT_ARCH_X86_64, 0, 0, 0x7fff0000},              // read
+			{linux.AUDIT_ARCH_X86_64, 0x1, 0x1, 0x7fff0000},          // write
+			{linux.AUDIT_ARCH_X86_64, 0x27, 0, 0x7fff0000},           // getpid
+			{linux.AUDIT_ARCH_X86_64, 0x65, 0, 0x50026},              // ptrace
+			{linux.AUDIT_ARCH_X86_64, 0xa9, 0, 0x50026},              // reboot
+			{linux.AUDIT_ARCH_X86_64, 0xa5, 0, 0x50026},              // mount
+			{linux.AUDIT_ARCH_X86_64, 0xf6, 0, 0x50001},              // kexec_load
+			{linux.AUDIT_ARCH_X86_64, 0x2, 0, 0x7fff0000},            // open
+			{linux.AUDIT_ARCH_X86_64, 0x101, 0, 0x7fff0000},          // openat
+			{linux.AUDIT_ARCH_X86_64, 0x87, 0, 0x7fff0000},           // personality
+			{linux.AUDIT_ARCH_X86_64, 0x87, 0x8, 0x7fff0000},         // personality
+			{linux.AUDIT_ARCH_X86_64, 0x87, 0xffffffff, 0x7fff0000},  // personality
+			{linux.AUDIT_ARCH_X86_64, 0x87, 0x100000008, 0x50026},    // personality
+			{linux.AUDIT_ARCH_X86_64, 0x87, 0x9, 0x50026},            // personality
+			{linux.AUDIT_ARCH_X86_64, 0x38, 0x11, 0x7fff0000},        // clone
+			{linux.AUDIT_ARCH_X86_64, 0x38, 0x20000, 0x50026},        // clone
+			{linux.AUDIT_ARCH_X86_64, 0x38, 0x10000000, 0x50026},     // clone
+			{linux.AUDIT_ARCH_X86_64, 0x38, 0x100000011, 0x7fff0000}, // clone
+			{linux.AUDIT_ARCH_X86_64, 0x29, 0x2, 0x7fff0000},         // socket
+			{linux.AUDIT_ARCH_X86_64, 0x29, 0x28, 0x50026},           // socket
+			{linux.AUDIT_ARCH_X86_64, 0x29, 0x100000028, 0x7fff0000}, // socket
+			{linux.AUDIT_ARCH_X86_64, 0x1b3, 0, 0x50026},             // clone3
+			{linux.AUDIT_ARCH_X86_64, 0x143, 0, 0x50001},             // userfaultfd
+			{linux.AUDIT_ARCH_X86_64, 0x116, 0, 0x50001},             // vmsplice
+			{linux.AUDIT_ARCH_X86_64, 0x3e8, 0, 0x50026},             // unknown
+			{linux.AUDIT_ARCH_X86_64, 0x40000000, 0, 0x7fff0000},     // x32 read
+			{linux.AUDIT_ARCH_X86_64, 0x40000027, 0, 0x7fff0000},     // x32 getpid
+			{linux.AUDIT_ARCH_X86_64, 0x400000a9, 0, 0x50026},        // x32 reboot
+			{linux.AUDIT_ARCH_I386, 0x3, 0, 0x7fff0000},              // read
+			{linux.AUDIT_ARCH_I386, 0x14, 0, 0x7fff0000},             // getpid
+			{linux.AUDIT_ARCH_I386, 0x1a, 0, 0x50026},                // ptrace
+			{linux.AUDIT_ARCH_I386, 0x58, 0, 0x50026},                // reboot
+			{linux.AUDIT_ARCH_I386, 0x66, 0x1, 0x7fff0000},           // socketcall
+			{linux.AUDIT_ARCH_I386, 0x88, 0, 0x7fff0000},             // personality
+			{linux.AUDIT_ARCH_I386, 0x88, 0xffffffff, 0x7fff0000},    // personality
+			{linux.AUDIT_ARCH_I386, 0x88, 0x9, 0x50026},              // personality
+			{linux.AUDIT_ARCH_I386, 0x78, 0x11, 0x7fff0000},          // clone
+			{linux.AUDIT_ARCH_I386, 0x78, 0x20000, 0x50026},          // clone
+			{linux.AUDIT_ARCH_I386, 0x167, 0x2, 0x7fff0000},          // socket
+			{linux.AUDIT_ARCH_I386, 0x167, 0x28, 0x50026},            // socket
+			{linux.AUDIT_ARCH_I386, 0x71, 0, 0x50001},                // vm86old
+			{linux.AUDIT_ARCH_I386, 0x3e8, 0, 0x50026},               // unknown
+		},
+	},
+	{
+		name: "restricted",
+		program: `
+			20000000040000001500000e3e0000c020000000000000003500000100000040
+			1500000bffffffff150007000000000015000600010000001500070002000000
+			150004000f000000150003002700000015000001650000000600000000000300
+			15000001e7000000060000000000ff7f1500000101010000060000000d000500
+			0600000000000080`,
+		cases: []seccompProfileCase{
+			{linux.AUDIT_ARCH_X86_64, 0, 0, 0x7fff0000},              // read
+			{linux.AUDIT_ARCH_X86_64, 0x1, 0x1, 0x7fff0000},          // write
+			{linux.AUDIT_ARCH_X86_64, 0x27, 0, 0x7fff0000},           // getpid
+			{linux.AUDIT_ARCH_X86_64, 0x65, 0, 0x30000},              // ptrace
+			{linux.AUDIT_ARCH_X86_64, 0xa9, 0, 0x80000000},           // reboot
+			{linux.AUDIT_ARCH_X86_64, 0xa5, 0, 0x80000000},           // mount
+			{linux.AUDIT_ARCH_X86_64, 0xf6, 0, 0x80000000},           // kexec_load
+			{linux.AUDIT_ARCH_X86_64, 0x2, 0, 0x5000d},               // open
+			{linux.AUDIT_ARCH_X86_64, 0x101, 0, 0x5000d},             // openat
+			{linux.AUDIT_ARCH_X86_64, 0x87, 0, 0x80000000},           // personality
+			{linux.AUDIT_ARCH_X86_64, 0x87, 0x8, 0x80000000},         // personality
+			{linux.AUDIT_ARCH_X86_64, 0x87, 0xffffffff, 0x80000000},  // personality
+			{linux.AUDIT_ARCH_X86_64, 0x87, 0x100000008, 0x80000000}, // personality
+			{linux.AUDIT_ARCH_X86_64, 0x87, 0x9, 0x80000000},         // personality
+			{linux.AUDIT_ARCH_X86_64, 0x38, 0x11, 0x80000000},        // clone
+			{linux.AUDIT_ARCH_X86_64, 0x38, 0x20000, 0x80000000},     // clone
+			{linux.AUDIT_ARCH_X86_64, 0x38, 0x10000000, 0x80000000},  // clone
+			{linux.AUDIT_ARCH_X86_64, 0x38, 0x100000011, 0x80000000}, // clone
+			{linux.AUDIT_ARCH_X86_64, 0x29, 0x2, 0x80000000},         // socket
+			{linux.AUDIT_ARCH_X86_64, 0x29, 0x28, 0x80000000},        // socket
+			{linux.AUDIT_ARCH_X86_64, 0x29, 0x100000028, 0x80000000}, // socket
+			{linux.AUDIT_ARCH_X86_64, 0x1b3, 0, 0x80000000},          // clone3
+			{linux.AUDIT_ARCH_X86_64, 0x143, 0, 0x80000000},          // userfaultfd
+			{linux.AUDIT_ARCH_X86_64, 0x116, 0, 0x80000000},          // vmsplice
+			{linux.AUDIT_ARCH_X86_64, 0x3e8, 0, 0x80000000},          // unknown
+			{linux.AUDIT_ARCH_X86_64, 0x40000000, 0, 0x80000000},     // x32 read
+			{linux.AUDIT_ARCH_X86_64, 0x40000027, 0, 0x80000000},     // x32 getpid
+			{linux.AUDIT_ARCH_X86_64, 0x400000a9, 0, 0x80000000},     // x32 reboot
+			{linux.AUDIT_ARCH_I386, 0x3, 0, 0x80000000},              // read
+			{linux.AUDIT_ARCH_I386, 0x14, 0, 0x80000000},             // getpid
+			{linux.AUDIT_ARCH_I386, 0x1a, 0, 0x80000000},             // ptrace
+			{linux.AUDIT_ARCH_I386, 0x58, 0, 0x80000000},             // reboot
+			{linux.AUDIT_ARCH_I386, 0x66, 0x1, 0x80000000},           // socketcall
+			{linux.AUDIT_ARCH_I386, 0x88, 0, 0x80000000},             // personality
+			{linux.AUDIT_ARCH_I386, 0x88, 0xffffffff, 0x80000000},    // personality
+			{linux.AUDIT_ARCH_I386, 0x88, 0x9, 0x80000000},           // personality
+			{linux.AUDIT_ARCH_I386, 0x78, 0x11, 0x80000000},          // clone
+			{linux.AUDIT_ARCH_I386, 0x78, 0x20000, 0x80000000},       // clone
+			{linux.AUDIT_ARCH_I386, 0x167, 0x2, 0x80000000},          // socket
+			{linux.AUDIT_ARCH_I386, 0x167, 0x28, 0x80000000},         // socket
+			{linux.AUDIT_ARCH_I386, 0x71, 0, 0x80000000},             // vm86old
+			{linux.AUDIT_ARCH_I386, 0x3e8, 0, 0x80000000},            // unknown
+		},
+	},
+}
+
+// seccompProfileProgram decodes and compiles the program of the profile
+// called name in seccompProfiles.
+func seccompProfileProgram(t testing.TB, name, program string) bpf.Program {
+	t.Helper()
+	buf, err := hex.DecodeString(strings.Join(strings.Fields(program), ""))
+	if err != nil {
+		t.Fatalf("%s: invalid program: %v", name, err)
+	}
+	insns := make([]linux.BPFInstruction, len(buf)/bpfInstructionSize)
+	binary.Unmarshal(buf, binary.LittleEndian, insns)
+	p, err := bpf.Compile(insns)
+	if err != nil {
+		t.Fatalf("%s: bpf.Compile failed: %v", name, err)
+	}
+	return p
+}
+
+// TestSeccompProfiles checks that evaluateSyscallFilters returns the same
+// result as Linux for real seccomp profiles, with and without
+// CacheSeccompResults.
+func TestSeccompProfiles(t *testing.T) {
+	defer atomic.StoreUint32(&CacheSeccompResults, atomic.LoadUint32(&CacheSeccompResults))
+	for _, cache := range []uint32{0, 1} {
+		atomic.StoreUint32(&CacheSeccompResults, cache)
+		for _, profile := range seccompProfiles {
+			task := newSeccompTestTask()
+			if err := task.AppendSyscallFilter(seccompProfileProgram(t, profile.name, profile.program), 0); err != nil {
+				t.Fatalf("%s: AppendSyscallFilter failed: %v", profile.name, err)
+			}
+			// Evaluate each case twice, so that cached results are
+			// checked.
+			for i := 0; i < 2; i++ {
+				for _, c := range profile.cases {
+					data := seccompData{nr: c.nr, arch: c.arch, args: [6]uint64{c.arg0}}
+					if got, _ := task.evaluateSyscallFilters(&data); got != c.want {
+						t.Errorf("%s: cache=%d: evaluateSyscallFilters(arch=%#x, nr=%#x, arg0=%#x): got %#x, want %#x", profile.name, cache, c.arch, c.nr, c.arg0, got, c.want)
+					}
+				}
+			}
+		}
+	}
+}
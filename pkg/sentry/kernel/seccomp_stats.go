@@ -0,0 +1,105 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kernel
+
+import (
+	"sort"
+	"sync/atomic"
+)
+
+// CountSeccompEvaluations is a flag used to enable or disable counting, for
+// each system call number, the number of system calls that are evaluated by
+// application seccomp-bpf filters. The counts are returned by
+// SeccompEvaluationCounts. Valid values are 0 or 1.
+//
+// CountSeccompEvaluations must be accessed atomically.
+var CountSeccompEvaluations uint32
+
+// seccompEvaluations counts the system calls evaluated by seccomp-bpf filters
+// while CountSeccompEvaluations is set, indexed by system call number. System
+// calls with the same number but different architectures share a bucket, and
+// system calls with numbers outside of the supported range are not counted.
+//
+// Elements of seccompEvaluations must be accessed atomically.
+var seccompEvaluations [maxSyscallNum + 1]uint64
+
+// countSeccompEvaluation records the evaluation of system call nr.
+func countSeccompEvaluation(nr int32) {
+	if nr >= 0 && int(nr) < len(seccompEvaluations) {
+		atomic.AddUint64(&seccompEvaluations[nr], 1)
+	}
+}
+
+// SeccompEvaluationCounts returns the number of times each system call number
+// has been evaluated by application seccomp-bpf filters while
+// CountSeccompEvaluations was set, omitting system calls that were never
+// evaluated. If reset is true, the returned counts are also reset to 0, so
+// that successive calls return the evaluations between them.
+func SeccompEvaluationCounts(reset bool) map[int32]uint64 {
+	counts := make(map[int32]uint64)
+	for nr := range seccompEvaluations {
+		var n uint64
+		if reset {
+			n = atomic.SwapUint64(&seccompEvaluations[nr], 0)
+		} else {
+			n = atomic.LoadUint64(&seccompEvaluations[nr])
+		}
+		if n != 0 {
+			counts[int32(nr)] = n
+		}
+	}
+	return counts
+}
+
+// SeccompTaskStatus describes the seccomp state of a task, as returned by
+// TaskSet.SeccompStatuses.
+type SeccompTaskStatus struct {
+	// TID is the task's thread ID in the root PID namespace.
+	TID ThreadID
+
+	// Mode is the task's seccomp mode, as returned by Task.SeccompMode.
+	Mode int
+
+	// Filters is the number of the task's seccomp filters, as returned by
+	// Task.SeccompFilterCount.
+	Filters int
+}
+
+// SeccompStatuses returns the seccomp state of every task in ts, ordered by
+// TID. Tasks without filters are included, with mode SECCOMP_MODE_NONE, so
+// that tasks which a policy failed to reach can be found.
+//
+// The snapshot is taken with ts.mu locked, as by
+// SyncSyscallFiltersToThreadGroup, so no task is created or reaped and no
+// thread group's filters are synchronized while it is taken. The mode and
+// filter count of each task are consistent with each other.
+func (ts *TaskSet) SeccompStatuses() []SeccompTaskStatus {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	statuses := make([]SeccompTaskStatus, 0, len(ts.Root.tids))
+	for t, tid := range ts.Root.tids {
+		t.tg.seccompMu.Lock()
+		t.seccompMu.Lock()
+		statuses = append(statuses, SeccompTaskStatus{
+			TID:     tid,
+			Mode:    t.seccompModeLocked(),
+			Filters: len(guestSyscallFilters(t.syscallFilterChain())),
+		})
+		t.seccompMu.Unlock()
+		t.tg.seccompMu.Unlock()
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].TID < statuses[j].TID })
+	return statuses
+}
@@ -0,0 +1,4865 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kernel
+
+import (
+	"bytes"
+	encbinary "encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"gvisor.googlesource.com/gvisor/pkg/abi/linux"
+	"gvisor.googlesource.com/gvisor/pkg/binary"
+	"gvisor.googlesource.com/gvisor/pkg/bpf"
+	"gvisor.googlesource.com/gvisor/pkg/cpuid"
+	"gvisor.googlesource.com/gvisor/pkg/eventchannel"
+	"gvisor.googlesource.com/gvisor/pkg/log"
+	"gvisor.googlesource.com/gvisor/pkg/seccomp"
+	"gvisor.googlesource.com/gvisor/pkg/sentry/arch"
+	"gvisor.googlesource.com/gvisor/pkg/sentry/fs"
+	"gvisor.googlesource.com/gvisor/pkg/sentry/fs/filetest"
+	apb "gvisor.googlesource.com/gvisor/pkg/sentry/kernel/audit/audit_go_proto"
+	"gvisor.googlesource.com/gvisor/pkg/sentry/kernel/auth"
+	"gvisor.googlesource.com/gvisor/pkg/sentry/limits"
+	"gvisor.googlesource.com/gvisor/pkg/sentry/platform"
+	"gvisor.googlesource.com/gvisor/pkg/sentry/usermem"
+	"gvisor.googlesource.com/gvisor/pkg/syserror"
+	"gvisor.googlesource.com/gvisor/pkg/waiter"
+)
+
+// newSeccompTestTask returns a minimal Task on which seccomp filters can be
+// evaluated outside of a Kernel.
+func newSeccompTestTask() *Task {
+	t := &Task{}
+	ts := newTaskSet()
+	t.tg = &ThreadGroup{pidns: ts.Root, leader: t, signalHandlers: NewSignalHandlers()}
+	ts.Root.tids[t] = 1
+	t.creds = auth.NewRootCredentials(auth.NewRootUserNamespace())
+	t.tc.Arch = arch.New(arch.AMD64, cpuid.HostFeatureSet())
+	t.tc.st = &SyscallTable{AuditNumber: linux.AUDIT_ARCH_X86_64}
+	t.logPrefix.Store("")
+	t.ptraceTracer.Store((*Task)(nil))
+	return t
+}
+
+func TestSeccompActionAvailable(t *testing.T) {
+	for _, test := range []struct {
+		action uint32
+		want   bool
+	}{
+		{linux.SECCOMP_RET_KILL_PROCESS, true},
+		{linux.SECCOMP_RET_KILL_THREAD, true},
+		{linux.SECCOMP_RET_TRAP, true},
+		{linux.SECCOMP_RET_ERRNO, true},
+		{linux.SECCOMP_RET_USER_NOTIF, true},
+		{linux.SECCOMP_RET_TRACE, true},
+		{linux.SECCOMP_RET_LOG, true},
+		{linux.SECCOMP_RET_ALLOW, true},
+		// Actions with data are not actions.
+		{linux.SECCOMP_RET_ERRNO | 1, false},
+		// Undefined actions.
+		{0x7ff80000, false},
+		{0xffff0000, false},
+	} {
+		if got := SeccompActionAvailable(test.action); got != test.want {
+			t.Errorf("SeccompActionAvailable(%#x) got %v, want %v", test.action, got, test.want)
+		}
+	}
+}
+
+func TestSeccompActionsAvailable(t *testing.T) {
+	want := []SeccompAction{
+		{linux.SECCOMP_RET_KILL_PROCESS, "kill_process"},
+		{linux.SECCOMP_RET_KILL_THREAD, "kill_thread"},
+		{linux.SECCOMP_RET_TRAP, "trap"},
+		{linux.SECCOMP_RET_ERRNO, "errno"},
+		{linux.SECCOMP_RET_USER_NOTIF, "user_notif"},
+		{linux.SECCOMP_RET_TRACE, "trace"},
+		{linux.SECCOMP_RET_LOG, "log"},
+		{linux.SECCOMP_RET_ALLOW, "allow"},
+	}
+	got := SeccompActionsAvailable()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SeccompActionsAvailable got %+v, want %+v", got, want)
+	}
+	for _, a := range got {
+		if !SeccompActionAvailable(a.Action) {
+			t.Errorf("SeccompActionAvailable(%#x) got false for listed action %s", a.Action, a.Name)
+		}
+	}
+}
+
+func TestSeccompErrno(t *testing.T) {
+	for _, test := range []struct {
+		result uint32
+		want   uint32
+	}{
+		{linux.SECCOMP_RET_ERRNO, 0},
+		{linux.SECCOMP_RET_ERRNO | 1, 1},
+		{linux.SECCOMP_RET_ERRNO | linux.MAX_ERRNO, linux.MAX_ERRNO},
+		{linux.SECCOMP_RET_ERRNO | 0x8000, linux.MAX_ERRNO},
+		{linux.SECCOMP_RET_ERRNO | linux.SECCOMP_RET_DATA, linux.MAX_ERRNO},
+	} {
+		if got := seccompErrno(test.result); got != test.want {
+			t.Errorf("seccompErrno(%#x) got %d, want %d", test.result, got, test.want)
+		}
+	}
+}
+
+func TestLogRateLimiter(t *testing.T) {
+	l := newLogRateLimiter(2, 3)
+	now := time.Unix(1000, 0)
+	for _, test := range []struct {
+		desc  string
+		delay time.Duration
+		want  bool
+	}{
+		{desc: "burst 1", want: true},
+		{desc: "burst 2", want: true},
+		{desc: "burst 3", want: true},
+		{desc: "burst exhausted", want: false},
+		{desc: "partial token", delay: 250 * time.Millisecond, want: false},
+		{desc: "refilled token", delay: 250 * time.Millisecond, want: true},
+		{desc: "refilled token used", want: false},
+		{desc: "burst refilled 1", delay: time.Hour, want: true},
+		{desc: "burst refilled 2", want: true},
+		{desc: "burst refilled 3", want: true},
+		{desc: "burst refilled exhausted", want: false},
+	} {
+		now = now.Add(test.delay)
+		if got := l.allowAt(now); got != test.want {
+			t.Errorf("%s: allowAt got %t, want %t", test.desc, got, test.want)
+		}
+	}
+
+	l.setLimit(1, 1)
+	now = now.Add(time.Hour)
+	if !l.allowAt(now) {
+		t.Errorf("allowAt after setLimit got false, want true")
+	}
+	if l.allowAt(now) {
+		t.Errorf("allowAt after setLimit with exhausted burst got true, want false")
+	}
+}
+
+func TestSeccompLogSuppressed(t *testing.T) {
+	defer func(l *logRateLimiter) {
+		seccompLogLimiter = l
+	}(seccompLogLimiter)
+	seccompLogLimiter = newLogRateLimiter(0, 1)
+
+	task := newSeccompTestTask()
+	data := task.seccompData(1, arch.SyscallArguments{}, 0)
+	before := seccompLogSuppressedMetric.Value()
+	for i := 0; i < 3; i++ {
+		task.seccompLog(&data, linux.SECCOMP_RET_LOG)
+	}
+	if got, want := seccompLogSuppressedMetric.Value()-before, uint64(2); got != want {
+		t.Errorf("suppressed seccomp log records got %d, want %d", got, want)
+	}
+}
+
+func TestSeccompLogFields(t *testing.T) {
+	task := newSeccompTestTask()
+	data := seccompData{nr: 1, arch: linux.AUDIT_ARCH_X86_64, instructionPointer: 0x1000}
+	want := "pid=1 tid=1 syscall=sys_1 nr=1 arch=0xc000003e ip=0x1000 action=errno"
+	if got := task.seccompLogFields(&data, linux.SECCOMP_RET_ERRNO|1); got != want {
+		t.Errorf("seccompLogFields got %q, want %q", got, want)
+	}
+}
+
+// seccompLogEmitter is a log.Emitter that records emitted log records.
+type seccompLogEmitter struct {
+	mu      sync.Mutex
+	records []string
+}
+
+// Emit implements log.Emitter.Emit.
+func (e *seccompLogEmitter) Emit(level log.Level, timestamp time.Time, format string, v ...interface{}) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.records = append(e.records, fmt.Sprintf(format, v...))
+}
+
+func TestSeccompLogKillAction(t *testing.T) {
+	defer func(l *logRateLimiter) {
+		seccompLogLimiter = l
+	}(seccompLogLimiter)
+	defer log.SetTarget(log.Log().Emitter)
+	defer log.SetLevel(log.Log().Level)
+	log.SetLevel(log.Debug)
+
+	for _, test := range []struct {
+		result uint32
+		want   string
+	}{
+		{linux.SECCOMP_RET_KILL_THREAD, "action=kill_thread"},
+		{linux.SECCOMP_RET_KILL_PROCESS, "action=kill_process"},
+		// Invalid actions kill the thread group, but are logged as
+		// returned by the filter.
+		{0x00010000, "action=0x10000"},
+	} {
+		seccompLogLimiter = newLogRateLimiter(0, 1)
+		e := &seccompLogEmitter{}
+		log.SetTarget(e)
+		p, err := bpf.Compile([]linux.BPFInstruction{bpf.Stmt(bpf.Ret|bpf.K, test.result)})
+		if err != nil {
+			t.Fatalf("bpf.Compile failed: %v", err)
+		}
+		task := newSeccompTestTask()
+		task.syscallFilters.Store([]*syscallFilter{task.newSyscallFilter(p, 0)})
+		task.checkSeccompSyscall(1, arch.SyscallArguments{}, 0)
+		if len(e.records) != 1 || !strings.Contains(e.records[0], test.want) {
+			t.Errorf("result %#x: got log records %q, want one containing %q", test.result, e.records, test.want)
+		}
+	}
+}
+
+func TestSeccompDataMarshal(t *testing.T) {
+	data := seccompData{
+		nr:                 -1,
+		arch:               linux.AUDIT_ARCH_X86_64,
+		instructionPointer: 0x0123456789abcdef,
+		args:               [6]uint64{1, 2, 3, 4, 5, 0xfedcba9876543210},
+	}
+	if got := binary.Size(data); got != SeccompDataSize {
+		t.Fatalf("binary.Size(seccompData{}) got %d, want %d", got, SeccompDataSize)
+	}
+	for _, order := range []encbinary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		want := binary.Marshal(nil, order, &data)
+		var got [SeccompDataSize]byte
+		data.marshal(got[:], order)
+		if !bytes.Equal(got[:], want) {
+			t.Errorf("marshal(%v) got %v, want %v", order, got, want)
+		}
+	}
+}
+
+func TestSeccompDataRoundTrip(t *testing.T) {
+	want := linux.SeccompData{
+		Nr:                 -1,
+		Arch:               linux.AUDIT_ARCH_X86_64,
+		InstructionPointer: 0x0123456789abcdef,
+		Args:               [6]uint64{1, 2, 3, 4, 5, 0xfedcba9876543210},
+	}
+	for _, order := range []encbinary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		var buf [SeccompDataSize]byte
+		MarshalSeccompData(buf[:], order, &want)
+		var got linux.SeccompData
+		UnmarshalSeccompData(buf[:], order, &got)
+		if got != want {
+			t.Errorf("UnmarshalSeccompData(MarshalSeccompData(%+v), %v) got %+v", want, order, got)
+		}
+	}
+}
+
+func TestSeccompDataLayout(t *testing.T) {
+	data := linux.SeccompData{
+		Nr:                 0x01020304,
+		Arch:               0x05060708,
+		InstructionPointer: 0x1011121314151617,
+		Args: [6]uint64{
+			0x2021222324252627,
+			0x3031323334353637,
+			0x4041424344454647,
+			0x5051525354555657,
+			0x6061626364656667,
+			0x7071727374757677,
+		},
+	}
+	for _, test := range []struct {
+		order encbinary.ByteOrder
+		want  string
+	}{
+		{
+			order: binary.LittleEndian,
+			want: "04030201" + "08070605" + "1716151413121110" +
+				"2726252423222120" + "3736353433323130" + "4746454443424140" +
+				"5756555453525150" + "6766656463626160" + "7776757473727170",
+		},
+		{
+			order: binary.BigEndian,
+			want: "01020304" + "05060708" + "1011121314151617" +
+				"2021222324252627" + "3031323334353637" + "4041424344454647" +
+				"5051525354555657" + "6061626364656667" + "7071727374757677",
+		},
+	} {
+		var buf [SeccompDataSize]byte
+		MarshalSeccompData(buf[:], test.order, &data)
+		if got := hex.EncodeToString(buf[:]); got != test.want {
+			t.Errorf("MarshalSeccompData(%v) got %s, want %s", test.order, got, test.want)
+		}
+
+		notif := linux.SeccompNotif{
+			ID:    0x8081828384858687,
+			Pid:   0x90919293,
+			Flags: 0xa0a1a2a3,
+			Data:  data,
+		}
+		var nbuf [SeccompNotifSize]byte
+		MarshalSeccompNotif(nbuf[:], test.order, &notif)
+		if want := binary.Marshal(nil, test.order, &notif); !bytes.Equal(nbuf[:], want) {
+			t.Errorf("MarshalSeccompNotif(%v) got %x, want %x", test.order, nbuf, want)
+		}
+		if !bytes.Equal(nbuf[16:], buf[:]) {
+			t.Errorf("MarshalSeccompNotif(%v) data got %x, want %x", test.order, nbuf[16:], buf)
+		}
+	}
+	if got := binary.Size(linux.SeccompNotif{}); got != SeccompNotifSize {
+		t.Errorf("binary.Size(linux.SeccompNotif{}) got %d, want %d", got, SeccompNotifSize)
+	}
+}
+
+func TestSeccompDataByteOrder(t *testing.T) {
+	// Return the system call number if it and the architecture match, and the
+	// low half of args[0] otherwise.
+	p, err := bpf.Compile([]linux.BPFInstruction{
+		{OpCode: bpf.Ld | bpf.Abs | bpf.W, K: seccompDataArchOffset},
+		{OpCode: bpf.Jmp | bpf.Jeq | bpf.K, JumpIfTrue: 0, JumpIfFalse: 3, K: linux.AUDIT_ARCH_X86_64},
+		{OpCode: bpf.Ld | bpf.Abs | bpf.W, K: 0},
+		{OpCode: bpf.Jmp | bpf.Jeq | bpf.K, JumpIfTrue: 0, JumpIfFalse: 1, K: 39},
+		{OpCode: bpf.Ret | bpf.A},
+		{OpCode: bpf.Ret | bpf.K, K: linux.SECCOMP_RET_ALLOW},
+	})
+	if err != nil {
+		t.Fatalf("bpf.Compile failed: %v", err)
+	}
+	data := seccompData{
+		nr:   39,
+		arch: linux.AUDIT_ARCH_X86_64,
+		args: [6]uint64{0x0123456789abcdef},
+	}
+	for _, test := range []struct {
+		order encbinary.ByteOrder
+		// argLowOffset is the offset of the low half of args[0].
+		argLowOffset int
+	}{
+		{order: binary.LittleEndian, argLowOffset: 16},
+		{order: binary.BigEndian, argLowOffset: 20},
+	} {
+		var buf [SeccompDataSize]byte
+		data.marshal(buf[:], test.order)
+		if got, want := test.order.Uint32(buf[test.argLowOffset:]), uint32(0x89abcdef); got != want {
+			t.Errorf("%v: low half of args[0] got %#x, want %#x", test.order, got, want)
+		}
+		ret, err := bpf.Exec(p, bpf.InputBytes{Data: buf[:], Order: test.order})
+		if err != nil {
+			t.Errorf("%v: bpf.Exec failed: %v", test.order, err)
+			continue
+		}
+		if ret != uint32(data.nr) {
+			t.Errorf("%v: bpf.Exec got %#x, want %#x", test.order, ret, data.nr)
+		}
+
+		// Filters see the same values when seccompDataByteOrder is changed.
+		func() {
+			defer func(order encbinary.ByteOrder) { seccompDataByteOrder = order }(seccompDataByteOrder)
+			seccompDataByteOrder = test.order
+			var in seccompInput
+			ret, err := bpf.Exec(p, in.load(&data))
+			if err != nil || ret != uint32(data.nr) {
+				t.Errorf("%v: bpf.Exec with seccompInput got (%#x, %v), want (%#x, nil)", test.order, ret, err, data.nr)
+			}
+		}()
+	}
+}
+
+func TestSeccompInputNoAlloc(t *testing.T) {
+	task := newSeccompTestTask()
+	data := seccompData{nr: 1, arch: linux.AUDIT_ARCH_X86_64}
+	allocs := testing.AllocsPerRun(100, func() {
+		task.seccompInput.load(&data)
+	})
+	if allocs != 0 {
+		t.Errorf("seccompInput.load got %v allocations, want 0", allocs)
+	}
+}
+
+func TestSeccompDataCompat(t *testing.T) {
+	// Allow only syscalls using the i386 calling convention.
+	p, err := bpf.Compile([]linux.BPFInstruction{
+		bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 4), // arch
+		bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, linux.AUDIT_ARCH_I386, 0, 1),
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ALLOW),
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ERRNO|1),
+	})
+	if err != nil {
+		t.Fatalf("bpf.Compile failed: %v", err)
+	}
+	args := arch.SyscallArguments{{Value: 0x100000002}}
+	for _, test := range []struct {
+		name      string
+		auditArch uint32
+		wantArg   uint64
+		want      uint32
+	}{
+		{
+			name:      "compat",
+			auditArch: linux.AUDIT_ARCH_I386,
+			wantArg:   2,
+			want:      linux.SECCOMP_RET_ALLOW,
+		},
+		{
+			name:      "native",
+			auditArch: linux.AUDIT_ARCH_X86_64,
+			wantArg:   0x100000002,
+			want:      linux.SECCOMP_RET_ERRNO | 1,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			task := newSeccompTestTask()
+			task.tc.st.AuditNumber = test.auditArch
+			task.noNewPrivs = true
+			if err := task.AppendSyscallFilter(p, 0); err != nil {
+				t.Fatalf("AppendSyscallFilter failed: %v", err)
+			}
+			data := task.seccompData(1, args, 0)
+			if data.arch != test.auditArch {
+				t.Errorf("seccompData arch got %#x, want %#x", data.arch, test.auditArch)
+			}
+			if data.args[0] != test.wantArg {
+				t.Errorf("seccompData args[0] got %#x, want %#x", data.args[0], test.wantArg)
+			}
+			if got, _ := task.evaluateSyscallFilters(&data); got != test.want {
+				t.Errorf("evaluateSyscallFilters got %#x, want %#x", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCompileSeccompFilters(t *testing.T) {
+	p, err := bpf.Compile([]linux.BPFInstruction{
+		bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 0), // nr
+		bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, 1, 2, 0),
+		bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, 2, 1, 0),
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ERRNO|1),
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ALLOW),
+	})
+	if err != nil {
+		t.Fatalf("bpf.Compile failed: %v", err)
+	}
+	defer atomic.StoreUint32(&CompileSeccompFilters, atomic.LoadUint32(&CompileSeccompFilters))
+	for _, compile := range []uint32{0, 1} {
+		atomic.StoreUint32(&CompileSeccompFilters, compile)
+		task := newSeccompTestTask()
+		task.noNewPrivs = true
+		if err := task.AppendSyscallFilter(p, 0); err != nil {
+			t.Fatalf("AppendSyscallFilter failed: %v", err)
+		}
+		f, err := task.seccompFilter(0)
+		if err != nil {
+			t.Fatalf("seccompFilter failed: %v", err)
+		}
+		if got, want := f.executable != nil, compile != 0; got != want {
+			t.Errorf("CompileSeccompFilters=%d: filter compiled got %t, want %t", compile, got, want)
+		}
+		for _, test := range []struct {
+			sysno int32
+			want  uint32
+		}{
+			{sysno: 0, want: linux.SECCOMP_RET_ERRNO | 1},
+			{sysno: 1, want: linux.SECCOMP_RET_ALLOW},
+			{sysno: 2, want: linux.SECCOMP_RET_ALLOW},
+			{sysno: 3, want: linux.SECCOMP_RET_ERRNO | 1},
+		} {
+			data := task.seccompData(test.sysno, arch.SyscallArguments{}, 0)
+			if got, _ := task.evaluateSyscallFilters(&data); got != test.want {
+				t.Errorf("CompileSeccompFilters=%d: evaluateSyscallFilters(%d) got %#x, want %#x", compile, test.sysno, got, test.want)
+			}
+		}
+	}
+}
+
+func TestEvaluateSyscallFilters(t *testing.T) {
+	p, err := bpf.Compile([]linux.BPFInstruction{
+		bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 0), // nr
+		bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, 1, 0, 1),
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ALLOW),
+		bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, 2, 0, 3),
+		bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 16), // args[0], low half
+		bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, 3, 0, 1),
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_TRAP|5),
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ERRNO|uint32(syscall.EPERM)),
+	})
+	if err != nil {
+		t.Fatalf("bpf.Compile failed: %v", err)
+	}
+	task := newSeccompNotifyTestTask(&Kernel{})
+	if got := task.EvaluateSyscallFilters(2, arch.SyscallArguments{}, 0); got != linux.SECCOMP_RET_ALLOW {
+		t.Errorf("EvaluateSyscallFilters without filters got %#x, want %#x", got, linux.SECCOMP_RET_ALLOW)
+	}
+	task.noNewPrivs = true
+	if err := task.AppendSyscallFilter(p, 0); err != nil {
+		t.Fatalf("AppendSyscallFilter failed: %v", err)
+	}
+	const ret = 0x1234
+	task.Arch().SetReturn(ret)
+	for _, test := range []struct {
+		sysno int32
+		arg0  uintptr
+		want  uint32
+	}{
+		{sysno: 1, want: linux.SECCOMP_RET_ALLOW},
+		{sysno: 2, want: linux.SECCOMP_RET_ERRNO | uint32(syscall.EPERM)},
+		{sysno: 2, arg0: 3, want: linux.SECCOMP_RET_TRAP | 5},
+		{sysno: 3, arg0: 3, want: linux.SECCOMP_RET_ERRNO | uint32(syscall.EPERM)},
+	} {
+		var args arch.SyscallArguments
+		args[0].Value = test.arg0
+		if got := task.EvaluateSyscallFilters(test.sysno, args, 0); got != test.want {
+			t.Errorf("EvaluateSyscallFilters(%d, %#x) got %#x, want %#x", test.sysno, test.arg0, got, test.want)
+		}
+	}
+	// The results were not acted on.
+	if info := task.pendingSignals.dequeue(0); info != nil {
+		t.Errorf("signal %d pending after EvaluateSyscallFilters", info.Signo)
+	}
+	if got := task.Arch().Return(); got != ret {
+		t.Errorf("return value got %#x, want unchanged %#x", got, ret)
+	}
+}
+
+func TestEvaluateSyscallFiltersNoSideEffects(t *testing.T) {
+	defer atomic.StoreUint32(&CacheSeccompResults, atomic.LoadUint32(&CacheSeccompResults))
+	atomic.StoreUint32(&CacheSeccompResults, 1)
+	atomic.StoreUint32(&CountSeccompEvaluations, 1)
+	defer atomic.StoreUint32(&CountSeccompEvaluations, 0)
+	SeccompEvaluationCounts(true /* reset */)
+
+	// Return SECCOMP_RET_ERRNO with 12 divided by the system call number as
+	// the errno, so that system call 0 fails the filter's execution.
+	p, err := bpf.Compile([]linux.BPFInstruction{
+		bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 0), // nr
+		bpf.Stmt(bpf.St, 0),
+		bpf.Stmt(bpf.Ldx|bpf.Mem, 0),
+		bpf.Stmt(bpf.Ld|bpf.Imm, 12),
+		bpf.Stmt(bpf.Alu|bpf.Div|bpf.X, 0),
+		bpf.Stmt(bpf.Alu|bpf.Xor|bpf.K, linux.SECCOMP_RET_ERRNO),
+		bpf.Stmt(bpf.Ret|bpf.A, 0),
+	})
+	if err != nil {
+		t.Fatalf("bpf.Compile failed: %v", err)
+	}
+	task := newSeccompTestTask()
+	task.noNewPrivs = true
+	if err := task.AppendSyscallFilter(p, 0); err != nil {
+		t.Fatalf("AppendSyscallFilter failed: %v", err)
+	}
+
+	execErrors := seccompExecErrorMetric.Value()
+	if got, want := task.EvaluateSyscallFilters(4, arch.SyscallArguments{}, 0), uint32(linux.SECCOMP_RET_ERRNO|3); got != want {
+		t.Errorf("EvaluateSyscallFilters(4) got %#x, want %#x", got, want)
+	}
+	if got, want := task.EvaluateSyscallFilters(0, arch.SyscallArguments{}, 0), uint32(linux.SECCOMP_RET_KILL_THREAD); got != want {
+		t.Errorf("EvaluateSyscallFilters(0) got %#x, want %#x", got, want)
+	}
+	if got := seccompExecErrorMetric.Value(); got != execErrors {
+		t.Errorf("seccompExecErrorMetric got %d, want unchanged %d", got, execErrors)
+	}
+	if got := SeccompEvaluationCounts(true /* reset */); len(got) != 0 {
+		t.Errorf("SeccompEvaluationCounts got %v, want none", got)
+	}
+	if task.seccompResults.len != 0 {
+		t.Errorf("cached %d results, want 0", task.seccompResults.len)
+	}
+}
+
+func TestSeccompPolicy(t *testing.T) {
+	p, err := bpf.Compile([]linux.BPFInstruction{
+		bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 0), // nr
+		bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, 2, 0, 3),
+		bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 16), // args[0], low half
+		bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, 3, 0, 1),
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_TRAP|5),
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ALLOW),
+	})
+	if err != nil {
+		t.Fatalf("bpf.Compile failed: %v", err)
+	}
+	denyAll, err := bpf.Compile([]linux.BPFInstruction{
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ERRNO|uint32(syscall.EPERM)),
+	})
+	if err != nil {
+		t.Fatalf("bpf.Compile failed: %v", err)
+	}
+
+	task := newSeccompNotifyTestTask(&Kernel{})
+	task.noNewPrivs = true
+	empty := task.SeccompPolicy()
+	if err := task.AppendSyscallFilter(p, 0); err != nil {
+		t.Fatalf("AppendSyscallFilter failed: %v", err)
+	}
+	policy := task.SeccompPolicy()
+
+	// The policy agrees with EvaluateSyscallFilters over a grid of inputs.
+	auditArch := task.SyscallTable().AuditNumber
+	for sysno := int32(0); sysno < 4; sysno++ {
+		for arg0 := uintptr(0); arg0 < 4; arg0++ {
+			var args arch.SyscallArguments
+			args[0].Value = arg0
+			want := task.EvaluateSyscallFilters(sysno, args, 0)
+			data := linux.SeccompData{Nr: sysno, Arch: auditArch}
+			data.Args[0] = uint64(arg0)
+			if got := policy(data); got != want {
+				t.Errorf("policy(%d, %#x) got %#x, want %#x", sysno, arg0, got, want)
+			}
+			if got := empty(data); got != linux.SECCOMP_RET_ALLOW {
+				t.Errorf("policy without filters (%d, %#x) got %#x, want %#x", sysno, arg0, got, linux.SECCOMP_RET_ALLOW)
+			}
+		}
+	}
+
+	// Filters installed later don't change the snapshot.
+	if err := task.AppendSyscallFilter(denyAll, 0); err != nil {
+		t.Fatalf("AppendSyscallFilter failed: %v", err)
+	}
+	data := linux.SeccompData{Nr: 1, Arch: auditArch}
+	if got := policy(data); got != linux.SECCOMP_RET_ALLOW {
+		t.Errorf("snapshot policy after appending filter got %#x, want %#x", got, linux.SECCOMP_RET_ALLOW)
+	}
+	if got, want := task.SeccompPolicy()(data), uint32(linux.SECCOMP_RET_ERRNO|uint32(syscall.EPERM)); got != want {
+		t.Errorf("new policy got %#x, want %#x", got, want)
+	}
+
+	// The policy may be called concurrently.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data := linux.SeccompData{Nr: 2, Arch: auditArch}
+			data.Args[0] = 3
+			if got := policy(data); got != linux.SECCOMP_RET_TRAP|5 {
+				t.Errorf("concurrent policy got %#x, want %#x", got, linux.SECCOMP_RET_TRAP|5)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestSeccompPolicyStrict(t *testing.T) {
+	task := newSeccompTestTask()
+	task.tc.st.SeccompStrict = []uintptr{0, 1, 15, 60}
+	if err := task.SetSeccompStrict(); err != nil {
+		t.Fatalf("SetSeccompStrict failed: %v", err)
+	}
+	policy := task.SeccompPolicy()
+	if got := policy(linux.SeccompData{Nr: 1}); got != linux.SECCOMP_RET_ALLOW {
+		t.Errorf("policy(write) got %#x, want %#x", got, linux.SECCOMP_RET_ALLOW)
+	}
+	if got := policy(linux.SeccompData{Nr: 2}); got != linux.SECCOMP_RET_KILL_THREAD {
+		t.Errorf("policy(open) got %#x, want %#x", got, linux.SECCOMP_RET_KILL_THREAD)
+	}
+}
+
+func TestSeccompBaselineFilter(t *testing.T) {
+	// The baseline filter denies write(2).
+	baseline, err := bpf.Compile([]linux.BPFInstruction{
+		bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 0), // nr
+		bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, 1, 0, 1),
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ERRNO|uint32(syscall.EPERM)),
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ALLOW),
+	})
+	if err != nil {
+		t.Fatalf("bpf.Compile failed: %v", err)
+	}
+	allowAll, err := bpf.Compile([]linux.BPFInstruction{
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ALLOW),
+	})
+	if err != nil {
+		t.Fatalf("bpf.Compile failed: %v", err)
+	}
+	const deny = linux.SECCOMP_RET_ERRNO | uint32(syscall.EPERM)
+
+	t.Run("guest allow", func(t *testing.T) {
+		task := newSeccompNotifyTestTask(&Kernel{})
+		if err := task.InstallBaselineSyscallFilter(baseline); err != nil {
+			t.Fatalf("InstallBaselineSyscallFilter failed: %v", err)
+		}
+		// The baseline filter is hidden from the application.
+		if got := task.SeccompMode(); got != linux.SECCOMP_MODE_NONE {
+			t.Errorf("SeccompMode with baseline filter got %d, want %d", got, linux.SECCOMP_MODE_NONE)
+		}
+		if got := task.SeccompFilterCount(); got != 0 {
+			t.Errorf("SeccompFilterCount with baseline filter got %d, want 0", got)
+		}
+		if _, err := task.seccompFilter(0); err != syserror.EINVAL {
+			t.Errorf("seccompFilter(0) with baseline filter got error %v, want %v", err, syserror.EINVAL)
+		}
+
+		// A guest filter allowing every system call doesn't override the
+		// baseline filter's denial.
+		task.noNewPrivs = true
+		if err := task.AppendSyscallFilter(allowAll, 0); err != nil {
+			t.Fatalf("AppendSyscallFilter failed: %v", err)
+		}
+		if got := task.SeccompFilterCount(); got != 1 {
+			t.Errorf("SeccompFilterCount got %d, want 1", got)
+		}
+		if got := task.GetSeccompFilters(); len(got) != 1 || !reflect.DeepEqual(got[0].Program, allowAll) {
+			t.Errorf("GetSeccompFilters got %+v, want only the guest filter", got)
+		}
+		for _, test := range []struct {
+			sysno int32
+			want  uint32
+		}{
+			{sysno: 0, want: linux.SECCOMP_RET_ALLOW},
+			{sysno: 1, want: deny},
+		} {
+			if got := task.EvaluateSyscallFilters(test.sysno, arch.SyscallArguments{}, 0); got != test.want {
+				t.Errorf("EvaluateSyscallFilters(%d) got %#x, want %#x", test.sysno, got, test.want)
+			}
+		}
+
+		// Baseline filters can't follow guest filters.
+		if err := task.InstallBaselineSyscallFilter(baseline); err != syserror.EBUSY {
+			t.Errorf("InstallBaselineSyscallFilter after guest filter got error %v, want %v", err, syserror.EBUSY)
+		}
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		task := newSeccompNotifyTestTask(&Kernel{})
+		task.tc.st.SeccompStrict = []uintptr{0, 1, 15, 60}
+		if err := task.InstallBaselineSyscallFilter(baseline); err != nil {
+			t.Fatalf("InstallBaselineSyscallFilter failed: %v", err)
+		}
+		if err := task.SetSeccompStrict(); err != nil {
+			t.Fatalf("SetSeccompStrict with baseline filter failed: %v", err)
+		}
+		// write(2) is allowed by strict mode, but not by the baseline
+		// filter.
+		if r := task.checkSeccompSyscall(1, arch.SyscallArguments{}, 0); r != seccompResultDeny {
+			t.Errorf("checkSeccompSyscall(write) got %v, want %v", r, seccompResultDeny)
+		}
+		if got, want := int64(task.Arch().Return()), -int64(syscall.EPERM); got != want {
+			t.Errorf("write return value got %d, want %d", got, want)
+		}
+		if r := task.checkSeccompSyscall(0, arch.SyscallArguments{}, 0); r != seccompResultAllow {
+			t.Errorf("checkSeccompSyscall(read) got %v, want %v", r, seccompResultAllow)
+		}
+	})
+}
+
+func TestSeccompArgHalves(t *testing.T) {
+	// As generated by libseccomp for 64-bit architectures, compare each
+	// argument to want one 32-bit half at a time, high half first,
+	// returning ERRNO|1 if the high half differs and ERRNO|2 if the low half
+	// does.
+	const want = 0x00000001fffffffe
+	lowOffset, highOffset := uint32(0), uint32(4)
+	if seccompDataByteOrder.Uint16([]byte{0, 1}) == 1 {
+		lowOffset, highOffset = 4, 0
+	}
+	defer atomic.StoreUint32(&CompileSeccompFilters, atomic.LoadUint32(&CompileSeccompFilters))
+	for arg := uint32(0); arg < 6; arg++ {
+		p, err := bpf.Compile([]linux.BPFInstruction{
+			bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 16+8*arg+highOffset),
+			bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, want>>32, 0, 4),
+			bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 16+8*arg+lowOffset),
+			bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, want&0xffffffff, 0, 1),
+			bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ALLOW),
+			bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ERRNO|2),
+			bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ERRNO|1),
+		})
+		if err != nil {
+			t.Fatalf("bpf.Compile failed: %v", err)
+		}
+		for _, compile := range []uint32{0, 1} {
+			atomic.StoreUint32(&CompileSeccompFilters, compile)
+			task := newSeccompTestTask()
+			task.noNewPrivs = true
+			if err := task.AppendSyscallFilter(p, 0); err != nil {
+				t.Fatalf("AppendSyscallFilter failed: %v", err)
+			}
+			for _, test := range []struct {
+				desc  string
+				value uint64
+				want  uint32
+			}{
+				{desc: "equal", value: want, want: linux.SECCOMP_RET_ALLOW},
+				{desc: "high half differs", value: 0x00000002fffffffe, want: linux.SECCOMP_RET_ERRNO | 1},
+				{desc: "low half differs", value: 0x00000001fffffffd, want: linux.SECCOMP_RET_ERRNO | 2},
+				{desc: "halves swapped", value: 0xfffffffe00000001, want: linux.SECCOMP_RET_ERRNO | 1},
+				{desc: "sign extended", value: 0xfffffffffffffffe, want: linux.SECCOMP_RET_ERRNO | 1},
+			} {
+				var args arch.SyscallArguments
+				args[arg].Value = uintptr(test.value)
+				data := task.seccompData(0, args, 0)
+				// Evaluate twice, so that a cached result is also checked.
+				for i := 0; i < 2; i++ {
+					if got, _ := task.evaluateSyscallFilters(&data); got != test.want {
+						t.Errorf("args[%d] %s (%#x), CompileSeccompFilters=%d: evaluateSyscallFilters got %#x, want %#x", arg, test.desc, test.value, compile, got, test.want)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestSeccompCompiledShared(t *testing.T) {
+	defer atomic.StoreUint32(&CompileSeccompFilters, atomic.LoadUint32(&CompileSeccompFilters))
+	atomic.StoreUint32(&CompileSeccompFilters, 1)
+	insns := []linux.BPFInstruction{
+		bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 0),
+		bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, 1, 0, 1),
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ERRNO|1),
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ALLOW),
+	}
+	install := func(insns []linux.BPFInstruction) *syscallFilter {
+		p, err := bpf.Compile(insns)
+		if err != nil {
+			t.Fatalf("bpf.Compile failed: %v", err)
+		}
+		task := newSeccompTestTask()
+		task.noNewPrivs = true
+		if err := task.AppendSyscallFilter(p, 0); err != nil {
+			t.Fatalf("AppendSyscallFilter failed: %v", err)
+		}
+		f, err := task.seccompFilter(0)
+		if err != nil {
+			t.Fatalf("seccompFilter failed: %v", err)
+		}
+		return f
+	}
+
+	// Two tasks installing identical, separately compiled programs share
+	// the compiled artifact.
+	first := install(insns)
+	hits := seccompCompiledHitMetric.Value()
+	second := install(append([]linux.BPFInstruction(nil), insns...))
+	if first.executable == nil || second.executable != first.executable {
+		t.Errorf("identical filters got executables %p and %p, want the same non-nil executable", first.executable, second.executable)
+	}
+	if got := seccompCompiledHitMetric.Value() - hits; got != 1 {
+		t.Errorf("compiled cache hits got %d, want 1", got)
+	}
+
+	// A program differing in any field of any instruction is compiled
+	// separately.
+	for i, mutate := range []func(*linux.BPFInstruction){
+		func(i *linux.BPFInstruction) { i.OpCode = bpf.Jmp | bpf.Jgt | bpf.K },
+		func(i *linux.BPFInstruction) { i.JumpIfTrue = 1 },
+		func(i *linux.BPFInstruction) { i.JumpIfFalse = 0 },
+		func(i *linux.BPFInstruction) { i.K = 2 },
+	} {
+		other := append([]linux.BPFInstruction(nil), insns...)
+		mutate(&other[1])
+		if seccompProgramKeyOf(other) == seccompProgramKeyOf(insns) {
+			t.Errorf("mutation %d: program key unchanged", i)
+		}
+		if f := install(other); f.executable == first.executable {
+			t.Errorf("mutation %d: different filters share executable %p", i, f.executable)
+		}
+	}
+}
+
+func TestSeccompCompiledCacheBounded(t *testing.T) {
+	c := newSeccompCompiledCache(2)
+	p1, p2, p3 := seccompTestProgram(t, 1), seccompTestProgram(t, 2), seccompTestProgram(t, 3)
+	c1 := c.get(p1, false)
+	c2 := c.get(p2, false)
+	if got := c.get(p1, false); got != c1 {
+		t.Errorf("get(p1) got %p, want cached %p", got, c1)
+	}
+	// p2 is now the least recently used program, so it is evicted.
+	c.get(p3, false)
+	if got, want := c.len(), 2; got != want {
+		t.Errorf("len got %d, want %d", got, want)
+	}
+	if got := c.get(p1, false); got != c1 {
+		t.Errorf("get(p1) after eviction got %p, want cached %p", got, c1)
+	}
+	if got := c.get(p2, false); got == c2 {
+		t.Errorf("get(p2) after eviction got cached %p, want recompiled", got)
+	}
+
+	// Requesting an executable for a program cached without one compiles it.
+	if got := c.get(p1, true); got.executable == nil {
+		t.Errorf("get(p1, true) got no executable")
+	}
+}
+
+func TestSeccompCompiledCacheConcurrent(t *testing.T) {
+	c := newSeccompCompiledCache(4)
+	programs := make([]bpf.Program, 8)
+	for i := range programs {
+		programs[i] = seccompTestProgram(t, i+1)
+	}
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				p := programs[(g+i)%len(programs)]
+				if got := c.get(p, i%2 == 0); got.optimized.Length() == 0 {
+					t.Errorf("get(%d instructions) got empty optimized program", p.Length())
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	if got := c.len(); got > 4 {
+		t.Errorf("len got %d, want at most 4", got)
+	}
+}
+
+func TestSeccompBuildFilter(t *testing.T) {
+	p, err := seccomp.BuildFilter([]seccomp.RuleSet{
+		{
+			Rules: seccomp.SyscallRules{
+				0: {},
+				1: {},
+			},
+			Action: linux.SECCOMP_RET_ALLOW,
+		},
+		{
+			Rules: seccomp.SyscallRules{
+				2: {
+					{seccomp.AllowValue(5)},
+				},
+			},
+			Action: linux.SECCOMP_RET_ERRNO | uint32(syscall.EACCES),
+		},
+	}, linux.SECCOMP_RET_KILL_PROCESS)
+	if err != nil {
+		t.Fatalf("seccomp.BuildFilter failed: %v", err)
+	}
+	task := newSeccompTestTask()
+	task.noNewPrivs = true
+	if err := task.AppendSyscallFilter(p, 0); err != nil {
+		t.Fatalf("AppendSyscallFilter failed: %v", err)
+	}
+	for _, test := range []struct {
+		sysno int32
+		arg0  uintptr
+		want  uint32
+	}{
+		{sysno: 0, want: linux.SECCOMP_RET_ALLOW},
+		{sysno: 1, arg0: 5, want: linux.SECCOMP_RET_ALLOW},
+		{sysno: 2, arg0: 5, want: linux.SECCOMP_RET_ERRNO | uint32(syscall.EACCES)},
+		{sysno: 2, arg0: 6, want: linux.SECCOMP_RET_KILL_PROCESS},
+		{sysno: 3, want: linux.SECCOMP_RET_KILL_PROCESS},
+	} {
+		data := task.seccompData(test.sysno, arch.SyscallArguments{{Value: test.arg0}}, 0)
+		if got, _ := task.evaluateSyscallFilters(&data); got != test.want {
+			t.Errorf("evaluateSyscallFilters(%d, %#x) got %#x, want %#x", test.sysno, test.arg0, got, test.want)
+		}
+	}
+}
+
+func TestSeccompDivisionByZero(t *testing.T) {
+	// Return SECCOMP_RET_ERRNO with 12 divided by the system call number as
+	// the errno.
+	p, err := bpf.Compile([]linux.BPFInstruction{
+		bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 0), // nr
+		bpf.Stmt(bpf.St, 0),
+		bpf.Stmt(bpf.Ldx|bpf.Mem, 0),
+		bpf.Stmt(bpf.Ld|bpf.Imm, 12),
+		bpf.Stmt(bpf.Alu|bpf.Div|bpf.X, 0),
+		bpf.Stmt(bpf.Alu|bpf.Xor|bpf.K, linux.SECCOMP_RET_ERRNO),
+		bpf.Stmt(bpf.Ret|bpf.A, 0),
+	})
+	if err != nil {
+		t.Fatalf("bpf.Compile failed: %v", err)
+	}
+	task := newSeccompTestTask()
+	task.noNewPrivs = true
+	if err := task.AppendSyscallFilter(p, 0); err != nil {
+		t.Fatalf("AppendSyscallFilter failed: %v", err)
+	}
+	for _, test := range []struct {
+		sysno int32
+		want  uint32
+	}{
+		{sysno: 4, want: linux.SECCOMP_RET_ERRNO | 3},
+		// As in Linux, where a division by zero terminates the filter with
+		// return value 0, the task is killed.
+		{sysno: 0, want: linux.SECCOMP_RET_KILL_THREAD},
+	} {
+		data := task.seccompData(test.sysno, arch.SyscallArguments{}, 0)
+		if got, _ := task.evaluateSyscallFilters(&data); got != test.want {
+			t.Errorf("evaluateSyscallFilters(%d) got %#x, want %#x", test.sysno, got, test.want)
+		}
+	}
+}
+
+func TestSeccompModXor(t *testing.T) {
+	// BPF_XOR is allowed in seccomp filters. Return SECCOMP_RET_ERRNO with
+	// the system call number XORed with 0x3 and then with X as the errno.
+	p, err := bpf.Compile([]linux.BPFInstruction{
+		bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 0), // nr
+		bpf.Stmt(bpf.Alu|bpf.Xor|bpf.K, 0x3),
+		bpf.Stmt(bpf.Ldx|bpf.Imm, 0x10),
+		bpf.Stmt(bpf.Alu|bpf.Xor|bpf.X, 0),
+		bpf.Stmt(bpf.Alu|bpf.Or|bpf.K, linux.SECCOMP_RET_ERRNO),
+		bpf.Stmt(bpf.Ret|bpf.A, 0),
+	})
+	if err != nil {
+		t.Fatalf("bpf.Compile failed: %v", err)
+	}
+	task := newSeccompTestTask()
+	task.noNewPrivs = true
+	if err := task.AppendSyscallFilter(p, 0); err != nil {
+		t.Fatalf("AppendSyscallFilter failed: %v", err)
+	}
+	data := task.seccompData(5, arch.SyscallArguments{}, 0)
+	const want = linux.SECCOMP_RET_ERRNO | (5 ^ 0x3 ^ 0x10)
+	if got, _ := task.evaluateSyscallFilters(&data); got != want {
+		t.Errorf("evaluateSyscallFilters got %#x, want %#x", got, want)
+	}
+
+	// As in Linux, BPF_MOD is valid BPF but is rejected in seccomp filters,
+	// with either source.
+	for _, src := range []uint16{bpf.K, bpf.X} {
+		p, err := bpf.Compile([]linux.BPFInstruction{
+			bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 0), // nr
+			bpf.Stmt(bpf.Ldx|bpf.Imm, 2),
+			bpf.Stmt(bpf.Alu|bpf.Mod|src, 2),
+			bpf.Stmt(bpf.Ret|bpf.A, 0),
+		})
+		if err != nil {
+			t.Fatalf("bpf.Compile failed: %v", err)
+		}
+		if err := task.AppendSyscallFilter(p, 0); err != syserror.EINVAL {
+			t.Errorf("AppendSyscallFilter with BPF_MOD|%#x got error %v, want %v", src, err, syserror.EINVAL)
+		}
+	}
+}
+
+func TestSeccompDataAArch64(t *testing.T) {
+	// Allow only read(2) using the arm64 syscall ABI, in which read is
+	// syscall 63 (rather than 0, as on amd64).
+	const sysRead = 63
+	p, err := bpf.Compile([]linux.BPFInstruction{
+		bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 4), // arch
+		bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, linux.AUDIT_ARCH_AARCH64, 1, 0),
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_KILL_PROCESS),
+		bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 0), // nr
+		bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, sysRead, 0, 1),
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ALLOW),
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ERRNO|1),
+	})
+	if err != nil {
+		t.Fatalf("bpf.Compile failed: %v", err)
+	}
+	task := newSeccompTestTask()
+	task.tc.st.AuditNumber = linux.AUDIT_ARCH_AARCH64
+	task.noNewPrivs = true
+	if err := task.AppendSyscallFilter(p, 0); err != nil {
+		t.Fatalf("AppendSyscallFilter failed: %v", err)
+	}
+	for _, test := range []struct {
+		sysno int32
+		want  uint32
+	}{
+		{sysRead, linux.SECCOMP_RET_ALLOW},
+		{0, linux.SECCOMP_RET_ERRNO | 1},
+	} {
+		data := task.seccompData(test.sysno, arch.SyscallArguments{}, 0)
+		if data.arch != linux.AUDIT_ARCH_AARCH64 || data.nr != test.sysno {
+			t.Errorf("seccompData(%d) got arch %#x, nr %d, want arch %#x, nr %d", test.sysno, data.arch, data.nr, linux.AUDIT_ARCH_AARCH64, test.sysno)
+		}
+		if got, _ := task.evaluateSyscallFilters(&data); got != test.want {
+			t.Errorf("evaluateSyscallFilters(%d) got %#x, want %#x", test.sysno, got, test.want)
+		}
+	}
+}
+
+func TestSeccompCache(t *testing.T) {
+	// Allow syscall 1 unconditionally, allow syscall 2 only if its first
+	// argument is 0, and deny everything else with EPERM.
+	p, err := bpf.Compile([]linux.BPFInstruction{
+		bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 4), // arch
+		bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, linux.AUDIT_ARCH_X86_64, 1, 0),
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_KILL_PROCESS),
+		bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 0), // nr
+		bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, 1, 3, 0),
+		bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, 2, 0, 3),
+		bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 16), // args[0], low half
+		bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, 0, 0, 1),
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ALLOW),
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ERRNO|1),
+	})
+	if err != nil {
+		t.Fatalf("bpf.Compile failed: %v", err)
+	}
+	c := newSeccompCache(p, linux.AUDIT_ARCH_X86_64, 3)
+
+	for _, test := range []struct {
+		name   string
+		data   seccompData
+		want   uint32
+		wantOK bool
+	}{
+		{
+			name:   "constant deny",
+			data:   seccompData{nr: 0, arch: linux.AUDIT_ARCH_X86_64},
+			want:   linux.SECCOMP_RET_ERRNO | 1,
+			wantOK: true,
+		},
+		{
+			name:   "constant allow",
+			data:   seccompData{nr: 1, arch: linux.AUDIT_ARCH_X86_64, args: [6]uint64{1}},
+			want:   linux.SECCOMP_RET_ALLOW,
+			wantOK: true,
+		},
+		{
+			name: "depends on args",
+			data: seccompData{nr: 2, arch: linux.AUDIT_ARCH_X86_64},
+		},
+		{
+			name: "out of range",
+			data: seccompData{nr: 3, arch: linux.AUDIT_ARCH_X86_64},
+		},
+		{
+			name: "negative",
+			data: seccompData{nr: -1, arch: linux.AUDIT_ARCH_X86_64},
+		},
+		{
+			name: "other arch",
+			data: seccompData{nr: 1, arch: 0},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := c.lookup(&test.data)
+			if ok != test.wantOK || (ok && got != test.want) {
+				t.Errorf("lookup(%+v) got (%#x, %v), want (%#x, %v)", test.data, got, ok, test.want, test.wantOK)
+			}
+		})
+	}
+}
+
+func TestSeccompCacheAlwaysKills(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		insns       []linux.BPFInstruction
+		numSyscalls int
+		want        bool
+	}{
+		{
+			name:        "kill thread",
+			insns:       []linux.BPFInstruction{bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_KILL_THREAD)},
+			numSyscalls: 3,
+			want:        true,
+		},
+		{
+			name:        "kill process",
+			insns:       []linux.BPFInstruction{bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_KILL_PROCESS)},
+			numSyscalls: 3,
+			want:        true,
+		},
+		{
+			name:        "invalid action",
+			insns:       []linux.BPFInstruction{bpf.Stmt(bpf.Ret|bpf.K, 0x7ff80000)},
+			numSyscalls: 3,
+			want:        true,
+		},
+		{
+			name: "kill in either branch",
+			insns: []linux.BPFInstruction{
+				bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 4), // arch
+				bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, linux.AUDIT_ARCH_X86_64, 1, 0),
+				bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_KILL_PROCESS),
+				bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_KILL_THREAD),
+			},
+			numSyscalls: 3,
+			want:        true,
+		},
+		{
+			name: "allows one syscall",
+			insns: []linux.BPFInstruction{
+				bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 0), // nr
+				bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, 2, 0, 1),
+				bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ALLOW),
+				bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_KILL_THREAD),
+			},
+			numSyscalls: 3,
+		},
+		{
+			// The program might allow some arguments, so it is not provably
+			// always-kill.
+			name: "depends on args",
+			insns: []linux.BPFInstruction{
+				bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 16), // args[0], low half
+				bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_KILL_THREAD),
+			},
+			numSyscalls: 3,
+		},
+		{
+			name:        "errno",
+			insns:       []linux.BPFInstruction{bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ERRNO|1)},
+			numSyscalls: 3,
+		},
+		{
+			name:  "empty syscall table",
+			insns: []linux.BPFInstruction{bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_KILL_THREAD)},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := bpf.Compile(test.insns)
+			if err != nil {
+				t.Fatalf("bpf.Compile failed: %v", err)
+			}
+			c := newSeccompCache(p, linux.AUDIT_ARCH_X86_64, test.numSyscalls)
+			if got := c.alwaysKills(); got != test.want {
+				t.Errorf("alwaysKills got %t, want %t", got, test.want)
+			}
+		})
+	}
+
+	// Filters that always kill are installed anyway.
+	p, err := bpf.Compile([]linux.BPFInstruction{bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_KILL_THREAD)})
+	if err != nil {
+		t.Fatalf("bpf.Compile failed: %v", err)
+	}
+	task := newSeccompTestTask()
+	task.tc.st.lookup = make([]SyscallFn, 3)
+	task.noNewPrivs = true
+	if err := task.AppendSyscallFilter(p, 0); err != nil {
+		t.Fatalf("AppendSyscallFilter failed: %v", err)
+	}
+	if got := task.SeccompFilterCount(); got != 1 {
+		t.Errorf("SeccompFilterCount got %d, want 1", got)
+	}
+}
+
+func TestSplitSeccompResult(t *testing.T) {
+	for _, test := range []struct {
+		result     uint32
+		wantAction uint32
+		wantData   uint16
+	}{
+		{0, linux.SECCOMP_RET_KILL_THREAD, 0},
+		{linux.SECCOMP_RET_DATA, linux.SECCOMP_RET_KILL_THREAD, 0xffff},
+		// The lowest action bit is not data.
+		{0x00010000, 0x00010000, 0},
+		{0x0001ffff, 0x00010000, 0xffff},
+		{linux.SECCOMP_RET_ERRNO | 1, linux.SECCOMP_RET_ERRNO, 1},
+		{linux.SECCOMP_RET_TRAP | 0x8000, linux.SECCOMP_RET_TRAP, 0x8000},
+		{linux.SECCOMP_RET_TRACE | 0xffff, linux.SECCOMP_RET_TRACE, 0xffff},
+		{linux.SECCOMP_RET_ALLOW | 0xffff, linux.SECCOMP_RET_ALLOW, 0xffff},
+		// The sign bit is part of the action, distinguishing
+		// SECCOMP_RET_KILL_PROCESS from SECCOMP_RET_KILL_THREAD.
+		{linux.SECCOMP_RET_KILL_PROCESS, linux.SECCOMP_RET_KILL_PROCESS, 0},
+		{linux.SECCOMP_RET_KILL_PROCESS | 0xffff, linux.SECCOMP_RET_KILL_PROCESS, 0xffff},
+		{0xffffffff, 0xffff0000, 0xffff},
+	} {
+		action, data := splitSeccompResult(test.result)
+		if action != test.wantAction || data != test.wantData {
+			t.Errorf("splitSeccompResult(%#x) got (%#x, %#x), want (%#x, %#x)", test.result, action, data, test.wantAction, test.wantData)
+		}
+	}
+}
+
+func TestSeccompResultDataBoundary(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		result uint32
+		want   seccompResult
+		check  func(*Task) error
+	}{
+		{
+			name:   "errno with all data bits is clamped",
+			result: linux.SECCOMP_RET_ERRNO | linux.SECCOMP_RET_DATA,
+			want:   seccompResultDeny,
+			check: func(task *Task) error {
+				if got, want := int64(task.Arch().Return()), -int64(linux.MAX_ERRNO); got != want {
+					return fmt.Errorf("return value got %d, want %d", got, want)
+				}
+				return nil
+			},
+		},
+		{
+			name:   "trap with all data bits",
+			result: linux.SECCOMP_RET_TRAP | linux.SECCOMP_RET_DATA,
+			want:   seccompResultDeny,
+			check: func(task *Task) error {
+				info := task.pendingSignals.dequeue(0)
+				if info == nil {
+					return fmt.Errorf("no signal pending")
+				}
+				if info.Errno != 0xffff {
+					return fmt.Errorf("si_errno got %#x, want %#x", info.Errno, 0xffff)
+				}
+				return nil
+			},
+		},
+		{
+			name:   "trace with all data bits",
+			result: linux.SECCOMP_RET_TRACE | linux.SECCOMP_RET_DATA,
+			want:   seccompResultAllow,
+			check: func(task *Task) error {
+				sink := task.tg.SeccompTraceSink().(*seccompTestTraceSink)
+				if len(sink.events) != 1 || sink.events[0].Data != 0xffff {
+					return fmt.Errorf("sink got events %+v, want one with data %#x", sink.events, 0xffff)
+				}
+				return nil
+			},
+		},
+		{
+			name:   "allow with data",
+			result: linux.SECCOMP_RET_ALLOW | linux.SECCOMP_RET_DATA,
+			want:   seccompResultAllow,
+		},
+		{
+			// An action bit just above SECCOMP_RET_DATA makes the result an
+			// invalid action, which kills the thread group as in Linux,
+			// rather than SECCOMP_RET_KILL_THREAD with data.
+			name:   "invalid action just above data",
+			result: 0x00010000,
+			want:   seccompResultKillProcess,
+		},
+		{
+			name:   "kill thread with data",
+			result: linux.SECCOMP_RET_KILL_THREAD | linux.SECCOMP_RET_DATA,
+			want:   seccompResultKill,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := bpf.Compile([]linux.BPFInstruction{
+				bpf.Stmt(bpf.Ret|bpf.K, test.result),
+			})
+			if err != nil {
+				t.Fatalf("bpf.Compile failed: %v", err)
+			}
+			task := newSeccompNotifyTestTask(&Kernel{})
+			task.syscallFilters.Store([]*syscallFilter{task.newSyscallFilter(p, 0)})
+			task.tg.SetSeccompTraceSink(&seccompTestTraceSink{})
+			if r := task.checkSeccompSyscall(1, arch.SyscallArguments{}, 0); r != test.want {
+				t.Fatalf("checkSeccompSyscall got %v, want %v", r, test.want)
+			}
+			if test.check != nil {
+				if err := test.check(task); err != nil {
+					t.Error(err)
+				}
+			}
+		})
+	}
+}
+
+func TestSeccompTrapSiginfo(t *testing.T) {
+	const (
+		sysno = 39
+		data  = 0xbeef
+		ip    = 0x7f0000001234
+	)
+	p, err := bpf.Compile([]linux.BPFInstruction{
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_TRAP|data),
+	})
+	if err != nil {
+		t.Fatalf("bpf.Compile failed: %v", err)
+	}
+	task := newSeccompNotifyTestTask(&Kernel{})
+	task.syscallFilters.Store([]*syscallFilter{task.newSyscallFilter(p, 0)})
+
+	// The task's registers make the system call.
+	task.Arch().StateData().Regs.Orig_rax = sysno
+	task.Arch().SetIP(ip)
+	if _, ok := task.doSyscall().(*runSyscallExit); !ok {
+		t.Fatalf("doSyscall did not skip to syscall exit")
+	}
+	info := task.pendingSignals.dequeue(0)
+	if info == nil {
+		t.Fatalf("no signal pending after SECCOMP_RET_TRAP")
+	}
+
+	// Check the siginfo_t seen by the application's signal handler, as laid
+	// out by struct siginfo's _sigsys member. si_arch is that of the task's
+	// syscall table, whose convention the system call was executed with.
+	buf := binary.Marshal(nil, usermem.ByteOrder, info)
+	for _, test := range []struct {
+		field string
+		got   uint64
+		want  uint64
+	}{
+		{"si_signo", uint64(usermem.ByteOrder.Uint32(buf[0:])), uint64(linux.SIGSYS)},
+		{"si_errno", uint64(usermem.ByteOrder.Uint32(buf[4:])), data},
+		{"si_code", uint64(usermem.ByteOrder.Uint32(buf[8:])), uint64(arch.SYS_SECCOMP)},
+		{"si_call_addr", usermem.ByteOrder.Uint64(buf[16:]), ip},
+		{"si_syscall", uint64(usermem.ByteOrder.Uint32(buf[24:])), sysno},
+		{"si_arch", uint64(usermem.ByteOrder.Uint32(buf[28:])), linux.AUDIT_ARCH_X86_64},
+	} {
+		if test.got != test.want {
+			t.Errorf("%s got %#x, want %#x", test.field, test.got, test.want)
+		}
+	}
+}
+func TestSeccompSiginfoLayout(t *testing.T) {
+	if usermem.ByteOrder != binary.LittleEndian {
+		t.Skip("reference siginfo_t is little-endian")
+	}
+	data := seccompData{
+		nr:                 39,
+		arch:               linux.AUDIT_ARCH_X86_64,
+		instructionPointer: 0x7f0000001234,
+	}
+	// The SIGSYS delivered by Linux for SECCOMP_RET_TRAP|0xffff from
+	// getpid(2) at that address, as seen by an x86-64 signal handler:
+	// si_signo (SIGSYS), si_errno (SECCOMP_RET_DATA), si_code
+	// (SYS_SECCOMP), padding, si_call_addr, si_syscall and si_arch,
+	// followed by zeroes.
+	want := "1f000000" + "ffff0000" + "01000000" + "00000000" +
+		"34120000007f0000" + "27000000" + "3e0000c0" + strings.Repeat("00", 96)
+	buf := binary.Marshal(nil, usermem.ByteOrder, seccompSiginfo(&data, 0xffff))
+	if got := hex.EncodeToString(buf); got != want {
+		t.Errorf("seccompSiginfo got %s, want %s", got, want)
+	}
+}
+
+func TestSeccompTrapForcesSIGSYS(t *testing.T) {
+	p, err := bpf.Compile([]linux.BPFInstruction{
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_TRAP),
+	})
+	if err != nil {
+		t.Fatalf("bpf.Compile failed: %v", err)
+	}
+	for _, test := range []struct {
+		desc    string
+		blocked bool
+		handler uint64
+	}{
+		{"default", false, arch.SignalActDefault},
+		{"blocked", true, arch.SignalActDefault},
+		{"ignored", false, arch.SignalActIgnore},
+		{"blocked and ignored", true, arch.SignalActIgnore},
+	} {
+		task := newSeccompNotifyTestTask(&Kernel{})
+		task.syscallFilters.Store([]*syscallFilter{task.newSyscallFilter(p, 0)})
+		task.tg.signalHandlers.actions[linux.SIGSYS] = arch.SignalAct{Handler: test.handler}
+		if test.blocked {
+			task.signalMask = linux.SignalSetOf(linux.SIGSYS)
+		}
+
+		if r := task.checkSeccompSyscall(1, task.Arch().SyscallArgs(), 0); r != seccompResultDeny {
+			t.Errorf("%s: checkSeccompSyscall got %v, want %v", test.desc, r, seccompResultDeny)
+			continue
+		}
+
+		// SIGSYS must be pending and deliverable with its default action,
+		// which kills the thread group with SIGSYS.
+		task.tg.signalHandlers.mu.Lock()
+		info := task.dequeueSignalLocked(task.signalMask)
+		act := task.tg.signalHandlers.actions[linux.SIGSYS]
+		task.tg.signalHandlers.mu.Unlock()
+		if info == nil || linux.Signal(info.Signo) != linux.SIGSYS {
+			t.Errorf("%s: dequeued signal got %+v, want SIGSYS", test.desc, info)
+			continue
+		}
+		if got := computeAction(linux.SIGSYS, act); got != SignalActionCore {
+			t.Errorf("%s: SIGSYS action got %v, want %v", test.desc, got, SignalActionCore)
+		}
+		if _, ok := task.deliverSignal(info, act).(*runExit); !ok {
+			t.Errorf("%s: deliverSignal did not exit the task", test.desc)
+		}
+		if got, want := task.ExitStatus(), (ExitStatus{Signo: int(linux.SIGSYS)}); got != want {
+			t.Errorf("%s: exit status got %+v, want %+v", test.desc, got, want)
+		}
+	}
+}
+
+func TestSeccompTrapSkipsSyscall(t *testing.T) {
+	p, err := bpf.Compile([]linux.BPFInstruction{
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_TRAP),
+	})
+	if err != nil {
+		t.Fatalf("bpf.Compile failed: %v", err)
+	}
+	for _, test := range []struct {
+		desc    string
+		blocked bool
+		handler uint64
+	}{
+		{"default", false, arch.SignalActDefault},
+		{"blocked", true, arch.SignalActDefault},
+		{"ignored", false, arch.SignalActIgnore},
+		{"blocked and ignored", true, arch.SignalActIgnore},
+	} {
+		task := newSeccompNotifyTestTask(&Kernel{})
+		// The task's registers make system call 0, which records that it was
+		// executed.
+		executed := false
+		fn := func(*Task, arch.SyscallArguments) (uintptr, *SyscallControl, error) {
+			executed = true
+			return 0, nil, nil
+		}
+		task.tc.st = &SyscallTable{
+			AuditNumber: linux.AUDIT_ARCH_X86_64,
+			Table:       map[uintptr]SyscallFn{0: fn},
+			lookup:      []SyscallFn{fn},
+		}
+		task.syscallFilters.Store([]*syscallFilter{task.newSyscallFilter(p, 0)})
+		task.tg.signalHandlers.actions[linux.SIGSYS] = arch.SignalAct{Handler: test.handler}
+		if test.blocked {
+			task.signalMask = linux.SignalSetOf(linux.SIGSYS)
+		}
+
+		if _, ok := task.doSyscall().(*runSyscallExit); !ok {
+			t.Errorf("%s: doSyscall did not skip to syscall exit", test.desc)
+		}
+		if executed {
+			t.Errorf("%s: system call executed despite SECCOMP_RET_TRAP", test.desc)
+		}
+		// As in Linux, the skipped system call returns ENOSYS.
+		if got, want := int64(task.Arch().Return()), -int64(syscall.ENOSYS); got != want {
+			t.Errorf("%s: return value got %d, want %d", test.desc, got, want)
+		}
+
+		// However the guest configured SIGSYS, it is now unblocked, pending
+		// and has its default action.
+		if task.signalMask&linux.SignalSetOf(linux.SIGSYS) != 0 {
+			t.Errorf("%s: SIGSYS still blocked", test.desc)
+		}
+		if got := computeAction(linux.SIGSYS, task.tg.signalHandlers.actions[linux.SIGSYS]); got != SignalActionCore {
+			t.Errorf("%s: SIGSYS action got %v, want %v", test.desc, got, SignalActionCore)
+		}
+		if task.pendingSignals.pendingSet&linux.SignalSetOf(linux.SIGSYS) == 0 {
+			t.Errorf("%s: SIGSYS not pending", test.desc)
+		}
+	}
+}
+
+func TestSeccompTraceNoTracer(t *testing.T) {
+	const sysno = 1
+	p, err := bpf.Compile([]linux.BPFInstruction{
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_TRACE),
+	})
+	if err != nil {
+		t.Fatalf("bpf.Compile failed: %v", err)
+	}
+
+	// The guest-visible result of a SECCOMP_RET_TRACE filter with no tracer
+	// should be identical to that of an unimplemented syscall.
+	want := newSeccompTestTask()
+	want.setSyscallError(syscall.ENOSYS, sysno)
+
+	got := newSeccompTestTask()
+	got.syscallFilters.Store([]*syscallFilter{got.newSyscallFilter(p, 0)})
+	if r := got.checkSeccompSyscall(sysno, got.Arch().SyscallArgs(), 0); r != seccompResultDeny {
+		t.Fatalf("checkSeccompSyscall got %v, want %v", r, seccompResultDeny)
+	}
+
+	if got, want := got.Arch().Return(), want.Arch().Return(); got != want {
+		t.Errorf("return value got %#x, want %#x", got, want)
+	}
+	if got.haveSyscallReturn != want.haveSyscallReturn {
+		t.Errorf("haveSyscallReturn got %v, want %v", got.haveSyscallReturn, want.haveSyscallReturn)
+	}
+}
+
+// seccompTestTraceSink is a SeccompTraceSink that records the events it
+// receives and returns err.
+type seccompTestTraceSink struct {
+	events []SeccompTraceEvent
+	err    error
+}
+
+// SeccompTrace implements SeccompTraceSink.SeccompTrace.
+func (s *seccompTestTraceSink) SeccompTrace(t *Task, ev SeccompTraceEvent) error {
+	s.events = append(s.events, ev)
+	return s.err
+}
+
+func TestSeccompTraceSink(t *testing.T) {
+	const (
+		sysno = 1
+		msg   = 0x1234
+	)
+	p, err := bpf.Compile([]linux.BPFInstruction{
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_TRACE|msg),
+	})
+	if err != nil {
+		t.Fatalf("bpf.Compile failed: %v", err)
+	}
+	for _, test := range []struct {
+		desc       string
+		err        error
+		wantResult seccompResult
+	}{
+		{"allow", nil, seccompResultAllow},
+		{"deny", syserror.EPERM, seccompResultDeny},
+	} {
+		task := newSeccompTestTask()
+		task.syscallFilters.Store([]*syscallFilter{task.newSyscallFilter(p, 0)})
+		sink := &seccompTestTraceSink{err: test.err}
+		task.tg.SetSeccompTraceSink(sink)
+
+		args := arch.SyscallArguments{{Value: 1}, {Value: 2}, {Value: 3}, {Value: 4}, {Value: 5}, {Value: 6}}
+		if r := task.checkSeccompSyscall(sysno, args, 0x1000); r != test.wantResult {
+			t.Errorf("%s: checkSeccompSyscall got %v, want %v", test.desc, r, test.wantResult)
+		}
+		want := []SeccompTraceEvent{{
+			Data:               msg,
+			Arch:               linux.AUDIT_ARCH_X86_64,
+			Sysno:              sysno,
+			Args:               [6]uint64{1, 2, 3, 4, 5, 6},
+			InstructionPointer: 0x1000,
+		}}
+		if !reflect.DeepEqual(sink.events, want) {
+			t.Errorf("%s: sink got events %+v, want %+v", test.desc, sink.events, want)
+		}
+		if test.err != nil {
+			if got, want := int64(task.Arch().Return()), -int64(syscall.EPERM); got != want {
+				t.Errorf("%s: return value got %#x, want %#x", test.desc, got, want)
+			}
+		}
+	}
+
+	// Unregistering the sink restores the ENOSYS fallback.
+	task := newSeccompTestTask()
+	task.syscallFilters.Store([]*syscallFilter{task.newSyscallFilter(p, 0)})
+	sink := &seccompTestTraceSink{}
+	task.tg.SetSeccompTraceSink(sink)
+	task.tg.SetSeccompTraceSink(nil)
+	if r := task.checkSeccompSyscall(sysno, task.Arch().SyscallArgs(), 0); r != seccompResultDeny {
+		t.Errorf("checkSeccompSyscall without sink got %v, want %v", r, seccompResultDeny)
+	}
+	if got, want := int64(task.Arch().Return()), -int64(syscall.ENOSYS); got != want {
+		t.Errorf("return value without sink got %#x, want %#x", got, want)
+	}
+	if len(sink.events) != 0 {
+		t.Errorf("unregistered sink got events %+v, want none", sink.events)
+	}
+}
+
+func TestSeccompTraceSinkTracerPrecedence(t *testing.T) {
+	p, err := bpf.Compile([]linux.BPFInstruction{
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_TRACE),
+	})
+	if err != nil {
+		t.Fatalf("bpf.Compile failed: %v", err)
+	}
+	task := newSeccompTestTask()
+	task.syscallFilters.Store([]*syscallFilter{task.newSyscallFilter(p, 0)})
+	sink := &seccompTestTraceSink{}
+	task.tg.SetSeccompTraceSink(sink)
+
+	tracer := newSeccompTestTask()
+	tracer.tg.pidns = task.tg.pidns
+	task.tg.pidns.tids[tracer] = 2
+	task.ptraceTracer.Store(tracer)
+	task.ptraceOpts.TraceSeccomp = true
+
+	if r := task.checkSeccompSyscall(1, task.Arch().SyscallArgs(), 0); r != seccompResultTrace {
+		t.Errorf("checkSeccompSyscall got %v, want %v", r, seccompResultTrace)
+	}
+	if len(sink.events) != 0 {
+		t.Errorf("sink got events %+v with a tracer, want none", sink.events)
+	}
+}
+
+func TestSeccompTraceEventMessage(t *testing.T) {
+	const data = 0x1234
+	p, err := bpf.Compile([]linux.BPFInstruction{
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_TRACE|data),
+	})
+	if err != nil {
+		t.Fatalf("bpf.Compile failed: %v", err)
+	}
+	task := newSeccompTestTask()
+	task.syscallFilters.Store([]*syscallFilter{task.newSyscallFilter(p, 0)})
+
+	tracer := newSeccompTestTask()
+	tracer.tg.pidns = task.tg.pidns
+	task.tg.pidns.tids[tracer] = 2
+	task.ptraceTracer.Store(tracer)
+	task.ptraceOpts.TraceSeccomp = true
+
+	if r := task.checkSeccompSyscall(1, task.Arch().SyscallArgs(), 0); r != seccompResultTrace {
+		t.Fatalf("checkSeccompSyscall got %v, want %v", r, seccompResultTrace)
+	}
+	// PTRACE_GETEVENTMSG returns ptraceEventMsg.
+	if got := task.ptraceEventMsg; got != data {
+		t.Errorf("event message got %#x, want %#x", got, data)
+	}
+	if got, want := task.ptraceCode, int32(linux.SIGTRAP)|linux.PTRACE_EVENT_SECCOMP<<8; got != want {
+		t.Errorf("ptrace stop code got %#x, want %#x", got, want)
+	}
+}
+
+func TestSeccompTraceToUserNotif(t *testing.T) {
+	const (
+		sysno = 1
+		val   = 42
+	)
+	allow, err := bpf.Compile([]linux.BPFInstruction{
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ALLOW),
+	})
+	if err != nil {
+		t.Fatalf("bpf.Compile failed: %v", err)
+	}
+	trace, err := bpf.Compile([]linux.BPFInstruction{
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_TRACE),
+	})
+	if err != nil {
+		t.Fatalf("bpf.Compile failed: %v", err)
+	}
+	defer atomic.StoreUint32(&SeccompTraceToUserNotif, atomic.LoadUint32(&SeccompTraceToUserNotif))
+
+	// newTask returns a task whose filters return SECCOMP_RET_TRACE from a
+	// filter other than the one with the listener, if listener is true.
+	k := &Kernel{}
+	newTask := func(listener bool) (*Task, *SeccompListener) {
+		task := newSeccompNotifyTestTask(k)
+		lf := task.newSyscallFilter(allow, 0)
+		var l *SeccompListener
+		if listener {
+			l = NewSeccompListener()
+			lf.listener = l
+		}
+		task.syscallFilters.Store([]*syscallFilter{lf, task.newSyscallFilter(trace, 0)})
+		return task, l
+	}
+	wantENOSYS := func(name string, task *Task) {
+		if r := task.checkSeccompSyscall(sysno, task.Arch().SyscallArgs(), 0); r != seccompResultDeny {
+			t.Errorf("%s: checkSeccompSyscall got %v, want %v", name, r, seccompResultDeny)
+		}
+		if got, want := int64(task.Arch().Return()), -int64(syscall.ENOSYS); got != want {
+			t.Errorf("%s: return value got %#x, want %#x", name, got, want)
+		}
+	}
+
+	t.Run("tracer", func(t *testing.T) {
+		atomic.StoreUint32(&SeccompTraceToUserNotif, 1)
+		task, l := newTask(true)
+		defer l.Release()
+		tracer := newSeccompTestTask()
+		tracer.tg.pidns = task.tg.pidns
+		task.tg.pidns.tids[tracer] = 2
+		task.ptraceTracer.Store(tracer)
+		task.ptraceOpts.TraceSeccomp = true
+		if r := task.checkSeccompSyscall(sysno, task.Arch().SyscallArgs(), 0); r != seccompResultTrace {
+			t.Errorf("checkSeccompSyscall got %v, want %v", r, seccompResultTrace)
+		}
+		if _, err := l.Recv(task); err != syserror.ErrWouldBlock {
+			t.Errorf("Recv with a tracer got error %v, want %v", err, syserror.ErrWouldBlock)
+		}
+	})
+
+	t.Run("listener", func(t *testing.T) {
+		atomic.StoreUint32(&SeccompTraceToUserNotif, 1)
+		task, l := newTask(true)
+		defer l.Release()
+		e, ch := waiter.NewChannelEntry(nil)
+		l.EventRegister(&e, waiter.EventIn)
+		defer l.EventUnregister(&e)
+		done := make(chan seccompResult, 1)
+		go func() {
+			done <- task.checkSeccompSyscall(sysno, task.Arch().SyscallArgs(), 0)
+		}()
+		var notif linux.SeccompNotif
+		for {
+			var err error
+			if notif, err = l.Recv(task); err == nil {
+				break
+			}
+			if err != syserror.ErrWouldBlock {
+				t.Fatalf("Recv failed: %v", err)
+			}
+			<-ch
+		}
+		if notif.Data.Nr != sysno {
+			t.Errorf("notification got system call %d, want %d", notif.Data.Nr, sysno)
+		}
+		if err := l.Send(linux.SeccompNotifResp{ID: notif.ID, Val: val}); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+		if r := <-done; r != seccompResultDeny {
+			t.Errorf("checkSeccompSyscall got %v, want %v", r, seccompResultDeny)
+		}
+		if got := task.Arch().Return(); got != val {
+			t.Errorf("return value got %d, want %d", got, val)
+		}
+	})
+
+	t.Run("listener without option", func(t *testing.T) {
+		atomic.StoreUint32(&SeccompTraceToUserNotif, 0)
+		task, l := newTask(true)
+		defer l.Release()
+		wantENOSYS("listener without option", task)
+		if _, err := l.Recv(task); err != syserror.ErrWouldBlock {
+			t.Errorf("Recv without option got error %v, want %v", err, syserror.ErrWouldBlock)
+		}
+	})
+
+	t.Run("neither", func(t *testing.T) {
+		atomic.StoreUint32(&SeccompTraceToUserNotif, 1)
+		task, _ := newTask(false)
+		wantENOSYS("neither", task)
+	})
+}
+
+func TestSeccompFilterCount(t *testing.T) {
+	task := newSeccompTestTask()
+	if got := task.SeccompFilterCount(); got != 0 {
+		t.Errorf("SeccompFilterCount with no filters got %d, want 0", got)
+	}
+	task.syscallFilters.Store([]*syscallFilter{{}, {}})
+	if got := task.SeccompFilterCount(); got != 2 {
+		t.Errorf("SeccompFilterCount got %d, want 2", got)
+	}
+}
+
+func TestSeccompStatus(t *testing.T) {
+	task := newSeccompTestTask()
+	if got, want := task.SeccompStatus(), "NoNewPrivs:\t0\nSeccomp:\t0\nSeccomp_filters:\t0\n"; got != want {
+		t.Errorf("SeccompStatus with no filters got %q, want %q", got, want)
+	}
+
+	task.SetNoNewPrivs()
+	for i := 0; i < 2; i++ {
+		if err := task.AppendSyscallFilter(seccompTestProgram(t, 1), 0); err != nil {
+			t.Fatalf("AppendSyscallFilter failed: %v", err)
+		}
+	}
+	if got, want := task.SeccompStatus(), "NoNewPrivs:\t1\nSeccomp:\t2\nSeccomp_filters:\t2\n"; got != want {
+		t.Errorf("SeccompStatus with filters got %q, want %q", got, want)
+	}
+
+	strict := newSeccompTestTask()
+	if err := strict.SetSeccompStrict(); err != nil {
+		t.Fatalf("SetSeccompStrict failed: %v", err)
+	}
+	if got, want := strict.SeccompStatus(), "NoNewPrivs:\t0\nSeccomp:\t1\nSeccomp_filters:\t0\n"; got != want {
+		t.Errorf("SeccompStatus in strict mode got %q, want %q", got, want)
+	}
+}
+
+func TestGetSeccompFilters(t *testing.T) {
+	task := newSeccompTestTask()
+	if got := task.GetSeccompFilters(); got != nil {
+		t.Errorf("GetSeccompFilters with no filters got %v, want nil", got)
+	}
+
+	p1, p2 := seccompTestProgram(t, 1), seccompTestProgram(t, 2)
+	task.syscallFilters.Store([]*syscallFilter{
+		{program: p1},
+		{program: p2, flags: linux.SECCOMP_FILTER_FLAG_LOG},
+	})
+	got := task.GetSeccompFilters()
+	want := []SeccompFilter{
+		{Program: p1},
+		{Program: p2, Flags: linux.SECCOMP_FILTER_FLAG_LOG},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("GetSeccompFilters got %+v, want %+v", got, want)
+	}
+
+	// Modifying the returned slice doesn't affect the task.
+	got[0] = SeccompFilter{}
+	if again := task.GetSeccompFilters(); !reflect.DeepEqual(again, want) {
+		t.Errorf("GetSeccompFilters after modifying its result got %+v, want %+v", again, want)
+	}
+}
+
+func TestSeccompPreservedAcrossExec(t *testing.T) {
+	task := newSeccompTestTask()
+	task.noNewPrivs = true
+	filters := []*syscallFilter{{program: seccompTestProgram(t, 1)}}
+	task.syscallFilters.Store(filters)
+
+	newTC := &TaskContext{Name: "exec"}
+	task.mu.Lock()
+	task.switchTaskContextForExecLocked(newTC)
+	task.mu.Unlock()
+
+	if task.Name() != "exec" {
+		t.Fatalf("Name after exec got %q, want %q", task.Name(), "exec")
+	}
+	if got := task.syscallFilters.Load().([]*syscallFilter); !reflect.DeepEqual(got, filters) {
+		t.Errorf("filters after exec got %v, want %v", got, filters)
+	}
+	if got := task.SeccompMode(); got != linux.SECCOMP_MODE_FILTER {
+		t.Errorf("SeccompMode after exec got %d, want %d", got, linux.SECCOMP_MODE_FILTER)
+	}
+	if !task.NoNewPrivs() {
+		t.Errorf("NoNewPrivs after exec got false, want true")
+	}
+}
+
+func TestSeccompInheritedOnClone(t *testing.T) {
+	parent := newSeccompTestTask()
+	parent.noNewPrivs = true
+	parent.tg.tasks.PushBack(parent)
+	filters := []*syscallFilter{{program: seccompTestProgram(t, 1)}}
+	parent.tg.syscallFilters.Store(filters)
+
+	for _, test := range []struct {
+		desc      string
+		newThread bool
+	}{
+		{desc: "CLONE_THREAD", newThread: true},
+		{desc: "fork"},
+	} {
+		child := &Task{}
+		if test.newThread {
+			child.tg = parent.tg
+			parent.tg.tasks.PushBack(child)
+		} else {
+			child.tg = &ThreadGroup{pidns: parent.tg.pidns, leader: child}
+			child.tg.tasks.PushBack(child)
+		}
+		parent.inheritSeccompLocked(child)
+
+		if got := child.SeccompMode(); got != linux.SECCOMP_MODE_FILTER {
+			t.Errorf("%s: child SeccompMode got %d, want %d", test.desc, got, linux.SECCOMP_MODE_FILTER)
+		}
+		// The child shares the parent's immutable filter chain.
+		got := child.syscallFilterChain()
+		if len(got) != len(filters) || &got[0] != &filters[0] {
+			t.Errorf("%s: child filters got %p, want parent's %p", test.desc, got, filters)
+		}
+		if !child.noNewPrivs {
+			t.Errorf("%s: child did not inherit no_new_privs", test.desc)
+		}
+		// A later TSYNC by the parent can replace the child's filters.
+		if test.newThread {
+			if ot := parent.unsyncableTaskLocked(); ot != nil {
+				t.Errorf("%s: child is not syncable with parent", test.desc)
+			}
+		}
+	}
+}
+
+func TestSeccompFiltersMarshalRoundTrip(t *testing.T) {
+	insns := []linux.BPFInstruction{
+		bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 16),                // A = args[0] (low)
+		bpf.Jump(bpf.Jmp|bpf.Jgt|bpf.K, 2, 0, 1),          // if A > 2:
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_TRAP|1), //   return TRAP
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ALLOW),  // return ALLOW
+	}
+	args, err := bpf.Compile(insns)
+	if err != nil {
+		t.Fatalf("bpf.Compile failed: %v", err)
+	}
+	task := newSeccompTestTask()
+	task.noNewPrivs = true
+	for _, f := range []SeccompFilter{
+		{Program: seccompAllowlistProgram(t, []int32{1, 3, 5}, true /* checkArch */, false /* inline */)},
+		{Program: args, Flags: linux.SECCOMP_FILTER_FLAG_LOG},
+		{Program: seccompAllowlistProgram(t, []int32{2, 3}, false /* checkArch */, true /* inline */)},
+	} {
+		if err := task.AppendSyscallFilter(f.Program, f.Flags); err != nil {
+			t.Fatalf("AppendSyscallFilter failed: %v", err)
+		}
+	}
+
+	filters, err := UnmarshalSeccompFilters(MarshalSeccompFilters(task.GetSeccompFilters()))
+	if err != nil {
+		t.Fatalf("UnmarshalSeccompFilters failed: %v", err)
+	}
+	restored := newSeccompTestTask()
+	restored.noNewPrivs = true
+	for _, f := range filters {
+		if err := restored.AppendSyscallFilter(f.Program, f.Flags); err != nil {
+			t.Fatalf("AppendSyscallFilter failed: %v", err)
+		}
+	}
+	if got, want := restored.GetSeccompFilters(), task.GetSeccompFilters(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("restored filters got %+v, want %+v", got, want)
+	}
+
+	for _, arch := range []uint32{linux.AUDIT_ARCH_X86_64, linux.AUDIT_ARCH_I386} {
+		for nr := int32(0); nr < 8; nr++ {
+			for arg := uint64(0); arg < 5; arg++ {
+				data := seccompData{nr: nr, arch: arch, args: [6]uint64{arg}}
+				want, wantFilter := task.evaluateSyscallFilters(&data)
+				got, gotFilter := restored.evaluateSyscallFilters(&data)
+				if got != want || gotFilter.flags != wantFilter.flags {
+					t.Errorf("evaluateSyscallFilters(%+v) got (%#x, flags %#x), want (%#x, flags %#x)", data, got, gotFilter.flags, want, wantFilter.flags)
+				}
+			}
+		}
+	}
+}
+
+func TestUnmarshalSeccompFiltersErrors(t *testing.T) {
+	buf := MarshalSeccompFilters([]SeccompFilter{{Program: seccompTestProgram(t, 2)}})
+	// A filter that doesn't end with a return instruction.
+	invalid := binary.Marshal(nil, usermem.ByteOrder, &seccompFilterRecord{Len: 1})
+	invalid = binary.Marshal(invalid, usermem.ByteOrder, []linux.BPFInstruction{bpf.Stmt(bpf.Ld|bpf.Imm|bpf.W, 0)})
+	for _, test := range []struct {
+		desc string
+		buf  []byte
+	}{
+		{desc: "truncated header", buf: buf[:seccompFilterRecordSize-1]},
+		{desc: "truncated instructions", buf: buf[:len(buf)-1]},
+		{desc: "invalid program", buf: invalid},
+	} {
+		if _, err := UnmarshalSeccompFilters(test.buf); err == nil {
+			t.Errorf("%s: UnmarshalSeccompFilters succeeded, want error", test.desc)
+		}
+	}
+}
+
+func TestSeccompFilterBundle(t *testing.T) {
+	want := []SeccompFilter{
+		{Program: seccompAllowlistProgram(t, []int32{1, 3, 5}, true /* checkArch */, false /* inline */)},
+		{Program: seccompTestProgram(t, 4), Flags: linux.SECCOMP_FILTER_FLAG_LOG},
+		{Program: seccompAllowlistProgram(t, []int32{2, 3}, false /* checkArch */, true /* inline */), Flags: linux.SECCOMP_FILTER_FLAG_SPEC_ALLOW},
+	}
+	filters, err := UnmarshalSeccompFilterBundle(MarshalSeccompFilterBundle(want))
+	if err != nil {
+		t.Fatalf("UnmarshalSeccompFilterBundle failed: %v", err)
+	}
+	if !reflect.DeepEqual(filters, want) {
+		t.Fatalf("UnmarshalSeccompFilterBundle got %+v, want %+v", filters, want)
+	}
+
+	task := newSeccompTestTask()
+	task.noNewPrivs = true
+	if err := task.InstallSeccompFilters(filters); err != nil {
+		t.Fatalf("InstallSeccompFilters failed: %v", err)
+	}
+	if got := task.GetSeccompFilters(); !reflect.DeepEqual(got, want) {
+		t.Errorf("GetSeccompFilters got %+v, want %+v", got, want)
+	}
+
+	// An empty bundle is valid.
+	if filters, err := UnmarshalSeccompFilterBundle(MarshalSeccompFilterBundle(nil)); err != nil || len(filters) != 0 {
+		t.Errorf("UnmarshalSeccompFilterBundle of an empty bundle got (%+v, %v), want no filters", filters, err)
+	}
+}
+
+func TestInstallSeccompFiltersUnprivileged(t *testing.T) {
+	filters := []SeccompFilter{{Program: seccompTestProgram(t, 2)}, {Program: seccompTestProgram(t, 3)}}
+	// The task has neither no_new_privs nor CAP_SYS_ADMIN, like the init
+	// process of a container with the default capabilities.
+	task := newSeccompTestTask()
+	task.creds = auth.NewAnonymousCredentials()
+	if err := task.InstallSeccompFilters(filters); err != nil {
+		t.Fatalf("InstallSeccompFilters failed: %v", err)
+	}
+	if got := task.GetSeccompFilters(); !reflect.DeepEqual(got, filters) {
+		t.Errorf("GetSeccompFilters got %+v, want %+v", got, filters)
+	}
+	// Installing the filters doesn't set no_new_privs.
+	if task.NoNewPrivs() {
+		t.Errorf("NoNewPrivs got true, want false")
+	}
+	// The application itself is still subject to the privilege check.
+	if err := task.AppendSyscallFilter(seccompTestProgram(t, 2), 0); err != syserror.EACCES {
+		t.Errorf("AppendSyscallFilter got error %v, want %v", err, syserror.EACCES)
+	}
+}
+
+func TestUnmarshalSeccompFilterBundleErrors(t *testing.T) {
+	buf := MarshalSeccompFilterBundle([]SeccompFilter{{Program: seccompTestProgram(t, 2)}, {Program: seccompTestProgram(t, 3)}})
+	// modified returns a copy of buf with the header field at off set to v.
+	modified := func(off int, v uint32) []byte {
+		b := append([]byte(nil), buf...)
+		usermem.ByteOrder.PutUint32(b[off:], v)
+		return b
+	}
+	// A valid BPF program that is not a valid seccomp filter.
+	badProgram, err := bpf.Compile([]linux.BPFInstruction{
+		bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, SeccompDataSize),
+		bpf.Stmt(bpf.Ret|bpf.A, 0),
+	})
+	if err != nil {
+		t.Fatalf("bpf.Compile failed: %v", err)
+	}
+	long := seccompTestProgram(t, bpf.MaxInstructions)
+	var tooLong []SeccompFilter
+	for l := 0; l <= maxSyscallFilterInstructions; l += long.Length() + syscallFilterOverhead {
+		tooLong = append(tooLong, SeccompFilter{Program: long})
+	}
+	for _, test := range []struct {
+		desc string
+		buf  []byte
+	}{
+		{desc: "empty", buf: nil},
+		{desc: "truncated header", buf: buf[:seccompFilterBundleHeaderSize-1]},
+		{desc: "truncated filters", buf: buf[:len(buf)-1]},
+		{desc: "trailing data", buf: append(append([]byte(nil), buf...), 0)},
+		{desc: "bad magic", buf: modified(0, 0)},
+		{desc: "bad version", buf: modified(4, SeccompFilterBundleVersion+1)},
+		{desc: "too few filters", buf: modified(8, 1)},
+		{desc: "too many filters", buf: modified(8, 3)},
+		{desc: "oversized count", buf: modified(8, ^uint32(0))},
+		{desc: "oversized size", buf: modified(12, ^uint32(0))},
+		{desc: "invalid flags", buf: MarshalSeccompFilterBundle([]SeccompFilter{{Program: seccompTestProgram(t, 2), Flags: linux.SECCOMP_FILTER_FLAG_TSYNC}})},
+		{desc: "invalid seccomp filter", buf: MarshalSeccompFilterBundle([]SeccompFilter{{Program: badProgram}})},
+		{desc: "too many instructions", buf: MarshalSeccompFilterBundle(tooLong)},
+	} {
+		if filters, err := UnmarshalSeccompFilterBundle(test.buf); err == nil {
+			t.Errorf("%s: UnmarshalSeccompFilterBundle got %d filters, want error", test.desc, len(filters))
+		}
+	}
+}
+
+func TestSeccompFilterIndex(t *testing.T) {
+	task := newSeccompTestTask()
+	if _, err := task.seccompFilter(0); err != syserror.EINVAL {
+		t.Errorf("seccompFilter(0) with no filters got error %v, want %v", err, syserror.EINVAL)
+	}
+
+	first, second := &syscallFilter{}, &syscallFilter{}
+	task.syscallFilters.Store([]*syscallFilter{first, second})
+	for _, test := range []struct {
+		index   uint64
+		want    *syscallFilter
+		wantErr error
+	}{
+		// Index 0 is the most recently installed filter.
+		{index: 0, want: second},
+		{index: 1, want: first},
+		{index: 2, wantErr: syserror.ENOENT},
+	} {
+		got, err := task.seccompFilter(test.index)
+		if got != test.want || err != test.wantErr {
+			t.Errorf("seccompFilter(%d) got (%p, %v), want (%p, %v)", test.index, got, err, test.want, test.wantErr)
+		}
+	}
+}
+
+func TestAppendSyscallFilterPermission(t *testing.T) {
+	p, err := bpf.Compile([]linux.BPFInstruction{
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ALLOW),
+	})
+	if err != nil {
+		t.Fatalf("bpf.Compile failed: %v", err)
+	}
+	for _, test := range []struct {
+		name       string
+		creds      *auth.Credentials
+		noNewPrivs bool
+		want       error
+	}{
+		{
+			name:  "unprivileged",
+			creds: auth.NewAnonymousCredentials(),
+			want:  syserror.EACCES,
+		},
+		{
+			name:       "unprivileged with no_new_privs",
+			creds:      auth.NewAnonymousCredentials(),
+			noNewPrivs: true,
+		},
+		{
+			name:  "CAP_SYS_ADMIN",
+			creds: auth.NewRootCredentials(auth.NewRootUserNamespace()),
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			task := newSeccompTestTask()
+			task.creds = test.creds
+			task.noNewPrivs = test.noNewPrivs
+			if err := task.AppendSyscallFilter(p, 0); err != test.want {
+				t.Fatalf("AppendSyscallFilter got error %v, want %v", err, test.want)
+			}
+			wantCount := 1
+			if test.want != nil {
+				wantCount = 0
+			}
+			if got := task.SeccompFilterCount(); got != wantCount {
+				t.Errorf("SeccompFilterCount got %d, want %d", got, wantCount)
+			}
+		})
+	}
+}
+
+func TestSeccompModeTransitions(t *testing.T) {
+	p, err := bpf.Compile([]linux.BPFInstruction{
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ALLOW),
+	})
+	if err != nil {
+		t.Fatalf("bpf.Compile failed: %v", err)
+	}
+	const (
+		unprivileged = iota
+		noNewPrivs
+		capSysAdmin
+	)
+	privNames := []string{"unprivileged", "no_new_privs", "CAP_SYS_ADMIN"}
+	modeNames := map[int]string{
+		linux.SECCOMP_MODE_NONE:   "none",
+		linux.SECCOMP_MODE_STRICT: "strict",
+		linux.SECCOMP_MODE_FILTER: "filter",
+	}
+	for _, test := range []struct {
+		mode    int
+		newMode int
+		priv    int
+		want    error
+	}{
+		{linux.SECCOMP_MODE_NONE, linux.SECCOMP_MODE_STRICT, unprivileged, nil},
+		{linux.SECCOMP_MODE_NONE, linux.SECCOMP_MODE_STRICT, noNewPrivs, nil},
+		{linux.SECCOMP_MODE_NONE, linux.SECCOMP_MODE_STRICT, capSysAdmin, nil},
+		{linux.SECCOMP_MODE_NONE, linux.SECCOMP_MODE_FILTER, unprivileged, syserror.EACCES},
+		{linux.SECCOMP_MODE_NONE, linux.SECCOMP_MODE_FILTER, noNewPrivs, nil},
+		{linux.SECCOMP_MODE_NONE, linux.SECCOMP_MODE_FILTER, capSysAdmin, nil},
+		{linux.SECCOMP_MODE_STRICT, linux.SECCOMP_MODE_STRICT, unprivileged, nil},
+		{linux.SECCOMP_MODE_STRICT, linux.SECCOMP_MODE_STRICT, noNewPrivs, nil},
+		{linux.SECCOMP_MODE_STRICT, linux.SECCOMP_MODE_STRICT, capSysAdmin, nil},
+		// The privilege check precedes the mode check.
+		{linux.SECCOMP_MODE_STRICT, linux.SECCOMP_MODE_FILTER, unprivileged, syserror.EACCES},
+		{linux.SECCOMP_MODE_STRICT, linux.SECCOMP_MODE_FILTER, noNewPrivs, syserror.EINVAL},
+		{linux.SECCOMP_MODE_STRICT, linux.SECCOMP_MODE_FILTER, capSysAdmin, syserror.EINVAL},
+		{linux.SECCOMP_MODE_FILTER, linux.SECCOMP_MODE_STRICT, unprivileged, syserror.EINVAL},
+		{linux.SECCOMP_MODE_FILTER, linux.SECCOMP_MODE_STRICT, noNewPrivs, syserror.EINVAL},
+		{linux.SECCOMP_MODE_FILTER, linux.SECCOMP_MODE_STRICT, capSysAdmin, syserror.EINVAL},
+		{linux.SECCOMP_MODE_FILTER, linux.SECCOMP_MODE_FILTER, unprivileged, syserror.EACCES},
+		{linux.SECCOMP_MODE_FILTER, linux.SECCOMP_MODE_FILTER, noNewPrivs, nil},
+		{linux.SECCOMP_MODE_FILTER, linux.SECCOMP_MODE_FILTER, capSysAdmin, nil},
+	} {
+		name := fmt.Sprintf("%s to %s %s", modeNames[test.mode], modeNames[test.newMode], privNames[test.priv])
+		t.Run(name, func(t *testing.T) {
+			task := newSeccompTestTask()
+			switch test.mode {
+			case linux.SECCOMP_MODE_STRICT:
+				task.seccompStrict = true
+			case linux.SECCOMP_MODE_FILTER:
+				task.syscallFilters.Store([]*syscallFilter{task.newSyscallFilter(p, 0)})
+			}
+			if test.priv != capSysAdmin {
+				task.creds = auth.NewAnonymousCredentials()
+			}
+			task.noNewPrivs = test.priv == noNewPrivs
+			wantFilters := task.SeccompFilterCount()
+
+			var err error
+			switch test.newMode {
+			case linux.SECCOMP_MODE_STRICT:
+				err = task.SetSeccompStrict()
+			case linux.SECCOMP_MODE_FILTER:
+				err = task.AppendSyscallFilter(p, 0)
+				if err == nil {
+					wantFilters++
+				}
+			}
+			if err != test.want {
+				t.Fatalf("got error %v, want %v", err, test.want)
+			}
+			wantMode := test.newMode
+			if test.want != nil {
+				wantMode = test.mode
+			}
+			if got := task.SeccompMode(); got != wantMode {
+				t.Errorf("SeccompMode got %s, want %s", modeNames[got], modeNames[wantMode])
+			}
+			if got := task.SeccompFilterCount(); got != wantFilters {
+				t.Errorf("SeccompFilterCount got %d, want %d", got, wantFilters)
+			}
+		})
+	}
+}
+
+func TestSeccompFilterBytes(t *testing.T) {
+	defer atomic.StoreUint64(&MaxSeccompFilterBytes, atomic.LoadUint64(&MaxSeccompFilterBytes))
+	p := seccompTestProgram(t, 8)
+	tasks := newSeccompTestThreadGroup(2)
+	leader, other := tasks[0], tasks[1]
+	size := leader.newSyscallFilter(p, 0).size
+	if min := uint64(2 * p.Length() * bpfInstructionSize); size < min {
+		t.Fatalf("filter size got %d, want at least %d", size, min)
+	}
+
+	// Allow two filters, but not three.
+	atomic.StoreUint64(&MaxSeccompFilterBytes, 2*size+size/2)
+	installed := seccompFilterBytesInstalledMetric.Value()
+	for i := 0; i < 2; i++ {
+		if err := leader.AppendSyscallFilter(p, 0); err != nil {
+			t.Fatalf("AppendSyscallFilter %d failed: %v", i, err)
+		}
+	}
+	if err := leader.AppendSyscallFilter(p, 0); err != syserror.ENOMEM {
+		t.Fatalf("AppendSyscallFilter exceeding the limit got error %v, want %v", err, syserror.ENOMEM)
+	}
+	if got, want := leader.SeccompFilterCount(), 2; got != want {
+		t.Errorf("SeccompFilterCount got %d, want %d", got, want)
+	}
+	if current, limit := leader.SeccompFilterBytes(); current != 2*size || limit != 2*size+size/2 {
+		t.Errorf("SeccompFilterBytes got (%d, %d), want (%d, %d)", current, limit, 2*size, 2*size+size/2)
+	}
+	if got := seccompFilterBytesInstalledMetric.Value() - installed; got != 2*size {
+		t.Errorf("installed filter bytes got %d, want %d", got, 2*size)
+	}
+
+	// Without a limit, synchronizing the filters copies the leader's chain,
+	// which is shared rather than charged again for each thread.
+	atomic.StoreUint64(&MaxSeccompFilterBytes, 0)
+	installed = seccompFilterBytesInstalledMetric.Value()
+	if err := leader.SyncSyscallFiltersToThreadGroup(p, 0); err != nil {
+		t.Fatalf("SyncSyscallFiltersToThreadGroup failed: %v", err)
+	}
+	if got := seccompFilterBytesInstalledMetric.Value() - installed; got != size {
+		t.Errorf("installed filter bytes after synchronization got %d, want %d", got, size)
+	}
+	for _, task := range tasks {
+		if current, limit := task.SeccompFilterBytes(); current != 3*size || limit != 0 {
+			t.Errorf("SeccompFilterBytes of thread %d got (%d, %d), want (%d, 0)", task.ThreadID(), current, limit, 3*size)
+		}
+	}
+
+	// The limit applies to each task's whole chain, including filters it
+	// shares with other tasks.
+	atomic.StoreUint64(&MaxSeccompFilterBytes, 3*size)
+	if err := other.AppendSyscallFilter(p, 0); err != syserror.ENOMEM {
+		t.Errorf("AppendSyscallFilter exceeding the limit after synchronization got error %v, want %v", err, syserror.ENOMEM)
+	}
+}
+
+func TestAppendSyscallFiltersAtomic(t *testing.T) {
+	task := newSeccompTestTask()
+	task.noNewPrivs = true
+	// Fill most of the length budget, leaving room for a short filter but
+	// not a long one.
+	const existing = 7
+	var filters []*syscallFilter
+	for i := 0; i < existing; i++ {
+		filters = append(filters, task.newSyscallFilter(seccompTestProgram(t, bpf.MaxInstructions), 0))
+	}
+	task.syscallFilters.Store(filters)
+
+	short := seccompTestProgram(t, 1)
+	long := seccompTestProgram(t, bpf.MaxInstructions)
+	if err := task.AppendSyscallFilters([]SeccompFilter{{Program: short}, {Program: long}}); err != syserror.ENOMEM {
+		t.Fatalf("AppendSyscallFilters overflowing the budget got error %v, want %v", err, syserror.ENOMEM)
+	}
+	if got := task.SeccompFilterCount(); got != existing {
+		t.Errorf("SeccompFilterCount after failed AppendSyscallFilters got %d, want %d", got, existing)
+	}
+
+	// An invalid program anywhere in the batch also prevents installation.
+	invalid, err := bpf.Compile([]linux.BPFInstruction{bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, SeccompDataSize), bpf.Stmt(bpf.Ret|bpf.A, 0)})
+	if err != nil {
+		t.Fatalf("bpf.Compile failed: %v", err)
+	}
+	if err := task.AppendSyscallFilters([]SeccompFilter{{Program: short}, {Program: invalid}}); err != syserror.EINVAL {
+		t.Fatalf("AppendSyscallFilters with an invalid program got error %v, want %v", err, syserror.EINVAL)
+	}
+	if got := task.SeccompFilterCount(); got != existing {
+		t.Errorf("SeccompFilterCount after failed AppendSyscallFilters got %d, want %d", got, existing)
+	}
+
+	// Filters that fit are installed in order.
+	if err := task.AppendSyscallFilters([]SeccompFilter{{Program: short}, {Program: short, Flags: linux.SECCOMP_FILTER_FLAG_LOG}}); err != nil {
+		t.Fatalf("AppendSyscallFilters failed: %v", err)
+	}
+	got := task.GetSeccompFilters()
+	if len(got) != existing+2 {
+		t.Fatalf("GetSeccompFilters got %d filters, want %d", len(got), existing+2)
+	}
+	if got[existing].Flags != 0 || got[existing+1].Flags != linux.SECCOMP_FILTER_FLAG_LOG {
+		t.Errorf("GetSeccompFilters got flags %#x, %#x for the new filters, want 0, %#x", got[existing].Flags, got[existing+1].Flags, linux.SECCOMP_FILTER_FLAG_LOG)
+	}
+}
+
+func TestInstallSeccompFilter(t *testing.T) {
+	encode := func(insns ...linux.BPFInstruction) []byte {
+		return binary.Marshal(nil, usermem.ByteOrder, insns)
+	}
+	allow := encode(bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ALLOW))
+	for _, test := range []struct {
+		name       string
+		buf        []byte
+		flags      uint32
+		noNewPrivs bool
+		want       error
+	}{
+		{
+			name:       "valid",
+			buf:        allow,
+			noNewPrivs: true,
+		},
+		{
+			name:       "log",
+			buf:        allow,
+			flags:      linux.SECCOMP_FILTER_FLAG_LOG,
+			noNewPrivs: true,
+		},
+		{
+			name:       "tsync",
+			buf:        allow,
+			flags:      linux.SECCOMP_FILTER_FLAG_TSYNC,
+			noNewPrivs: true,
+		},
+		{
+			name:       "spec allow",
+			buf:        allow,
+			flags:      linux.SECCOMP_FILTER_FLAG_SPEC_ALLOW | linux.SECCOMP_FILTER_FLAG_LOG,
+			noNewPrivs: true,
+		},
+		{
+			name:       "new listener",
+			buf:        allow,
+			flags:      linux.SECCOMP_FILTER_FLAG_NEW_LISTENER,
+			noNewPrivs: true,
+			want:       syserror.EINVAL,
+		},
+		{
+			name:       "empty",
+			noNewPrivs: true,
+			want:       syserror.EINVAL,
+		},
+		{
+			name:       "partial instruction",
+			buf:        allow[:len(allow)-1],
+			noNewPrivs: true,
+			want:       syserror.EINVAL,
+		},
+		{
+			name:       "no return",
+			buf:        encode(bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 0)),
+			noNewPrivs: true,
+			want:       syserror.EINVAL,
+		},
+		{
+			name:       "load out of bounds",
+			buf:        encode(bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, SeccompDataSize), bpf.Stmt(bpf.Ret|bpf.A, 0)),
+			noNewPrivs: true,
+			want:       syserror.EINVAL,
+		},
+		{
+			name: "unprivileged",
+			buf:  allow,
+			want: syserror.EACCES,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			task := newSeccompTestTask()
+			task.creds = auth.NewAnonymousCredentials()
+			task.noNewPrivs = test.noNewPrivs
+			task.tg.signalHandlers = NewSignalHandlers()
+			task.tg.tasks.PushBack(task)
+			if err := task.InstallSeccompFilter(test.buf, test.flags); err != test.want {
+				t.Fatalf("InstallSeccompFilter got error %v, want %v", err, test.want)
+			}
+			var want []SeccompFilter
+			if test.want == nil {
+				want = []SeccompFilter{{Flags: test.flags &^ linux.SECCOMP_FILTER_FLAG_TSYNC}}
+			}
+			got := task.GetSeccompFilters()
+			if len(got) != len(want) {
+				t.Fatalf("GetSeccompFilters got %d filters, want %d", len(got), len(want))
+			}
+			for i := range got {
+				if got[i].Flags != want[i].Flags {
+					t.Errorf("filter %d got flags %#x, want %#x", i, got[i].Flags, want[i].Flags)
+				}
+				if !reflect.DeepEqual(got[i].Program.Instructions(), []linux.BPFInstruction{bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ALLOW)}) {
+					t.Errorf("filter %d got program %v, want the installed program", i, got[i].Program.Instructions())
+				}
+			}
+		})
+	}
+}
+
+func TestCheckSeccompProgram(t *testing.T) {
+	// negative converts a negative offset, such as linux.SKF_AD_OFF, to the
+	// K of a load from that offset.
+	negative := func(off int32) uint32 {
+		return uint32(off)
+	}
+	for _, test := range []struct {
+		name  string
+		insns []linux.BPFInstruction
+		want  error
+	}{
+		{
+			name: "valid",
+			insns: []linux.BPFInstruction{
+				bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 60), // args[5], high half
+				bpf.Stmt(bpf.Alu|bpf.Div|bpf.K, 2),
+				bpf.Stmt(bpf.Ld|bpf.Len|bpf.W, 0),
+				bpf.Stmt(bpf.Ret|bpf.A, 0),
+			},
+		},
+		{
+			name: "load out of bounds",
+			insns: []linux.BPFInstruction{
+				bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 64),
+				bpf.Stmt(bpf.Ret|bpf.A, 0),
+			},
+			want: syserror.EINVAL,
+		},
+		{
+			name: "misaligned load",
+			insns: []linux.BPFInstruction{
+				bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 2),
+				bpf.Stmt(bpf.Ret|bpf.A, 0),
+			},
+			want: syserror.EINVAL,
+		},
+		{
+			name: "ancillary data load",
+			insns: []linux.BPFInstruction{
+				bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, negative(linux.SKF_AD_OFF)),
+				bpf.Stmt(bpf.Ret|bpf.A, 0),
+			},
+			want: syserror.EINVAL,
+		},
+		{
+			name: "network header load",
+			insns: []linux.BPFInstruction{
+				bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, negative(linux.SKF_NET_OFF)),
+				bpf.Stmt(bpf.Ret|bpf.A, 0),
+			},
+			want: syserror.EINVAL,
+		},
+		{
+			name: "link layer header load",
+			insns: []linux.BPFInstruction{
+				bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, negative(linux.SKF_LL_OFF+12)),
+				bpf.Stmt(bpf.Ret|bpf.A, 0),
+			},
+			want: syserror.EINVAL,
+		},
+		{
+			name: "halfword load",
+			insns: []linux.BPFInstruction{
+				bpf.Stmt(bpf.Ld|bpf.Abs|bpf.H, 0),
+				bpf.Stmt(bpf.Ret|bpf.A, 0),
+			},
+			want: syserror.EINVAL,
+		},
+		{
+			name: "indirect load",
+			insns: []linux.BPFInstruction{
+				bpf.Stmt(bpf.Ld|bpf.Ind|bpf.W, 0),
+				bpf.Stmt(bpf.Ret|bpf.A, 0),
+			},
+			want: syserror.EINVAL,
+		},
+		{
+			name: "modulo",
+			insns: []linux.BPFInstruction{
+				bpf.Stmt(bpf.Alu|bpf.Mod|bpf.K, 2),
+				bpf.Stmt(bpf.Ret|bpf.A, 0),
+			},
+			want: syserror.EINVAL,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := bpf.Compile(test.insns)
+			if err != nil {
+				t.Fatalf("bpf.Compile failed: %v", err)
+			}
+			if err := checkSeccompProgram(p); err != test.want {
+				t.Errorf("checkSeccompProgram got error %v, want %v", err, test.want)
+			}
+			task := newSeccompTestTask()
+			task.noNewPrivs = true
+			if err := task.AppendSyscallFilter(p, 0); err != test.want {
+				t.Errorf("AppendSyscallFilter got error %v, want %v", err, test.want)
+			}
+		})
+	}
+}
+
+func TestSeccompStrict(t *testing.T) {
+	task := newSeccompTestTask()
+	task.tc.st.SeccompStrict = []uintptr{0, 1, 15, 60}
+	if err := task.SetSeccompStrict(); err != nil {
+		t.Fatalf("SetSeccompStrict failed: %v", err)
+	}
+	if got := task.SeccompMode(); got != linux.SECCOMP_MODE_STRICT {
+		t.Errorf("SeccompMode got %d, want %d", got, linux.SECCOMP_MODE_STRICT)
+	}
+	for _, test := range []struct {
+		sysno int32
+		want  seccompResult
+	}{
+		{0, seccompResultAllow},
+		{1, seccompResultAllow},
+		{15, seccompResultAllow},
+		{60, seccompResultAllow},
+		{39, seccompResultKillStrict},
+		{231, seccompResultKillStrict},
+	} {
+		if got := task.checkSeccompSyscall(test.sysno, arch.SyscallArguments{}, 0); got != test.want {
+			t.Errorf("checkSeccompSyscall(%d) got %v, want %v", test.sysno, got, test.want)
+		}
+	}
+
+	// Filters can't be installed in strict mode.
+	task.noNewPrivs = true
+	p := seccompTestProgram(t, 1)
+	if err := task.AppendSyscallFilter(p, 0); err != syserror.EINVAL {
+		t.Errorf("AppendSyscallFilter in strict mode got error %v, want %v", err, syserror.EINVAL)
+	}
+}
+
+func TestSeccompStrictAfterFilter(t *testing.T) {
+	task := newSeccompTestTask()
+	task.noNewPrivs = true
+	if err := task.AppendSyscallFilter(seccompTestProgram(t, 1), 0); err != nil {
+		t.Fatalf("AppendSyscallFilter failed: %v", err)
+	}
+	if err := task.SetSeccompStrict(); err != syserror.EINVAL {
+		t.Errorf("SetSeccompStrict with filters got error %v, want %v", err, syserror.EINVAL)
+	}
+	if got := task.SeccompMode(); got != linux.SECCOMP_MODE_FILTER {
+		t.Errorf("SeccompMode got %d, want %d", got, linux.SECCOMP_MODE_FILTER)
+	}
+}
+
+func TestSeccompMetadataSize(t *testing.T) {
+	// struct seccomp_metadata consists of two __u64s.
+	if got, want := binary.Size(linux.SeccompMetadata{}), uintptr(16); got != want {
+		t.Errorf("sizeof(struct seccomp_metadata) got %d, want %d", got, want)
+	}
+}
+
+func TestLoadSyscallFilters(t *testing.T) {
+	p, err := bpf.Compile([]linux.BPFInstruction{
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ALLOW),
+	})
+	if err != nil {
+		t.Fatalf("bpf.Compile failed: %v", err)
+	}
+	f := &syscallFilter{program: p, flags: linux.SECCOMP_FILTER_FLAG_LOG}
+
+	// Tasks that shared filters when saved should still share them when
+	// restored.
+	t1, t2 := newSeccompTestTask(), newSeccompTestTask()
+	t1.loadSyscallFilters([]*syscallFilter{f})
+	t2.loadSyscallFilters([]*syscallFilter{f})
+	if got := t1.SeccompMode(); got != linux.SECCOMP_MODE_FILTER {
+		t.Errorf("SeccompMode got %d, want %d", got, linux.SECCOMP_MODE_FILTER)
+	}
+	f1, err := t1.seccompFilter(0)
+	if err != nil {
+		t.Fatalf("seccompFilter failed: %v", err)
+	}
+	f2, err := t2.seccompFilter(0)
+	if err != nil {
+		t.Fatalf("seccompFilter failed: %v", err)
+	}
+	if f1 != f2 || f1.flags != linux.SECCOMP_FILTER_FLAG_LOG {
+		t.Errorf("restored filters got (%p, %p) with flags %#x, want shared filter with flags %#x", f1, f2, f1.flags, linux.SECCOMP_FILTER_FLAG_LOG)
+	}
+}
+
+// seccompTestProgram returns a BPF program of length n that allows all system
+// calls.
+func seccompTestProgram(t *testing.T, n int) bpf.Program {
+	insns := make([]linux.BPFInstruction, n)
+	insns[n-1] = bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ALLOW)
+	p, err := bpf.Compile(insns)
+	if err != nil {
+		t.Fatalf("bpf.Compile failed: %v", err)
+	}
+	return p
+}
+
+func TestSyscallFiltersLength(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		lengths []int
+		want    int
+	}{
+		{
+			name: "no filters",
+		},
+		{
+			name:    "one filter",
+			lengths: []int{10},
+			want:    10,
+		},
+		{
+			// The most recently installed filter incurs no penalty.
+			name:    "three filters",
+			lengths: []int{10, 20, 30},
+			want:    10 + 4 + 20 + 4 + 30,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var filters []*syscallFilter
+			for _, l := range test.lengths {
+				filters = append(filters, &syscallFilter{program: seccompTestProgram(t, l)})
+			}
+			if got := syscallFiltersLength(filters); got != test.want {
+				t.Errorf("syscallFiltersLength got %d, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestAddSyscallFiltersLength(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		lengths []int
+		want    int
+	}{
+		{
+			name:    "small",
+			lengths: []int{10, syscallFilterOverhead, 20},
+			want:    34,
+		},
+		{
+			name:    "max",
+			lengths: []int{maxInt - 1, 1},
+			want:    maxInt,
+		},
+		{
+			// Without saturation, this sum would wrap around to a small
+			// length within maxSyscallFilterInstructions.
+			name:    "wraps",
+			lengths: []int{maxInt, maxInt, 2},
+			want:    maxInt,
+		},
+		{
+			name:    "penalty overflows",
+			lengths: []int{maxInt - 2, syscallFilterOverhead, 1},
+			want:    maxInt,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var total int
+			for _, l := range test.lengths {
+				total = addSyscallFiltersLength(total, l)
+			}
+			if total != test.want {
+				t.Errorf("total length got %d, want %d", total, test.want)
+			}
+			// A chain whose length saturates exceeds the limit, so
+			// installing it fails with ENOMEM rather than succeeding.
+			if test.want == maxInt && total <= maxSyscallFilterInstructions {
+				t.Errorf("saturated length %d within limit %d", total, maxSyscallFilterInstructions)
+			}
+		})
+	}
+}
+
+func TestAppendSyscallFilterLength(t *testing.T) {
+	// Seven maximum-length filters leave room for a final filter of the
+	// following length.
+	const existing = 7
+	const remaining = maxSyscallFilterInstructions - existing*(bpf.MaxInstructions+syscallFilterOverhead)
+	for _, test := range []struct {
+		name   string
+		length int
+		want   error
+	}{
+		{
+			name:   "at limit",
+			length: remaining,
+		},
+		{
+			name:   "over limit",
+			length: remaining + 1,
+			want:   syserror.ENOMEM,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			task := newSeccompTestTask()
+			task.noNewPrivs = true
+			var filters []*syscallFilter
+			for i := 0; i < existing; i++ {
+				filters = append(filters, &syscallFilter{program: seccompTestProgram(t, bpf.MaxInstructions)})
+			}
+			task.syscallFilters.Store(filters)
+			if err := task.AppendSyscallFilter(seccompTestProgram(t, test.length), 0); err != test.want {
+				t.Errorf("AppendSyscallFilter of length %d got error %v, want %v", test.length, err, test.want)
+			}
+		})
+	}
+}
+
+func TestAppendSyscallFilterCopyOnWrite(t *testing.T) {
+	// Leave spare capacity in the shared slice, so that appending to it in
+	// place would be observable.
+	shared := make([]*syscallFilter, 1, 2)
+	shared[0] = &syscallFilter{program: seccompTestProgram(t, 1)}
+	task := newSeccompTestTask()
+	task.noNewPrivs = true
+	task.syscallFilters.Store(shared)
+	if err := task.AppendSyscallFilter(seccompTestProgram(t, 1), 0); err != nil {
+		t.Fatalf("AppendSyscallFilter failed: %v", err)
+	}
+	if shared[:2][1] != nil {
+		t.Errorf("AppendSyscallFilter modified shared filter slice")
+	}
+	got := task.syscallFilters.Load().([]*syscallFilter)
+	if len(got) != 2 || got[0] != shared[0] {
+		t.Errorf("AppendSyscallFilter got filters %v, want [%p <new filter>]", got, shared[0])
+	}
+}
+
+func TestLoadSyscallFiltersTooLong(t *testing.T) {
+	insns := make([]linux.BPFInstruction, bpf.MaxInstructions)
+	insns[len(insns)-1] = bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ALLOW)
+	p, err := bpf.Compile(insns)
+	if err != nil {
+		t.Fatalf("bpf.Compile failed: %v", err)
+	}
+	var filters []*syscallFilter
+	for l := 0; l <= maxSyscallFilterInstructions; l += p.Length() + syscallFilterOverhead {
+		filters = append(filters, &syscallFilter{program: p})
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("loadSyscallFilters with length %d did not panic", syscallFiltersLength(filters))
+		}
+	}()
+	newSeccompTestTask().loadSyscallFilters(filters)
+}
+
+func TestSeccompListenerAddFDErrors(t *testing.T) {
+	l := NewSeccompListener()
+	l.pending = []*seccompNotification{{id: 1, wake: make(chan struct{}, 1)}}
+	busy := &seccompNotification{
+		id:     2,
+		task:   newSeccompTestTask(),
+		wake:   make(chan struct{}, 1),
+		state:  seccompNotificationSent,
+		addFDs: []*seccompAddFDRequest{{done: make(chan struct{})}},
+	}
+	l.sent = map[uint64]*seccompNotification{2: busy}
+
+	for _, test := range []struct {
+		desc string
+		id   uint64
+		add  SeccompAddFD
+		want error
+	}{
+		{desc: "unknown notification", id: 3, want: syserror.ENOENT},
+		{desc: "notification not yet received", id: 1, want: syscall.EINPROGRESS},
+		{desc: "send with outstanding request", id: 2, add: SeccompAddFD{Send: true}, want: syserror.EBUSY},
+	} {
+		// None of these cases block, so no task is needed.
+		if _, err := l.AddFD(nil, test.id, test.add); err != test.want {
+			t.Errorf("%s: AddFD got error %v, want %v", test.desc, err, test.want)
+		}
+	}
+	if busy.state != seccompNotificationSent || l.sent[2] != busy {
+		t.Errorf("AddFD with SECCOMP_ADDFD_FLAG_SEND changed the state of a busy notification")
+	}
+}
+
+// interruptOnlyContext is a platform.Context that can only be interrupted.
+type interruptOnlyContext struct {
+	platform.Context
+}
+
+// Interrupt implements platform.Context.Interrupt.
+func (interruptOnlyContext) Interrupt() {}
+
+// newSeccompNotifyTestTask returns a Task on which SeccompListener.notify can
+// block on a goroutine other than a real task goroutine, and be killed.
+func newSeccompNotifyTestTask(k *Kernel) *Task {
+	t := newSeccompTestTask()
+	t.k = k
+	t.p = interruptOnlyContext{}
+	t.interruptChan = make(chan struct{}, 1)
+	t.tg.signalHandlers = NewSignalHandlers()
+	t.gosched.State = TaskGoroutineRunningSys
+	return t
+}
+
+func TestSeccompListenerInheritedOnFork(t *testing.T) {
+	p, err := bpf.Compile([]linux.BPFInstruction{
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_USER_NOTIF),
+	})
+	if err != nil {
+		t.Fatalf("bpf.Compile failed: %v", err)
+	}
+	k := &Kernel{}
+	parent := newSeccompNotifyTestTask(k)
+	parent.noNewPrivs = true
+	l, err := parent.AppendSyscallFilterWithListener(p, 0)
+	if err != nil {
+		t.Fatalf("AppendSyscallFilterWithListener failed: %v", err)
+	}
+	defer l.Release()
+	child := newSeccompNotifyTestTask(k)
+	child.tg.pidns = parent.tg.pidns
+	parent.tg.pidns.tids[child] = 2
+	parent.inheritSeccompLocked(child)
+	if got := child.seccompListener(); got != l {
+		t.Fatalf("child's listener got %p, want parent's %p", got, l)
+	}
+
+	e, ch := waiter.NewChannelEntry(nil)
+	l.EventRegister(&e, waiter.EventIn|waiter.EventHUp)
+	defer l.EventUnregister(&e)
+
+	// Notifications from the parent and child are received by the same
+	// supervisor, with distinct IDs, and each is answered to its sender.
+	tasks := map[uint32]*Task{1: parent, 2: child}
+	done := make(map[uint32]chan seccompResult)
+	for pid, task := range tasks {
+		task := task
+		done[pid] = make(chan seccompResult, 1)
+		go func(d chan seccompResult) {
+			d <- task.checkSeccompSyscall(1, arch.SyscallArguments{}, 0)
+		}(done[pid])
+	}
+	ids := make(map[uint64]bool)
+	for len(ids) < len(tasks) {
+		notif, err := l.Recv(parent)
+		if err == syserror.ErrWouldBlock {
+			<-ch
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Recv failed: %v", err)
+		}
+		if tasks[notif.Pid] == nil {
+			t.Fatalf("notification got pid %d, want 1 or 2", notif.Pid)
+		}
+		if ids[notif.ID] {
+			t.Fatalf("notification ID %d received twice", notif.ID)
+		}
+		ids[notif.ID] = true
+		if err := l.Send(linux.SeccompNotifResp{ID: notif.ID, Val: int64(notif.Pid) * 10}); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+	for pid, task := range tasks {
+		if r := <-done[pid]; r != seccompResultDeny {
+			t.Errorf("pid %d: checkSeccompSyscall got %v, want %v", pid, r, seccompResultDeny)
+		}
+		if got, want := task.Arch().Return(), uintptr(pid*10); got != want {
+			t.Errorf("pid %d: return value got %d, want %d", pid, got, want)
+		}
+	}
+
+	// The listener is only orphaned once both tasks have been reaped.
+	parent.releaseSeccompLocked()
+	if l.Readiness(waiter.EventHUp) != 0 {
+		t.Errorf("listener orphaned while the child uses it")
+	}
+	child.releaseSeccompLocked()
+	if l.Readiness(waiter.EventHUp) == 0 {
+		t.Errorf("listener not orphaned after both tasks were reaped")
+	}
+}
+
+func TestSeccompListenerIDValidAfterKill(t *testing.T) {
+	k := &Kernel{}
+	l := NewSeccompListener()
+	e, ch := waiter.NewChannelEntry(nil)
+	l.EventRegister(&e, waiter.EventIn)
+	defer l.EventUnregister(&e)
+
+	// recv receives the notification sent by calling notify on a new task,
+	// and returns the task and a channel that receives notify's result.
+	recv := func() (*Task, linux.SeccompNotif, <-chan bool) {
+		task := newSeccompNotifyTestTask(k)
+		done := make(chan bool, 1)
+		go func() {
+			done <- l.notify(task, &seccompData{nr: 1, arch: linux.AUDIT_ARCH_X86_64})
+		}()
+		for {
+			notif, err := l.Recv(task)
+			if err == nil {
+				return task, notif, done
+			}
+			if err != syserror.ErrWouldBlock {
+				t.Fatalf("Recv failed: %v", err)
+			}
+			<-ch
+		}
+	}
+
+	task, notif, done := recv()
+	if err := l.IDValid(notif.ID); err != nil {
+		t.Fatalf("IDValid of pending notification got error %v, want nil", err)
+	}
+	task.tg.signalHandlers.mu.Lock()
+	task.killLocked()
+	task.tg.signalHandlers.mu.Unlock()
+	if <-done {
+		t.Errorf("notify of killed task returned true, want false")
+	}
+	if err := l.IDValid(notif.ID); err != syserror.ENOENT {
+		t.Errorf("IDValid after notifying task was killed got error %v, want %v", err, syserror.ENOENT)
+	}
+	if err := l.Send(linux.SeccompNotifResp{ID: notif.ID}); err != syserror.ENOENT {
+		t.Errorf("Send after notifying task was killed got error %v, want %v", err, syserror.ENOENT)
+	}
+
+	// A later notification gets a new ID.
+	task, next, done := recv()
+	if next.ID <= notif.ID {
+		t.Errorf("later notification got ID %d, want greater than %d", next.ID, notif.ID)
+	}
+	if err := l.Send(linux.SeccompNotifResp{ID: next.ID}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	<-done
+	if err := l.IDValid(next.ID); err != syserror.ENOENT {
+		t.Errorf("IDValid after response got error %v, want %v", err, syserror.ENOENT)
+	}
+}
+
+func TestSeccompListenerAddFDSend(t *testing.T) {
+	// The filter defers socket(2) to the supervisor, which emulates it by
+	// installing a file of its own as the system call's result.
+	p, err := bpf.Compile([]linux.BPFInstruction{
+		bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 0), // nr
+		bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, 41, 0, 1),
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_USER_NOTIF),
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ALLOW),
+	})
+	if err != nil {
+		t.Fatalf("bpf.Compile failed: %v", err)
+	}
+	k := &Kernel{}
+	supervisor := newSeccompNotifyTestTask(k)
+	e, ch := waiter.NewChannelEntry(nil)
+
+	// notify starts socket(2) in a new task with the filter installed, and
+	// returns the task, its notification, and a channel that receives the
+	// result of checkSeccompSyscall.
+	notify := func(l **SeccompListener) (*Task, linux.SeccompNotif, <-chan seccompResult) {
+		task := newSeccompNotifyTestTask(k)
+		task.noNewPrivs = true
+		task.fds = newTestFDMap()
+		task.tg.limits = limits.NewLimitSet()
+		var err error
+		if *l, err = task.AppendSyscallFilterWithListener(p, 0); err != nil {
+			t.Fatalf("AppendSyscallFilterWithListener failed: %v", err)
+		}
+		(*l).EventRegister(&e, waiter.EventIn)
+		done := make(chan seccompResult, 1)
+		go func() {
+			done <- task.checkSeccompSyscall(41, arch.SyscallArguments{{Value: 1}, {Value: 1}}, 0)
+		}()
+		for {
+			notif, err := (*l).Recv(supervisor)
+			if err == nil {
+				return task, notif, done
+			}
+			if err != syserror.ErrWouldBlock {
+				t.Fatalf("Recv failed: %v", err)
+			}
+			<-ch
+		}
+	}
+
+	t.Run("send", func(t *testing.T) {
+		var l *SeccompListener
+		task, notif, done := notify(&l)
+		defer l.Release()
+		defer l.EventUnregister(&e)
+		if notif.Data.Nr != 41 {
+			t.Fatalf("notification for system call %d, want 41", notif.Data.Nr)
+		}
+		file := filetest.NewTestFile(t)
+		fd, err := l.AddFD(supervisor, notif.ID, SeccompAddFD{File: file, Send: true})
+		if err != nil {
+			t.Fatalf("AddFD failed: %v", err)
+		}
+		if r := <-done; r != seccompResultDeny {
+			t.Errorf("checkSeccompSyscall got %v, want %v", r, seccompResultDeny)
+		}
+		if got := task.Arch().Return(); got != uintptr(fd) {
+			t.Errorf("return value got %d, want new file descriptor %d", got, fd)
+		}
+		if got := task.fds.GetFile(fd); got != file {
+			t.Errorf("file descriptor %d got file %p, want %p", fd, got, file)
+		} else {
+			got.DecRef()
+		}
+		// The notification was completed by AddFD, so it can't be responded
+		// to again.
+		if err := l.Send(linux.SeccompNotifResp{ID: notif.ID}); err != syserror.ENOENT {
+			t.Errorf("Send after AddFD with send got error %v, want %v", err, syserror.ENOENT)
+		}
+	})
+
+	t.Run("target killed", func(t *testing.T) {
+		var l *SeccompListener
+		task, notif, done := notify(&l)
+		defer l.Release()
+		defer l.EventUnregister(&e)
+		task.tg.signalHandlers.mu.Lock()
+		task.killLocked()
+		task.tg.signalHandlers.mu.Unlock()
+		if _, err := l.AddFD(supervisor, notif.ID, SeccompAddFD{File: filetest.NewTestFile(t), Send: true}); err != syserror.ENOENT {
+			t.Errorf("AddFD after notifying task was killed got error %v, want %v", err, syserror.ENOENT)
+		}
+		<-done
+		if got := task.fds.Size(); got != 0 {
+			t.Errorf("notifying task has %d file descriptors, want 0", got)
+		}
+	})
+}
+
+func TestSeccompListenerKillOrdering(t *testing.T) {
+	const val = 42
+	k := &Kernel{}
+	l := NewSeccompListener()
+	defer l.Release()
+	for i := 0; i < 100; i++ {
+		task := newSeccompNotifyTestTask(k)
+		done := make(chan bool, 1)
+		go func() {
+			done <- l.notify(task, &seccompData{nr: 1, arch: linux.AUDIT_ARCH_X86_64})
+		}()
+		var notif linux.SeccompNotif
+		for {
+			var err error
+			if notif, err = l.Recv(task); err == nil {
+				break
+			}
+			runtime.Gosched()
+		}
+
+		// Race a response against killing the notifying task. Exactly one
+		// of them must take effect.
+		sendErr := make(chan error, 1)
+		go func() {
+			sendErr <- l.Send(linux.SeccompNotifResp{ID: notif.ID, Val: val})
+		}()
+		task.tg.signalHandlers.mu.Lock()
+		task.killLocked()
+		task.tg.signalHandlers.mu.Unlock()
+
+		// The ID is invalid as soon as the task is killed, even if the task
+		// hasn't yet withdrawn its notification.
+		if err := l.IDValid(notif.ID); err != syserror.ENOENT {
+			t.Fatalf("IDValid after kill got error %v, want %v", err, syserror.ENOENT)
+		}
+		if err := l.Send(linux.SeccompNotifResp{ID: notif.ID}); err != syserror.ENOENT {
+			t.Fatalf("Send after kill got error %v, want %v", err, syserror.ENOENT)
+		}
+
+		err := <-sendErr
+		if <-done {
+			t.Fatalf("notify returned true, want false")
+		}
+		switch got := int64(task.Arch().Return()); err {
+		case nil:
+			if got != val {
+				t.Fatalf("return value after accepted response got %d, want %d", got, val)
+			}
+		case syserror.ENOENT:
+			if want := -int64(ERESTARTSYS); got != want {
+				t.Fatalf("return value after rejected response got %d, want %d", got, want)
+			}
+		default:
+			t.Fatalf("racing Send got error %v, want nil or %v", err, syserror.ENOENT)
+		}
+	}
+}
+
+func TestSeccompListenerMaxNotifications(t *testing.T) {
+	defer func(max uint32) { atomic.StoreUint32(&MaxSeccompNotifications, max) }(atomic.LoadUint32(&MaxSeccompNotifications))
+	atomic.StoreUint32(&MaxSeccompNotifications, 2)
+
+	k := &Kernel{}
+	l := NewSeccompListener()
+	defer l.Release()
+	// outstanding returns the number of notifications outstanding in l and
+	// the number of tasks waiting to send one.
+	outstanding := func() (int, int) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return len(l.pending) + len(l.sent), len(l.waiters)
+	}
+	// waitFor waits until outstanding returns (n, w).
+	waitFor := func(n, w int) {
+		for {
+			if gotN, gotW := outstanding(); gotN == n && gotW == w {
+				return
+			}
+			runtime.Gosched()
+		}
+	}
+	notify := func(task *Task) <-chan bool {
+		done := make(chan bool, 1)
+		go func() {
+			done <- l.notify(task, &seccompData{nr: 1, arch: linux.AUDIT_ARCH_X86_64})
+		}()
+		return done
+	}
+
+	var dones []<-chan bool
+	for i := 0; i < 2; i++ {
+		dones = append(dones, notify(newSeccompNotifyTestTask(k)))
+	}
+	waitFor(2, 0)
+
+	// Further notifiers wait for room rather than failing.
+	waiting := newSeccompNotifyTestTask(k)
+	dones = append(dones, notify(waiting))
+	interrupted := newSeccompNotifyTestTask(k)
+	interruptedDone := notify(interrupted)
+	waitFor(2, 2)
+	select {
+	case <-dones[2]:
+		t.Fatalf("notify returned with a full listener, want it to wait")
+	default:
+	}
+
+	// An interrupted waiter restarts its system call without sending a
+	// notification.
+	interrupted.interrupt()
+	if <-interruptedDone {
+		t.Errorf("notify of interrupted waiter returned true, want false")
+	}
+	if got, want := int64(interrupted.Arch().Return()), -int64(ERESTARTSYS); got != want {
+		t.Errorf("interrupted waiter return value got %d, want %d", got, want)
+	}
+	waitFor(2, 1)
+
+	// Responding to a notification makes room for the waiter's.
+	notif, err := l.Recv(waiting)
+	if err != nil {
+		t.Fatalf("Recv failed: %v", err)
+	}
+	if err := l.Send(linux.SeccompNotifResp{ID: notif.ID, Flags: linux.SECCOMP_USER_NOTIF_FLAG_CONTINUE}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	waitFor(2, 0)
+
+	// All notifications, including the waiter's, can be responded to.
+	for n := 2; n > 0; n-- {
+		notif, err := l.Recv(waiting)
+		if err != nil {
+			t.Fatalf("Recv failed: %v", err)
+		}
+		if err := l.Send(linux.SeccompNotifResp{ID: notif.ID, Flags: linux.SECCOMP_USER_NOTIF_FLAG_CONTINUE}); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+	for i, done := range dones {
+		if !<-done {
+			t.Errorf("notify %d returned false, want true", i)
+		}
+	}
+}
+
+func TestSeccompListenerRecvWithdrawn(t *testing.T) {
+	k := &Kernel{}
+	l := NewSeccompListener()
+	e, ch := waiter.NewChannelEntry(nil)
+	l.EventRegister(&e, waiter.EventIn)
+	defer l.EventUnregister(&e)
+
+	task := newSeccompNotifyTestTask(k)
+	done := make(chan bool, 1)
+	go func() {
+		done <- l.notify(task, &seccompData{nr: 1, arch: linux.AUDIT_ARCH_X86_64})
+	}()
+	for l.Readiness(waiter.EventIn) == 0 {
+		<-ch
+	}
+
+	// Kill the notifying task before the notification is received.
+	task.tg.signalHandlers.mu.Lock()
+	task.killLocked()
+	task.tg.signalHandlers.mu.Unlock()
+	if <-done {
+		t.Errorf("notify of killed task returned true, want false")
+	}
+	if got := l.Readiness(waiter.EventIn); got != 0 {
+		t.Errorf("Readiness after notifying task was killed got %v, want 0", got)
+	}
+	if _, err := l.Recv(task); err != syserror.ErrWouldBlock {
+		t.Errorf("Recv after notifying task was killed got error %v, want %v", err, syserror.ErrWouldBlock)
+	}
+}
+
+func TestSeccompListenerSend(t *testing.T) {
+	for _, test := range []struct {
+		desc string
+		resp linux.SeccompNotifResp
+		// If wantExec is true, the system call should be executed;
+		// otherwise, it should return wantReturn.
+		wantExec   bool
+		wantReturn int64
+	}{
+		{
+			desc:     "continue",
+			resp:     linux.SeccompNotifResp{Flags: linux.SECCOMP_USER_NOTIF_FLAG_CONTINUE},
+			wantExec: true,
+		},
+		{
+			desc:       "return value",
+			resp:       linux.SeccompNotifResp{Val: 42},
+			wantReturn: 42,
+		},
+		{
+			desc:       "errno",
+			resp:       linux.SeccompNotifResp{Error: -int32(syscall.EPERM)},
+			wantReturn: -int64(syscall.EPERM),
+		},
+		{
+			desc:       "errno takes precedence over return value",
+			resp:       linux.SeccompNotifResp{Val: 42, Error: -int32(syscall.EACCES)},
+			wantReturn: -int64(syscall.EACCES),
+		},
+	} {
+		k := &Kernel{}
+		l := NewSeccompListener()
+		e, ch := waiter.NewChannelEntry(nil)
+		l.EventRegister(&e, waiter.EventIn)
+
+		task := newSeccompNotifyTestTask(k)
+		done := make(chan bool, 1)
+		go func() {
+			done <- l.notify(task, &seccompData{nr: 1, arch: linux.AUDIT_ARCH_X86_64})
+		}()
+		for l.Readiness(waiter.EventIn) == 0 {
+			<-ch
+		}
+		l.EventUnregister(&e)
+
+		// The notification can't be responded to before it is received.
+		id := l.pending[0].id
+		test.resp.ID = id
+		if err := l.Send(test.resp); err != syscall.EINPROGRESS {
+			t.Errorf("%s: Send before Recv got error %v, want %v", test.desc, err, syscall.EINPROGRESS)
+		}
+		if _, err := l.Recv(task); err != nil {
+			t.Fatalf("%s: Recv failed: %v", test.desc, err)
+		}
+		if err := l.Send(test.resp); err != nil {
+			t.Fatalf("%s: Send failed: %v", test.desc, err)
+		}
+		if got := <-done; got != test.wantExec {
+			t.Errorf("%s: notify got %t, want %t", test.desc, got, test.wantExec)
+		}
+		if !test.wantExec {
+			if got := int64(task.Arch().Return()); got != test.wantReturn {
+				t.Errorf("%s: system call returned %d, want %d", test.desc, got, test.wantReturn)
+			}
+		}
+		// Only one response is allowed.
+		if err := l.Send(test.resp); err != syserror.ENOENT {
+			t.Errorf("%s: second Send got error %v, want %v", test.desc, err, syserror.ENOENT)
+		}
+	}
+}
+
+func TestSeccompListenerSendContinue(t *testing.T) {
+	for _, test := range []struct {
+		desc string
+		resp linux.SeccompNotifResp
+		want error
+	}{
+		{
+			desc: "continue",
+			resp: linux.SeccompNotifResp{Flags: linux.SECCOMP_USER_NOTIF_FLAG_CONTINUE},
+		},
+		{
+			desc: "continue with error",
+			resp: linux.SeccompNotifResp{Error: -int32(syscall.EPERM), Flags: linux.SECCOMP_USER_NOTIF_FLAG_CONTINUE},
+			want: syserror.EINVAL,
+		},
+		{
+			desc: "continue with return value",
+			resp: linux.SeccompNotifResp{Val: 1, Flags: linux.SECCOMP_USER_NOTIF_FLAG_CONTINUE},
+			want: syserror.EINVAL,
+		},
+		{
+			desc: "unknown flag",
+			resp: linux.SeccompNotifResp{Flags: 2},
+			want: syserror.EINVAL,
+		},
+	} {
+		l := NewSeccompListener()
+		n := &seccompNotification{
+			id:    1,
+			task:  newSeccompTestTask(),
+			wake:  make(chan struct{}, 1),
+			state: seccompNotificationSent,
+		}
+		l.sent = map[uint64]*seccompNotification{1: n}
+		test.resp.ID = 1
+		if err := l.Send(test.resp); err != test.want {
+			t.Errorf("%s: Send got error %v, want %v", test.desc, err, test.want)
+			continue
+		}
+		wantState := seccompNotificationReplied
+		if test.want != nil {
+			// Rejected responses leave the notification awaiting another.
+			wantState = seccompNotificationSent
+		}
+		if n.state != wantState {
+			t.Errorf("%s: notification state got %v, want %v", test.desc, n.state, wantState)
+		}
+	}
+}
+
+// seccompAllowlistProgram returns a program in the form recognized by
+// newSeccompAllowlist, which kills the process unless the architecture is
+// AUDIT_ARCH_X86_64 (if checkArch is true), and otherwise returns
+// SECCOMP_RET_ERRNO|i for the i-th system call number in nrs and
+// SECCOMP_RET_ALLOW for all others. If inline is true, each comparison is
+// followed by its Ret instruction, as in the example in seccomp(2); otherwise,
+// all Ret instructions follow the comparisons.
+func seccompAllowlistProgram(t testing.TB, nrs []int32, checkArch, inline bool) bpf.Program {
+	var insns []linux.BPFInstruction
+	// rets maps the pcs of jumps to the Ret instructions at the end of the
+	// program that they must jump to if inline is false.
+	rets := make(map[int]int)
+	var tail []linux.BPFInstruction
+	// archJump is the pc of the architecture check, whose false branch jumps
+	// to its Ret instruction if inline is false.
+	archJump := -1
+	if checkArch {
+		insns = append(insns, bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 4))
+		if inline {
+			insns = append(insns,
+				bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, linux.AUDIT_ARCH_X86_64, 1, 0),
+				bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_KILL_PROCESS))
+		} else {
+			archJump = len(insns)
+			rets[archJump] = len(tail)
+			insns = append(insns, bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, linux.AUDIT_ARCH_X86_64, 0, 0))
+			tail = append(tail, bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_KILL_PROCESS))
+		}
+	}
+	insns = append(insns, bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 0))
+	for i, nr := range nrs {
+		action := linux.SECCOMP_RET_ERRNO | uint32(i)
+		if inline {
+			insns = append(insns,
+				bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, uint32(nr), 0, 1),
+				bpf.Stmt(bpf.Ret|bpf.K, action))
+		} else {
+			rets[len(insns)] = len(tail)
+			insns = append(insns, bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, uint32(nr), 0, 0))
+			tail = append(tail, bpf.Stmt(bpf.Ret|bpf.K, action))
+		}
+	}
+	insns = append(insns, bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ALLOW))
+	for pc, ret := range rets {
+		off := uint8(len(insns) + ret - pc - 1)
+		if pc == archJump {
+			insns[pc].JumpIfFalse = off
+		} else {
+			insns[pc].JumpIfTrue = off
+		}
+	}
+	p, err := bpf.Compile(append(insns, tail...))
+	if err != nil {
+		t.Fatalf("bpf.Compile failed: %v", err)
+	}
+	return p
+}
+
+func TestSeccompAllowlistMatchesExec(t *testing.T) {
+	r := rand.New(rand.NewSource(0))
+	for iter := 0; iter < 100; iter++ {
+		// Duplicate system call numbers are likely.
+		nrs := make([]int32, r.Intn(50))
+		for i := range nrs {
+			nrs[i] = int32(r.Intn(64))
+		}
+		checkArch, inline := r.Intn(2) == 0, r.Intn(2) == 0
+		p := seccompAllowlistProgram(t, nrs, checkArch, inline)
+		a := newSeccompAllowlist(p)
+		if a == nil {
+			t.Fatalf("newSeccompAllowlist(%v) returned nil", p)
+		}
+		for nr := int32(-1); nr <= 64; nr++ {
+			for _, arch := range []uint32{linux.AUDIT_ARCH_X86_64, linux.AUDIT_ARCH_I386} {
+				data := seccompData{nr: nr, arch: arch}
+				want, err := bpf.Exec(p, bpf.InputBytes{binary.Marshal(nil, usermem.ByteOrder, &data), usermem.ByteOrder})
+				if err != nil {
+					t.Fatalf("bpf.Exec(%v) failed: %v", p, err)
+				}
+				if got := a.evaluate(&data); got != want {
+					t.Fatalf("program %v with nr %d, arch %#x: evaluate got %#x, want %#x", p, nr, arch, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestSeccompAllowlistRejected(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		insns []linux.BPFInstruction
+	}{
+		{
+			name: "inspects arguments",
+			insns: []linux.BPFInstruction{
+				bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 0),
+				bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, 1, 0, 2),
+				bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 16),
+				bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, 0, 0, 1),
+				bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ALLOW),
+				bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ERRNO),
+			},
+		},
+		{
+			name: "allows on mismatch",
+			insns: []linux.BPFInstruction{
+				bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 0),
+				bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, 1, 1, 0),
+				bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ALLOW),
+				bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 0),
+				bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, 2, 0, 1),
+				bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ALLOW),
+				bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ERRNO),
+			},
+		},
+		{
+			name: "compares ranges",
+			insns: []linux.BPFInstruction{
+				bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 0),
+				bpf.Jump(bpf.Jmp|bpf.Jge|bpf.K, 10, 0, 1),
+				bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ERRNO),
+				bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ALLOW),
+			},
+		},
+		{
+			name: "checks arch after nr",
+			insns: []linux.BPFInstruction{
+				bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 0),
+				bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, 1, 2, 0),
+				bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 4),
+				bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, linux.AUDIT_ARCH_X86_64, 1, 0),
+				bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ERRNO),
+				bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ALLOW),
+			},
+		},
+		{
+			name: "returns A",
+			insns: []linux.BPFInstruction{
+				bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 0),
+				bpf.Stmt(bpf.Ret|bpf.A, 0),
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := bpf.Compile(test.insns)
+			if err != nil {
+				t.Fatalf("bpf.Compile failed: %v", err)
+			}
+			if a := newSeccompAllowlist(p); a != nil {
+				t.Errorf("newSeccompAllowlist(%v) got %+v, want nil", p, a)
+			}
+		})
+	}
+}
+
+// seccompBenchmarkNrs returns the system call numbers allowed by the filters
+// used by the allowlist benchmarks.
+func seccompBenchmarkNrs() []int32 {
+	nrs := make([]int32, 100)
+	for i := range nrs {
+		nrs[i] = int32(2 * i)
+	}
+	return nrs
+}
+
+func BenchmarkSeccompAllowlist(b *testing.B) {
+	a := newSeccompAllowlist(seccompAllowlistProgram(b, seccompBenchmarkNrs(), true /* checkArch */, true /* inline */))
+	data := seccompData{nr: 199, arch: linux.AUDIT_ARCH_X86_64}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		a.evaluate(&data)
+	}
+}
+
+func BenchmarkSeccompAllowlistInterpreted(b *testing.B) {
+	p := seccompAllowlistProgram(b, seccompBenchmarkNrs(), true /* checkArch */, true /* inline */)
+	data := seccompData{nr: 199, arch: linux.AUDIT_ARCH_X86_64}
+	var input seccompInput
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bpf.Exec(p, input.load(&data))
+	}
+}
+
+func TestFormatSeccompAudit(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		ev   apb.SeccompAudit
+		want string
+	}{
+		{
+			name: "kill",
+			ev: apb.SeccompAudit{
+				Pid:     7,
+				Comm:    "cat",
+				Exe:     "/bin/cat",
+				Sig:     int32(linux.SIGSYS),
+				Arch:    linux.AUDIT_ARCH_X86_64,
+				Syscall: 2,
+				Ip:      0x7f0000001234,
+				Code:    linux.SECCOMP_RET_KILL_PROCESS,
+			},
+			want: `type=SECCOMP msg=audit(1546300800.250:3): auid=4294967295 uid=0 gid=0 ses=4294967295 pid=7 comm="cat" exe="/bin/cat" sig=31 arch=c000003e syscall=2 compat=0 ip=0x7f0000001234 code=0x80000000`,
+		},
+		{
+			name: "untrusted strings",
+			ev: apb.SeccompAudit{
+				Uid:     1000,
+				Gid:     1000,
+				Pid:     1,
+				Comm:    "a b",
+				Arch:    linux.AUDIT_ARCH_I386,
+				Syscall: 1,
+				Compat:  true,
+				Code:    linux.SECCOMP_RET_LOG,
+			},
+			want: `type=SECCOMP msg=audit(1546300800.250:3): auid=4294967295 uid=1000 gid=1000 ses=4294967295 pid=1 comm=612062 exe=(null) sig=0 arch=40000003 syscall=1 compat=1 ip=0x0 code=0x7ffc0000`,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			now := time.Unix(1546300800, 250*int64(time.Millisecond))
+			if got := formatSeccompAudit(now, 3, &test.ev); got != test.want {
+				t.Errorf("formatSeccompAudit got:\n%s\nwant:\n%s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSeccompAuditLogged(t *testing.T) {
+	unlogged := &syscallFilter{}
+	logged := &syscallFilter{flags: linux.SECCOMP_FILTER_FLAG_LOG}
+	for _, test := range []struct {
+		action    uint32
+		want      bool
+		wantFlags bool
+	}{
+		{linux.SECCOMP_RET_ALLOW, false, false},
+		{linux.SECCOMP_RET_LOG, true, true},
+		{linux.SECCOMP_RET_ERRNO, false, true},
+		{linux.SECCOMP_RET_TRAP, false, true},
+		{linux.SECCOMP_RET_TRACE, false, true},
+		{linux.SECCOMP_RET_USER_NOTIF, false, true},
+		{linux.SECCOMP_RET_KILL_THREAD, true, true},
+		{linux.SECCOMP_RET_KILL_PROCESS, true, true},
+		{0x12340000, true, true},
+	} {
+		if got := seccompAuditLogged(test.action, unlogged); got != test.want {
+			t.Errorf("seccompAuditLogged(%#x) got %v, want %v", test.action, got, test.want)
+		}
+		if got := seccompAuditLogged(test.action, logged); got != test.wantFlags {
+			t.Errorf("seccompAuditLogged(%#x) with SECCOMP_FILTER_FLAG_LOG got %v, want %v", test.action, got, test.wantFlags)
+		}
+	}
+}
+
+// auditEmitter implements eventchannel.Emitter by recording AUDIT_SECCOMP
+// events.
+type auditEmitter struct {
+	mu     sync.Mutex
+	events []*apb.SeccompAudit
+}
+
+// Emit implements eventchannel.Emitter.Emit.
+func (e *auditEmitter) Emit(msg proto.Message) (bool, error) {
+	if ev, ok := msg.(*apb.SeccompAudit); ok {
+		e.mu.Lock()
+		e.events = append(e.events, ev)
+		e.mu.Unlock()
+	}
+	return false, nil
+}
+
+// Close implements eventchannel.Emitter.Close.
+func (*auditEmitter) Close() error {
+	return nil
+}
+
+func TestSeccompVsyscallInstructionPointer(t *testing.T) {
+	// The amd64 vsyscall entry points, as in the amd64 syscall table's
+	// Emulate map.
+	for _, test := range []struct {
+		entry usermem.Addr
+		sysno int32
+	}{
+		{0xffffffffff600000, 96},  // gettimeofday(2)
+		{0xffffffffff600400, 201}, // time(2)
+		{0xffffffffff600800, 309}, // getcpu(2)
+	} {
+		// Fail the system call with EPERM if and only if it was made at the
+		// vsyscall entry point.
+		p, err := bpf.Compile([]linux.BPFInstruction{
+			bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 8), // A = instruction_pointer (low)
+			bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, uint32(test.entry), 0, 3),
+			bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 12), // A = instruction_pointer (high)
+			bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, uint32(test.entry>>32), 0, 1),
+			bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ERRNO|uint32(syscall.EPERM)),
+			bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ALLOW),
+		})
+		if err != nil {
+			t.Fatalf("bpf.Compile failed: %v", err)
+		}
+		task := newSeccompTestTask()
+		task.noNewPrivs = true
+		if err := task.AppendSyscallFilter(p, 0); err != nil {
+			t.Fatalf("AppendSyscallFilter failed: %v", err)
+		}
+
+		// The vsyscall's caller is elsewhere, but the faulting instruction,
+		// and hence the application's instruction pointer, is the entry point.
+		// doVsyscall passes the entry point, not the caller's address.
+		task.Arch().SetIP(0x400000)
+		if r := task.checkSeccompSyscall(test.sysno, arch.SyscallArguments{}, test.entry); r != seccompResultDeny {
+			t.Errorf("checkSeccompSyscall(%d) at vsyscall entry %#x got %v, want %v", test.sysno, test.entry, r, seccompResultDeny)
+		} else if got := int64(task.Arch().Return()); got != -int64(syscall.EPERM) {
+			t.Errorf("checkSeccompSyscall(%d) at vsyscall entry %#x returned %d, want %d", test.sysno, test.entry, got, -int64(syscall.EPERM))
+		}
+		if r := task.checkSeccompSyscall(test.sysno, arch.SyscallArguments{}, usermem.Addr(task.Arch().IP())); r != seccompResultAllow {
+			t.Errorf("checkSeccompSyscall(%d) at %#x got %v, want %v", test.sysno, task.Arch().IP(), r, seccompResultAllow)
+		}
+
+		// SIGSYS reports the same address.
+		data := task.seccompData(test.sysno, arch.SyscallArguments{}, test.entry)
+		if si := seccompSiginfo(&data, 0); si.CallAddr() != uint64(test.entry) {
+			t.Errorf("seccompSiginfo call address got %#x, want %#x", si.CallAddr(), test.entry)
+		}
+	}
+}
+
+func TestSeccompAuditEmitted(t *testing.T) {
+	e := &auditEmitter{}
+	eventchannel.AddEmitter(e)
+
+	task := newSeccompTestTask()
+	task.tc.st.SeccompStrict = []uintptr{0}
+	if err := task.SetSeccompStrict(); err != nil {
+		t.Fatalf("SetSeccompStrict failed: %v", err)
+	}
+	task.checkSeccompSyscall(39, arch.SyscallArguments{}, 0x1000)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.events) != 1 {
+		t.Fatalf("got %d AUDIT_SECCOMP events, want 1", len(e.events))
+	}
+	ev := e.events[0]
+	if ev.Sig != int32(linux.SIGKILL) || ev.Syscall != 39 || ev.Ip != 0x1000 || ev.Code != linux.SECCOMP_RET_KILL_THREAD || ev.Pid != 1 {
+		t.Errorf("got event %+v, want sig=%d syscall=39 ip=0x1000 code=%#x pid=1", ev, linux.SIGKILL, linux.SECCOMP_RET_KILL_THREAD)
+	}
+	if !strings.HasPrefix(ev.Record, "type=SECCOMP msg=audit(") {
+		t.Errorf("got record %q, want an AUDIT_SECCOMP record", ev.Record)
+	}
+}
+
+func TestEvaluateSyscallFiltersPrecedence(t *testing.T) {
+	task := newSeccompTestTask()
+	actions := []uint32{
+		linux.SECCOMP_RET_ALLOW,
+		linux.SECCOMP_RET_LOG,
+		linux.SECCOMP_RET_ERRNO | 1,
+		linux.SECCOMP_RET_KILL_THREAD,
+		linux.SECCOMP_RET_KILL_PROCESS,
+		// An invalid action with the sign bit set, which is less restrictive
+		// than SECCOMP_RET_KILL_PROCESS.
+		0x80010000,
+	}
+	filters := make([]*syscallFilter, len(actions))
+	for i, action := range actions {
+		p, err := bpf.Compile([]linux.BPFInstruction{bpf.Stmt(bpf.Ret|bpf.K, action)})
+		if err != nil {
+			t.Fatalf("bpf.Compile failed: %v", err)
+		}
+		filters[i] = task.newSyscallFilter(p, 0)
+	}
+
+	// Try all chains of three filters, where the last filter is evaluated
+	// first.
+	data := seccompData{nr: 1, arch: linux.AUDIT_ARCH_X86_64}
+	for i := range actions {
+		for j := range actions {
+			for k := range actions {
+				chain := []*syscallFilter{filters[i], filters[j], filters[k]}
+				task.syscallFilters.Store(chain)
+
+				// The result is the most restrictive action, from the most
+				// recently installed filter that returned it.
+				want, wantFilter := uint32(0), (*syscallFilter)(nil)
+				for l := len(chain) - 1; l >= 0; l-- {
+					ret, _ := bpf.Exec(chain[l].program, bpf.InputBytes{binary.Marshal(nil, usermem.ByteOrder, &data), usermem.ByteOrder})
+					if wantFilter == nil || int32(ret) < int32(want) {
+						want, wantFilter = ret, chain[l]
+					}
+				}
+				got, gotFilter := task.evaluateSyscallFilters(&data)
+				if got != want || gotFilter != wantFilter {
+					t.Errorf("filters returning %#x, %#x, %#x: got (%#x, %p), want (%#x, %p)", actions[i], actions[j], actions[k], got, gotFilter, want, wantFilter)
+				}
+			}
+		}
+	}
+}
+
+func TestEvaluateSyscallFiltersSameAction(t *testing.T) {
+	task := newSeccompTestTask()
+	newFilter := func(ret uint32, flags uint32) *syscallFilter {
+		p, err := bpf.Compile([]linux.BPFInstruction{bpf.Stmt(bpf.Ret|bpf.K, ret)})
+		if err != nil {
+			t.Fatalf("bpf.Compile failed: %v", err)
+		}
+		return task.newSyscallFilter(p, flags)
+	}
+	trap1 := newFilter(linux.SECCOMP_RET_TRAP|1, 0)
+	trap2 := newFilter(linux.SECCOMP_RET_TRAP|2, linux.SECCOMP_FILTER_FLAG_LOG)
+	errno1 := newFilter(linux.SECCOMP_RET_ERRNO|1, 0)
+	errno2 := newFilter(linux.SECCOMP_RET_ERRNO|2, 0)
+
+	data := seccompData{nr: 1, arch: linux.AUDIT_ARCH_X86_64}
+	for _, test := range []struct {
+		name       string
+		chain      []*syscallFilter
+		want       uint32
+		wantFilter *syscallFilter
+	}{
+		{
+			// The data of the most recently installed filter is used, even
+			// though the older filter's result is numerically smaller.
+			name:       "newer trap data larger",
+			chain:      []*syscallFilter{trap1, trap2},
+			want:       linux.SECCOMP_RET_TRAP | 2,
+			wantFilter: trap2,
+		},
+		{
+			name:       "newer trap data smaller",
+			chain:      []*syscallFilter{trap2, trap1},
+			want:       linux.SECCOMP_RET_TRAP | 1,
+			wantFilter: trap1,
+		},
+		{
+			name:       "errno",
+			chain:      []*syscallFilter{errno1, errno2, errno1},
+			want:       linux.SECCOMP_RET_ERRNO | 1,
+			wantFilter: errno1,
+		},
+		{
+			// A more restrictive action still takes precedence.
+			name:       "trap over newer errno",
+			chain:      []*syscallFilter{trap2, errno1, errno2},
+			want:       linux.SECCOMP_RET_TRAP | 2,
+			wantFilter: trap2,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			task.syscallFilters.Store(test.chain)
+			got, gotFilter := task.evaluateSyscallFilters(&data)
+			if got != test.want || gotFilter != test.wantFilter {
+				t.Errorf("got (%#x, %p), want (%#x, %p)", got, gotFilter, test.want, test.wantFilter)
+			}
+		})
+	}
+}
+
+func TestSeccompExecErrorString(t *testing.T) {
+	task := newSeccompTestTask()
+	for _, test := range []struct {
+		name  string
+		insns []linux.BPFInstruction
+		want  string
+	}{
+		{
+			name: "load out of bounds",
+			insns: []linux.BPFInstruction{
+				bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, SeccompDataSize),
+				bpf.Stmt(bpf.Ret|bpf.A, 0),
+			},
+			want: "load out of bounds or violates input alignment requirements at instruction 0 of optimized program (A <- P[64:4])",
+		},
+		{
+			name: "division by zero",
+			insns: []linux.BPFInstruction{
+				bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 0), // nr
+				bpf.Stmt(bpf.Alu|bpf.Div|bpf.X, 0),
+				bpf.Stmt(bpf.Ret|bpf.A, 0),
+			},
+			want: "division by zero at instruction 1 of optimized program (A <- A / X)",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := bpf.Compile(test.insns)
+			if err != nil {
+				t.Fatalf("bpf.Compile failed: %v", err)
+			}
+			f := task.newSyscallFilter(p, 0)
+			var in seccompInput
+			data := seccompData{nr: 1, arch: linux.AUDIT_ARCH_X86_64}
+			_, err = f.exec(in.load(&data))
+			if err == nil {
+				t.Fatalf("exec succeeded, want error")
+			}
+			if got := seccompExecErrorString(f, err); got != test.want {
+				t.Errorf("seccompExecErrorString got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateSyscallFiltersKillProcessShortCircuit(t *testing.T) {
+	task := newSeccompTestTask()
+	p, err := bpf.Compile([]linux.BPFInstruction{bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_KILL_PROCESS)})
+	if err != nil {
+		t.Fatalf("bpf.Compile failed: %v", err)
+	}
+	kill := task.newSyscallFilter(p, 0)
+	allow := task.newSyscallFilter(seccompTestProgram(t, 1), 0)
+
+	data := seccompData{nr: 1, arch: linux.AUDIT_ARCH_X86_64}
+	for _, test := range []struct {
+		name       string
+		filters    []*syscallFilter
+		wantFilter *syscallFilter
+	}{
+		{name: "kill then allow", filters: []*syscallFilter{allow, kill}, wantFilter: kill},
+		{name: "allow then kill", filters: []*syscallFilter{kill, allow}, wantFilter: kill},
+		{name: "kill then kill", filters: []*syscallFilter{kill, kill, allow}, wantFilter: kill},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			task.syscallFilters.Store(test.filters)
+			got, gotFilter := task.evaluateSyscallFilters(&data)
+			if got != linux.SECCOMP_RET_KILL_PROCESS || gotFilter != test.wantFilter {
+				t.Errorf("evaluateSyscallFilters got (%#x, %p), want (%#x, %p)", got, gotFilter, linux.SECCOMP_RET_KILL_PROCESS, test.wantFilter)
+			}
+		})
+	}
+}
+
+func TestIgnoreSeccompFilters(t *testing.T) {
+	task := newSeccompTestTask()
+	p, err := bpf.Compile([]linux.BPFInstruction{bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_KILL_PROCESS)})
+	if err != nil {
+		t.Fatalf("bpf.Compile failed: %v", err)
+	}
+	task.syscallFilters.Store([]*syscallFilter{task.newSyscallFilter(p, 0)})
+
+	atomic.StoreUint32(&IgnoreSeccompFilters, 1)
+	defer atomic.StoreUint32(&IgnoreSeccompFilters, 0)
+	data := seccompData{nr: 1, arch: linux.AUDIT_ARCH_X86_64}
+	if got, filter := task.evaluateSyscallFilters(&data); got != linux.SECCOMP_RET_ALLOW || filter != nil {
+		t.Errorf("evaluateSyscallFilters got (%#x, %p), want (%#x, nil)", got, filter, linux.SECCOMP_RET_ALLOW)
+	}
+	// The filters are still reported.
+	if got := task.SeccompMode(); got != linux.SECCOMP_MODE_FILTER {
+		t.Errorf("SeccompMode got %d, want %d", got, linux.SECCOMP_MODE_FILTER)
+	}
+}
+
+func TestIgnoreSeccompFiltersBaseline(t *testing.T) {
+	// The baseline filter denies write(2); the application's filter kills
+	// every system call.
+	baseline, err := bpf.Compile([]linux.BPFInstruction{
+		bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 0), // nr
+		bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, 1, 0, 1),
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ERRNO|uint32(syscall.EPERM)),
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ALLOW),
+	})
+	if err != nil {
+		t.Fatalf("bpf.Compile failed: %v", err)
+	}
+	kill, err := bpf.Compile([]linux.BPFInstruction{bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_KILL_PROCESS)})
+	if err != nil {
+		t.Fatalf("bpf.Compile failed: %v", err)
+	}
+	task := newSeccompTestTask()
+	if err := task.InstallBaselineSyscallFilter(baseline); err != nil {
+		t.Fatalf("InstallBaselineSyscallFilter failed: %v", err)
+	}
+	task.noNewPrivs = true
+	if err := task.AppendSyscallFilter(kill, 0); err != nil {
+		t.Fatalf("AppendSyscallFilter failed: %v", err)
+	}
+
+	atomic.StoreUint32(&IgnoreSeccompFilters, 1)
+	defer atomic.StoreUint32(&IgnoreSeccompFilters, 0)
+	// Only the application's filter is ignored.
+	for _, test := range []struct {
+		sysno int32
+		want  uint32
+	}{
+		{sysno: 0, want: linux.SECCOMP_RET_ALLOW},
+		{sysno: 1, want: linux.SECCOMP_RET_ERRNO | uint32(syscall.EPERM)},
+	} {
+		data := seccompData{nr: test.sysno, arch: linux.AUDIT_ARCH_X86_64}
+		got, filter := task.evaluateSyscallFilters(&data)
+		if got != test.want {
+			t.Errorf("evaluateSyscallFilters(%d) got %#x, want %#x", test.sysno, got, test.want)
+		}
+		if filter == nil || !filter.baseline {
+			t.Errorf("evaluateSyscallFilters(%d) got filter %+v, want the baseline filter", test.sysno, filter)
+		}
+	}
+
+	// Enforcing the application's filter again takes effect immediately.
+	atomic.StoreUint32(&IgnoreSeccompFilters, 0)
+	data := seccompData{nr: 0, arch: linux.AUDIT_ARCH_X86_64}
+	if got, _ := task.evaluateSyscallFilters(&data); got != linux.SECCOMP_RET_KILL_PROCESS {
+		t.Errorf("evaluateSyscallFilters without IgnoreSeccompFilters got %#x, want %#x", got, linux.SECCOMP_RET_KILL_PROCESS)
+	}
+}
+
+func TestDenySeccompExecErrors(t *testing.T) {
+	task := newSeccompTestTask()
+	// Division by zero passes validation, but fails when executed.
+	p, err := bpf.Compile([]linux.BPFInstruction{
+		bpf.Stmt(bpf.Ldx|bpf.Imm|bpf.W, 0),
+		bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 0),
+		bpf.Stmt(bpf.Alu|bpf.Div|bpf.X, 0),
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ALLOW),
+	})
+	if err != nil {
+		t.Fatalf("bpf.Compile failed: %v", err)
+	}
+	if err := checkSeccompProgram(p); err != nil {
+		t.Fatalf("checkSeccompProgram failed: %v", err)
+	}
+	task.syscallFilters.Store([]*syscallFilter{task.newSyscallFilter(p, 0)})
+
+	for _, test := range []struct {
+		deny uint32
+		want uint32
+	}{
+		{0, linux.SECCOMP_RET_KILL_THREAD},
+		{1, linux.SECCOMP_RET_ERRNO | uint32(syscall.EPERM)},
+	} {
+		atomic.StoreUint32(&DenySeccompExecErrors, test.deny)
+		before := seccompExecErrorMetric.Value()
+		data := seccompData{nr: 1, arch: linux.AUDIT_ARCH_X86_64}
+		if got, _ := task.evaluateSyscallFilters(&data); got != test.want {
+			t.Errorf("DenySeccompExecErrors=%d: evaluateSyscallFilters got %#x, want %#x", test.deny, got, test.want)
+		}
+		if got := seccompExecErrorMetric.Value() - before; got != 1 {
+			t.Errorf("DenySeccompExecErrors=%d: seccompExecErrorMetric incremented by %d, want 1", test.deny, got)
+		}
+	}
+	atomic.StoreUint32(&DenySeccompExecErrors, 0)
+}
+
+func TestCountSeccompEvaluations(t *testing.T) {
+	task := newSeccompTestTask()
+	task.syscallFilters.Store([]*syscallFilter{task.newSyscallFilter(seccompTestProgram(t, 1), 0)})
+	SeccompEvaluationCounts(true /* reset */)
+
+	evaluate := func(nrs ...int32) {
+		for _, nr := range nrs {
+			task.evaluateSyscallFilters(&seccompData{nr: nr, arch: linux.AUDIT_ARCH_X86_64})
+		}
+	}
+	// Evaluations aren't counted unless enabled.
+	evaluate(1)
+	atomic.StoreUint32(&CountSeccompEvaluations, 1)
+	defer atomic.StoreUint32(&CountSeccompEvaluations, 0)
+	evaluate(1, 2, 1, -1, maxSyscallNum+1)
+
+	want := map[int32]uint64{1: 2, 2: 1}
+	if got := SeccompEvaluationCounts(true /* reset */); !reflect.DeepEqual(got, want) {
+		t.Errorf("SeccompEvaluationCounts got %v, want %v", got, want)
+	}
+	if got := SeccompEvaluationCounts(false /* reset */); len(got) != 0 {
+		t.Errorf("SeccompEvaluationCounts after reset got %v, want none", got)
+	}
+}
+
+func TestSeccompResultCacheLRU(t *testing.T) {
+	filters := []*syscallFilter{{}}
+	var c seccompResultCache
+	data := func(nr int) *seccompData {
+		return &seccompData{nr: int32(nr), arch: linux.AUDIT_ARCH_X86_64}
+	}
+	for nr := 0; nr < seccompResultCacheSize; nr++ {
+		c.insert(filters, data(nr), uint32(nr), filters[0])
+	}
+	// Use system call 0, making 1 the least recently used.
+	if ret, _, ok := c.lookup(filters, data(0)); !ok || ret != 0 {
+		t.Errorf("lookup(0) got (%#x, %v), want (0, true)", ret, ok)
+	}
+	c.insert(filters, data(seccompResultCacheSize), seccompResultCacheSize, filters[0])
+	for nr := 0; nr <= seccompResultCacheSize; nr++ {
+		ret, _, ok := c.lookup(filters, data(nr))
+		if wantOK := nr != 1; ok != wantOK || (ok && ret != uint32(nr)) {
+			t.Errorf("lookup(%d) got (%#x, %v), want (%#x, %v)", nr, ret, ok, nr, wantOK)
+		}
+	}
+
+	// Entries are discarded for other filter chains, even of the same length.
+	other := []*syscallFilter{filters[0]}
+	if _, _, ok := c.lookup(other, data(0)); ok {
+		t.Errorf("lookup with other filters succeeded")
+	}
+	c.insert(other, data(2), 2, filters[0])
+	if _, _, ok := c.lookup(other, data(0)); ok {
+		t.Errorf("lookup of entry for other filters succeeded")
+	}
+}
+
+func TestSeccompResultCacheFilterMutation(t *testing.T) {
+	defer atomic.StoreUint32(&CacheSeccompResults, atomic.LoadUint32(&CacheSeccompResults))
+	atomic.StoreUint32(&CacheSeccompResults, 1)
+
+	// Allow system calls whose first argument is 0, and fail all others with
+	// EPERM, so that results can't be cached by seccompCache.
+	argFilter, err := bpf.Compile([]linux.BPFInstruction{
+		bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 16), // args[0], low half
+		bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, 0, 0, 1),
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ALLOW),
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ERRNO|1),
+	})
+	if err != nil {
+		t.Fatalf("bpf.Compile failed: %v", err)
+	}
+	denyFilter, err := bpf.Compile([]linux.BPFInstruction{
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ERRNO|2),
+	})
+	if err != nil {
+		t.Fatalf("bpf.Compile failed: %v", err)
+	}
+
+	task := newSeccompTestTask()
+	task.noNewPrivs = true
+	if err := task.AppendSyscallFilter(argFilter, 0); err != nil {
+		t.Fatalf("AppendSyscallFilter failed: %v", err)
+	}
+	check := func(desc string, arg0 uintptr, want uint32) {
+		t.Helper()
+		for i := 0; i < 2; i++ {
+			data := task.seccompData(1, arch.SyscallArguments{{Value: arg0}}, 0)
+			if got, _ := task.evaluateSyscallFilters(&data); got != want {
+				t.Errorf("%s: evaluateSyscallFilters(arg0=%d) #%d got %#x, want %#x", desc, arg0, i, got, want)
+			}
+		}
+	}
+	check("one filter", 0, linux.SECCOMP_RET_ALLOW)
+	check("one filter", 1, linux.SECCOMP_RET_ERRNO|1)
+	if task.seccompResults.len != 2 {
+		t.Errorf("cached %d results, want 2", task.seccompResults.len)
+	}
+
+	// Results change when a filter is appended.
+	if err := task.AppendSyscallFilter(denyFilter, 0); err != nil {
+		t.Fatalf("AppendSyscallFilter failed: %v", err)
+	}
+	check("appended filter", 0, linux.SECCOMP_RET_ERRNO|2)
+
+	// And when the filters are replaced by another task, as by
+	// SECCOMP_FILTER_FLAG_TSYNC, with a chain of the same length.
+	filters := task.syscallFilters.Load().([]*syscallFilter)
+	task.syscallFilters.Store([]*syscallFilter{filters[0], filters[0]})
+	check("synchronized filters", 0, linux.SECCOMP_RET_ALLOW)
+}
+
+// benchmarkSeccompResultCache benchmarks evaluateSyscallFilters for a system
+// call with arguments, with CacheSeccompResults set to cache.
+func benchmarkSeccompResultCache(b *testing.B, cache uint32) {
+	defer atomic.StoreUint32(&CacheSeccompResults, atomic.LoadUint32(&CacheSeccompResults))
+	atomic.StoreUint32(&CacheSeccompResults, cache)
+	p, err := bpf.Compile([]linux.BPFInstruction{
+		bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 0),          // nr
+		bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, 202, 0, 5), // futex
+		bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 24),         // args[1], low half
+		bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, 0, 2, 0),   // FUTEX_WAIT
+		bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, 1, 1, 0),   // FUTEX_WAKE
+		bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, 128, 0, 1), // FUTEX_PRIVATE_FLAG
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ALLOW),
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ERRNO|1),
+	})
+	if err != nil {
+		b.Fatalf("bpf.Compile failed: %v", err)
+	}
+	task := newSeccompTestTask()
+	task.syscallFilters.Store([]*syscallFilter{task.newSyscallFilter(p, 0), task.newSyscallFilter(p, 0)})
+	data := task.seccompData(202, arch.SyscallArguments{{Value: 0x1000}, {Value: 1}}, 0)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		task.evaluateSyscallFilters(&data)
+	}
+}
+
+func BenchmarkSeccompResultCacheDisabled(b *testing.B) {
+	benchmarkSeccompResultCache(b, 0)
+}
+
+func BenchmarkSeccompResultCacheEnabled(b *testing.B) {
+	benchmarkSeccompResultCache(b, 1)
+}
+
+// seccompAllowSetProgram returns a program that allows read(2) and write(2),
+// allows ioctl(2) only with a zero first argument, and fails all other system
+// calls with errno. If denyWrite is true, write(2) also fails with errno.
+func seccompAllowSetProgram(t testing.TB, errno uint32, denyWrite bool) bpf.Program {
+	writeAction := uint32(linux.SECCOMP_RET_ALLOW)
+	if denyWrite {
+		writeAction = linux.SECCOMP_RET_ERRNO | errno
+	}
+	p, err := bpf.Compile([]linux.BPFInstruction{
+		bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 4), // arch
+		bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, linux.AUDIT_ARCH_X86_64, 0, 9),
+		bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 0),         // nr
+		bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, 0, 8, 0),  // read
+		bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, 1, 5, 0),  // write
+		bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, 16, 0, 5), // ioctl
+		bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 16),        // args[0], low half
+		bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, 0, 0, 3),
+		bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 20), // args[0], high half
+		bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, 0, 2, 1),
+		bpf.Stmt(bpf.Ret|bpf.K, writeAction),
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ERRNO|errno),
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ALLOW),
+	})
+	if err != nil {
+		t.Fatalf("bpf.Compile failed: %v", err)
+	}
+	return p
+}
+
+func TestSeccompAllowSet(t *testing.T) {
+	task := newSeccompTestTask()
+	// Filters only cache results for system calls in the table.
+	task.tc.st.lookup = make([]SyscallFn, maxSyscallNum+1)
+	task.noNewPrivs = true
+	if err := task.AppendSyscallFilter(seccompAllowSetProgram(t, 1, false /* denyWrite */), 0); err != nil {
+		t.Fatalf("AppendSyscallFilter failed: %v", err)
+	}
+	check := func(name string, want map[int32]bool) {
+		filters := task.syscallFilters.Load().([]*syscallFilter)
+		for nr := int32(-1); nr < 20; nr++ {
+			data := seccompData{nr: nr, arch: linux.AUDIT_ARCH_X86_64}
+			if got := task.seccompAllowed.contains(filters, &data); got != want[nr] {
+				t.Errorf("%s: contains(%d) got %t, want %t", name, nr, got, want[nr])
+			}
+		}
+		data := seccompData{nr: 0, arch: linux.AUDIT_ARCH_I386}
+		if task.seccompAllowed.contains(filters, &data) {
+			t.Errorf("%s: contains(%d) for arch %#x got true, want false", name, data.nr, data.arch)
+		}
+	}
+	// ioctl(2) is not in the set, since its result depends on its argument.
+	check("one filter", map[int32]bool{0: true, 1: true})
+
+	if err := task.AppendSyscallFilter(seccompAllowSetProgram(t, 2, true /* denyWrite */), 0); err != nil {
+		t.Fatalf("AppendSyscallFilter failed: %v", err)
+	}
+	check("two filters", map[int32]bool{0: true})
+
+	for _, test := range []struct {
+		nr   int32
+		arg0 uint64
+		want uint32
+	}{
+		{0, 0, linux.SECCOMP_RET_ALLOW},
+		{1, 0, linux.SECCOMP_RET_ERRNO | 2},
+		{16, 0, linux.SECCOMP_RET_ALLOW},
+		{16, 1 << 32, linux.SECCOMP_RET_ERRNO | 2},
+		{2, 0, linux.SECCOMP_RET_ERRNO | 2},
+	} {
+		data := seccompData{nr: test.nr, arch: linux.AUDIT_ARCH_X86_64, args: [6]uint64{test.arg0}}
+		filters := task.syscallFilters.Load().([]*syscallFilter)
+		got, filter := task.evaluateSyscallFilters(&data)
+		if got != test.want {
+			t.Errorf("evaluateSyscallFilters(nr=%d, arg0=%#x) got %#x, want %#x", test.nr, test.arg0, got, test.want)
+		}
+		if test.want == linux.SECCOMP_RET_ALLOW && filter != filters[1] {
+			t.Errorf("evaluateSyscallFilters(nr=%d, arg0=%#x) got filter %p, want most recent filter %p", test.nr, test.arg0, filter, filters[1])
+		}
+	}
+
+	// The set is recomputed when the filters are replaced by another task,
+	// as by SECCOMP_FILTER_FLAG_TSYNC.
+	filters := task.syscallFilters.Load().([]*syscallFilter)
+	task.syscallFilters.Store([]*syscallFilter{filters[0], filters[0]})
+	check("synchronized filters", map[int32]bool{0: true, 1: true})
+}
+
+// BenchmarkSeccompAllowSet benchmarks evaluateSyscallFilters for a write(2)
+// allowed by a chain of filters that also inspect the arguments of other
+// system calls.
+func BenchmarkSeccompAllowSet(b *testing.B) {
+	task := newSeccompTestTask()
+	task.tc.st.lookup = make([]SyscallFn, maxSyscallNum+1)
+	p := seccompAllowSetProgram(b, 1, false /* denyWrite */)
+	task.syscallFilters.Store([]*syscallFilter{task.newSyscallFilter(p, 0), task.newSyscallFilter(p, 0), task.newSyscallFilter(p, 0)})
+	data := task.seccompData(1, arch.SyscallArguments{{Value: 1}, {Value: 0x1000}, {Value: 10}}, 0)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		task.evaluateSyscallFilters(&data)
+	}
+}
+
+// newSeccompTestThreadGroup returns the threads of a thread group of n tasks
+// on which seccomp filters can be installed and synchronized, starting with
+// the leader.
+func newSeccompTestThreadGroup(n int) []*Task {
+	leader := newSeccompTestTask()
+	leader.noNewPrivs = true
+	tasks := []*Task{leader}
+	leader.tg.tasks.PushBack(leader)
+	leader.tg.tasksCount = n
+	for i := 1; i < n; i++ {
+		task := &Task{tg: leader.tg, creds: leader.creds, noNewPrivs: true}
+		task.tc.st = leader.tc.st
+		leader.tg.tasks.PushBack(task)
+		leader.tg.pidns.tids[task] = ThreadID(i + 1)
+		tasks = append(tasks, task)
+	}
+	return tasks
+}
+
+func TestSeccompStatuses(t *testing.T) {
+	tasks := newSeccompTestThreadGroup(3)
+	p := seccompTestProgram(t, 1)
+	if err := tasks[1].AppendSyscallFilters([]SeccompFilter{{Program: p}, {Program: p}}); err != nil {
+		t.Fatalf("AppendSyscallFilters failed: %v", err)
+	}
+	tasks[2].seccompStrict = true
+
+	want := []SeccompTaskStatus{
+		{TID: 1, Mode: linux.SECCOMP_MODE_NONE},
+		{TID: 2, Mode: linux.SECCOMP_MODE_FILTER, Filters: 2},
+		{TID: 3, Mode: linux.SECCOMP_MODE_STRICT},
+	}
+	got := tasks[0].tg.pidns.owner.SeccompStatuses()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SeccompStatuses got %+v, want %+v", got, want)
+	}
+}
+
+func TestResetSeccomp(t *testing.T) {
+	p, err := bpf.Compile([]linux.BPFInstruction{
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_USER_NOTIF),
+	})
+	if err != nil {
+		t.Fatalf("bpf.Compile failed: %v", err)
+	}
+	tasks := newSeccompTestThreadGroup(3)
+	if err := tasks[0].InstallBaselineSyscallFilter(seccompTestProgram(t, 1)); err != nil {
+		t.Fatalf("InstallBaselineSyscallFilter failed: %v", err)
+	}
+	l, err := tasks[0].SyncSyscallFiltersToThreadGroupWithListener(p, 0)
+	if err != nil {
+		t.Fatalf("SyncSyscallFiltersToThreadGroupWithListener failed: %v", err)
+	}
+	defer l.Release()
+	if err := tasks[1].AppendSyscallFilter(seccompTestProgram(t, 1), 0); err != nil {
+		t.Fatalf("AppendSyscallFilter failed: %v", err)
+	}
+
+	// Resetting is refused while any thread may be executing a system call.
+	tasks[2].gosched.State = TaskGoroutineRunningSys
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Errorf("ResetSeccomp with a running thread succeeded, want panic")
+			}
+		}()
+		tasks[0].ResetSeccomp()
+	}()
+	if got := tasks[0].SeccompMode(); got != linux.SECCOMP_MODE_FILTER {
+		t.Errorf("SeccompMode after refused reset got %d, want %d", got, linux.SECCOMP_MODE_FILTER)
+	}
+	tasks[2].gosched.State = TaskGoroutineNonexistent
+
+	tasks[0].ResetSeccomp()
+	for i, task := range tasks {
+		if got := task.SeccompMode(); got != linux.SECCOMP_MODE_NONE {
+			t.Errorf("thread %d: SeccompMode after reset got %d, want %d", i, got, linux.SECCOMP_MODE_NONE)
+		}
+		if got := len(task.syscallFilterChain()); got != 0 {
+			t.Errorf("thread %d: %d filters after reset, want none", i, got)
+		}
+	}
+	if got := atomic.LoadInt64(&l.users); got != 0 {
+		t.Errorf("listener users after reset got %d, want 0", got)
+	}
+	if got := l.Readiness(waiter.EventHUp); got != waiter.EventHUp {
+		t.Errorf("listener readiness after reset got %#x, want %#x", got, waiter.EventHUp)
+	}
+	if got := tasks[0].tg.seccompDiverged; got != 0 {
+		t.Errorf("seccompDiverged after reset got %d, want 0", got)
+	}
+
+	// Filters can be installed again, including baseline filters.
+	if err := tasks[0].InstallBaselineSyscallFilter(seccompTestProgram(t, 1)); err != nil {
+		t.Errorf("InstallBaselineSyscallFilter after reset failed: %v", err)
+	}
+	if err := tasks[0].AppendSyscallFilter(seccompTestProgram(t, 1), 0); err != nil {
+		t.Errorf("AppendSyscallFilter after reset failed: %v", err)
+	}
+}
+
+func TestResetSeccompStrict(t *testing.T) {
+	tasks := newSeccompTestThreadGroup(2)
+	for _, task := range tasks {
+		if err := task.SetSeccompStrict(); err != nil {
+			t.Fatalf("SetSeccompStrict failed: %v", err)
+		}
+	}
+	tasks[0].ResetSeccomp()
+	if got := tasks[0].SeccompMode(); got != linux.SECCOMP_MODE_NONE {
+		t.Errorf("SeccompMode of resetting thread got %d, want %d", got, linux.SECCOMP_MODE_NONE)
+	}
+	if got := tasks[1].SeccompMode(); got != linux.SECCOMP_MODE_STRICT {
+		t.Errorf("SeccompMode of other thread got %d, want %d", got, linux.SECCOMP_MODE_STRICT)
+	}
+	if got := tasks[0].tg.seccompDiverged; got != 1 {
+		t.Errorf("seccompDiverged after reset got %d, want 1", got)
+	}
+}
+
+// BenchmarkSeccompSyncContention benchmarks locking Task.mu in the threads of
+// a large thread group while another goroutine repeatedly appends filters to
+// one of them and synchronizes filters to all of them, which does not require
+// Task.mu.
+func BenchmarkSeccompSyncContention(b *testing.B) {
+	const threads = 64
+	tasks := newSeccompTestThreadGroup(threads)
+	leader := tasks[0]
+	p, err := bpf.Compile([]linux.BPFInstruction{
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ALLOW),
+	})
+	if err != nil {
+		b.Fatalf("bpf.Compile failed: %v", err)
+	}
+	f := leader.newSyscallFilter(p, 0)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			err := leader.appendSyscallFilter(f)
+			if err == nil {
+				err = leader.syncSyscallFiltersToThreadGroup(f)
+			}
+			if err == syserror.ENOMEM {
+				// Start again from an empty filter chain.
+				leader.tg.seccompMu.Lock()
+				leader.tg.syscallFilters.Store([]*syscallFilter(nil))
+				for _, task := range tasks {
+					task.seccompMu.Lock()
+					task.syscallFilters.Store([]*syscallFilter(nil))
+					task.seccompMu.Unlock()
+				}
+				leader.tg.seccompDiverged = 0
+				leader.tg.seccompMu.Unlock()
+			} else if err != nil {
+				panic(fmt.Sprintf("installing filters failed: %v", err))
+			}
+		}
+	}()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tasks[i%threads].WithMuLocked(func(*Task) {})
+	}
+	b.StopTimer()
+	close(stop)
+	wg.Wait()
+}
+
+func TestSeccompListenerFilterCombinations(t *testing.T) {
+	filters := func(task *Task) []*syscallFilter {
+		return task.syscallFilterChain()
+	}
+	listeners := func(task *Task) []*SeccompListener {
+		var ls []*SeccompListener
+		for _, f := range filters(task) {
+			if f.listener != nil {
+				ls = append(ls, f.listener)
+			}
+		}
+		return ls
+	}
+	p := seccompTestProgram(t, 1)
+
+	t.Run("append after listener", func(t *testing.T) {
+		task := newSeccompTestTask()
+		task.noNewPrivs = true
+		l, err := task.AppendSyscallFilterWithListener(p, 0)
+		if err != nil {
+			t.Fatalf("AppendSyscallFilterWithListener failed: %v", err)
+		}
+		if _, err := task.AppendSyscallFilterWithListener(p, 0); err != syserror.EBUSY {
+			t.Errorf("second AppendSyscallFilterWithListener got error %v, want %v", err, syserror.EBUSY)
+		}
+		// Filters without listeners can still be appended.
+		if err := task.AppendSyscallFilter(p, 0); err != nil {
+			t.Errorf("AppendSyscallFilter after listener failed: %v", err)
+		}
+		if got := listeners(task); !reflect.DeepEqual(got, []*SeccompListener{l}) {
+			t.Errorf("listeners got %v, want [%p]", got, l)
+		}
+	})
+
+	t.Run("sync with listener", func(t *testing.T) {
+		tasks := newSeccompTestThreadGroup(3)
+		l, err := tasks[0].SyncSyscallFiltersToThreadGroupWithListener(p, 0)
+		if err != nil {
+			t.Fatalf("SyncSyscallFiltersToThreadGroupWithListener failed: %v", err)
+		}
+		// Every thread shares the filter, and hence the listener.
+		for i, task := range tasks {
+			if got := listeners(task); !reflect.DeepEqual(got, []*SeccompListener{l}) {
+				t.Errorf("thread %d listeners got %v, want [%p]", i, got, l)
+			}
+		}
+		if got := atomic.LoadInt64(&l.users); got != int64(len(tasks)) {
+			t.Errorf("listener users got %d, want %d", got, len(tasks))
+		}
+
+		// A second listener can't be synchronized into the chain, and
+		// nothing changes.
+		want := filters(tasks[0])
+		if _, err := tasks[0].SyncSyscallFiltersToThreadGroupWithListener(p, 0); err != syserror.EBUSY {
+			t.Errorf("second SyncSyscallFiltersToThreadGroupWithListener got error %v, want %v", err, syserror.EBUSY)
+		}
+		// Nor can another thread add one to the inherited chain.
+		if _, err := tasks[1].AppendSyscallFilterWithListener(p, 0); err != syserror.EBUSY {
+			t.Errorf("AppendSyscallFilterWithListener on another thread got error %v, want %v", err, syserror.EBUSY)
+		}
+		for i, task := range tasks {
+			if got := filters(task); !reflect.DeepEqual(got, want) {
+				t.Errorf("thread %d filters changed after failed synchronization", i)
+			}
+		}
+
+		// Filters without listeners can still be synchronized.
+		if err := tasks[0].SyncSyscallFiltersToThreadGroup(p, 0); err != nil {
+			t.Fatalf("SyncSyscallFiltersToThreadGroup after listener failed: %v", err)
+		}
+		for i, task := range tasks {
+			if got := len(filters(task)); got != 2 {
+				t.Errorf("thread %d got %d filters, want 2", i, got)
+			}
+		}
+	})
+
+	t.Run("sync with listener conflict", func(t *testing.T) {
+		tasks := newSeccompTestThreadGroup(3)
+		if err := tasks[2].AppendSyscallFilter(p, 0); err != nil {
+			t.Fatalf("AppendSyscallFilter failed: %v", err)
+		}
+		want := filters(tasks[2])
+		_, err := tasks[0].SyncSyscallFiltersToThreadGroupWithListener(p, 0)
+		if want := (&SyscallFilterSyncError{TID: 3}); !reflect.DeepEqual(err, want) {
+			t.Fatalf("SyncSyscallFiltersToThreadGroupWithListener got error %v, want %v", err, want)
+		}
+		// The listener was not installed in any thread.
+		for i, task := range tasks {
+			if got := listeners(task); len(got) != 0 {
+				t.Errorf("thread %d got listeners %v, want none", i, got)
+			}
+		}
+		if got := filters(tasks[2]); !reflect.DeepEqual(got, want) {
+			t.Errorf("conflicting thread's filters changed")
+		}
+	})
+}
+
+func TestSeccompSyncMetrics(t *testing.T) {
+	const threads = 4
+	tasks := newSeccompTestThreadGroup(threads)
+	leader := tasks[0]
+	syncs, aborted, synced := seccompTsyncMetric.Value(), seccompTsyncAbortedMetric.Value(), seccompTsyncThreadsMetric.Value()
+	check := func(name string, wantSyncs, wantAborted, wantSynced uint64) {
+		if got := seccompTsyncMetric.Value() - syncs; got != wantSyncs {
+			t.Errorf("%s: synchronizations got %d, want %d", name, got, wantSyncs)
+		}
+		if got := seccompTsyncAbortedMetric.Value() - aborted; got != wantAborted {
+			t.Errorf("%s: aborted synchronizations got %d, want %d", name, got, wantAborted)
+		}
+		if got := seccompTsyncThreadsMetric.Value() - synced; got != wantSynced {
+			t.Errorf("%s: synchronized threads got %d, want %d", name, got, wantSynced)
+		}
+	}
+
+	if err := leader.SyncSyscallFiltersToThreadGroup(seccompTestProgram(t, 1), 0); err != nil {
+		t.Fatalf("SyncSyscallFiltersToThreadGroup failed: %v", err)
+	}
+	check("sync", 1, 0, threads)
+
+	// A thread that installs its own filter conflicts with later
+	// synchronizations.
+	if err := tasks[2].appendSyscallFilter(leader.newSyscallFilter(seccompTestProgram(t, 2), 0)); err != nil {
+		t.Fatalf("appendSyscallFilter failed: %v", err)
+	}
+	err := leader.SyncSyscallFiltersToThreadGroup(seccompTestProgram(t, 3), 0)
+	if want := (&SyscallFilterSyncError{TID: 3}); !reflect.DeepEqual(err, want) {
+		t.Fatalf("SyncSyscallFiltersToThreadGroup got error %v, want %v", err, want)
+	}
+	check("conflict", 1, 1, threads)
+}
+
+func TestSeccompThreadGroupFilterStore(t *testing.T) {
+	tasks := newSeccompTestThreadGroup(3)
+	leader := tasks[0]
+	tg := leader.tg
+	addThread := func(task *Task) {
+		tg.tasks.PushBack(task)
+		tg.tasksCount++
+		tg.pidns.tids[task] = ThreadID(tg.tasksCount)
+		tasks = append(tasks, task)
+	}
+	checkShared := func(name string) {
+		shared := tg.syscallFilters.Load().([]*syscallFilter)
+		for i, task := range tasks {
+			if own := task.ownSyscallFilters(); len(own) != 0 {
+				t.Errorf("%s: thread %d has %d filters of its own, want 0", name, i, len(own))
+			}
+			if got := task.syscallFilterChain(); len(got) != len(shared) || &got[0] != &shared[0] {
+				t.Errorf("%s: thread %d filters got %p, want thread group's %p", name, i, got, shared)
+			}
+		}
+		if tg.seccompDiverged != 0 {
+			t.Errorf("%s: seccompDiverged got %d, want 0", name, tg.seccompDiverged)
+		}
+	}
+	checkCounts := func(name string, want ...int) {
+		for i, task := range tasks {
+			if got := task.SeccompFilterCount(); got != want[i] {
+				t.Errorf("%s: thread %d SeccompFilterCount got %d, want %d", name, i, got, want[i])
+			}
+		}
+	}
+
+	// A thread without no_new_privs of its own gains it by synchronization.
+	plain := &Task{tg: tg}
+	addThread(plain)
+
+	// With no diverged threads, synchronization only stores the thread
+	// group's filters.
+	if err := leader.SyncSyscallFiltersToThreadGroup(seccompTestProgram(t, 1), 0); err != nil {
+		t.Fatalf("SyncSyscallFiltersToThreadGroup failed: %v", err)
+	}
+	checkShared("first sync")
+	if plain.noNewPrivs || !plain.NoNewPrivs() {
+		t.Errorf("synchronized thread NoNewPrivs got %t (own bit %t), want true (false)", plain.NoNewPrivs(), plain.noNewPrivs)
+	}
+
+	// A filter appended by one thread applies only to that thread.
+	if err := tasks[1].appendSyscallFilter(leader.newSyscallFilter(seccompTestProgram(t, 2), 0)); err != nil {
+		t.Fatalf("appendSyscallFilter failed: %v", err)
+	}
+	checkCounts("diverged", 1, 2, 1, 1)
+	if tg.seccompDiverged != 1 {
+		t.Errorf("seccompDiverged after append got %d, want 1", tg.seccompDiverged)
+	}
+
+	// New threads inherit the filters of the thread that created them,
+	// sharing the thread group's unless that thread has diverged.
+	fromDiverged := &Task{tg: tg}
+	tasks[1].inheritSeccompLocked(fromDiverged)
+	fromShared := &Task{tg: tg}
+	tasks[2].inheritSeccompLocked(fromShared)
+	addThread(fromDiverged)
+	addThread(fromShared)
+	checkCounts("inherited", 1, 2, 1, 1, 2, 1)
+	if own := fromShared.ownSyscallFilters(); len(own) != 0 {
+		t.Errorf("thread cloned from non-diverged thread has %d filters of its own, want 0", len(own))
+	}
+	if tg.seccompDiverged != 2 {
+		t.Errorf("seccompDiverged after clone got %d, want 2", tg.seccompDiverged)
+	}
+
+	// A diverged thread with filters that extend every other thread's can
+	// synchronize, which converges every thread onto the thread group's
+	// filters.
+	if err := tasks[1].SyncSyscallFiltersToThreadGroup(seccompTestProgram(t, 3), 0); err != nil {
+		t.Fatalf("SyncSyscallFiltersToThreadGroup from diverged thread failed: %v", err)
+	}
+	checkShared("converged")
+	checkCounts("converged", 3, 3, 3, 3, 3, 3)
+}
+
+func TestSeccompFilterInstructions(t *testing.T) {
+	task := newSeccompTestTask()
+	if current, limit := task.SeccompFilterInstructions(); current != 0 || limit != maxSyscallFilterInstructions {
+		t.Errorf("SeccompFilterInstructions with no filters got (%d, %d), want (0, %d)", current, limit, maxSyscallFilterInstructions)
+	}
+
+	task.noNewPrivs = true
+	p := seccompTestProgram(t, 10)
+	want := 0
+	for i := 0; i < 3; i++ {
+		if err := task.AppendSyscallFilter(p, 0); err != nil {
+			t.Fatalf("AppendSyscallFilter %d failed: %v", i, err)
+		}
+		if i != 0 {
+			want += syscallFilterOverhead
+		}
+		want += p.Length()
+		if current, limit := task.SeccompFilterInstructions(); current != want || limit != maxSyscallFilterInstructions {
+			t.Errorf("SeccompFilterInstructions with %d filters got (%d, %d), want (%d, %d)", i+1, current, limit, want, maxSyscallFilterInstructions)
+		}
+	}
+}
+
+func TestSeccompDump(t *testing.T) {
+	task := newSeccompTestTask()
+	if got, want := task.SeccompDump(), "mode: none\n"; got != want {
+		t.Errorf("SeccompDump with no filters got %q, want %q", got, want)
+	}
+
+	p, err := bpf.Compile([]linux.BPFInstruction{
+		bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 4),
+		bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, linux.AUDIT_ARCH_X86_64, 1, 0),
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_KILL_PROCESS),
+		bpf.Stmt(bpf.Ld|bpf.Abs|bpf.W, 0),
+		bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, 1, 0, 1),
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ALLOW),
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_ERRNO|1),
+	})
+	if err != nil {
+		t.Fatalf("bpf.Compile failed: %v", err)
+	}
+	task.noNewPrivs = true
+	if err := task.AppendSyscallFilter(p, linux.SECCOMP_FILTER_FLAG_LOG); err != nil {
+		t.Fatalf("AppendSyscallFilter failed: %v", err)
+	}
+	got := task.SeccompDump()
+	for _, want := range []string{
+		"mode: filter\n",
+		"instructions: 7/32768\n",
+		"filter 0: 7 instructions, flags log\n",
+		"; arch\n",
+		"; kill_process\n",
+		"; nr\n",
+		"jeq      #0x1             jt 5\tjf 6\t; sys_1\n",
+		"; allow\n",
+		"; errno 1\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("SeccompDump got:\n%s\nwant it to contain %q", got, want)
+		}
+	}
+	// The comparison against the architecture is not a system call.
+	if strings.Contains(got, "sys_3221225534") {
+		t.Errorf("SeccompDump got:\n%s\nwant no system call name for the architecture", got)
+	}
+
+	strict := newSeccompTestTask()
+	if err := strict.SetSeccompStrict(); err != nil {
+		t.Fatalf("SetSeccompStrict failed: %v", err)
+	}
+	if got, want := strict.SeccompDump(), "mode: strict\n"; got != want {
+		t.Errorf("SeccompDump in strict mode got %q, want %q", got, want)
+	}
+}
+
+func TestSeccompFilterSummary(t *testing.T) {
+	task := newSeccompTestTask()
+	if got, want := task.SeccompFilterSummary(), "mode: none\n"; got != want {
+		t.Errorf("SeccompFilterSummary with no filters got %q, want %q", got, want)
+	}
+
+	// The baseline filter is not listed.
+	if err := task.InstallBaselineSyscallFilter(seccompTestProgram(t, 2)); err != nil {
+		t.Fatalf("InstallBaselineSyscallFilter failed: %v", err)
+	}
+	if got, want := task.SeccompFilterSummary(), "mode: none\n"; got != want {
+		t.Errorf("SeccompFilterSummary with baseline filter got %q, want %q", got, want)
+	}
+
+	task.noNewPrivs = true
+	for _, f := range []struct {
+		length int
+		flags  uint32
+	}{
+		{3, 0},
+		{5, linux.SECCOMP_FILTER_FLAG_LOG},
+		{1, linux.SECCOMP_FILTER_FLAG_SPEC_ALLOW | linux.SECCOMP_FILTER_FLAG_LOG},
+	} {
+		if err := task.AppendSyscallFilter(seccompTestProgram(t, f.length), f.flags); err != nil {
+			t.Fatalf("AppendSyscallFilter failed: %v", err)
+		}
+	}
+	want := "mode: filter\n" +
+		"filters: 3\n" +
+		"filter 0: 3 instructions, flags none\n" +
+		"filter 1: 5 instructions, flags log\n" +
+		"filter 2: 1 instructions, flags log|spec_allow\n"
+	if got := task.SeccompFilterSummary(); got != want {
+		t.Errorf("SeccompFilterSummary got:\n%s\nwant:\n%s", got, want)
+	}
+
+	strict := newSeccompTestTask()
+	if err := strict.SetSeccompStrict(); err != nil {
+		t.Fatalf("SetSeccompStrict failed: %v", err)
+	}
+	if got, want := strict.SeccompFilterSummary(), "mode: strict\n"; got != want {
+		t.Errorf("SeccompFilterSummary in strict mode got %q, want %q", got, want)
+	}
+}
+
+func TestNewSeccompListenerFD(t *testing.T) {
+	p, err := bpf.Compile([]linux.BPFInstruction{
+		bpf.Stmt(bpf.Ret|bpf.K, linux.SECCOMP_RET_USER_NOTIF),
+	})
+	if err != nil {
+		t.Fatalf("bpf.Compile failed: %v", err)
+	}
+	newTask := func() *Task {
+		task := newSeccompTestTask()
+		task.noNewPrivs = true
+		task.fds = newTestFDMap()
+		task.tg.limits = limits.NewLimitSet()
+		// The task already has a file descriptor open.
+		if _, err := task.fds.NewFDFrom(0, filetest.NewTestFile(t), FDFlags{}, task.tg.limits); err != nil {
+			t.Fatalf("NewFDFrom failed: %v", err)
+		}
+		return task
+	}
+	newFile := func(*SeccompListener) *fs.File {
+		return filetest.NewTestFile(t)
+	}
+	for _, tsync := range []bool{false, true} {
+		t.Run(fmt.Sprintf("tsync=%t", tsync), func(t *testing.T) {
+			t.Run("emfile", func(t *testing.T) {
+				// With RLIMIT_NOFILE equal to the number of open file
+				// descriptors, no filter may be installed, since it could
+				// never be given a listener.
+				task := newTask()
+				task.tg.limits.SetUnchecked(limits.NumberOfFiles, limits.Limit{Cur: 1, Max: 1})
+				if _, err := task.NewSeccompListenerFD(p, 0, tsync, newFile, FDFlags{}); err != syscall.EMFILE {
+					t.Errorf("NewSeccompListenerFD got error %v, want %v", err, syscall.EMFILE)
+				}
+				if n := len(task.syscallFilterChain()); n != 0 {
+					t.Errorf("got %d filters, want 0", n)
+				}
+				if n := task.fds.Size(); n != 1 {
+					t.Errorf("got %d file descriptors, want 1", n)
+				}
+			})
+
+			t.Run("ebusy", func(t *testing.T) {
+				// If the filter can't be installed, its file descriptor is
+				// closed again.
+				task := newTask()
+				if _, err := task.NewSeccompListenerFD(p, 0, tsync, newFile, FDFlags{}); err != nil {
+					t.Fatalf("NewSeccompListenerFD failed: %v", err)
+				}
+				if _, err := task.NewSeccompListenerFD(p, 0, tsync, newFile, FDFlags{}); err != syscall.EBUSY {
+					t.Errorf("NewSeccompListenerFD got error %v, want %v", err, syscall.EBUSY)
+				}
+				if n := len(task.syscallFilterChain()); n != 1 {
+					t.Errorf("got %d filters, want 1", n)
+				}
+				if n := task.fds.Size(); n != 2 {
+					t.Errorf("got %d file descriptors, want 2", n)
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kernel
+
+// SeccompTraceEvent describes a system call for which a seccomp-bpf filter
+// returned SECCOMP_RET_TRACE.
+type SeccompTraceEvent struct {
+	// Data is the SECCOMP_RET_DATA portion of the filter's result, which a
+	// ptracer would receive as the PTRACE_EVENT_SECCOMP message.
+	Data uint16
+
+	// Arch is the AUDIT_ARCH_* value of the system call's calling
+	// convention.
+	Arch uint32
+
+	// Sysno is the system call number.
+	Sysno int32
+
+	// Args are the system call arguments.
+	Args [6]uint64
+
+	// InstructionPointer is the address of the system call instruction.
+	InstructionPointer uint64
+}
+
+// SeccompTraceSink receives system calls for which seccomp-bpf filters return
+// SECCOMP_RET_TRACE in a thread group, as registered by
+// ThreadGroup.SetSeccompTraceSink. This allows supervisors that are not
+// ptracers to decide whether such system calls are executed, without the
+// overhead of ptrace or a seccomp user notification listener.
+//
+// A ptracer that has set PTRACE_O_TRACESECCOMP takes precedence over the
+// sink: the sink only receives events that would otherwise fail with ENOSYS
+// because there is no tracer to notify.
+type SeccompTraceSink interface {
+	// SeccompTrace is called from the task goroutine of t before it executes
+	// the system call described by ev. If SeccompTrace returns nil, the system
+	// call is executed; otherwise it fails with the returned error without
+	// being executed.
+	SeccompTrace(t *Task, ev SeccompTraceEvent) error
+}
+
+// SetSeccompTraceSink registers sink to receive SECCOMP_RET_TRACE events for
+// all tasks in tg that are not traced, replacing any previously registered
+// sink. If sink is nil, SECCOMP_RET_TRACE events for such tasks are failed
+// with ENOSYS, as in Linux.
+func (tg *ThreadGroup) SetSeccompTraceSink(sink SeccompTraceSink) {
+	tg.signalHandlers.mu.Lock()
+	defer tg.signalHandlers.mu.Unlock()
+	tg.seccompTraceSink = sink
+}
+
+// SeccompTraceSink returns the sink registered by SetSeccompTraceSink, or nil
+// if there is none.
+func (tg *ThreadGroup) SeccompTraceSink() SeccompTraceSink {
+	tg.signalHandlers.mu.Lock()
+	defer tg.signalHandlers.mu.Unlock()
+	return tg.seccompTraceSink
+}
+
+// seccompTrace passes the system call described by data, for which a filter
+// returned SECCOMP_RET_TRACE with SECCOMP_RET_DATA msg, to t's thread group's
+// SeccompTraceSink. It returns false if there is no sink, and otherwise true
+// along with the sink's decision.
+func (t *Task) seccompTrace(data *seccompData, msg uint16) (bool, error) {
+	sink := t.tg.SeccompTraceSink()
+	if sink == nil {
+		return false, nil
+	}
+	return true, sink.SeccompTrace(t, SeccompTraceEvent{
+		Data:               msg,
+		Arch:               data.arch,
+		Sysno:              data.nr,
+		Args:               data.args,
+		InstructionPointer: data.instructionPointer,
+	})
+}
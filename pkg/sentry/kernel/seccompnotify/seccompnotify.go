@@ -0,0 +1,239 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package seccompnotify provides the file descriptors returned by
+// seccomp(SECCOMP_SET_MODE_FILTER, SECCOMP_FILTER_FLAG_NEW_LISTENER).
+package seccompnotify
+
+import (
+	"gvisor.googlesource.com/gvisor/pkg/abi/linux"
+	"gvisor.googlesource.com/gvisor/pkg/binary"
+	"gvisor.googlesource.com/gvisor/pkg/sentry/arch"
+	"gvisor.googlesource.com/gvisor/pkg/sentry/context"
+	"gvisor.googlesource.com/gvisor/pkg/sentry/fs"
+	"gvisor.googlesource.com/gvisor/pkg/sentry/fs/anon"
+	"gvisor.googlesource.com/gvisor/pkg/sentry/fs/fsutil"
+	"gvisor.googlesource.com/gvisor/pkg/sentry/kernel"
+	"gvisor.googlesource.com/gvisor/pkg/sentry/kernel/kdefs"
+	"gvisor.googlesource.com/gvisor/pkg/sentry/usermem"
+	"gvisor.googlesource.com/gvisor/pkg/syserror"
+	"gvisor.googlesource.com/gvisor/pkg/waiter"
+)
+
+// Sizes returns the sizes of the structures used by the listener ioctls, as
+// reported by seccomp(SECCOMP_GET_NOTIF_SIZES).
+func Sizes() linux.SeccompNotifSizes {
+	return linux.SeccompNotifSizes{
+		Notif:     kernel.SeccompNotifSize,
+		NotifResp: uint16(binary.Size(linux.SeccompNotifResp{})),
+		Data:      kernel.SeccompDataSize,
+	}
+}
+
+// ListenerOperations implements fs.FileOperations for a seccomp user
+// notification listener.
+//
+// +stateify savable
+type ListenerOperations struct {
+	fsutil.PipeSeek      `state:"nosave"`
+	fsutil.NotDirReaddir `state:"nosave"`
+	fsutil.NoFsync       `state:"nosave"`
+	fsutil.NoopFlush     `state:"nosave"`
+	fsutil.NoMMap        `state:"nosave"`
+
+	// listener is the kernel side of the listener. listener is immutable.
+	listener *kernel.SeccompListener
+}
+
+// New returns a new file representing listener l.
+func New(ctx context.Context, l *kernel.SeccompListener) *fs.File {
+	// name matches kernel/seccomp.c:init_listener.
+	dirent := fs.NewDirent(anon.NewInode(ctx), "anon_inode:seccomp notify")
+	return fs.NewFile(ctx, dirent, fs.FileFlags{Read: true, Write: true}, &ListenerOperations{
+		listener: l,
+	})
+}
+
+// Release implements fs.FileOperations.Release.
+func (lo *ListenerOperations) Release() {
+	lo.listener.Release()
+}
+
+// Read implements fs.FileOperations.Read.
+func (*ListenerOperations) Read(context.Context, *fs.File, usermem.IOSequence, int64) (int64, error) {
+	return 0, syserror.EINVAL
+}
+
+// Write implements fs.FileOperations.Write.
+func (*ListenerOperations) Write(context.Context, *fs.File, usermem.IOSequence, int64) (int64, error) {
+	return 0, syserror.EINVAL
+}
+
+// Readiness implements waiter.Waitable.Readiness.
+func (lo *ListenerOperations) Readiness(mask waiter.EventMask) waiter.EventMask {
+	return lo.listener.Readiness(mask)
+}
+
+// EventRegister implements waiter.Waitable.EventRegister.
+func (lo *ListenerOperations) EventRegister(e *waiter.Entry, mask waiter.EventMask) {
+	lo.listener.EventRegister(e, mask)
+}
+
+// EventUnregister implements waiter.Waitable.EventUnregister.
+func (lo *ListenerOperations) EventUnregister(e *waiter.Entry) {
+	lo.listener.EventUnregister(e)
+}
+
+// Ioctl implements fs.FileOperations.Ioctl.
+func (lo *ListenerOperations) Ioctl(ctx context.Context, io usermem.IO, args arch.SyscallArguments) (uintptr, error) {
+	addr := args[2].Pointer()
+	switch args[1].Uint() {
+	case linux.SECCOMP_IOCTL_NOTIF_RECV:
+		// As in Linux, the notification buffer must be zeroed.
+		var notif linux.SeccompNotif
+		if _, err := usermem.CopyObjectIn(ctx, io, addr, &notif, usermem.IOOpts{
+			AddressSpaceActive: true,
+		}); err != nil {
+			return 0, err
+		}
+		if notif != (linux.SeccompNotif{}) {
+			return 0, syserror.EINVAL
+		}
+		notif, err := lo.recv(ctx, kdefs.FD(args[0].Int()))
+		if err != nil {
+			return 0, err
+		}
+		buf := make([]byte, kernel.SeccompNotifSize)
+		kernel.MarshalSeccompNotif(buf, usermem.ByteOrder, &notif)
+		_, err = io.CopyOut(ctx, addr, buf, usermem.IOOpts{
+			AddressSpaceActive: true,
+		})
+		return 0, err
+
+	case linux.SECCOMP_IOCTL_NOTIF_SEND:
+		var resp linux.SeccompNotifResp
+		if _, err := usermem.CopyObjectIn(ctx, io, addr, &resp, usermem.IOOpts{
+			AddressSpaceActive: true,
+		}); err != nil {
+			return 0, err
+		}
+		return 0, lo.listener.Send(resp)
+
+	case linux.SECCOMP_IOCTL_NOTIF_ID_VALID:
+		var id uint64
+		if _, err := usermem.CopyObjectIn(ctx, io, addr, &id, usermem.IOOpts{
+			AddressSpaceActive: true,
+		}); err != nil {
+			return 0, err
+		}
+		return 0, lo.listener.IDValid(id)
+
+	case linux.SECCOMP_IOCTL_NOTIF_ADDFD:
+		var addfd linux.SeccompNotifAddfd
+		if _, err := usermem.CopyObjectIn(ctx, io, addr, &addfd, usermem.IOOpts{
+			AddressSpaceActive: true,
+		}); err != nil {
+			return 0, err
+		}
+		fd, err := lo.addFD(ctx, addfd)
+		return uintptr(fd), err
+
+	default:
+		return 0, syserror.ENOTTY
+	}
+}
+
+// addFD installs the caller's file descriptor addfd.SrcFD in the file
+// descriptor table of the task that sent notification addfd.ID.
+func (lo *ListenerOperations) addFD(ctx context.Context, addfd linux.SeccompNotifAddfd) (kdefs.FD, error) {
+	if addfd.Flags&^(linux.SECCOMP_ADDFD_FLAG_SETFD|linux.SECCOMP_ADDFD_FLAG_SEND) != 0 {
+		return 0, syserror.EINVAL
+	}
+	if addfd.NewFDFlags&^linux.O_CLOEXEC != 0 {
+		return 0, syserror.EINVAL
+	}
+	setFD := addfd.Flags&linux.SECCOMP_ADDFD_FLAG_SETFD != 0
+	if addfd.NewFD != 0 && !setFD {
+		return 0, syserror.EINVAL
+	}
+
+	t := kernel.TaskFromContext(ctx)
+	if t == nil {
+		// Only tasks have file descriptors.
+		return 0, syserror.EINVAL
+	}
+	file := t.FDMap().GetFile(kdefs.FD(addfd.SrcFD))
+	if file == nil {
+		return 0, syserror.EBADF
+	}
+	defer file.DecRef()
+
+	return lo.listener.AddFD(t, addfd.ID, kernel.SeccompAddFD{
+		File:  file,
+		SetFD: setFD,
+		FD:    kdefs.FD(addfd.NewFD),
+		Flags: kernel.FDFlags{
+			CloseOnExec: addfd.NewFDFlags&linux.O_CLOEXEC != 0,
+		},
+		Send: addfd.Flags&linux.SECCOMP_ADDFD_FLAG_SEND != 0,
+	})
+}
+
+// recv receives a notification from lo.listener, blocking until one is
+// available unless fd, the file descriptor passed to ioctl, is non-blocking.
+//
+// As in Linux, recv fails with EINTR, rather than being restarted, if it is
+// interrupted by a signal. Since the ioctl holds a reference on the listener's
+// file, closing fd while recv is blocked doesn't release the listener; recv
+// keeps waiting until a notification arrives or it is interrupted.
+func (lo *ListenerOperations) recv(ctx context.Context, fd kdefs.FD) (linux.SeccompNotif, error) {
+	t := kernel.TaskFromContext(ctx)
+	if t == nil {
+		// Only tasks can receive notifications.
+		return linux.SeccompNotif{}, syserror.EINVAL
+	}
+
+	notif, err := lo.listener.Recv(t)
+	if err != syserror.ErrWouldBlock || lo.nonBlocking(t, fd) {
+		return notif, err
+	}
+
+	e, ch := waiter.NewChannelEntry(nil)
+	lo.listener.EventRegister(&e, waiter.EventIn|waiter.EventHUp)
+	defer lo.listener.EventUnregister(&e)
+	for {
+		notif, err := lo.listener.Recv(t)
+		if err != syserror.ErrWouldBlock {
+			return notif, err
+		}
+		if err := t.Block(ch); err != nil {
+			if err == syserror.ErrInterrupted {
+				return linux.SeccompNotif{}, syserror.EINTR
+			}
+			return linux.SeccompNotif{}, err
+		}
+	}
+}
+
+// nonBlocking returns true if fd, in the file descriptor table of t, refers to
+// a non-blocking file for lo. fs.FileOperations.Ioctl is not passed the file,
+// so its flags must be looked up by file descriptor.
+func (lo *ListenerOperations) nonBlocking(t *kernel.Task, fd kdefs.FD) bool {
+	file := t.FDMap().GetFile(fd)
+	if file == nil {
+		return false
+	}
+	defer file.DecRef()
+	return file.FileOperations == fs.FileOperations(lo) && file.Flags().NonBlocking
+}
@@ -0,0 +1,46 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package seccompnotify
+
+import (
+	"testing"
+
+	"gvisor.googlesource.com/gvisor/pkg/abi/linux"
+	"gvisor.googlesource.com/gvisor/pkg/binary"
+)
+
+// ioctlSize returns the size of the argument of ioctl request req.
+func ioctlSize(req uint32) uint16 {
+	return uint16((req >> 16) & 0x3fff)
+}
+
+func TestSizes(t *testing.T) {
+	sizes := Sizes()
+	for _, test := range []struct {
+		name string
+		got  uint16
+		want uint16
+	}{
+		{"seccomp_notif", sizes.Notif, ioctlSize(linux.SECCOMP_IOCTL_NOTIF_RECV)},
+		{"seccomp_notif_resp", sizes.NotifResp, ioctlSize(linux.SECCOMP_IOCTL_NOTIF_SEND)},
+		{"seccomp_notif_addfd", uint16(binary.Size(linux.SeccompNotifAddfd{})), ioctlSize(linux.SECCOMP_IOCTL_NOTIF_ADDFD)},
+		// struct seccomp_data has been 64 bytes since its introduction.
+		{"seccomp_data", sizes.Data, 64},
+	} {
+		if test.got != test.want {
+			t.Errorf("size of struct %s got %d, want %d", test.name, test.got, test.want)
+		}
+	}
+}
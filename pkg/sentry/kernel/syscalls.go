@@ -171,6 +171,9 @@ type Stracer interface {
 
 	// SyscallExit is called on syscall exit.
 	SyscallExit(context interface{}, t *Task, sysno, rval uintptr, err error)
+
+	// Name returns the name of syscall sysno.
+	Name(sysno uintptr) string
 }
 
 // SyscallTable is a lookup table of system calls. Critically, a SyscallTable
@@ -190,7 +193,9 @@ type SyscallTable struct {
 
 	// AuditNumber is a numeric constant that represents the syscall table. If
 	// non-zero, auditNumber must be one of the AUDIT_ARCH_* values defined by
-	// linux/audit.h.
+	// linux/audit.h. It is reported to seccomp filters as seccomp_data.arch,
+	// so each architecture's table must use that architecture's value (e.g.
+	// AUDIT_ARCH_X86_64 or AUDIT_ARCH_AARCH64).
 	AuditNumber uint32 `state:"manual"`
 
 	// Table is the collection of functions.
@@ -204,6 +209,10 @@ type SyscallTable struct {
 	// keys are addresses, and the values are system call numbers.
 	Emulate map[usermem.Addr]uintptr `state:"manual"`
 
+	// SeccompStrict is the set of system call numbers that a task in
+	// SECCOMP_MODE_STRICT may invoke: read, write, exit and sigreturn.
+	SeccompStrict []uintptr `state:"manual"`
+
 	// The function to call in case of a missing system call.
 	Missing MissingFn `state:"manual"`
 
@@ -294,6 +303,15 @@ func (s *SyscallTable) Lookup(sysno uintptr) SyscallFn {
 	return nil
 }
 
+// LookupName returns the name of syscall sysno, or "sys_<sysno>" if the name
+// is unknown.
+func (s *SyscallTable) LookupName(sysno uintptr) string {
+	if s.Stracer != nil {
+		return s.Stracer.Name(sysno)
+	}
+	return fmt.Sprintf("sys_%d", sysno)
+}
+
 // LookupEmulate looks up an emulation syscall number.
 func (s *SyscallTable) LookupEmulate(addr usermem.Addr) (uintptr, bool) {
 	sysno, ok := s.Emulate[addr]
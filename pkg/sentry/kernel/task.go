@@ -15,11 +15,11 @@
 package kernel
 
 import (
+	"fmt"
 	"sync"
 	"sync/atomic"
 
 	"gvisor.googlesource.com/gvisor/pkg/abi/linux"
-	"gvisor.googlesource.com/gvisor/pkg/bpf"
 	"gvisor.googlesource.com/gvisor/pkg/sentry/arch"
 	"gvisor.googlesource.com/gvisor/pkg/sentry/context"
 	"gvisor.googlesource.com/gvisor/pkg/sentry/fs"
@@ -216,6 +216,13 @@ type Task struct {
 	// mu protects some of the following fields.
 	mu sync.Mutex `state:"nosave"`
 
+	// seccompMu protects the task's seccomp state: noNewPrivs, seccompStrict,
+	// and updates to syscallFilters. It is distinct from mu so that
+	// installing filters does not contend with unrelated users of mu.
+	// Changing seccompStrict or syscallFilters additionally requires locking
+	// the thread group's seccompMu, so either mutex suffices to read them.
+	seccompMu sync.Mutex `state:"nosave"`
+
 	// tc holds task data provided by the ELF loader.
 	//
 	// tc is protected by mu, and is owned by the task goroutine.
@@ -390,12 +397,52 @@ type Task struct {
 	// parentDeathSignal is protected by mu.
 	parentDeathSignal linux.Signal
 
-	// syscallFilters is all seccomp-bpf syscall filters applicable to the
-	// task, in the order in which they were installed. The type of the atomic
-	// is []bpf.Program. Writing needs to be protected by mu.
+	// If noNewPrivs is true, the task's no_new_privs bit (set by
+	// prctl(PR_SET_NO_NEW_PRIVS)) is set. Once set, it is never cleared, and
+	// it is inherited across fork, clone and execve.
+	//
+	// noNewPrivs is protected by seccompMu.
+	noNewPrivs bool
+
+	// syscallFilters is the task's own chain of seccomp-bpf syscall filters,
+	// in the order in which they were installed. If it is empty, the task
+	// has not diverged from its thread group, and the filters applicable to
+	// it are those of ThreadGroup.syscallFilters; otherwise, they are
+	// syscallFilters alone. See Task.syscallFilterChain. The type of the
+	// atomic is []*syscallFilter. Writing needs to be protected by seccompMu.
+	//
+	// Slices stored in syscallFilters are immutable, and may be shared with
+	// other tasks (for example, after fork or clone). Appending a filter
+	// stores a new slice rather than modifying the old one.
 	//
 	// syscallFilters is owned by the task goroutine.
-	syscallFilters atomic.Value `state:".([]bpf.Program)"`
+	syscallFilters atomic.Value `state:".([]*syscallFilter)"`
+
+	// If seccompStrict is true, the task is in SECCOMP_MODE_STRICT.
+	// seccompStrict is mutually exclusive with syscallFilters being
+	// non-empty.
+	//
+	// seccompStrict is protected by seccompMu, and is owned by the task
+	// goroutine.
+	seccompStrict bool
+
+	// seccompInput is a buffer for the input to seccomp-bpf filters, reused
+	// across system calls.
+	//
+	// seccompInput is exclusive to the task goroutine.
+	seccompInput seccompInput `state:"nosave"`
+
+	// seccompResults caches the results of syscallFilters for recent system
+	// calls if CacheSeccompResults is set.
+	//
+	// seccompResults is exclusive to the task goroutine.
+	seccompResults seccompResultCache `state:"nosave"`
+
+	// seccompAllowed is the set of system calls allowed by all of
+	// syscallFilters regardless of their arguments.
+	//
+	// seccompAllowed is exclusive to the task goroutine.
+	seccompAllowed seccompAllowSet `state:"nosave"`
 
 	// If cleartid is non-zero, treat it as a pointer to a ThreadID in the
 	// task's virtual address space; when the task exits, set the pointed-to
@@ -505,14 +552,19 @@ func (t *Task) loadLogPrefix(prefix string) {
 	t.logPrefix.Store(prefix)
 }
 
-func (t *Task) saveSyscallFilters() []bpf.Program {
+func (t *Task) saveSyscallFilters() []*syscallFilter {
 	if f := t.syscallFilters.Load(); f != nil {
-		return f.([]bpf.Program)
+		return f.([]*syscallFilter)
 	}
 	return nil
 }
 
-func (t *Task) loadSyscallFilters(filters []bpf.Program) {
+func (t *Task) loadSyscallFilters(filters []*syscallFilter) {
+	// Filters can't have been saved unless they passed AppendSyscallFilter's
+	// length check, so failing it here indicates corrupted state.
+	if l := syscallFiltersLength(filters); l > maxSyscallFilterInstructions {
+		panic(fmt.Sprintf("restored seccomp filters have length %d, exceeding limit %d", l, maxSyscallFilterInstructions))
+	}
 	t.syscallFilters.Store(filters)
 }
 
@@ -16,7 +16,6 @@ package kernel
 
 import (
 	"gvisor.googlesource.com/gvisor/pkg/abi/linux"
-	"gvisor.googlesource.com/gvisor/pkg/bpf"
 	"gvisor.googlesource.com/gvisor/pkg/sentry/kernel/auth"
 	"gvisor.googlesource.com/gvisor/pkg/sentry/usermem"
 	"gvisor.googlesource.com/gvisor/pkg/syserror"
@@ -259,6 +258,8 @@ func (t *Task) Clone(opts *CloneOptions) (ThreadID, *SyscallControl, error) {
 		IPCNamespace:            ipcns,
 		AbstractSocketNamespace: t.abstractSockets,
 		ContainerID:             t.ContainerID(),
+		SeccompStrict:           t.seccompStrict,
+		SeccompParent:           t,
 	}
 	if opts.NewThreadGroup {
 		cfg.Parent = t
@@ -298,13 +299,6 @@ func (t *Task) Clone(opts *CloneOptions) (ThreadID, *SyscallControl, error) {
 	tid := nt.k.tasks.Root.IDOfTask(nt)
 	defer nt.Start(tid)
 
-	// "If fork/clone and execve are allowed by @prog, any child processes will
-	// be constrained to the same filters and system call ABI as the parent." -
-	// Documentation/prctl/seccomp_filter.txt
-	if f := t.syscallFilters.Load(); f != nil {
-		copiedFilters := append([]bpf.Program(nil), f.([]bpf.Program)...)
-		nt.syscallFilters.Store(copiedFilters)
-	}
 	if opts.Vfork {
 		nt.vforkParent = t
 	}
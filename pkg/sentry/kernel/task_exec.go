@@ -201,11 +201,7 @@ func (r *runSyscallAfterExecStop) execute(t *Task) taskRunState {
 	// Switch to the new process.
 	t.MemoryManager().Deactivate()
 	t.mu.Lock()
-	// Update credentials to reflect the execve. This should precede switching
-	// MMs to ensure that dumpability has been reset first, if needed.
-	t.updateCredsForExecLocked()
-	t.tc.release()
-	t.tc = *r.tc
+	t.switchTaskContextForExecLocked(r.tc)
 	t.mu.Unlock()
 	t.unstopVforkParent()
 	// NOTE: All locks must be dropped prior to calling Activate.
@@ -215,6 +211,25 @@ func (r *runSyscallAfterExecStop) execute(t *Task) taskRunState {
 	return (*runSyscallExit)(nil)
 }
 
+// switchTaskContextForExecLocked updates t's credentials to reflect an execve
+// and replaces t's TaskContext with tc.
+//
+// Seccomp filters and mode, and the no_new_privs bit, are properties of the
+// task rather than its TaskContext, and are preserved across execve as in
+// Linux: "If fork(2) or clone(2) is allowed by the filter, any child processes
+// will be constrained to the same system call filters as the parent. If
+// execve(2) is allowed, the existing filters will be preserved across a call
+// to execve(2)." - seccomp(2)
+//
+// Preconditions: t.mu must be locked.
+func (t *Task) switchTaskContextForExecLocked(tc *TaskContext) {
+	// Update credentials to reflect the execve. This should precede switching
+	// MMs to ensure that dumpability has been reset first, if needed.
+	t.updateCredsForExecLocked()
+	t.tc.release()
+	t.tc = *tc
+}
+
 // promoteLocked makes t the leader of its thread group. If t is already the
 // thread group leader, promoteLocked is a no-op.
 //
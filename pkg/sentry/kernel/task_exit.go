@@ -678,6 +678,7 @@ func (t *Task) exitNotifyLocked(fromPtraceDetach bool) {
 		t.tg.tasksCount--
 		tc := t.tg.tasksCount
 		t.tg.signalHandlers.mu.Unlock()
+		t.releaseSeccompLocked()
 		if tc == 1 && t != t.tg.leader {
 			// Our fromPtraceDetach doesn't matter here (in Linux terms, this
 			// is via a call to release_task()).
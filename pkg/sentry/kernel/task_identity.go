@@ -50,6 +50,30 @@ func (t *Task) HasCapability(cp linux.Capability) bool {
 	return t.creds.HasCapability(cp)
 }
 
+// NoNewPrivs returns true if t's no_new_privs bit is set.
+func (t *Task) NoNewPrivs() bool {
+	t.tg.seccompMu.Lock()
+	defer t.tg.seccompMu.Unlock()
+	t.seccompMu.Lock()
+	defer t.seccompMu.Unlock()
+	return t.noNewPrivsLocked()
+}
+
+// noNewPrivsLocked returns true if t's no_new_privs bit is set, either by t
+// itself or by a synchronization of filters to t's thread group.
+//
+// Preconditions: t.tg.seccompMu and t.seccompMu must be locked.
+func (t *Task) noNewPrivsLocked() bool {
+	return t.noNewPrivs || t.tg.seccompNoNewPrivs
+}
+
+// SetNoNewPrivs sets t's no_new_privs bit. The bit cannot be cleared once set.
+func (t *Task) SetNoNewPrivs() {
+	t.seccompMu.Lock()
+	defer t.seccompMu.Unlock()
+	t.noNewPrivs = true
+}
+
 // SetUID implements the semantics of setuid(2).
 func (t *Task) SetUID(uid auth.UID) error {
 	// setuid considers -1 to be invalid.
@@ -424,16 +448,14 @@ func (t *Task) SetKeepCaps(k bool) {
 // (set-user/group-ID bits and file capabilities). This allows us to make a lot
 // of simplifying assumptions:
 //
-// - We assume the no_new_privs bit (set by prctl(SET_NO_NEW_PRIVS)), which
-// disables the features we don't support anyway, is always set. This
-// drastically simplifies this function.
-//
-// - We don't implement AT_SECURE, because no_new_privs always being set means
-// that the conditions that require AT_SECURE never arise. (Compare Linux's
-// security/commoncap.c:cap_bprm_set_creds() and cap_bprm_secureexec().)
+// - We behave as if the no_new_privs bit (set by prctl(SET_NO_NEW_PRIVS)),
+// which disables the features we don't support anyway, is always set, even if
+// Task.noNewPrivs is false. This drastically simplifies this function.
 //
-// - We don't check for CAP_SYS_ADMIN in prctl(PR_SET_SECCOMP), since
-// seccomp-bpf is also allowed if the task has no_new_privs set.
+// - We don't implement AT_SECURE, because no_new_privs always being
+// effectively set means that the conditions that require AT_SECURE never
+// arise. (Compare Linux's security/commoncap.c:cap_bprm_set_creds() and
+// cap_bprm_secureexec().)
 //
 // - Task.ptraceAttach does not serialize with execve as it does in Linux,
 // since no_new_privs being set has the same effect as the presence of an
@@ -80,6 +80,16 @@ type TaskConfig struct {
 
 	// ContainerID is the container the new task belongs to.
 	ContainerID string
+
+	// If SeccompStrict is true, the new task is in SECCOMP_MODE_STRICT.
+	SeccompStrict bool
+
+	// If SeccompParent is not nil, the new task inherits SeccompParent's
+	// seccomp filters and no_new_privs bit. These are copied while the
+	// TaskSet mutex is locked, so that the new task is consistent with
+	// filters concurrently synchronized to SeccompParent's thread group by
+	// SECCOMP_FILTER_FLAG_TSYNC.
+	SeccompParent *Task
 }
 
 // NewTask creates a new task defined by cfg.
@@ -128,6 +138,7 @@ func (ts *TaskSet) newTask(cfg *TaskConfig) (*Task, error) {
 		rseqCPU:         -1,
 		futexWaiter:     futex.NewWaiter(),
 		containerID:     cfg.ContainerID,
+		seccompStrict:   cfg.SeccompStrict,
 	}
 	t.endStopCond.L = &t.tg.signalHandlers.mu
 	t.ptraceTracer.Store((*Task)(nil))
@@ -153,6 +164,10 @@ func (ts *TaskSet) newTask(cfg *TaskConfig) (*Task, error) {
 	// Below this point, newTask is expected not to fail (there is no rollback
 	// of assignTIDsLocked or any of the following).
 
+	if p := cfg.SeccompParent; p != nil {
+		p.inheritSeccompLocked(t)
+	}
+
 	// Logging on t's behalf will panic if t.logPrefix hasn't been initialized.
 	// This is the earliest point at which we can do so (since t now has thread
 	// IDs).
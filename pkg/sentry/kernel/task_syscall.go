@@ -192,9 +192,14 @@ func (t *Task) doSyscall() taskRunState {
 	tmp := uintptr(syscall.ENOSYS)
 	t.Arch().SetReturn(-tmp)
 
-	// Check seccomp filters. The nil check is for performance (as seccomp use
+	// Check seccomp filters. The length check is for performance (as seccomp use
 	// is rare), not needed for correctness.
-	if t.syscallFilters.Load() != nil {
+	//
+	// Filters see the calling convention of the task's syscall table, which
+	// is the only one that the sentry executes for each task; platforms don't
+	// report the convention of the instruction that trapped (e.g. int 0x80
+	// from a 64-bit task).
+	if t.seccompStrict || len(t.syscallFilterChain()) != 0 {
 		switch r := t.checkSeccompSyscall(int32(sysno), args, usermem.Addr(t.Arch().IP())); r {
 		case seccompResultDeny:
 			t.Debugf("Syscall %d: denied by seccomp", sysno)
@@ -205,9 +210,17 @@ func (t *Task) doSyscall() taskRunState {
 			t.Debugf("Syscall %d: killed by seccomp", sysno)
 			t.PrepareExit(ExitStatus{Signo: int(linux.SIGSYS)})
 			return (*runExit)(nil)
+		case seccompResultKillProcess:
+			t.Debugf("Syscall %d: killed thread group by seccomp", sysno)
+			t.PrepareGroupExit(ExitStatus{Signo: int(linux.SIGSYS)})
+			return (*runExit)(nil)
 		case seccompResultTrace:
 			t.Debugf("Syscall %d: stopping for PTRACE_EVENT_SECCOMP", sysno)
 			return (*runSyscallAfterPtraceEventSeccomp)(nil)
+		case seccompResultKillStrict:
+			t.Debugf("Syscall %d: killed by strict seccomp", sysno)
+			t.PrepareExit(ExitStatus{Signo: int(linux.SIGKILL)})
+			return (*runExit)(nil)
 		default:
 			panic(fmt.Sprintf("Unknown seccomp result %d", r))
 		}
@@ -287,8 +300,7 @@ func (t *Task) doSyscallInvoke(sysno uintptr, args arch.SyscallArguments) taskRu
 			return ctrl.next
 		}
 	} else if err != nil {
-		t.Arch().SetReturn(uintptr(-t.ExtractErrno(err, int(sysno))))
-		t.haveSyscallReturn = true
+		t.setSyscallError(err, int(sysno))
 	} else {
 		t.Arch().SetReturn(rval)
 	}
@@ -296,6 +308,13 @@ func (t *Task) doSyscallInvoke(sysno uintptr, args arch.SyscallArguments) taskRu
 	return (*runSyscallExit)(nil).execute(t)
 }
 
+// setSyscallError sets the return value of syscall sysno to the errno
+// represented by err, as for a syscall implementation that returns err.
+func (t *Task) setSyscallError(err error, sysno int) {
+	t.Arch().SetReturn(uintptr(-t.ExtractErrno(err, sysno)))
+	t.haveSyscallReturn = true
+}
+
 // +stateify savable
 type runSyscallReinvoke struct{}
 
@@ -338,16 +357,28 @@ func (t *Task) doVsyscall(addr usermem.Addr, sysno uintptr) taskRunState {
 	// to syscall ABI because they both use RDI, RSI, and RDX for the first three
 	// arguments and none of the vsyscalls uses more than two arguments.
 	args := t.Arch().SyscallArgs()
-	if t.syscallFilters.Load() != nil {
+	if t.seccompStrict || len(t.syscallFilterChain()) != 0 {
 		switch r := t.checkSeccompSyscall(int32(sysno), args, addr); r {
 		case seccompResultDeny:
 			t.Debugf("vsyscall %d, caller %x: denied by seccomp", sysno, t.Arch().Value(caller))
 			return (*runApp)(nil)
 		case seccompResultAllow:
 			// ok
+		case seccompResultKill:
+			t.Debugf("vsyscall %d, caller %x: killed by seccomp", sysno, t.Arch().Value(caller))
+			t.PrepareExit(ExitStatus{Signo: int(linux.SIGSYS)})
+			return (*runExit)(nil)
+		case seccompResultKillProcess:
+			t.Debugf("vsyscall %d, caller %x: killed thread group by seccomp", sysno, t.Arch().Value(caller))
+			t.PrepareGroupExit(ExitStatus{Signo: int(linux.SIGSYS)})
+			return (*runExit)(nil)
 		case seccompResultTrace:
 			t.Debugf("vsyscall %d, caller %x: stopping for PTRACE_EVENT_SECCOMP", sysno, t.Arch().Value(caller))
 			return &runVsyscallAfterPtraceEventSeccomp{addr, sysno, caller}
+		case seccompResultKillStrict:
+			t.Debugf("vsyscall %d, caller %x: killed by strict seccomp", sysno, t.Arch().Value(caller))
+			t.PrepareExit(ExitStatus{Signo: int(linux.SIGKILL)})
+			return (*runExit)(nil)
 		default:
 			panic(fmt.Sprintf("Unknown seccomp result %d", r))
 		}
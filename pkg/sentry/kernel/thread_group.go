@@ -15,6 +15,7 @@
 package kernel
 
 import (
+	"fmt"
 	"sync"
 	"sync/atomic"
 
@@ -229,6 +230,45 @@ type ThreadGroup struct {
 
 	// rscr is the thread group's RSEQ critical region.
 	rscr atomic.Value `state:".(*RSEQCriticalRegion)"`
+
+	// seccompTraceSink receives SECCOMP_RET_TRACE events for tasks in the
+	// thread group that have no ptracer, if not nil. seccompTraceSink is not
+	// saved, since sinks are registered by the sentry's embedder, which must
+	// register them again after restore.
+	//
+	// seccompTraceSink is protected by the signal mutex.
+	seccompTraceSink SeccompTraceSink `state:"nosave"`
+
+	// seccompMu serializes changes to the seccomp state of tasks in the
+	// thread group: syscallFilters, seccompDiverged and seccompNoNewPrivs
+	// below, and the syscallFilters and seccompStrict fields of each task.
+	seccompMu sync.Mutex `state:"nosave"`
+
+	// syscallFilters is the chain of seccomp-bpf syscall filters shared by
+	// every task in the thread group that has not diverged from it (see
+	// Task.syscallFilters). The type of the atomic is []*syscallFilter.
+	// Writing needs to be protected by seccompMu.
+	//
+	// SECCOMP_FILTER_FLAG_TSYNC stores the synchronized chain here, and new
+	// threads share it without copying, so synchronizing a thread group in
+	// which no thread has diverged is O(1) in the number of threads.
+	syscallFilters atomic.Value `state:".([]*syscallFilter)"`
+
+	// seccompDiverged is an upper bound on the number of tasks in the thread
+	// group that have their own chain of syscall filters or are in
+	// SECCOMP_MODE_STRICT. If it is 0, every task uses syscallFilters, and
+	// TSYNC needn't visit each thread. Exiting tasks don't decrement it, so
+	// it is only reset by TSYNC.
+	//
+	// seccompDiverged is protected by seccompMu.
+	seccompDiverged int
+
+	// If seccompNoNewPrivs is true, every task in the thread group has its
+	// no_new_privs bit set, because a task with no_new_privs synchronized its
+	// filters to the thread group.
+	//
+	// seccompNoNewPrivs is protected by seccompMu.
+	seccompNoNewPrivs bool
 }
 
 // newThreadGroup returns a new, empty thread group in PID namespace ns. The
@@ -261,6 +301,23 @@ func (tg *ThreadGroup) loadRscr(rscr *RSEQCriticalRegion) {
 	tg.rscr.Store(rscr)
 }
 
+// saveSyscallFilters is invoked by stateify.
+func (tg *ThreadGroup) saveSyscallFilters() []*syscallFilter {
+	if f := tg.syscallFilters.Load(); f != nil {
+		return f.([]*syscallFilter)
+	}
+	return nil
+}
+
+// loadSyscallFilters is invoked by stateify.
+func (tg *ThreadGroup) loadSyscallFilters(filters []*syscallFilter) {
+	// As for Task.loadSyscallFilters.
+	if l := syscallFiltersLength(filters); l > maxSyscallFilterInstructions {
+		panic(fmt.Sprintf("restored seccomp filters have length %d, exceeding limit %d", l, maxSyscallFilterInstructions))
+	}
+	tg.syscallFilters.Store(filters)
+}
+
 // SignalHandlers returns the signal handlers used by tg.
 //
 // Preconditions: The caller must provide the synchronization required to read
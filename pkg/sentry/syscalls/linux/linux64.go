@@ -27,10 +27,6 @@ import (
 	"gvisor.googlesource.com/gvisor/pkg/syserror"
 )
 
-// AUDIT_ARCH_X86_64 identifies the Linux syscall API on AMD64, and is taken
-// from <linux/audit.h>.
-const _AUDIT_ARCH_X86_64 = 0xc000003e
-
 // AMD64 is a table of Linux amd64 syscall API with the corresponding syscall
 // numbers from Linux 3.11. The entries commented out are those syscalls we
 // don't currently support.
@@ -42,7 +38,7 @@ var AMD64 = &kernel.SyscallTable{
 		Release: "3.11.10",
 		Version: "#1 SMP Fri Nov 29 10:47:50 PST 2013",
 	},
-	AuditNumber: _AUDIT_ARCH_X86_64,
+	AuditNumber: linux.AUDIT_ARCH_X86_64,
 	Table: map[uintptr]kernel.SyscallFn{
 		0:  Read,
 		1:  Write,
@@ -368,6 +364,12 @@ var AMD64 = &kernel.SyscallTable{
 		0xffffffffff600400: 201, // vsyscall time(2)
 		0xffffffffff600800: 309, // vsyscall getcpu(2)
 	},
+	SeccompStrict: []uintptr{
+		0,  // read(2)
+		1,  // write(2)
+		15, // rt_sigreturn(2)
+		60, // exit(2)
+	},
 	Missing: func(t *kernel.Task, sysno uintptr, args arch.SyscallArguments) (uintptr, error) {
 		t.Kernel().EmitUnimplementedEvent(t)
 		return 0, syserror.ENOSYS
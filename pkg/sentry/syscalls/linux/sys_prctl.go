@@ -128,24 +128,34 @@ func Prctl(t *kernel.Task, args arch.SyscallArguments) (uintptr, *kernel.Syscall
 		if args[1].Int() != 1 || args[2].Int() != 0 || args[3].Int() != 0 || args[4].Int() != 0 {
 			return 0, nil, syscall.EINVAL
 		}
-		// no_new_privs is assumed to always be set. See
+		// Note that execve behaves as if no_new_privs is always set. See
 		// kernel.Task.updateCredsForExec.
+		t.SetNoNewPrivs()
 		return 0, nil, nil
 
 	case linux.PR_GET_NO_NEW_PRIVS:
 		if args[1].Int() != 0 || args[2].Int() != 0 || args[3].Int() != 0 || args[4].Int() != 0 {
 			return 0, nil, syscall.EINVAL
 		}
-		return 1, nil, nil
+		if t.NoNewPrivs() {
+			return 1, nil, nil
+		}
+		return 0, nil, nil
 
 	case linux.PR_SET_SECCOMP:
-		if args[1].Int() != linux.SECCOMP_MODE_FILTER {
+		switch args[1].Int() {
+		case linux.SECCOMP_MODE_STRICT:
+			// As in Linux, the filter argument is ignored.
+			_, err := seccomp(t, linux.SECCOMP_SET_MODE_STRICT, 0, 0)
+			return 0, nil, err
+		case linux.SECCOMP_MODE_FILTER:
+			_, err := seccomp(t, linux.SECCOMP_SET_MODE_FILTER, 0, args[2].Pointer())
+			return 0, nil, err
+		default:
 			// Unsupported mode.
 			return 0, nil, syscall.EINVAL
 		}
 
-		return 0, nil, seccomp(t, linux.SECCOMP_SET_MODE_FILTER, 0, args[2].Pointer())
-
 	case linux.PR_GET_SECCOMP:
 		return uintptr(t.SeccompMode()), nil, nil
 
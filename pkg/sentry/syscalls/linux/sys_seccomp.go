@@ -20,7 +20,9 @@ import (
 	"gvisor.googlesource.com/gvisor/pkg/abi/linux"
 	"gvisor.googlesource.com/gvisor/pkg/bpf"
 	"gvisor.googlesource.com/gvisor/pkg/sentry/arch"
+	"gvisor.googlesource.com/gvisor/pkg/sentry/fs"
 	"gvisor.googlesource.com/gvisor/pkg/sentry/kernel"
+	"gvisor.googlesource.com/gvisor/pkg/sentry/kernel/seccompnotify"
 	"gvisor.googlesource.com/gvisor/pkg/sentry/usermem"
 )
 
@@ -38,45 +40,167 @@ type userSockFprog struct {
 	Filter uint64
 }
 
-// seccomp applies a seccomp policy to the current task.
-func seccomp(t *kernel.Task, mode, flags uint64, addr usermem.Addr) error {
-	// We only support SECCOMP_SET_MODE_FILTER at the moment.
-	if mode != linux.SECCOMP_SET_MODE_FILTER {
-		// Unsupported mode.
+// seccompFilterFlags is the set of flags supported by SECCOMP_SET_MODE_FILTER,
+// equivalent to Linux's SECCOMP_FILTER_FLAG_MASK.
+const seccompFilterFlags = linux.SECCOMP_FILTER_FLAG_TSYNC |
+	linux.SECCOMP_FILTER_FLAG_LOG |
+	linux.SECCOMP_FILTER_FLAG_SPEC_ALLOW |
+	linux.SECCOMP_FILTER_FLAG_NEW_LISTENER |
+	linux.SECCOMP_FILTER_FLAG_TSYNC_ESRCH
+
+// checkSeccompFilterFlags returns EINVAL if flags, passed to
+// SECCOMP_SET_MODE_FILTER, contains unsupported flags or an invalid
+// combination of flags. As in Linux, the flags are checked before the filter
+// is read, so that callers can detect support for a flag by passing it with an
+// invalid filter address and checking for EFAULT.
+func checkSeccompFilterFlags(flags uint64) error {
+	if flags&^seccompFilterFlags != 0 {
+		// Unsupported flag.
+		return syscall.EINVAL
+	}
+	// Linux rejects this combination, since TSYNC failures are reported by
+	// returning a thread ID, which would be ambiguous with the listener file
+	// descriptor, unless SECCOMP_FILTER_FLAG_TSYNC_ESRCH requests that they
+	// are reported by failing with ESRCH instead.
+	if flags&linux.SECCOMP_FILTER_FLAG_TSYNC != 0 && flags&linux.SECCOMP_FILTER_FLAG_NEW_LISTENER != 0 && flags&linux.SECCOMP_FILTER_FLAG_TSYNC_ESRCH == 0 {
 		return syscall.EINVAL
 	}
+	return nil
+}
 
-	tsync := flags&linux.SECCOMP_FILTER_FLAG_TSYNC != 0
+// seccomp applies a seccomp policy to the current task. If the
+// SECCOMP_FILTER_FLAG_NEW_LISTENER flag is set, seccomp returns the new
+// listener file descriptor.
+func seccomp(t *kernel.Task, mode, flags uint64, addr usermem.Addr) (uintptr, error) {
+	switch mode {
+	case linux.SECCOMP_SET_MODE_STRICT:
+		if flags != 0 || addr != 0 {
+			return 0, syscall.EINVAL
+		}
+		return 0, t.SetSeccompStrict()
+	case linux.SECCOMP_SET_MODE_FILTER:
+		// Handled below.
+	case linux.SECCOMP_GET_ACTION_AVAIL:
+		return 0, seccompGetActionAvail(t, flags, addr)
+	case linux.SECCOMP_GET_NOTIF_SIZES:
+		return 0, seccompGetNotifSizes(t, flags, addr)
+	default:
+		// Unsupported mode.
+		return 0, syscall.EINVAL
+	}
 
-	// The only flag we support now is SECCOMP_FILTER_FLAG_TSYNC.
-	if flags&^linux.SECCOMP_FILTER_FLAG_TSYNC != 0 {
-		// Unsupported flag.
-		return syscall.EINVAL
+	if err := checkSeccompFilterFlags(flags); err != nil {
+		return 0, err
 	}
+	tsync := flags&linux.SECCOMP_FILTER_FLAG_TSYNC != 0
+	tsyncESRCH := flags&linux.SECCOMP_FILTER_FLAG_TSYNC_ESRCH != 0
+	newListener := flags&linux.SECCOMP_FILTER_FLAG_NEW_LISTENER != 0
 
+	// As in Linux, the filter's length and the task's privilege are checked
+	// before the filter itself is read, and the transition to
+	// SECCOMP_MODE_FILTER is checked only after the filter is validated.
 	var fprog userSockFprog
 	if _, err := t.CopyIn(addr, &fprog); err != nil {
-		return err
+		return 0, err
+	}
+	if fprog.Len == 0 || int(fprog.Len) > bpf.MaxInstructions {
+		return 0, syscall.EINVAL
+	}
+	if err := t.CheckSeccompFilterPrivilege(); err != nil {
+		return 0, err
 	}
 	filter := make([]linux.BPFInstruction, int(fprog.Len))
 	if _, err := t.CopyIn(usermem.Addr(fprog.Filter), &filter); err != nil {
-		return err
+		return 0, err
 	}
 	compiledFilter, err := bpf.Compile(filter)
 	if err != nil {
 		t.Debugf("Invalid seccomp-bpf filter: %v", err)
+		return 0, syscall.EINVAL
+	}
+
+	// Flags that are recorded with the filter.
+	//
+	// SECCOMP_FILTER_FLAG_SPEC_ALLOW asks Linux not to enable speculative
+	// store bypass mitigations for the task. The sentry doesn't control
+	// speculation mitigations on behalf of applications, so it is a no-op;
+	// it is accepted so that container runtimes and libseccomp, which pass
+	// it, don't fail with EINVAL.
+	filterFlags := uint32(flags & (linux.SECCOMP_FILTER_FLAG_LOG | linux.SECCOMP_FILTER_FLAG_SPEC_ALLOW))
+
+	if newListener {
+		fd, err := seccompNewListener(t, compiledFilter, filterFlags, tsync)
+		if _, ok := err.(*kernel.SyscallFilterSyncError); ok {
+			// checkSeccompFilterFlags ensures that tsyncESRCH is set.
+			return 0, syscall.ESRCH
+		}
+		return fd, err
+	}
+
+	if tsync {
+		// "On error, if SECCOMP_FILTER_FLAG_TSYNC was used, the return value
+		// is the ID of the thread that caused the synchronization failure." -
+		// seccomp(2)
+		//
+		// "ESRCH: Another thread caused a failure during thread sync, but
+		// SECCOMP_FILTER_FLAG_TSYNC_ESRCH was set." - seccomp(2)
+		err := t.SyncSyscallFiltersToThreadGroup(compiledFilter, filterFlags)
+		if serr, ok := err.(*kernel.SyscallFilterSyncError); ok {
+			if tsyncESRCH {
+				return 0, syscall.ESRCH
+			}
+			return uintptr(serr.TID), nil
+		}
+		return 0, err
+	}
+	return 0, t.AppendSyscallFilter(compiledFilter, filterFlags)
+}
+
+// seccompGetActionAvail implements SECCOMP_GET_ACTION_AVAIL, returning nil if
+// the action at addr is supported.
+func seccompGetActionAvail(t *kernel.Task, flags uint64, addr usermem.Addr) error {
+	if flags != 0 {
 		return syscall.EINVAL
 	}
+	var action uint32
+	if _, err := t.CopyIn(addr, &action); err != nil {
+		return err
+	}
+	if !kernel.SeccompActionAvailable(action) {
+		return syscall.EOPNOTSUPP
+	}
+	return nil
+}
 
-	err = t.AppendSyscallFilter(compiledFilter)
-	if err == nil && tsync {
-		// Now we must copy this seccomp program to all other threads.
-		err = t.SyncSyscallFiltersToThreadGroup()
+// seccompGetNotifSizes implements SECCOMP_GET_NOTIF_SIZES.
+func seccompGetNotifSizes(t *kernel.Task, flags uint64, addr usermem.Addr) error {
+	if flags != 0 {
+		return syscall.EINVAL
 	}
+	_, err := t.CopyOut(addr, seccompnotify.Sizes())
 	return err
 }
 
+// seccompNewListener installs p as a system call filter with a new listener
+// and per-filter flags flags, and returns the listener's file descriptor. If
+// tsync is true, the task's resulting filters are synchronized to its thread
+// group, as for SECCOMP_FILTER_FLAG_TSYNC.
+func seccompNewListener(t *kernel.Task, p bpf.Program, flags uint32, tsync bool) (uintptr, error) {
+	newFile := func(l *kernel.SeccompListener) *fs.File {
+		return seccompnotify.New(t, l)
+	}
+	// Listener file descriptors are always close-on-exec, as in Linux.
+	fd, err := t.NewSeccompListenerFD(p, flags, tsync, newFile, kernel.FDFlags{
+		CloseOnExec: true,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return uintptr(fd), nil
+}
+
 // Seccomp implements linux syscall seccomp(2).
 func Seccomp(t *kernel.Task, args arch.SyscallArguments) (uintptr, *kernel.SyscallControl, error) {
-	return 0, nil, seccomp(t, args[0].Uint64(), args[1].Uint64(), args[2].Pointer())
+	ret, err := seccomp(t, args[0].Uint64(), args[1].Uint64(), args[2].Pointer())
+	return ret, nil, err
 }
@@ -0,0 +1,50 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package linux
+
+import (
+	"syscall"
+	"testing"
+
+	"gvisor.googlesource.com/gvisor/pkg/abi/linux"
+)
+
+func TestCheckSeccompFilterFlags(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		flags uint64
+		want  error
+	}{
+		{name: "none"},
+		{name: "TSYNC", flags: linux.SECCOMP_FILTER_FLAG_TSYNC},
+		{name: "LOG", flags: linux.SECCOMP_FILTER_FLAG_LOG},
+		{name: "SPEC_ALLOW", flags: linux.SECCOMP_FILTER_FLAG_SPEC_ALLOW},
+		{name: "NEW_LISTENER", flags: linux.SECCOMP_FILTER_FLAG_NEW_LISTENER},
+		{name: "TSYNC|LOG|SPEC_ALLOW", flags: linux.SECCOMP_FILTER_FLAG_TSYNC | linux.SECCOMP_FILTER_FLAG_LOG | linux.SECCOMP_FILTER_FLAG_SPEC_ALLOW},
+		{name: "TSYNC|NEW_LISTENER", flags: linux.SECCOMP_FILTER_FLAG_TSYNC | linux.SECCOMP_FILTER_FLAG_NEW_LISTENER, want: syscall.EINVAL},
+		{name: "TSYNC|NEW_LISTENER|TSYNC_ESRCH", flags: linux.SECCOMP_FILTER_FLAG_TSYNC | linux.SECCOMP_FILTER_FLAG_NEW_LISTENER | linux.SECCOMP_FILTER_FLAG_TSYNC_ESRCH},
+		{name: "TSYNC|TSYNC_ESRCH", flags: linux.SECCOMP_FILTER_FLAG_TSYNC | linux.SECCOMP_FILTER_FLAG_TSYNC_ESRCH},
+		// As in Linux, SECCOMP_FILTER_FLAG_TSYNC_ESRCH is ignored without
+		// SECCOMP_FILTER_FLAG_TSYNC.
+		{name: "NEW_LISTENER|TSYNC_ESRCH", flags: linux.SECCOMP_FILTER_FLAG_NEW_LISTENER | linux.SECCOMP_FILTER_FLAG_TSYNC_ESRCH},
+		{name: "unknown", flags: 32, want: syscall.EINVAL},
+		{name: "unknown with known", flags: linux.SECCOMP_FILTER_FLAG_LOG | 1<<31, want: syscall.EINVAL},
+		{name: "unknown high bit", flags: 1 << 32, want: syscall.EINVAL},
+	} {
+		if got := checkSeccompFilterFlags(test.flags); got != test.want {
+			t.Errorf("%s: checkSeccompFilterFlags(%#x) got %v, want %v", test.name, test.flags, got, test.want)
+		}
+	}
+}
@@ -700,5 +700,6 @@ func Ptrace(t *kernel.Task, args arch.SyscallArguments) (uintptr, *kernel.Syscal
 	addr := args[2].Pointer()
 	data := args[3].Pointer()
 
-	return 0, nil, t.Ptrace(req, pid, addr, data)
+	ret, err := t.Ptrace(req, pid, addr, data)
+	return ret, nil, err
 }
@@ -192,6 +192,53 @@ type Config struct {
 	// disabled. Pardon the double negation, but default to enabled is important.
 	DisableSeccomp bool
 
+	// CompileAppSeccomp indicates that seccomp-bpf filters installed by the
+	// application should be compiled, rather than interpreted, when they are
+	// evaluated. See kernel.CompileSeccompFilters.
+	CompileAppSeccomp bool
+
+	// CacheAppSeccomp indicates that the results of seccomp-bpf filters
+	// installed by the application should be cached for recently repeated
+	// system calls. See kernel.CacheSeccompResults.
+	CacheAppSeccomp bool
+
+	// CountAppSeccomp indicates that the number of system calls evaluated by
+	// seccomp-bpf filters installed by the application should be counted for
+	// each system call number. See kernel.CountSeccompEvaluations.
+	CountAppSeccomp bool
+
+	// DenyAppSeccompErrors indicates that seccomp-bpf filters installed by
+	// the application that fail to execute should fail the system call with
+	// EPERM, rather than kill the task. See kernel.DenySeccompExecErrors.
+	DenyAppSeccompErrors bool
+
+	// MaxAppSeccompNotifications is the maximum number of outstanding
+	// seccomp user notifications of each listener created by the
+	// application, or 0 for no limit. See kernel.MaxSeccompNotifications.
+	MaxAppSeccompNotifications uint
+
+	// MaxAppSeccompFilterBytes is the maximum amount of memory, in bytes,
+	// that each task's seccomp filters may consume, or 0 for no limit. See
+	// kernel.MaxSeccompFilterBytes.
+	MaxAppSeccompFilterBytes uint64
+
+	// AppSeccompTraceToNotif indicates that SECCOMP_RET_TRACE results of
+	// seccomp-bpf filters installed by the application should be delivered
+	// to the application's seccomp user notification listener when there is
+	// no ptracer. See kernel.SeccompTraceToUserNotif.
+	AppSeccompTraceToNotif bool
+
+	// IgnoreAppSeccomp indicates that seccomp-bpf filters installed by the
+	// application should not be enforced. This is for debugging only. See
+	// kernel.IgnoreSeccompFilters.
+	IgnoreAppSeccomp bool
+
+	// AppSeccompFilters is the path to a file containing seccomp-bpf filters
+	// to install in the root container's init process before it starts, in
+	// the format written by kernel.MarshalSeccompFilterBundle. If empty, no
+	// filters are installed.
+	AppSeccompFilters string
+
 	// WatchdogAction sets what action the watchdog takes when triggered.
 	WatchdogAction watchdog.Action
 
@@ -222,6 +269,15 @@ func (c *Config) ToFlags() []string {
 		"--strace=" + strconv.FormatBool(c.Strace),
 		"--strace-syscalls=" + strings.Join(c.StraceSyscalls, ","),
 		"--strace-log-size=" + strconv.Itoa(int(c.StraceLogSize)),
+		"--compile-app-seccomp=" + strconv.FormatBool(c.CompileAppSeccomp),
+		"--cache-app-seccomp=" + strconv.FormatBool(c.CacheAppSeccomp),
+		"--count-app-seccomp=" + strconv.FormatBool(c.CountAppSeccomp),
+		"--deny-app-seccomp-errors=" + strconv.FormatBool(c.DenyAppSeccompErrors),
+		"--max-app-seccomp-notifications=" + strconv.FormatUint(uint64(c.MaxAppSeccompNotifications), 10),
+		"--max-app-seccomp-filter-bytes=" + strconv.FormatUint(c.MaxAppSeccompFilterBytes, 10),
+		"--app-seccomp-trace-to-notif=" + strconv.FormatBool(c.AppSeccompTraceToNotif),
+		"--ignore-app-seccomp=" + strconv.FormatBool(c.IgnoreAppSeccomp),
+		"--app-seccomp-filters=" + c.AppSeccompFilters,
 		"--watchdog-action=" + c.WatchdogAction.String(),
 		"--panic-signal=" + strconv.Itoa(c.PanicSignal),
 	}
@@ -94,6 +94,14 @@ const (
 
 	// SandboxStacks collects sandbox stacks for debugging.
 	SandboxStacks = "debug.Stacks"
+
+	// SandboxSeccompEvaluations collects the number of system calls
+	// evaluated by application seccomp filters for debugging.
+	SandboxSeccompEvaluations = "debug.SeccompEvaluations"
+
+	// SandboxSeccompFilters dumps the application seccomp filters installed
+	// by a task for debugging.
+	SandboxSeccompFilters = "debug.SeccompFilters"
 )
 
 // ControlSocketAddr generates an abstract unix socket name for the given ID.
@@ -132,7 +140,7 @@ func newController(fd int, k *kernel.Kernel, w *watchdog.Watchdog) (*controller,
 		srv.Register(net)
 	}
 
-	srv.Register(&debug{})
+	srv.Register(&debug{k: k})
 
 	if err := srv.StartServing(); err != nil {
 		return nil, err
@@ -15,10 +15,15 @@
 package boot
 
 import (
+	"fmt"
+
 	"gvisor.googlesource.com/gvisor/pkg/log"
+	"gvisor.googlesource.com/gvisor/pkg/sentry/kernel"
 )
 
 type debug struct {
+	// k is the sandbox's kernel.
+	k *kernel.Kernel
 }
 
 // Stacks collects all sandbox stacks and copies them to 'stacks'.
@@ -27,3 +32,43 @@ func (*debug) Stacks(_ *struct{}, stacks *string) error {
 	*stacks = string(buf)
 	return nil
 }
+
+// SeccompEvaluationsArgs are the arguments to SeccompEvaluations.
+type SeccompEvaluationsArgs struct {
+	// Reset indicates that the counts should be reset after they are read.
+	Reset bool
+}
+
+// SeccompEvaluations copies the number of system calls evaluated by
+// application seccomp filters, for each system call number, to counts. The
+// counts are only collected with --count-app-seccomp.
+func (*debug) SeccompEvaluations(args *SeccompEvaluationsArgs, counts *map[int32]uint64) error {
+	*counts = kernel.SeccompEvaluationCounts(args.Reset)
+	return nil
+}
+
+// SeccompFiltersArgs are the arguments to SeccompFilters.
+type SeccompFiltersArgs struct {
+	// TID is the thread ID of the task, in the sandbox's root PID namespace.
+	TID int32
+
+	// Summary indicates that only the index, length and flags of each filter
+	// should be copied, rather than its disassembly.
+	Summary bool
+}
+
+// SeccompFilters copies the disassembly of the application seccomp filters
+// installed by the task with the given thread ID, or their summary if
+// args.Summary is true, to dump.
+func (d *debug) SeccompFilters(args *SeccompFiltersArgs, dump *string) error {
+	t := d.k.TaskSet().Root.TaskWithID(kernel.ThreadID(args.TID))
+	if t == nil {
+		return fmt.Errorf("task %d not found", args.TID)
+	}
+	if args.Summary {
+		*dump = t.SeccompFilterSummary()
+		return nil
+	}
+	*dump = t.SeccompDump()
+	return nil
+}
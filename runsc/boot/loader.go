@@ -103,6 +103,10 @@ type Loader struct {
 	// rootProcArgs refers to the root sandbox init task.
 	rootProcArgs kernel.CreateProcessArgs
 
+	// appSeccompFilters are the seccomp-bpf filters installed in the root
+	// sandbox init task before it starts.
+	appSeccompFilters []kernel.SeccompFilter
+
 	// sandboxID is the ID for the whole sandbox.
 	sandboxID string
 
@@ -164,6 +168,10 @@ type Args struct {
 	TotalMem uint64
 	// UserLogFD is the file descriptor to write user logs to.
 	UserLogFD int
+	// AppSeccompFilters is an optional bundle of seccomp-bpf filters, in the
+	// format written by kernel.MarshalSeccompFilterBundle, to install in the
+	// root container's init process.
+	AppSeccompFilters []byte
 }
 
 // New initializes a new kernel loader configured by spec.
@@ -273,6 +281,66 @@ func New(args Args) (*Loader, error) {
 		atomic.StoreUint32(&sniffer.LogPackets, 0)
 	}
 
+	// Compile application seccomp filters if enabled.
+	if args.Conf.CompileAppSeccomp {
+		log.Infof("Application seccomp filter compilation enabled")
+		atomic.StoreUint32(&kernel.CompileSeccompFilters, 1)
+	} else {
+		atomic.StoreUint32(&kernel.CompileSeccompFilters, 0)
+	}
+
+	// Cache application seccomp filter results if enabled.
+	if args.Conf.CacheAppSeccomp {
+		log.Infof("Application seccomp filter result caching enabled")
+		atomic.StoreUint32(&kernel.CacheSeccompResults, 1)
+	} else {
+		atomic.StoreUint32(&kernel.CacheSeccompResults, 0)
+	}
+
+	// Count application seccomp filter evaluations if enabled.
+	if args.Conf.CountAppSeccomp {
+		log.Infof("Application seccomp filter evaluation counting enabled")
+		atomic.StoreUint32(&kernel.CountSeccompEvaluations, 1)
+	} else {
+		atomic.StoreUint32(&kernel.CountSeccompEvaluations, 0)
+	}
+
+	// Deny system calls for which application seccomp filters fail to
+	// execute if requested.
+	if args.Conf.DenyAppSeccompErrors {
+		log.Infof("Application seccomp filter execution errors will fail system calls with EPERM")
+		atomic.StoreUint32(&kernel.DenySeccompExecErrors, 1)
+	} else {
+		atomic.StoreUint32(&kernel.DenySeccompExecErrors, 0)
+	}
+
+	// Limit outstanding application seccomp user notifications.
+	log.Infof("Application seccomp user notifications limited to %d per listener", args.Conf.MaxAppSeccompNotifications)
+	atomic.StoreUint32(&kernel.MaxSeccompNotifications, uint32(args.Conf.MaxAppSeccompNotifications))
+
+	// Limit the memory consumed by application seccomp filters.
+	if args.Conf.MaxAppSeccompFilterBytes != 0 {
+		log.Infof("Application seccomp filters limited to %d bytes per task", args.Conf.MaxAppSeccompFilterBytes)
+	}
+	atomic.StoreUint64(&kernel.MaxSeccompFilterBytes, args.Conf.MaxAppSeccompFilterBytes)
+
+	// Deliver SECCOMP_RET_TRACE results to user notification listeners if
+	// requested.
+	if args.Conf.AppSeccompTraceToNotif {
+		log.Infof("Application seccomp SECCOMP_RET_TRACE results will be delivered to user notification listeners without a ptracer")
+		atomic.StoreUint32(&kernel.SeccompTraceToUserNotif, 1)
+	} else {
+		atomic.StoreUint32(&kernel.SeccompTraceToUserNotif, 0)
+	}
+
+	// Ignore application seccomp filters if requested.
+	if args.Conf.IgnoreAppSeccomp {
+		log.Warningf("Application seccomp filters will NOT be enforced. This mode is for debugging only and weakens the application's own defenses.")
+		atomic.StoreUint32(&kernel.IgnoreSeccompFilters, 1)
+	} else {
+		atomic.StoreUint32(&kernel.IgnoreSeccompFilters, 0)
+	}
+
 	// Create a watchdog.
 	watchdog := watchdog.New(k, watchdog.DefaultTimeout, args.Conf.WatchdogAction)
 
@@ -299,18 +367,28 @@ func New(args Args) (*Loader, error) {
 		return nil, fmt.Errorf("init compat logs: %v", err)
 	}
 
+	var appSeccompFilters []kernel.SeccompFilter
+	if len(args.AppSeccompFilters) != 0 {
+		appSeccompFilters, err = kernel.UnmarshalSeccompFilterBundle(args.AppSeccompFilters)
+		if err != nil {
+			return nil, fmt.Errorf("invalid application seccomp filters: %v", err)
+		}
+		log.Infof("Loaded %d application seccomp filters for the init process", len(appSeccompFilters))
+	}
+
 	l := &Loader{
-		k:            k,
-		ctrl:         ctrl,
-		conf:         args.Conf,
-		console:      args.Console,
-		watchdog:     watchdog,
-		spec:         args.Spec,
-		goferFDs:     args.GoferFDs,
-		stdioFDs:     args.StdioFDs,
-		rootProcArgs: procArgs,
-		sandboxID:    args.ID,
-		processes:    make(map[execID]*execProcess),
+		k:                 k,
+		ctrl:              ctrl,
+		conf:              args.Conf,
+		console:           args.Console,
+		watchdog:          watchdog,
+		spec:              args.Spec,
+		goferFDs:          args.GoferFDs,
+		stdioFDs:          args.StdioFDs,
+		rootProcArgs:      procArgs,
+		appSeccompFilters: appSeccompFilters,
+		sandboxID:         args.ID,
+		processes:         make(map[execID]*execProcess),
 	}
 
 	// We don't care about child signals; some platforms can generate a
@@ -466,13 +544,19 @@ func (l *Loader) run() error {
 		}
 
 		// Create the root container init task.
-		_, _, err := l.k.CreateProcess(l.rootProcArgs)
+		tg, _, err := l.k.CreateProcess(l.rootProcArgs)
 		if err != nil {
 			return fmt.Errorf("failed to create init process: %v", err)
 		}
 
 		// CreateProcess takes a reference on FDMap if successful.
 		l.rootProcArgs.FDMap.DecRef()
+
+		// Install the application's seccomp filters before the init task
+		// starts running.
+		if err := tg.Leader().InstallSeccompFilters(l.appSeccompFilters); err != nil {
+			return fmt.Errorf("failed to install application seccomp filters: %v", err)
+		}
 	}
 
 	eid := execID{cid: l.sandboxID}
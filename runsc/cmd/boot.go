@@ -15,6 +15,7 @@
 package cmd
 
 import (
+	"io/ioutil"
 	"os"
 	"runtime/debug"
 	"strings"
@@ -69,6 +70,10 @@ type Boot struct {
 
 	// userLogFD is the file descriptor to write user logs to.
 	userLogFD int
+
+	// appSeccompFiltersFD is the file descriptor that the application's
+	// seccomp filters will be read from, or -1 if there are none.
+	appSeccompFiltersFD int
 }
 
 // Name implements subcommands.Command.Name.
@@ -99,6 +104,7 @@ func (b *Boot) SetFlags(f *flag.FlagSet) {
 	f.IntVar(&b.cpuNum, "cpu-num", 0, "number of CPUs to create inside the sandbox")
 	f.Uint64Var(&b.totalMem, "total-memory", 0, "sets the initial amount of total memory to report back to the container")
 	f.IntVar(&b.userLogFD, "user-log-fd", 0, "file descriptor to write user logs to. 0 means no logging.")
+	f.IntVar(&b.appSeccompFiltersFD, "app-seccomp-filters-fd", -1, "FD with a bundle of seccomp-bpf filters to install in the init process")
 }
 
 // Execute implements subcommands.Command.Execute.  It starts a sandbox in a
@@ -155,6 +161,19 @@ func (b *Boot) Execute(_ context.Context, f *flag.FlagSet, args ...interface{})
 		panic("setCapsAndCallSelf must never return success")
 	}
 
+	// Read the application's seccomp filters, if any. This must happen after
+	// the call to setCapsAndCallSelf above, which would otherwise find the
+	// FD at EOF.
+	var appSeccompFilters []byte
+	if b.appSeccompFiltersFD != -1 {
+		filtersFile := os.NewFile(uintptr(b.appSeccompFiltersFD), "app seccomp filters file")
+		appSeccompFilters, err = ioutil.ReadAll(filtersFile)
+		filtersFile.Close()
+		if err != nil {
+			Fatalf("error reading application seccomp filters: %v", err)
+		}
+	}
+
 	// Create the loader.
 	bootArgs := boot.Args{
 		ID:           f.Arg(0),
@@ -168,6 +187,8 @@ func (b *Boot) Execute(_ context.Context, f *flag.FlagSet, args ...interface{})
 		NumCPU:       b.cpuNum,
 		TotalMem:     b.totalMem,
 		UserLogFD:    b.userLogFD,
+
+		AppSeccompFilters: appSeccompFilters,
 	}
 	l, err := boot.New(bootArgs)
 	if err != nil {
@@ -15,6 +15,9 @@
 package cmd
 
 import (
+	"bytes"
+	"fmt"
+	"sort"
 	"syscall"
 
 	"context"
@@ -27,9 +30,13 @@ import (
 
 // Debug implements subcommands.Command for the "debug" command.
 type Debug struct {
-	pid    int
-	stacks bool
-	signal int
+	pid                int
+	stacks             bool
+	signal             int
+	seccompEvaluations bool
+	resetSeccomp       bool
+	seccompFilters     int
+	seccompSummary     bool
 }
 
 // Name implements subcommands.Command.
@@ -52,6 +59,10 @@ func (d *Debug) SetFlags(f *flag.FlagSet) {
 	f.IntVar(&d.pid, "pid", 0, "sandbox process ID. Container ID is not necessary if this is set")
 	f.BoolVar(&d.stacks, "stacks", false, "if true, dumps all sandbox stacks to the log")
 	f.IntVar(&d.signal, "signal", -1, "sends signal to the sandbox")
+	f.BoolVar(&d.seccompEvaluations, "seccomp-evaluations", false, "if true, logs the number of system calls evaluated by the application's seccomp filters, which requires --count-app-seccomp")
+	f.BoolVar(&d.resetSeccomp, "reset-seccomp-evaluations", false, "if true, resets the counts reported by --seccomp-evaluations")
+	f.IntVar(&d.seccompFilters, "seccomp-filters", 0, "logs the disassembly of the application seccomp filters installed by the task with this thread ID in the sandbox")
+	f.BoolVar(&d.seccompSummary, "seccomp-filters-summary", false, "if true, --seccomp-filters logs only the index, length and flags of each filter, rather than its disassembly")
 }
 
 // Execute implements subcommands.Command.Execute.
@@ -114,5 +125,43 @@ func (d *Debug) Execute(_ context.Context, f *flag.FlagSet, args ...interface{})
 		}
 		log.Infof("     *** Stack dump ***\n%s", stacks)
 	}
+	if d.seccompEvaluations || d.resetSeccomp {
+		counts, err := c.Sandbox.SeccompEvaluations(d.resetSeccomp)
+		if err != nil {
+			Fatalf("error retrieving seccomp evaluations: %v", err)
+		}
+		if d.seccompEvaluations {
+			log.Infof("     *** Seccomp evaluations ***\n%s", formatSeccompEvaluations(counts))
+		}
+	}
+	if d.seccompFilters > 0 {
+		log.Infof("Retrieving seccomp filters for task %d", d.seccompFilters)
+		dump, err := c.Sandbox.SeccompFilters(int32(d.seccompFilters), d.seccompSummary)
+		if err != nil {
+			Fatalf("error retrieving seccomp filters: %v", err)
+		}
+		log.Infof("     *** Seccomp filters ***\n%s", dump)
+	}
 	return subcommands.ExitSuccess
 }
+
+// formatSeccompEvaluations returns counts, as returned by
+// Sandbox.SeccompEvaluations, with one system call per line in decreasing
+// order of count.
+func formatSeccompEvaluations(counts map[int32]uint64) string {
+	nrs := make([]int32, 0, len(counts))
+	for nr := range counts {
+		nrs = append(nrs, nr)
+	}
+	sort.Slice(nrs, func(i, j int) bool {
+		if counts[nrs[i]] != counts[nrs[j]] {
+			return counts[nrs[i]] > counts[nrs[j]]
+		}
+		return nrs[i] < nrs[j]
+	})
+	var b bytes.Buffer
+	for _, nr := range nrs {
+		fmt.Fprintf(&b, "%d\t%d\n", nr, counts[nr])
+	}
+	return b.String()
+}
@@ -62,6 +62,19 @@ var (
 	overlay        = flag.Bool("overlay", false, "wrap filesystem mounts with writable overlay. All modifications are stored in memory inside the sandbox.")
 	watchdogAction = flag.String("watchdog-action", "log", "sets what action the watchdog takes when triggered: log (default), panic.")
 	panicSignal    = flag.Int("panic-signal", -1, "register signal handling that panics. Usually set to SIGUSR2(12) to troubleshoot hangs. -1 disables it.")
+
+	// Experimental flags.
+	compileAppSeccomp  = flag.Bool("compile-app-seccomp", false, "EXPERIMENTAL: compile the application's seccomp filters instead of interpreting them.")
+	cacheAppSeccomp    = flag.Bool("cache-app-seccomp", false, "EXPERIMENTAL: cache the results of the application's seccomp filters for repeated system calls.")
+	countAppSeccomp    = flag.Bool("count-app-seccomp", false, "EXPERIMENTAL: count the system calls evaluated by the application's seccomp filters, which can be retrieved with 'runsc debug --seccomp-evaluations'.")
+	denyAppSeccompErr  = flag.Bool("deny-app-seccomp-errors", false, "EXPERIMENTAL: fail system calls with EPERM, rather than killing the task, if the application's seccomp filters fail to execute.")
+	maxAppSeccompNotif = flag.Uint("max-app-seccomp-notifications", 1024, "EXPERIMENTAL: maximum number of outstanding seccomp user notifications per listener before notifying system calls wait for the supervisor. 0 means no limit.")
+	maxAppSeccompBytes = flag.Uint64("max-app-seccomp-filter-bytes", 0, "EXPERIMENTAL: maximum amount of memory, in bytes, that each task's seccomp filters may consume before installing more fails with ENOMEM. 0 means no limit beyond the instruction limit.")
+	appSeccompTrace    = flag.Bool("app-seccomp-trace-to-notif", false, "EXPERIMENTAL: deliver SECCOMP_RET_TRACE results of the application's seccomp filters to its seccomp user notification listener, if any, when there is no ptracer, rather than failing the system call with ENOSYS.")
+	appSeccompFilters  = flag.String("app-seccomp-filters", "", "EXPERIMENTAL: path to a precompiled bundle of seccomp-bpf filters to install in the container's init process before it starts.")
+
+	// Debugging flags.
+	ignoreAppSeccomp = flag.Bool("ignore-app-seccomp", false, "DEBUG ONLY: allow all system calls regardless of the application's seccomp filters. This weakens the application's own defenses.")
 )
 
 // gitRevision is set during linking.
@@ -142,6 +155,16 @@ func main() {
 		StraceLogSize:  *straceLogSize,
 		WatchdogAction: wa,
 		PanicSignal:    *panicSignal,
+
+		CompileAppSeccomp:          *compileAppSeccomp,
+		CacheAppSeccomp:            *cacheAppSeccomp,
+		CountAppSeccomp:            *countAppSeccomp,
+		DenyAppSeccompErrors:       *denyAppSeccompErr,
+		MaxAppSeccompNotifications: *maxAppSeccompNotif,
+		MaxAppSeccompFilterBytes:   *maxAppSeccompBytes,
+		AppSeccompTraceToNotif:     *appSeccompTrace,
+		IgnoreAppSeccomp:           *ignoreAppSeccomp,
+		AppSeccompFilters:          *appSeccompFilters,
 	}
 	if len(*straceSyscalls) != 0 {
 		conf.StraceSyscalls = strings.Split(*straceSyscalls, ",")
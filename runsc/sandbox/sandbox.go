@@ -350,6 +350,19 @@ func (s *Sandbox) createSandboxProcess(spec *specs.Spec, conf *boot.Config, bund
 		nextFD++
 	}
 
+	// The sandbox can't open the application's seccomp filters after it
+	// chroots, so pass them as an FD.
+	if conf.AppSeccompFilters != "" {
+		filtersFile, err := os.Open(conf.AppSeccompFilters)
+		if err != nil {
+			return fmt.Errorf("opening application seccomp filters %q: %v", conf.AppSeccompFilters, err)
+		}
+		defer filtersFile.Close()
+		cmd.ExtraFiles = append(cmd.ExtraFiles, filtersFile)
+		cmd.Args = append(cmd.Args, "--app-seccomp-filters-fd="+strconv.Itoa(nextFD))
+		nextFD++
+	}
+
 	// The current process' stdio must be passed to the application via the
 	// --stdio-fds flag. The stdio of the sandbox process itself must not
 	// be connected to the same FDs, otherwise we risk leaking sandbox
@@ -820,6 +833,43 @@ func (s *Sandbox) Stacks() (string, error) {
 	return stacks, nil
 }
 
+// SeccompEvaluations returns the number of system calls evaluated by the
+// application's seccomp filters for each system call number, and resets the
+// counts if reset is true.
+func (s *Sandbox) SeccompEvaluations(reset bool) (map[int32]uint64, error) {
+	log.Debugf("Seccomp evaluations sandbox %q", s.ID)
+	conn, err := s.sandboxConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var counts map[int32]uint64
+	if err := conn.Call(boot.SandboxSeccompEvaluations, &boot.SeccompEvaluationsArgs{Reset: reset}, &counts); err != nil {
+		return nil, fmt.Errorf("err getting sandbox %q seccomp evaluations: %v", s.ID, err)
+	}
+	return counts, nil
+}
+
+// SeccompFilters returns the disassembly of the application seccomp filters
+// installed by the task with thread ID tid in the sandbox's root PID
+// namespace, or only the index, length and flags of each filter if summary is
+// true.
+func (s *Sandbox) SeccompFilters(tid int32, summary bool) (string, error) {
+	log.Debugf("Seccomp filters sandbox %q, TID: %d", s.ID, tid)
+	conn, err := s.sandboxConnect()
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	var dump string
+	if err := conn.Call(boot.SandboxSeccompFilters, &boot.SeccompFiltersArgs{TID: tid, Summary: summary}, &dump); err != nil {
+		return "", fmt.Errorf("err getting sandbox %q seccomp filters for task %d: %v", s.ID, tid, err)
+	}
+	return dump, nil
+}
+
 // DestroyContainer destroys the given container. If it is the root container,
 // then the entire sandbox is destroyed.
 func (s *Sandbox) DestroyContainer(cid string) error {